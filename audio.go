@@ -27,6 +27,8 @@ type AudioTransportConfig struct {
 	Channels uint8
 	// UseMCLK reports whether the transport is expected to drive a master clock.
 	UseMCLK bool
+	// MCLKMultiple is the master clock as a multiple of SampleRate. 0 means 256.
+	MCLKMultiple uint32
 }
 
 type audioTransport interface {
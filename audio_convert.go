@@ -0,0 +1,127 @@
+//go:build esp32 || esp32s3
+
+package cardputer
+
+import "github.com/sparques/cardputer/pcm"
+
+// audioConverter sits between Speaker or Microphone and the transport,
+// resampling between the rate the caller asked for and the rate the hardware
+// actually runs at, which SetSampleRate on the other one can change.
+type audioConverter struct {
+	// rate is the caller's sample rate, 0 meaning the hardware's.
+	rate         uint32
+	rs           *pcm.Resampler
+	rsFrom, rsTo uint32
+	// buf holds resampled output, or input still to be resampled from
+	// head to tail.
+	buf        [256]int16
+	head, tail int
+}
+
+func (c *audioConverter) callerRate(hw uint32) uint32 {
+	if c.rate == 0 {
+		return hw
+	}
+	return c.rate
+}
+
+// resampler returns the Resampler from rate from to rate to, or nil if they
+// match.
+func (c *audioConverter) resampler(from, to uint32) *pcm.Resampler {
+	if from == to {
+		c.rs = nil
+		return nil
+	}
+	if c.rs == nil || c.rsFrom != from || c.rsTo != to {
+		c.rs = pcm.NewResampler(from, to, pcm.Polyphase)
+		c.rsFrom, c.rsTo = from, to
+		c.head, c.tail = 0, 0
+	}
+	return c.rs
+}
+
+// write resamples samples to hw hertz and passes them to write.
+func (c *audioConverter) write(samples []int16, hw uint32, write func([]int16) (int, error)) (int, error) {
+	rs := c.resampler(c.callerRate(hw), hw)
+	if rs == nil {
+		return write(samples)
+	}
+	n := 0
+	for n < len(samples) {
+		out, used := rs.Process(c.buf[:], samples[n:])
+		n += used
+		if _, err := write(c.buf[:out]); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// read fills samples with input from read, resampled from hw hertz.
+func (c *audioConverter) read(samples []int16, hw uint32, read func([]int16) (int, error)) (int, error) {
+	rate := c.callerRate(hw)
+	rs := c.resampler(hw, rate)
+	if rs == nil {
+		return read(samples)
+	}
+	n := 0
+	for n < len(samples) {
+		if c.head == c.tail {
+			// Read only what the rest of samples needs, so a short read
+			// doesn't wait for input nobody asked for.
+			need := int(uint64(len(samples)-n)*uint64(hw)/uint64(rate)) + 1
+			m, err := read(c.buf[:min(len(c.buf), need)])
+			c.head, c.tail = 0, m
+			if err != nil {
+				return n, err
+			}
+		}
+		out, used := rs.Process(samples[n:], c.buf[c.head:c.tail])
+		n += out
+		c.head += used
+	}
+	return n, nil
+}
+
+// WriteStereo plays interleaved stereo frames, averaging the two channels,
+// and returns how many frames it queued.
+func (spk *speaker) WriteStereo(frames []int16) (int, error) {
+	var buf [128]int16
+	n := 0
+	for n < len(frames)/2 {
+		m := pcm.StereoToMono(buf[:], frames[2*n:])
+		if _, err := spk.Write(buf[:m]); err != nil {
+			return n, err
+		}
+		n += m
+	}
+	return n, nil
+}
+
+// WriteFloat32 plays samples in the range [-1, 1], clipping anything outside.
+func (spk *speaker) WriteFloat32(samples []float32) (int, error) {
+	var buf [128]int16
+	n := 0
+	for n < len(samples) {
+		m := pcm.FromFloat32(buf[:], samples[n:])
+		if _, err := spk.Write(buf[:m]); err != nil {
+			return n, err
+		}
+		n += m
+	}
+	return n, nil
+}
+
+// ReadFloat32 fills samples with input scaled to the range [-1, 1).
+func (mic *microphone) ReadFloat32(samples []float32) (int, error) {
+	var buf [128]int16
+	n := 0
+	for n < len(samples) {
+		m, err := mic.Read(buf[:min(len(buf), len(samples)-n)])
+		n += pcm.ToFloat32(samples[n:], buf[:m])
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -2,11 +2,27 @@
 
 package cardputer
 
+import (
+	"machine"
+
+	"github.com/sparques/cardputer/internal/esp32i2s"
+)
+
+// AudioMCLK is the pin that carries the I2S master clock to the codec when
+// the transport is configured with UseMCLK. NoPin keeps MCLK inside the chip,
+// where it still paces BCLK and LRCK; with NoPin, clear ES8311Config.UseMCLK
+// so the codec clocks itself from BCLK.
+var AudioMCLK = machine.NoPin
+
+// advAudioTransport streams PCM between the ES8311 and the ESP32-S3 I2S0
+// controller in full duplex: SpeakerBK is BCLK, I2SClock is LRCK, SpeakerData
+// carries samples to the codec and MicData carries them back.
 type advAudioTransport struct {
 	cfg AudioTransportConfig
+	i2s *esp32i2s.I2S
 }
 
-var sharedAudioTransport audioTransport = &advAudioTransport{}
+var sharedAudioTransport audioTransport = &advAudioTransport{i2s: esp32i2s.I2S0}
 
 func openAudioTransport() (audioTransport, error) {
 	return sharedAudioTransport, nil
@@ -20,15 +36,40 @@ func configureSharedAudioTransport(cfg ES8311Config) error {
 	return transport.Configure(audioTransportConfigFromES8311(cfg))
 }
 
+// Configure reprograms I2S0 when cfg differs from the running link. Repeating
+// the current settings leaves a stream in progress alone.
 func (t *advAudioTransport) Configure(cfg AudioTransportConfig) error {
+	if cfg == t.cfg {
+		return nil
+	}
+	mclk := uint8(esp32i2s.NoPin)
+	if cfg.UseMCLK && AudioMCLK != machine.NoPin {
+		mclk = uint8(AudioMCLK)
+	}
+	err := t.i2s.Configure(esp32i2s.Config{
+		SampleRate:    cfg.SampleRate,
+		BitsPerSample: uint8(cfg.BitsPerSample),
+		Channels:      cfg.Channels,
+		MCLKMultiple:  cfg.MCLKMultiple,
+		Pins: esp32i2s.Pins{
+			MCLK: mclk,
+			BCLK: uint8(SpeakerBK),
+			WS:   uint8(I2SClock),
+			DOut: uint8(SpeakerData),
+			DIn:  uint8(MicData),
+		},
+	})
+	if err != nil {
+		return err
+	}
 	t.cfg = cfg
 	return nil
 }
 
-func (*advAudioTransport) Write([]int16) (int, error) {
-	return 0, errAudioStreamUnavailable
+func (t *advAudioTransport) Write(samples []int16) (int, error) {
+	return t.i2s.Write(samples)
 }
 
-func (*advAudioTransport) Read([]int16) (int, error) {
-	return 0, errAudioStreamUnavailable
+func (t *advAudioTransport) Read(samples []int16) (int, error) {
+	return t.i2s.Read(samples)
 }
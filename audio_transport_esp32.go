@@ -2,25 +2,158 @@
 
 package cardputer
 
+import (
+	"errors"
+	"math"
+	"sync"
+
+	"github.com/sparques/cardputer/internal/esp32i2s"
+)
+
+// esp32AudioTransport runs the original Cardputer's NS4168 amplifier and
+// SPM1423 PDM microphone on I2S0. The two share GPIO43, as LRCK for one and
+// the PDM clock for the other, so only one can run at a time: Write switches
+// the controller to standard I2S output and Read to PDM input, dropping
+// whatever the other direction had queued.
 type esp32AudioTransport struct {
-	cfg AudioTransportConfig
+	mu   sync.Mutex
+	cfg  AudioTransportConfig
+	i2s  *esp32i2s.I2S
+	mode esp32AudioMode
 }
 
-var sharedAudioTransport audioTransport = &esp32AudioTransport{}
+var errAudioConfig = errors.New("unsupported audio configuration")
+
+type esp32AudioMode uint8
+
+const (
+	audioIdle esp32AudioMode = iota
+	audioSpeaker
+	audioMicrophone
+)
+
+var sharedAudioTransport audioTransport = &esp32AudioTransport{i2s: esp32i2s.I2S0}
+
+// sharedAudioConfig is the PCM format Speaker and Microphone share, matching
+// the Adv codec defaults.
+var sharedAudioConfig = AudioTransportConfig{
+	SampleRate:    16000,
+	BitsPerSample: ES8311Resolution16,
+	Channels:      1,
+}
 
 func openAudioTransport() (audioTransport, error) {
 	return sharedAudioTransport, nil
 }
 
+// configureSharedAudio applies update to the shared PCM format and hands the
+// result to the transport.
+func configureSharedAudio(update func(*AudioTransportConfig)) (audioTransport, error) {
+	cfg := sharedAudioConfig
+	update(&cfg)
+	transport, err := openAudioTransport()
+	if err != nil {
+		return nil, err
+	}
+	if err := transport.Configure(cfg); err != nil {
+		return nil, err
+	}
+	sharedAudioConfig = cfg
+	return transport, nil
+}
+
+// Configure records cfg for the next Write or Read. Repeating the current
+// settings leaves a stream in progress alone.
 func (t *esp32AudioTransport) Configure(cfg AudioTransportConfig) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if cfg == t.cfg {
+		return nil
+	}
+	if cfg.SampleRate == 0 || cfg.Channels == 0 || cfg.Channels > 2 {
+		return errAudioConfig
+	}
 	t.cfg = cfg
+	t.mode = audioIdle
+	return nil
+}
+
+func (t *esp32AudioTransport) Write(samples []int16) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.switchTo(audioSpeaker); err != nil {
+		return 0, err
+	}
+	return t.i2s.Write(samples)
+}
+
+func (t *esp32AudioTransport) Read(samples []int16) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.switchTo(audioMicrophone); err != nil {
+		return 0, err
+	}
+	return t.i2s.Read(samples)
+}
+
+func (t *esp32AudioTransport) switchTo(mode esp32AudioMode) error {
+	if t.mode == mode {
+		return nil
+	}
+	cfg := esp32i2s.Config{
+		SampleRate:    t.cfg.SampleRate,
+		BitsPerSample: uint8(t.cfg.BitsPerSample),
+		Channels:      t.cfg.Channels,
+	}
+	if mode == audioSpeaker {
+		cfg.Pins = esp32i2s.Pins{
+			MCLK: esp32i2s.NoPin,
+			BCLK: uint8(SpeakerBK),
+			WS:   uint8(I2SClock),
+			DOut: uint8(SpeakerData),
+			DIn:  esp32i2s.NoPin,
+		}
+	} else {
+		cfg.PDM = true
+		cfg.Pins = esp32i2s.Pins{
+			MCLK: esp32i2s.NoPin,
+			BCLK: esp32i2s.NoPin,
+			WS:   uint8(I2SClock),
+			DOut: esp32i2s.NoPin,
+			DIn:  uint8(MicData),
+		}
+	}
+	t.mode = audioIdle
+	if err := t.i2s.Configure(cfg); err != nil {
+		return err
+	}
+	t.mode = mode
 	return nil
 }
 
-func (*esp32AudioTransport) Write([]int16) (int, error) {
-	return 0, errAudioStreamUnavailable
+// volumeGain returns the Q16 amplitude for a 0-100 volume, following the
+// ES8311's 0.5dB-per-step volume register so a setting sounds alike on both
+// boards.
+func volumeGain(percent uint8) int32 {
+	if percent >= 100 {
+		return 1 << 16
+	}
+	if percent == 0 {
+		return 0
+	}
+	steps := 191 - int(percent)*191/100
+	return int32(math.Pow(10, -float64(steps)/40) * (1 << 16))
 }
 
-func (*esp32AudioTransport) Read([]int16) (int, error) {
-	return 0, errAudioStreamUnavailable
+// scaleSamples writes src times gain (Q16) to dst, clipping at full scale.
+func scaleSamples(dst, src []int16, gain int32) {
+	for i, v := range src {
+		s := int64(v) * int64(gain) >> 16
+		if s > math.MaxInt16 {
+			s = math.MaxInt16
+		} else if s < math.MinInt16 {
+			s = math.MinInt16
+		}
+		dst[i] = int16(s)
+	}
 }
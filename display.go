@@ -4,6 +4,7 @@ import (
 	"image"
 	"image/color"
 	"machine"
+	"sync"
 )
 
 const (
@@ -25,6 +26,11 @@ type display struct {
 	scroll int16
 	pix    []RGB565
 	line   []RGB565
+	// overlays are drawn over pix on the panel; see overlay.go
+	overlays []overlay
+	// mu serialises drawing, as overlays such as the mouse pointer are
+	// redrawn from other goroutines than the application's.
+	mu sync.Mutex
 }
 
 type RGB565 uint16
@@ -83,8 +89,13 @@ func (d *display) Set(x, y int, c color.Color) {
 		return
 	}
 	p := colorToRGB565(c)
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	if d.pix != nil {
 		d.pix[d.pixOffset(x, y)] = p
+		if o, ok := d.overlayAt(x, y); ok {
+			p = o
+		}
 	}
 	hwX, hwY := mapLogicalPoint(x, y)
 	d.device.Set(int(hwX), int(hwY), p)
@@ -100,6 +111,8 @@ func (d *display) Fill(r image.Rectangle, c color.Color) {
 		return
 	}
 	p := colorToRGB565(c)
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	if d.pix != nil {
 		for y := r.Min.Y; y < r.Max.Y; y++ {
 			row := y * dispWidth
@@ -110,6 +123,7 @@ func (d *display) Fill(r image.Rectangle, c color.Color) {
 	}
 	hw := mapLogicalRect(r)
 	d.device.Fill(hw, p)
+	d.redrawOverlays(r)
 }
 
 // Blit copies pixels from img into display, aligning img.Bounds().Min to 'at' within display.
@@ -145,6 +159,8 @@ func (d *display) RegionScroll(region image.Rectangle, amount int) {
 	if amount >= height || amount <= -height {
 		return
 	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
 	if amount > 0 {
 		for y := region.Min.Y; y < region.Max.Y-amount; y++ {
@@ -163,6 +179,7 @@ func (d *display) RegionScroll(region image.Rectangle, amount int) {
 	d.flush(region)
 }
 
+// flush redraws r from pix and the overlays. d.mu must be held.
 func (d *display) flush(r image.Rectangle) {
 	r = r.Intersect(d.Bounds())
 	if r.Empty() || d.pix == nil {
@@ -170,7 +187,11 @@ func (d *display) flush(r image.Rectangle) {
 	}
 	for y := r.Min.Y; y < r.Max.Y; y++ {
 		for x := r.Min.X; x < r.Max.X; x++ {
-			d.line[r.Dx()-1-(x-r.Min.X)] = d.pix[d.pixOffset(x, y)]
+			p := d.pix[d.pixOffset(x, y)]
+			if o, ok := d.overlayAt(x, y); ok {
+				p = o
+			}
+			d.line[r.Dx()-1-(x-r.Min.X)] = p
 		}
 		hw := mapLogicalRect(image.Rect(r.Min.X, y, r.Max.X, y+1))
 		d.device.Draw(hw, d.line[:r.Dx()])
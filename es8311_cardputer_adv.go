@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/sparques/cardputer/internal/adv"
+	"github.com/sparques/cardputer/pcm"
 	"machine"
 )
 
@@ -48,7 +49,9 @@ const (
 	es8311RegADCALC1    = 0x18
 	es8311RegADCALC2    = 0x19
 	es8311RegADCALC3    = 0x1A
+	es8311RegADCALC4    = 0x1B
 	es8311RegSystem14   = 0x1C
+	es8311RegADCEQ      = 0x1D
 	es8311RegDAC1       = 0x31
 	es8311RegDACVolume  = 0x32
 	es8311RegDACOffset  = 0x33
@@ -68,9 +71,16 @@ const (
 )
 
 const (
-	es8311DACMuteMask = 0x60
-	es8311ALCEnable   = 0x80
-	es8311DRCEnable   = 0x80
+	es8311DACMuteMask     = 0x60
+	es8311ALCEnable       = 0x80
+	es8311NoiseGateEnable = 0x40
+	es8311NoiseGateMask   = 0xE0
+	es8311HPFStage1Mask   = 0x1F
+	es8311ADCEQBypass     = 0x40
+	es8311ADCHPFEnable    = 0x20
+	es8311DRCEnable       = 0x80
+	es8311DACEQBypass     = 0x08
+	es8311MCLKFromBCLK    = 0x80
 )
 
 var errES8311UnsupportedClock = errors.New("unsupported ES8311 clock configuration")
@@ -90,13 +100,97 @@ type es8311ClockCoefficient struct {
 	dacOSR  uint8
 }
 
+// es8311ClockCoefficients are the codec's clock divider settings for each
+// supported pairing of input clock and sample rate. preMult is the
+// multiplier itself, 1, 2, 4 or 8.
 var es8311ClockCoefficients = [...]es8311ClockCoefficient{
+	// 8kHz
+	{12288000, 8000, 0x06, 0x01, 0x01, 0x01, 0x00, 0x05, 0xff, 0x04, 0x10, 0x20},
+	{18432000, 8000, 0x03, 0x02, 0x03, 0x03, 0x00, 0x05, 0xff, 0x18, 0x10, 0x20},
+	{16384000, 8000, 0x08, 0x01, 0x01, 0x01, 0x00, 0x07, 0xff, 0x04, 0x10, 0x20},
+	{8192000, 8000, 0x04, 0x01, 0x01, 0x01, 0x00, 0x03, 0xff, 0x04, 0x10, 0x20},
+	{6144000, 8000, 0x03, 0x01, 0x01, 0x01, 0x00, 0x02, 0xff, 0x04, 0x10, 0x20},
+	{4096000, 8000, 0x02, 0x01, 0x01, 0x01, 0x00, 0x01, 0xff, 0x04, 0x10, 0x20},
+	{3072000, 8000, 0x01, 0x01, 0x01, 0x01, 0x00, 0x01, 0x7f, 0x04, 0x10, 0x20},
+	{2048000, 8000, 0x01, 0x01, 0x01, 0x01, 0x00, 0x00, 0xff, 0x04, 0x10, 0x20},
+	{1536000, 8000, 0x03, 0x04, 0x01, 0x01, 0x00, 0x00, 0xbf, 0x04, 0x10, 0x20},
+	{1024000, 8000, 0x01, 0x02, 0x01, 0x01, 0x00, 0x00, 0x7f, 0x04, 0x10, 0x20},
+	// 11.025kHz
+	{11289600, 11025, 0x04, 0x01, 0x01, 0x01, 0x00, 0x03, 0xff, 0x04, 0x10, 0x20},
+	{5644800, 11025, 0x02, 0x01, 0x01, 0x01, 0x00, 0x01, 0xff, 0x04, 0x10, 0x20},
+	{2822400, 11025, 0x01, 0x01, 0x01, 0x01, 0x00, 0x00, 0xff, 0x04, 0x10, 0x20},
+	{1411200, 11025, 0x01, 0x02, 0x01, 0x01, 0x00, 0x00, 0x7f, 0x04, 0x10, 0x20},
+	// 12kHz
+	{12288000, 12000, 0x04, 0x01, 0x01, 0x01, 0x00, 0x03, 0xff, 0x04, 0x10, 0x20},
+	{6144000, 12000, 0x02, 0x01, 0x01, 0x01, 0x00, 0x01, 0xff, 0x04, 0x10, 0x20},
+	{3072000, 12000, 0x01, 0x01, 0x01, 0x01, 0x00, 0x00, 0xff, 0x04, 0x10, 0x20},
+	{1536000, 12000, 0x01, 0x02, 0x01, 0x01, 0x00, 0x00, 0x7f, 0x04, 0x10, 0x20},
+	// 16kHz
+	{12288000, 16000, 0x03, 0x01, 0x01, 0x01, 0x00, 0x02, 0xff, 0x04, 0x10, 0x20},
+	{18432000, 16000, 0x03, 0x02, 0x03, 0x03, 0x00, 0x02, 0xff, 0x0c, 0x10, 0x20},
+	{16384000, 16000, 0x04, 0x01, 0x01, 0x01, 0x00, 0x03, 0xff, 0x04, 0x10, 0x20},
+	{8192000, 16000, 0x02, 0x01, 0x01, 0x01, 0x00, 0x01, 0xff, 0x04, 0x10, 0x20},
+	{6144000, 16000, 0x03, 0x02, 0x01, 0x01, 0x00, 0x01, 0x7f, 0x04, 0x10, 0x20},
 	{4096000, 16000, 0x01, 0x01, 0x01, 0x01, 0x00, 0x00, 0xff, 0x04, 0x10, 0x20},
+	{3072000, 16000, 0x03, 0x04, 0x01, 0x01, 0x00, 0x00, 0xbf, 0x04, 0x10, 0x20},
+	{2048000, 16000, 0x01, 0x02, 0x01, 0x01, 0x00, 0x00, 0x7f, 0x04, 0x10, 0x20},
+	{1536000, 16000, 0x03, 0x08, 0x01, 0x01, 0x00, 0x00, 0x5f, 0x02, 0x10, 0x20},
+	{1024000, 16000, 0x01, 0x04, 0x01, 0x01, 0x00, 0x00, 0x3f, 0x02, 0x10, 0x20},
+	// 22.05kHz
+	{11289600, 22050, 0x02, 0x01, 0x01, 0x01, 0x00, 0x01, 0xff, 0x04, 0x10, 0x10},
 	{5644800, 22050, 0x01, 0x01, 0x01, 0x01, 0x00, 0x00, 0xff, 0x04, 0x10, 0x10},
+	{2822400, 22050, 0x01, 0x02, 0x01, 0x01, 0x00, 0x00, 0x7f, 0x04, 0x10, 0x10},
+	{1411200, 22050, 0x01, 0x04, 0x01, 0x01, 0x00, 0x00, 0x3f, 0x02, 0x10, 0x10},
+	// 24kHz
+	{12288000, 24000, 0x02, 0x01, 0x01, 0x01, 0x00, 0x01, 0xff, 0x04, 0x10, 0x10},
+	{18432000, 24000, 0x03, 0x01, 0x01, 0x01, 0x00, 0x02, 0xff, 0x04, 0x10, 0x10},
 	{6144000, 24000, 0x01, 0x01, 0x01, 0x01, 0x00, 0x00, 0xff, 0x04, 0x10, 0x10},
+	{3072000, 24000, 0x01, 0x02, 0x01, 0x01, 0x00, 0x00, 0x7f, 0x04, 0x10, 0x10},
+	{1536000, 24000, 0x01, 0x04, 0x01, 0x01, 0x00, 0x00, 0x3f, 0x02, 0x10, 0x10},
+	// 32kHz
+	{12288000, 32000, 0x03, 0x02, 0x01, 0x01, 0x00, 0x01, 0x7f, 0x04, 0x10, 0x10},
+	{18432000, 32000, 0x03, 0x04, 0x03, 0x03, 0x00, 0x02, 0x3f, 0x0c, 0x10, 0x10},
+	{16384000, 32000, 0x02, 0x01, 0x01, 0x01, 0x00, 0x01, 0xff, 0x04, 0x10, 0x10},
 	{8192000, 32000, 0x01, 0x01, 0x01, 0x01, 0x00, 0x00, 0xff, 0x04, 0x10, 0x10},
+	{6144000, 32000, 0x03, 0x04, 0x01, 0x01, 0x00, 0x00, 0xbf, 0x04, 0x10, 0x10},
+	{4096000, 32000, 0x01, 0x02, 0x01, 0x01, 0x00, 0x00, 0x7f, 0x04, 0x10, 0x10},
+	{3072000, 32000, 0x03, 0x08, 0x01, 0x01, 0x00, 0x00, 0x5f, 0x02, 0x10, 0x10},
+	{2048000, 32000, 0x01, 0x04, 0x01, 0x01, 0x00, 0x00, 0x3f, 0x02, 0x10, 0x10},
+	{1536000, 32000, 0x03, 0x08, 0x01, 0x01, 0x01, 0x00, 0x7f, 0x02, 0x10, 0x10},
+	{1024000, 32000, 0x01, 0x08, 0x01, 0x01, 0x01, 0x00, 0x7f, 0x02, 0x10, 0x10},
+	// 44.1kHz
 	{11289600, 44100, 0x01, 0x01, 0x01, 0x01, 0x00, 0x00, 0xff, 0x04, 0x10, 0x10},
+	{5644800, 44100, 0x01, 0x02, 0x01, 0x01, 0x00, 0x00, 0x7f, 0x04, 0x10, 0x10},
+	{2822400, 44100, 0x01, 0x04, 0x01, 0x01, 0x00, 0x00, 0x3f, 0x02, 0x10, 0x10},
+	{1411200, 44100, 0x01, 0x08, 0x01, 0x01, 0x00, 0x00, 0x1f, 0x02, 0x10, 0x10},
+	// 48kHz
 	{12288000, 48000, 0x01, 0x01, 0x01, 0x01, 0x00, 0x00, 0xff, 0x04, 0x10, 0x10},
+	{18432000, 48000, 0x03, 0x02, 0x01, 0x01, 0x00, 0x01, 0x7f, 0x04, 0x10, 0x10},
+	{6144000, 48000, 0x01, 0x02, 0x01, 0x01, 0x00, 0x00, 0x7f, 0x04, 0x10, 0x10},
+	{3072000, 48000, 0x01, 0x04, 0x01, 0x01, 0x00, 0x00, 0x3f, 0x02, 0x10, 0x10},
+	{1536000, 48000, 0x01, 0x08, 0x01, 0x01, 0x00, 0x00, 0x1f, 0x02, 0x10, 0x10},
+	// 64kHz
+	{12288000, 64000, 0x03, 0x04, 0x01, 0x01, 0x00, 0x00, 0xbf, 0x04, 0x10, 0x10},
+	{18432000, 64000, 0x03, 0x04, 0x03, 0x03, 0x01, 0x01, 0x7f, 0x06, 0x10, 0x10},
+	{16384000, 64000, 0x01, 0x01, 0x01, 0x01, 0x00, 0x00, 0xff, 0x04, 0x10, 0x10},
+	{8192000, 64000, 0x01, 0x02, 0x01, 0x01, 0x00, 0x00, 0x7f, 0x04, 0x10, 0x10},
+	{6144000, 64000, 0x01, 0x04, 0x01, 0x01, 0x01, 0x00, 0xbf, 0x03, 0x18, 0x18},
+	{4096000, 64000, 0x01, 0x04, 0x01, 0x01, 0x00, 0x00, 0x3f, 0x02, 0x10, 0x10},
+	{3072000, 64000, 0x01, 0x08, 0x01, 0x01, 0x01, 0x00, 0x5f, 0x02, 0x18, 0x18},
+	{2048000, 64000, 0x01, 0x08, 0x01, 0x01, 0x00, 0x00, 0x1f, 0x02, 0x10, 0x10},
+	{1536000, 64000, 0x01, 0x08, 0x01, 0x01, 0x01, 0x00, 0x2f, 0x02, 0x0c, 0x0c},
+	{1024000, 64000, 0x01, 0x08, 0x01, 0x01, 0x01, 0x00, 0x1f, 0x02, 0x10, 0x10},
+	// 88.2kHz
+	{11289600, 88200, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00, 0x7f, 0x02, 0x10, 0x10},
+	{5644800, 88200, 0x01, 0x04, 0x01, 0x01, 0x01, 0x00, 0x3f, 0x02, 0x10, 0x10},
+	{2822400, 88200, 0x01, 0x08, 0x01, 0x01, 0x01, 0x00, 0x1f, 0x02, 0x10, 0x10},
+	{1411200, 88200, 0x01, 0x08, 0x01, 0x01, 0x01, 0x00, 0x0f, 0x02, 0x10, 0x10},
+	// 96kHz
+	{12288000, 96000, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00, 0x7f, 0x02, 0x10, 0x10},
+	{18432000, 96000, 0x03, 0x04, 0x01, 0x01, 0x01, 0x00, 0xbf, 0x02, 0x10, 0x10},
+	{6144000, 96000, 0x01, 0x04, 0x01, 0x01, 0x01, 0x00, 0x3f, 0x02, 0x10, 0x10},
+	{3072000, 96000, 0x01, 0x08, 0x01, 0x01, 0x01, 0x00, 0x1f, 0x02, 0x10, 0x10},
+	{1536000, 96000, 0x01, 0x08, 0x01, 0x01, 0x01, 0x00, 0x0f, 0x02, 0x10, 0x10},
 }
 
 // ES8311Config describes the shared Adv audio codec configuration.
@@ -105,8 +199,11 @@ type ES8311Config struct {
 	SampleRate uint32
 	// BitsPerSample is the target PCM word size.
 	BitsPerSample ES8311Resolution
-	// UseMCLK requests a master clock driven at 256*SampleRate.
+	// UseMCLK clocks the codec from a master clock at MCLKMultiple times
+	// SampleRate. Without it the codec derives its clock from BCLK.
 	UseMCLK bool
+	// MCLKMultiple is MCLK as a multiple of SampleRate. 0 means 256.
+	MCLKMultiple uint16
 	// UseMicrophone enables the codec microphone input path.
 	UseMicrophone bool
 	// DACVolume is the raw DAC volume register value.
@@ -117,20 +214,92 @@ type ES8311Config struct {
 	ADCGain uint8
 	// Muted requests the DAC mute bit.
 	Muted bool
+	// ALC is the microphone automatic level control and noise gate.
+	ALC ES8311ALC
+	// DRC is the speaker dynamic range compressor.
+	DRC ES8311DRC
+	// HighPass is the ADC's DC-blocking high-pass filter.
+	HighPass ES8311HighPass
+	// ADCEQ and DACEQ are the codec's recording and playback equalizers.
+	ADCEQ ES8311ADCEQ
+	DACEQ ES8311DACEQ
+}
+
+// ES8311ALC configures the ADC automatic level control, which rides the
+// microphone gain to hold the input between MinLevel and MaxLevel.
+//
+// Levels are 4-bit codes: level n is (n+1)/32 of full scale, so 0 is
+// -30.1dBFS, 7 is -12dBFS and 15 is -6dBFS.
+type ES8311ALC struct {
+	Enable             bool
+	MinLevel, MaxLevel uint8
+	// Window is the 4-bit averaging window code. Each step doubles the
+	// window, slowing both attack and decay.
+	Window uint8
+	// NoiseGate mutes the ADC while the input stays below its threshold, so
+	// the ALC does not pump background noise up between words.
+	NoiseGate ES8311NoiseGate
+}
+
+// ES8311NoiseGate configures the ADC automute.
+type ES8311NoiseGate struct {
+	Enable bool
+	// Threshold is the 4-bit gate level code: -96dBFS plus 6dB a step.
+	Threshold uint8
+	// Window is the 4-bit code for how long the input must stay below
+	// Threshold before the gate closes. Each step doubles it.
+	Window uint8
+	// Attenuation is the 3-bit code for how far the gated input is turned
+	// down; 0 mutes it fully.
+	Attenuation uint8
+}
+
+// ES8311DRC configures the DAC dynamic range compressor. Levels and Window
+// use the same codes as ES8311ALC.
+type ES8311DRC struct {
+	Enable             bool
+	MinLevel, MaxLevel uint8
+	Window             uint8
+}
+
+// ES8311HighPass configures the ADC's two-stage high-pass filter, which
+// removes the microphone's DC offset and low-frequency rumble.
+type ES8311HighPass struct {
+	Enable bool
+	// Stage1 and Stage2 are the 5-bit coefficient codes of the two stages.
+	// Higher codes move the corner up.
+	Stage1, Stage2 uint8
+}
+
+// ES8311ADCEQ is the ADC's biquad equalizer. The coefficients are the
+// codec's 30-bit values, as produced by Everest's coefficient calculator.
+type ES8311ADCEQ struct {
+	Enable             bool
+	B0, A1, A2, B1, B2 uint32
+}
+
+// ES8311DACEQ is the DAC's first-order equalizer, with coefficients in the
+// same format as ES8311ADCEQ.
+type ES8311DACEQ struct {
+	Enable     bool
+	B0, B1, A1 uint32
 }
 
 // DefaultES8311Config returns the package's conservative Adv audio defaults.
 // The default profile is muted, uses 16-bit mono PCM at 16kHz, and enables the microphone path.
+// The high-pass filter is on; ALC, DRC and both equalizers are off.
 func DefaultES8311Config() ES8311Config {
 	return ES8311Config{
 		SampleRate:    16000,
 		BitsPerSample: ES8311Resolution16,
 		UseMCLK:       true,
+		MCLKMultiple:  256,
 		UseMicrophone: true,
 		DACVolume:     es8311Volume0dB,
 		ADCVolume:     0xC8,
 		ADCGain:       4, // datasheet default: +24dB
 		Muted:         true,
+		HighPass:      ES8311HighPass{Enable: true, Stage1: 0x0A, Stage2: 0x0A},
 	}
 }
 
@@ -142,6 +311,9 @@ func normalizeES8311Config(cfg ES8311Config) ES8311Config {
 	if cfg.BitsPerSample == 0 {
 		cfg.BitsPerSample = defaults.BitsPerSample
 	}
+	if cfg.MCLKMultiple == 0 {
+		cfg.MCLKMultiple = defaults.MCLKMultiple
+	}
 	if cfg.ADCGain > 7 {
 		cfg.ADCGain = 7
 	}
@@ -221,7 +393,7 @@ func (c *ES8311) Reset() error {
 }
 
 // ConfigureDefaults applies a conservative codec configuration for future I2S
-// transport use. This assumes a standard I2S link with MCLK = 256*Fs.
+// transport use. This assumes a standard I2S link with two slots a frame.
 func (c *ES8311) ConfigureDefaults(cfg ES8311Config) error {
 	if err := c.ConfigureClock(cfg); err != nil {
 		return err
@@ -232,10 +404,13 @@ func (c *ES8311) ConfigureDefaults(cfg ES8311Config) error {
 	if err := c.ConfigureMicrophone(cfg); err != nil {
 		return err
 	}
-	if err := c.DisableALC(); err != nil {
+	if err := c.ConfigureALC(cfg.ALC); err != nil {
 		return err
 	}
-	if err := c.DisableDRC(); err != nil {
+	if err := c.ConfigureDRC(cfg.DRC); err != nil {
+		return err
+	}
+	if err := c.ConfigureFilters(cfg); err != nil {
 		return err
 	}
 	if err := c.SetADCGain(cfg.ADCGain); err != nil {
@@ -298,6 +473,7 @@ func audioTransportConfigFromES8311(cfg ES8311Config) AudioTransportConfig {
 		BitsPerSample: cfg.BitsPerSample,
 		Channels:      1,
 		UseMCLK:       cfg.UseMCLK,
+		MCLKMultiple:  uint32(cfg.MCLKMultiple),
 	}
 }
 
@@ -324,18 +500,19 @@ func (c *ES8311) SetMuted(muted bool) error {
 }
 
 // ConfigureClock programs the codec clock tree for the supplied PCM settings.
-// Only MCLK=256*sample-rate profiles present in the built-in coefficient table are supported.
+// The sample rate and input clock, MCLK or BCLK, must pair up in the built-in
+// coefficient table; SelectES8311Clock picks a pairing that does.
 func (c *ES8311) ConfigureClock(cfg ES8311Config) error {
-	if !cfg.UseMCLK {
-		return errES8311UnsupportedClock
-	}
-
-	coeff, ok := lookupES8311ClockCoefficient(cfg.SampleRate, cfg.SampleRate*256)
+	coeff, ok := lookupES8311ClockCoefficient(cfg.SampleRate, es8311InputClock(cfg))
 	if !ok {
 		return errES8311UnsupportedClock
 	}
 
-	if err := c.WriteRegister(es8311RegClock1, 0x3F); err != nil {
+	reg01 := uint8(0x3F)
+	if !cfg.UseMCLK {
+		reg01 |= es8311MCLKFromBCLK
+	}
+	if err := c.WriteRegister(es8311RegClock1, reg01); err != nil {
 		return err
 	}
 
@@ -345,7 +522,7 @@ func (c *ES8311) ConfigureClock(cfg ES8311Config) error {
 	}
 	reg02 &= 0x07
 	reg02 |= (coeff.preDiv - 1) << 5
-	reg02 |= coeff.preMult << 3
+	reg02 |= es8311PreMultBits(coeff.preMult) << 3
 	if err := c.WriteRegister(es8311RegClock2, reg02); err != nil {
 		return err
 	}
@@ -413,6 +590,9 @@ func (c *ES8311) ConfigureMicrophone(cfg ES8311Config) error {
 }
 
 // PowerUpPlaybackPath enables the codec playback path registers used by the Adv speaker output.
+// The DAC ramp rate and equalizer bypass (REG37) and the ADC filters (REG1C)
+// are left to ConfigureFilters, which ConfigureDefaults also calls; call it
+// as well when powering up the path by hand.
 func (c *ES8311) PowerUpPlaybackPath() error {
 	if err := c.WriteRegister(es8311RegSystem3, 0x01); err != nil {
 		return err
@@ -423,13 +603,7 @@ func (c *ES8311) PowerUpPlaybackPath() error {
 	if err := c.WriteRegister(es8311RegSystem8, 0x00); err != nil {
 		return err
 	}
-	if err := c.WriteRegister(es8311RegSystem9, 0x10); err != nil {
-		return err
-	}
-	if err := c.WriteRegister(es8311RegDAC6, 0x08); err != nil {
-		return err
-	}
-	return c.WriteRegister(es8311RegSystem14, 0x6A)
+	return c.WriteRegister(es8311RegSystem9, 0x10)
 }
 
 // DisableALC disables the codec automatic level control block.
@@ -442,6 +616,122 @@ func (c *ES8311) DisableDRC() error {
 	return c.UpdateRegisterBits(es8311RegDACDRC1, es8311DRCEnable, 0)
 }
 
+// ConfigureALC programs the codec automatic level control and noise gate.
+func (c *ES8311) ConfigureALC(alc ES8311ALC) error {
+	alc1, alc2, alc3, gate := es8311ALCRegisters(alc)
+	if err := c.WriteRegister(es8311RegADCALC2, alc2); err != nil {
+		return err
+	}
+	if err := c.WriteRegister(es8311RegADCALC3, alc3); err != nil {
+		return err
+	}
+	if err := c.UpdateRegisterBits(es8311RegADCALC4, es8311NoiseGateMask, gate); err != nil {
+		return err
+	}
+	return c.WriteRegister(es8311RegADCALC1, alc1)
+}
+
+func es8311ALCRegisters(alc ES8311ALC) (alc1, alc2, alc3, gate uint8) {
+	alc1 = alc.Window & 0x0F
+	if alc.Enable {
+		alc1 |= es8311ALCEnable
+	}
+	if alc.NoiseGate.Enable {
+		alc1 |= es8311NoiseGateEnable
+	}
+	alc2 = es8311Levels(alc.MinLevel, alc.MaxLevel)
+	alc3 = alc.NoiseGate.Window<<4 | alc.NoiseGate.Threshold&0x0F
+	gate = alc.NoiseGate.Attenuation << 5
+	return alc1, alc2, alc3, gate
+}
+
+// ConfigureDRC programs the codec dynamic range compressor.
+func (c *ES8311) ConfigureDRC(drc ES8311DRC) error {
+	drc1, drc2 := es8311DRCRegisters(drc)
+	if err := c.WriteRegister(es8311RegDACDRC2, drc2); err != nil {
+		return err
+	}
+	return c.WriteRegister(es8311RegDACDRC1, drc1)
+}
+
+func es8311DRCRegisters(drc ES8311DRC) (drc1, drc2 uint8) {
+	drc1 = drc.Window & 0x0F
+	if drc.Enable {
+		drc1 |= es8311DRCEnable
+	}
+	return drc1, es8311Levels(drc.MinLevel, drc.MaxLevel)
+}
+
+// es8311Levels packs a min/max level pair, max in the high nibble.
+func es8311Levels(minLevel, maxLevel uint8) uint8 {
+	minLevel, maxLevel = minLevel&0x0F, maxLevel&0x0F
+	if minLevel > maxLevel {
+		minLevel = maxLevel
+	}
+	return maxLevel<<4 | minLevel
+}
+
+// ConfigureFilters programs the ADC high-pass filter and both equalizers,
+// which own REG1C and REG37, and sets the DAC volume ramp rate to 0.
+// Equalizer coefficients are written before the equalizer is switched in.
+func (c *ES8311) ConfigureFilters(cfg ES8311Config) error {
+	if cfg.ADCEQ.Enable {
+		eq := cfg.ADCEQ
+		if err := c.writeCoefficients(es8311RegADCEQ, eq.B0, eq.A1, eq.A2, eq.B1, eq.B2); err != nil {
+			return err
+		}
+	}
+	if err := c.UpdateRegisterBits(es8311RegADCALC4, es8311HPFStage1Mask, cfg.HighPass.Stage1); err != nil {
+		return err
+	}
+	if err := c.WriteRegister(es8311RegSystem14, es8311ADCFilterRegister(cfg)); err != nil {
+		return err
+	}
+
+	if cfg.DACEQ.Enable {
+		eq := cfg.DACEQ
+		if err := c.writeCoefficients(es8311RegDAC7, eq.B0, eq.B1, eq.A1); err != nil {
+			return err
+		}
+	}
+	return c.WriteRegister(es8311RegDAC6, es8311DACFilterRegister(cfg))
+}
+
+func es8311ADCFilterRegister(cfg ES8311Config) uint8 {
+	reg := cfg.HighPass.Stage2 & 0x1F
+	if cfg.HighPass.Enable {
+		reg |= es8311ADCHPFEnable
+	}
+	if !cfg.ADCEQ.Enable {
+		reg |= es8311ADCEQBypass
+	}
+	return reg
+}
+
+// es8311DACFilterRegister returns REG37: the DAC equalizer bypass, with the
+// DAC volume ramp rate bits left at 0.
+func es8311DACFilterRegister(cfg ES8311Config) uint8 {
+	if cfg.DACEQ.Enable {
+		return 0
+	}
+	return es8311DACEQBypass
+}
+
+// writeCoefficients writes 30-bit filter coefficients big-endian to
+// consecutive registers from reg.
+func (c *ES8311) writeCoefficients(reg uint8, coeffs ...uint32) error {
+	for _, v := range coeffs {
+		v &= 1<<30 - 1
+		for shift := 24; shift >= 0; shift -= 8 {
+			if err := c.WriteRegister(reg, uint8(v>>shift)); err != nil {
+				return err
+			}
+			reg++
+		}
+	}
+	return nil
+}
+
 // SetADCGain sets the codec ADC gain field, clamped to the device's 3-bit range.
 func (c *ES8311) SetADCGain(scale uint8) error {
 	if scale > 7 {
@@ -477,15 +767,136 @@ func es8311ResolutionBits(resolution ES8311Resolution) uint8 {
 	}
 }
 
-func lookupES8311ClockCoefficient(rate, mclk uint32) (es8311ClockCoefficient, bool) {
-	for _, coeff := range es8311ClockCoefficients {
-		if coeff.rate == rate && coeff.mclk == mclk {
-			return coeff, true
+// lookupES8311ClockCoefficient returns the coefficients for rate from an
+// input clock of clk hertz. An entry for a faster clock serves too when its
+// pre-multiplier has room to make up the difference.
+func lookupES8311ClockCoefficient(rate, clk uint32) (es8311ClockCoefficient, bool) {
+	for k := uint32(1); k <= 8; k *= 2 {
+		for _, coeff := range es8311ClockCoefficients {
+			if coeff.rate == rate && coeff.mclk == clk*k && uint32(coeff.preMult)*k <= 8 {
+				coeff.preMult *= uint8(k)
+				return coeff, true
+			}
 		}
 	}
 	return es8311ClockCoefficient{}, false
 }
 
+// es8311PreMultBits encodes a pre-multiplier for REG02.
+func es8311PreMultBits(mult uint8) uint8 {
+	switch mult {
+	case 2:
+		return 1
+	case 4:
+		return 2
+	case 8:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// es8311InputClock returns the frequency of the clock cfg drives the codec
+// from: MCLK, or BCLK for two slots a frame.
+func es8311InputClock(cfg ES8311Config) uint32 {
+	if cfg.UseMCLK {
+		multiple := uint32(cfg.MCLKMultiple)
+		if multiple == 0 {
+			multiple = 256
+		}
+		return cfg.SampleRate * multiple
+	}
+	return cfg.SampleRate * es8311FrameBits(cfg.BitsPerSample)
+}
+
+// es8311FrameBits returns the BCLK cycles in a two-slot frame of bits-wide
+// samples.
+func es8311FrameBits(bits ES8311Resolution) uint32 {
+	return 2 * 8 * uint32(pcm.SlotWidth(uint8(bits)))
+}
+
+// ES8311Clock is a codec clock profile.
+type ES8311Clock struct {
+	// SampleRate is the rate the codec runs at.
+	SampleRate uint32
+	// MCLKMultiple is MCLK as a multiple of SampleRate, or 0 when the codec
+	// clocks from BCLK.
+	MCLKMultiple uint16
+}
+
+// SelectES8311Clock returns the clock profile to run the codec at for a
+// stream at rate, given cfg's UseMCLK, MCLKMultiple and BitsPerSample. The
+// profile's SampleRate is rate itself when the codec can run at it,
+// otherwise the lowest multiple of it, the lowest rate above it, or failing
+// those the highest rate there is. With UseMCLK it keeps cfg.MCLKMultiple
+// where it can, then tries 256, then the highest multiple the I2S controller
+// can drive.
+func SelectES8311Clock(rate uint32, cfg ES8311Config) ES8311Clock {
+	var exact, multiple, above, highest ES8311Clock
+	for i, coeff := range es8311ClockCoefficients {
+		r := coeff.rate
+		if i > 0 && es8311ClockCoefficients[i-1].rate == r {
+			continue
+		}
+		clk, ok := es8311ClockAt(r, cfg)
+		if !ok {
+			continue
+		}
+		switch {
+		case r == rate:
+			exact = clk
+		case rate != 0 && r%rate == 0 && (multiple.SampleRate == 0 || r < multiple.SampleRate):
+			multiple = clk
+		}
+		if r > rate && (above.SampleRate == 0 || r < above.SampleRate) {
+			above = clk
+		}
+		if r > highest.SampleRate {
+			highest = clk
+		}
+	}
+	switch {
+	case exact.SampleRate != 0:
+		return exact
+	case multiple.SampleRate != 0:
+		return multiple
+	case above.SampleRate != 0:
+		return above
+	}
+	return highest
+}
+
+// es8311ClockAt returns the profile cfg would run at rate with, if any.
+func es8311ClockAt(rate uint32, cfg ES8311Config) (ES8311Clock, bool) {
+	cfg.SampleRate = rate
+	if !cfg.UseMCLK {
+		_, ok := lookupES8311ClockCoefficient(rate, es8311InputClock(cfg))
+		return ES8311Clock{SampleRate: rate}, ok
+	}
+
+	frame := es8311FrameBits(cfg.BitsPerSample)
+	usable := func(m uint32) bool {
+		// The I2S controller divides MCLK down to BCLK by 2 to 64.
+		if m%frame != 0 || m/frame < 2 || m/frame > 64 {
+			return false
+		}
+		_, ok := lookupES8311ClockCoefficient(rate, rate*m)
+		return ok
+	}
+	for _, m := range [...]uint16{cfg.MCLKMultiple, 256} {
+		if m != 0 && usable(uint32(m)) {
+			return ES8311Clock{SampleRate: rate, MCLKMultiple: m}, true
+		}
+	}
+	var best uint32
+	for _, coeff := range es8311ClockCoefficients {
+		if coeff.rate == rate && coeff.mclk%rate == 0 && coeff.mclk/rate > best && usable(coeff.mclk/rate) {
+			best = coeff.mclk / rate
+		}
+	}
+	return ES8311Clock{SampleRate: rate, MCLKMultiple: uint16(best)}, best != 0
+}
+
 func percentToES8311Volume(percent uint8) uint8 {
 	if percent >= 100 {
 		return es8311Volume0dB
@@ -56,6 +56,57 @@ func TestLookupES8311ClockCoefficient(t *testing.T) {
 	}
 }
 
+func TestSelectES8311Clock(t *testing.T) {
+	mclk := DefaultES8311Config()
+	mclk384 := mclk
+	mclk384.MCLKMultiple = 384
+	bclk := mclk
+	bclk.UseMCLK = false
+	bclk24 := bclk
+	bclk24.BitsPerSample = ES8311Resolution24
+
+	tests := []struct {
+		rate uint32
+		cfg  ES8311Config
+		want ES8311Clock
+	}{
+		{16000, mclk, ES8311Clock{16000, 256}},
+		{44100, mclk, ES8311Clock{44100, 256}},
+		{8000, mclk, ES8311Clock{8000, 256}},
+		{11025, mclk, ES8311Clock{11025, 256}},
+		{20000, mclk, ES8311Clock{22050, 256}},
+		{96000, mclk, ES8311Clock{96000, 192}},
+		{192000, mclk, ES8311Clock{96000, 192}},
+		{48000, mclk384, ES8311Clock{48000, 384}},
+		{11025, mclk384, ES8311Clock{11025, 256}},
+		{8000, bclk, ES8311Clock{8000, 0}},
+		{96000, bclk, ES8311Clock{96000, 0}},
+		{12000, bclk24, ES8311Clock{12000, 0}},
+	}
+	for _, tc := range tests {
+		if got := SelectES8311Clock(tc.rate, tc.cfg); got != tc.want {
+			t.Errorf("SelectES8311Clock(%d, mclk=%v/%d bits=%d) = %+v, want %+v",
+				tc.rate, tc.cfg.UseMCLK, tc.cfg.MCLKMultiple, tc.cfg.BitsPerSample, got, tc.want)
+		}
+	}
+}
+
+func TestES8311InputClock(t *testing.T) {
+	cfg := DefaultES8311Config()
+	cfg.SampleRate = 8000
+	if got := es8311InputClock(cfg); got != 2048000 {
+		t.Fatalf("es8311InputClock(mclk) = %d, want 2048000", got)
+	}
+	cfg.UseMCLK = false
+	if got := es8311InputClock(cfg); got != 256000 {
+		t.Fatalf("es8311InputClock(bclk) = %d, want 256000", got)
+	}
+	coeff, ok := lookupES8311ClockCoefficient(8000, 256000)
+	if !ok || coeff.preMult != 8 || es8311PreMultBits(coeff.preMult) != 3 {
+		t.Fatalf("lookupES8311ClockCoefficient(8000, 256000) = %+v, %v, want x8 pre-multiplier", coeff, ok)
+	}
+}
+
 func TestES8311ResolutionBits(t *testing.T) {
 	tests := []struct {
 		resolution ES8311Resolution
@@ -86,3 +137,61 @@ func TestPercentToES8311Volume(t *testing.T) {
 		t.Fatalf("percentToES8311Volume(200) = %d, want %d", got, es8311Volume0dB)
 	}
 }
+
+func TestES8311ALCRegisters(t *testing.T) {
+	alc1, alc2, alc3, gate := es8311ALCRegisters(ES8311ALC{
+		Enable:   true,
+		MinLevel: 12,
+		MaxLevel: 10,
+		Window:   0x13,
+		NoiseGate: ES8311NoiseGate{
+			Enable:      true,
+			Threshold:   5,
+			Window:      2,
+			Attenuation: 3,
+		},
+	})
+	if alc1 != 0xC3 {
+		t.Fatalf("es8311ALCRegisters() alc1 = %#x, want 0xc3", alc1)
+	}
+	if alc2 != 0xAA {
+		t.Fatalf("es8311ALCRegisters() alc2 = %#x, want 0xaa", alc2)
+	}
+	if alc3 != 0x25 {
+		t.Fatalf("es8311ALCRegisters() alc3 = %#x, want 0x25", alc3)
+	}
+	if gate != 0x60 {
+		t.Fatalf("es8311ALCRegisters() gate = %#x, want 0x60", gate)
+	}
+
+	if alc1, _, _, _ := es8311ALCRegisters(ES8311ALC{}); alc1 != 0 {
+		t.Fatalf("es8311ALCRegisters() disabled alc1 = %#x, want 0", alc1)
+	}
+}
+
+func TestES8311DRCRegisters(t *testing.T) {
+	drc1, drc2 := es8311DRCRegisters(ES8311DRC{Enable: true, MinLevel: 2, MaxLevel: 13, Window: 4})
+	if drc1 != 0x84 || drc2 != 0xD2 {
+		t.Fatalf("es8311DRCRegisters() = %#x, %#x, want 0x84, 0xd2", drc1, drc2)
+	}
+}
+
+func TestES8311ADCFilterRegister(t *testing.T) {
+	if reg := es8311ADCFilterRegister(DefaultES8311Config()); reg != 0x6A {
+		t.Fatalf("es8311ADCFilterRegister(default) = %#x, want 0x6a", reg)
+	}
+	cfg := ES8311Config{ADCEQ: ES8311ADCEQ{Enable: true}}
+	if reg := es8311ADCFilterRegister(cfg); reg != 0x00 {
+		t.Fatalf("es8311ADCFilterRegister(eq, no hpf) = %#x, want 0", reg)
+	}
+}
+
+func TestES8311DACFilterRegister(t *testing.T) {
+	if reg := es8311DACFilterRegister(DefaultES8311Config()); reg != 0x08 {
+		t.Fatalf("es8311DACFilterRegister(default) = %#x, want 0x08", reg)
+	}
+	cfg := ES8311Config{DACEQ: ES8311DACEQ{Enable: true}}
+	if reg := es8311DACFilterRegister(cfg); reg != 0x00 {
+		t.Fatalf("es8311DACFilterRegister(eq) = %#x, want 0", reg)
+	}
+}
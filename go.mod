@@ -3,13 +3,13 @@ module github.com/sparques/cardputer
 go 1.24.6
 
 require (
+	github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510
 	github.com/sparques/irtrx v0.0.0-20260507154607-699a3d70b068
 	tinygo.org/x/drivers v0.31.0
 	tinygo.org/x/tinyfs v0.5.0
 )
 
 require (
-	github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510 // indirect
 	github.com/sparques/pwm v0.0.2 // indirect
 )
 
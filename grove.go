@@ -0,0 +1,63 @@
+//go:build esp32 || esp32s3
+
+package cardputer
+
+import (
+	"machine"
+
+	"github.com/sparques/cardputer/keypad"
+)
+
+var (
+	groveI2C           = machine.I2C1
+	groveI2CConfigured bool
+)
+
+// GroveI2C returns an I2C bus on the Grove port (GroveSDA/GroveSCL) at 100kHz,
+// the speed most Grove devices expect. It is separate from the Adv's shared
+// peripheral bus.
+func GroveI2C() (*machine.I2C, error) {
+	if groveI2CConfigured {
+		return groveI2C, nil
+	}
+
+	err := groveI2C.Configure(machine.I2CConfig{
+		Frequency: 100 * machine.KHz,
+		SDA:       GroveSDA,
+		SCL:       GroveSCL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groveI2CConfigured = true
+	return groveI2C, nil
+}
+
+// StartCardKB starts polling for an M5Stack CardKB on the Grove port and
+// merges its keys into KP. The CardKB may be plugged in before or after the
+// call; use the returned keyboard's ConnectCallback to follow it.
+func StartCardKB() (*keypad.ExternalKeyboard, error) {
+	bus, err := GroveI2C()
+	if err != nil {
+		return nil, err
+	}
+	kb := keypad.NewCardKB(bus)
+	kb.Attach(KP.Device)
+	kb.Start()
+	return kb, nil
+}
+
+// StartTCA8418Keyboard starts polling for a rows by cols key matrix scanned by
+// a TCA8418 on the Grove port and merges its keys into KP. keymap maps matrix
+// positions to buttons; nil uses the Cardputer-Adv layout.
+func StartTCA8418Keyboard(rows, cols int, keymap func(row, col int) keypad.Keys) (*keypad.ExternalKeyboard, error) {
+	bus, err := GroveI2C()
+	if err != nil {
+		return nil, err
+	}
+	kb := keypad.NewTCA8418Keyboard(bus, rows, cols, keymap)
+	kb.Attach(KP.Device)
+	kb.Start()
+	return kb, nil
+}
@@ -3,12 +3,15 @@
 package cardputer
 
 // Speaker exposes the Cardputer-Adv speaker path.
-// Codec configuration is supported; PCM streaming still depends on a future ESP32 I2S backend.
+// PCM written to it streams to the ES8311 over I2S0. Rates the codec can't
+// clock are resampled to the nearest one it can.
 var Speaker = &speaker{}
 
 type speaker struct {
+	speakerTone
 	codec     *ES8311
 	transport audioTransport
+	conv      audioConverter
 }
 
 func (spk *speaker) Init() error {
@@ -52,12 +55,14 @@ func (spk *speaker) SetVolume(volume uint8) error {
 
 func (spk *speaker) SetSampleRate(rate uint32) error {
 	codec, err := configureSharedES8311(func(cfg *ES8311Config) {
-		cfg.SampleRate = rate
+		clk := SelectES8311Clock(rate, *cfg)
+		cfg.SampleRate, cfg.MCLKMultiple = clk.SampleRate, clk.MCLKMultiple
 	})
 	if err != nil {
 		return err
 	}
 	spk.codec = codec
+	spk.conv.rate = rate
 	return spk.attachTransport()
 }
 
@@ -76,7 +81,7 @@ func (spk *speaker) Write(samples []int16) (int, error) {
 	if err := spk.Init(); err != nil {
 		return 0, err
 	}
-	return spk.transport.Write(samples)
+	return spk.conv.write(samples, sharedES8311Config.SampleRate, spk.transport.Write)
 }
 
 func (spk *speaker) attachTransport() error {
@@ -88,11 +93,6 @@ func (spk *speaker) attachTransport() error {
 	return nil
 }
 
-// Beep is currently a no-op until I2S streaming support is added for ESP32-S3.
-func (*speaker) Beep() {}
-
-// AckBeep is currently a no-op until I2S streaming support is added for ESP32-S3.
-func (*speaker) AckBeep() {}
-
-// NakBeep is currently a no-op until I2S streaming support is added for ESP32-S3.
-func (*speaker) NakBeep() {}
+func (spk *speaker) sampleRate() uint32 {
+	return spk.conv.callerRate(sharedES8311Config.SampleRate)
+}
@@ -2,43 +2,88 @@
 
 package cardputer
 
-import "errors"
+// Speaker drives the original Cardputer's NS4168 amplifier over I2S0. The
+// amplifier has no volume or mute control of its own, so both are applied to
+// the samples; like the Adv it starts muted.
+var Speaker = &speaker{muted: true, gain: 1 << 16}
 
-// Speaker exposes speaker control for the current board.
-// On the original Cardputer build, configuration and streaming are not yet implemented.
-var Speaker = &speaker{}
-
-type speaker struct{}
-
-func (*speaker) Init() error {
-	return errors.New("speaker support is not implemented for the ESP32-S3 cardputer target")
+type speaker struct {
+	speakerTone
+	transport audioTransport
+	muted     bool
+	gain      int32
+	conv      audioConverter
+	buf       [256]int16
 }
 
-func (*speaker) SetMuted(bool) error {
-	return errors.New("speaker support is not implemented for the ESP32-S3 cardputer target")
+func (spk *speaker) Init() error {
+	transport, err := configureSharedAudio(func(*AudioTransportConfig) {})
+	if err != nil {
+		return err
+	}
+	spk.transport = transport
+	return nil
 }
 
-func (*speaker) SetVolume(uint8) error {
-	return errors.New("speaker support is not implemented for the ESP32-S3 cardputer target")
+func (spk *speaker) SetMuted(muted bool) error {
+	spk.muted = muted
+	return spk.Init()
 }
 
-func (*speaker) SetSampleRate(uint32) error {
-	return errors.New("speaker support is not implemented for the ESP32-S3 cardputer target")
+func (spk *speaker) SetVolume(volume uint8) error {
+	spk.gain = volumeGain(volume)
+	return spk.Init()
 }
 
-func (*speaker) SetBitsPerSample(ES8311Resolution) error {
-	return errors.New("speaker support is not implemented for the ESP32-S3 cardputer target")
+func (spk *speaker) SetSampleRate(rate uint32) error {
+	transport, err := configureSharedAudio(func(cfg *AudioTransportConfig) {
+		cfg.SampleRate = rate
+	})
+	if err != nil {
+		return err
+	}
+	spk.transport = transport
+	spk.conv.rate = rate
+	return nil
 }
 
-func (*speaker) Write([]int16) (int, error) {
-	return 0, errAudioStreamUnavailable
+func (spk *speaker) SetBitsPerSample(bits ES8311Resolution) error {
+	transport, err := configureSharedAudio(func(cfg *AudioTransportConfig) {
+		cfg.BitsPerSample = bits
+	})
+	if err != nil {
+		return err
+	}
+	spk.transport = transport
+	return nil
 }
 
-// Beep is currently a no-op until audio support is implemented for ESP32-S3.
-func (*speaker) Beep() {}
+func (spk *speaker) Write(samples []int16) (int, error) {
+	if err := spk.Init(); err != nil {
+		return 0, err
+	}
+	return spk.conv.write(samples, sharedAudioConfig.SampleRate, spk.writeScaled)
+}
 
-// AckBeep is currently a no-op until audio support is implemented for ESP32-S3.
-func (*speaker) AckBeep() {}
+// writeScaled applies the volume to samples and queues them.
+func (spk *speaker) writeScaled(samples []int16) (int, error) {
+	gain := spk.gain
+	if spk.muted {
+		gain = 0
+	}
+	n := 0
+	for n < len(samples) {
+		chunk := spk.buf[:min(len(spk.buf), len(samples)-n)]
+		scaleSamples(chunk, samples[n:], gain)
+		m, err := spk.transport.Write(chunk)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
 
-// NakBeep is currently a no-op until audio support is implemented for ESP32-S3.
-func (*speaker) NakBeep() {}
+func (spk *speaker) sampleRate() uint32 {
+	return spk.conv.callerRate(sharedAudioConfig.SampleRate)
+}
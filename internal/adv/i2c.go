@@ -2,7 +2,10 @@
 
 package adv
 
-import "machine"
+import (
+	"machine"
+	"time"
+)
 
 const (
 	SharedI2CSDA = machine.GPIO8
@@ -33,3 +36,31 @@ func SharedI2C() (*machine.I2C, error) {
 	sharedI2CConfigured = true
 	return sharedI2C, nil
 }
+
+// ResetSharedI2C recovers the shared bus after a peripheral was left holding
+// SDA low, for example by a reset in the middle of a transfer. It clocks SCL
+// until SDA is released, issues a STOP condition and reconfigures the bus.
+func ResetSharedI2C() error {
+	SharedI2CSDA.Configure(machine.PinConfig{Mode: machine.PinInputPullup})
+	SharedI2CSCL.Configure(machine.PinConfig{Mode: machine.PinOutput})
+	SharedI2CSCL.High()
+	for i := 0; i < 9 && !SharedI2CSDA.Get(); i++ {
+		SharedI2CSCL.Low()
+		time.Sleep(5 * time.Microsecond)
+		SharedI2CSCL.High()
+		time.Sleep(5 * time.Microsecond)
+	}
+
+	// STOP: SDA rises while SCL is high
+	SharedI2CSDA.Configure(machine.PinConfig{Mode: machine.PinOutput})
+	SharedI2CSCL.Low()
+	SharedI2CSDA.Low()
+	time.Sleep(5 * time.Microsecond)
+	SharedI2CSCL.High()
+	time.Sleep(5 * time.Microsecond)
+	SharedI2CSDA.High()
+
+	sharedI2CConfigured = false
+	_, err := SharedI2C()
+	return err
+}
@@ -0,0 +1,107 @@
+package esp32i2s
+
+import "errors"
+
+// SourceClock is the PLL_F160M clock the I2S module clock is divided from.
+const SourceClock = 160_000_000
+
+// pdmOversample is the PDM clock as a multiple of the sample rate.
+const pdmOversample = 64
+
+// maxDenominator bounds the fractional part of the MCLK divider.
+const maxDenominator = 63
+
+var errClock = errors.New("sample rate out of range for the i2s clock")
+
+// clockConfig holds the divider settings for one sample format.
+type clockConfig struct {
+	// mclk is the module clock in hertz.
+	mclk uint32
+	// div, num and den divide SourceClock down to mclk as div + num/den.
+	div, num, den uint32
+	// bckDiv divides mclk down to the bit clock.
+	bckDiv uint32
+}
+
+// clocks works out the dividers for rate with slotBits-wide stereo slots and
+// an MCLK of multiple times rate.
+func clocks(rate, slotBits, multiple uint32) (clockConfig, error) {
+	if rate == 0 || multiple == 0 {
+		return clockConfig{}, errClock
+	}
+	return dividers(rate*multiple, rate*slotBits*2)
+}
+
+// pdmClocks works out the dividers for PDM input at rate. The PDM clock runs
+// at pdmOversample times rate and MCLK at eight times that.
+func pdmClocks(rate uint32) (clockConfig, error) {
+	if rate == 0 {
+		return clockConfig{}, errClock
+	}
+	return dividers(rate*pdmOversample*8, rate*pdmOversample)
+}
+
+func dividers(mclk, bclk uint32) (clockConfig, error) {
+	if mclk%bclk != 0 || mclk/bclk < 2 || mclk/bclk > 64 {
+		return clockConfig{}, errClock
+	}
+	div, num, den := mclkDivider(SourceClock, mclk)
+	if div < 2 || div > 255 {
+		return clockConfig{}, errClock
+	}
+	return clockConfig{mclk: mclk, div: div, num: num, den: den, bckDiv: mclk / bclk}, nil
+}
+
+// mclkDivider approximates src/mclk as div + num/den with den no greater than
+// maxDenominator, rounding up to the next integer when the fraction is within
+// half a step of it.
+func mclkDivider(src, mclk uint32) (div, num, den uint32) {
+	div, rem := src/mclk, src%mclk
+	if rem == 0 {
+		return div, 0, 1
+	}
+	if uint64(rem)*2*maxDenominator > uint64(mclk)*(2*maxDenominator-1) {
+		return div + 1, 0, 1
+	}
+	var bestDiff, bestDen uint64
+	for a := uint64(2); a <= maxDenominator; a++ {
+		b := (uint64(rem)*a*2 + uint64(mclk)) / (uint64(mclk) * 2)
+		ma, mb := uint64(rem)*a, uint64(mclk)*b
+		diff := ma - mb
+		if mb > ma {
+			diff = mb - ma
+		}
+		// The error is diff/(mclk*a); compare across denominators.
+		if bestDen == 0 || diff*bestDen < bestDiff*a {
+			bestDiff, bestDen = diff, a
+			num, den = uint32(b), uint32(a)
+		}
+		if diff == 0 {
+			break
+		}
+	}
+	if num == den {
+		return div + 1, 0, 1
+	}
+	return div, num, den
+}
+
+// divConf encodes the fractional part num/den into the X, Y, Z and YN1
+// fields of I2S_*_CLKM_DIV_CONF.
+func divConf(num, den uint32) uint32 {
+	if num == 0 || den == 0 {
+		return 0
+	}
+	var x, y, z, yn1 uint32
+	if num > den/2 {
+		x = den/(den-num) - 1
+		y = den % (den - num)
+		z = den - num
+		yn1 = i2sDivYN1
+	} else {
+		x = den/num - 1
+		y = den%num + 1
+		z = num
+	}
+	return (x&i2sDivFields)<<i2sDivXPos | (y&i2sDivFields)<<i2sDivYPos | (z&i2sDivFields)<<i2sDivZPos | yn1
+}
@@ -0,0 +1,100 @@
+package esp32i2s
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// Descriptor is a GDMA linked-list descriptor. The DMA engine reads and
+// writes it in place, so it must stay at a fixed address in internal RAM
+// while the channel runs.
+type Descriptor struct {
+	// Config holds the buffer size, the valid length, and the EOF and owner
+	// flags.
+	Config uint32
+	// Buf is the bus address of the buffer.
+	Buf uint32
+	// Next is the bus address of the next descriptor, or 0.
+	Next uint32
+}
+
+// Descriptor Config fields.
+const (
+	descSizeMask  = 0xFFF
+	descLengthPos = 12
+	descSucEOF    = 1 << 30
+	descOwnerDMA  = 1 << 31
+
+	// MaxBufferSize is the largest buffer a single descriptor can describe.
+	MaxBufferSize = descSizeMask &^ 3
+)
+
+func (d *Descriptor) ownedByDMA() bool {
+	return atomic.LoadUint32(&d.Config)&descOwnerDMA != 0
+}
+
+func (d *Descriptor) length() int {
+	return int(atomic.LoadUint32(&d.Config)>>descLengthPos) & descSizeMask
+}
+
+// give hands the descriptor to the DMA engine with length valid bytes.
+func (d *Descriptor) give(size, length int) {
+	atomic.StoreUint32(&d.Config, uint32(size)|uint32(length)<<descLengthPos|descSucEOF|descOwnerDMA)
+}
+
+// arm hands an empty descriptor to the DMA engine to fill.
+func (d *Descriptor) arm(size int) {
+	atomic.StoreUint32(&d.Config, uint32(size)|descOwnerDMA)
+}
+
+// take marks the descriptor as owned by the CPU.
+func (d *Descriptor) take(size int) {
+	atomic.StoreUint32(&d.Config, uint32(size))
+}
+
+// ring is a circular chain of descriptors over one backing buffer, used for
+// one direction of the link.
+type ring struct {
+	descs []Descriptor
+	buf   []byte
+	size  int
+	// next is the descriptor the CPU fills (TX) or drains (RX) next.
+	next int
+	// off is how much of descs[next] has been drained.
+	off     int
+	running bool
+}
+
+// build allocates count buffers of size bytes and links their descriptors
+// into a loop, all owned by the CPU.
+func (r *ring) build(count, size int, addr func(unsafe.Pointer) uint32) {
+	if len(r.descs) != count || r.size != size {
+		r.descs = make([]Descriptor, count)
+		r.buf = make([]byte, count*size)
+		r.size = size
+	}
+	for i := range r.descs {
+		d := &r.descs[i]
+		d.take(size)
+		d.Buf = addr(unsafe.Pointer(&r.buf[i*size]))
+		d.Next = addr(unsafe.Pointer(&r.descs[(i+1)%count]))
+	}
+	r.next, r.off, r.running = 0, 0, false
+}
+
+// chunk returns the buffer of descriptor i.
+func (r *ring) chunk(i int) []byte {
+	return r.buf[i*r.size : (i+1)*r.size]
+}
+
+// firstOwned returns the first descriptor from next onwards that the DMA
+// engine owns, which is where a stalled channel has to resume, or -1.
+func (r *ring) firstOwned() int {
+	for i := range r.descs {
+		j := (r.next + i) % len(r.descs)
+		if r.descs[j].ownedByDMA() {
+			return j
+		}
+	}
+	return -1
+}
@@ -0,0 +1,448 @@
+// Package esp32i2s drives the ESP32-S3 I2S0 peripheral as a standard I2S
+// (Philips) master, or as a PDM microphone input. Samples stream through
+// rings of GDMA descriptors, so Write and Read only block while the rings
+// are full or empty, and both directions can run at once sharing one bit
+// clock.
+//
+// All hardware access goes through Regs, which lets the clock and descriptor
+// handling be tested on the host against a fake.
+package esp32i2s // import "github.com/sparques/cardputer/internal/esp32i2s"
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/sparques/cardputer/pcm"
+)
+
+// NoPin marks an unused signal in Pins.
+const NoPin = noPin
+
+// Pins names the GPIOs carrying the I2S signals.
+type Pins struct {
+	// MCLK, BCLK and WS are driven by the chip. MCLK may be NoPin when the
+	// codec clocks itself from BCLK.
+	MCLK, BCLK, WS uint8
+	// DOut carries samples to the codec and DIn from it. Either may be NoPin
+	// for a one-way link.
+	DOut, DIn uint8
+}
+
+// Config describes the I2S link.
+type Config struct {
+	// SampleRate is the frame rate in hertz.
+	SampleRate uint32
+	// BitsPerSample is the codec word size. 16 uses 16-bit slots; anything
+	// wider uses 32-bit slots with the sample in the top bits.
+	BitsPerSample uint8
+	// Channels is 1 or 2. Mono samples are sent in both slots and read from
+	// the left one.
+	Channels uint8
+	// MCLKMultiple is MCLK as a multiple of SampleRate. 0 means 256.
+	MCLKMultiple uint32
+	// Pins routes the signals.
+	Pins Pins
+	// BufferSize is the size in bytes of each DMA buffer, 0 meaning 512, and
+	// Buffers how many make up each ring, 0 meaning 4.
+	BufferSize, Buffers int
+	// DMAChannel is the GDMA channel used for both directions.
+	DMAChannel uint8
+	// PDM receives from a PDM microphone instead, converting to 16-bit PCM.
+	// WS carries the PDM clock and DIn the data; BCLK and DOut are unused.
+	PDM bool
+}
+
+var (
+	errConfig    = errors.New("invalid i2s configuration")
+	errNoTX      = errors.New("i2s output is not configured")
+	errNoRX      = errors.New("i2s input is not configured")
+	errTimeout   = errors.New("i2s dma timed out")
+	pollInterval = time.Millisecond
+	dmaTimeout   = time.Second
+)
+
+// I2S is the I2S0 controller with one GDMA channel.
+type I2S struct {
+	regs  Regs
+	addr  func(unsafe.Pointer) uint32
+	sleep func(time.Duration)
+
+	txMu, rxMu sync.Mutex
+	cfg        Config
+	clk        clockConfig
+	slotBytes  int
+	// stride is the bytes one caller sample takes in a buffer.
+	stride int
+	tx, rx ring
+}
+
+// New returns an I2S using regs, with addr giving the bus address of memory
+// handed to the DMA engine.
+func New(regs Regs, addr func(unsafe.Pointer) uint32) *I2S {
+	return &I2S{regs: regs, addr: addr, sleep: time.Sleep}
+}
+
+// Configure stops any running transfer, programs the clocks, format, DMA
+// channel and pins from cfg, and allocates the descriptor rings. Nothing is
+// clocked out until the first Write or Read.
+func (s *I2S) Configure(cfg Config) error {
+	if cfg.BitsPerSample == 0 {
+		cfg.BitsPerSample = 16
+	}
+	if cfg.Channels == 0 {
+		cfg.Channels = 1
+	}
+	if cfg.MCLKMultiple == 0 {
+		cfg.MCLKMultiple = 256
+	}
+	if cfg.BufferSize == 0 {
+		cfg.BufferSize = 512
+	}
+	if cfg.Buffers == 0 {
+		cfg.Buffers = 4
+	}
+	slotBytes := pcm.SlotWidth(cfg.BitsPerSample)
+	if cfg.PDM {
+		slotBytes = 2
+	}
+	slotBits := uint32(8 * slotBytes)
+	if cfg.BitsPerSample > 32 || cfg.Channels > 2 || cfg.DMAChannel >= gdmaChannels ||
+		cfg.Buffers < 2 || cfg.BufferSize > MaxBufferSize || cfg.BufferSize%(2*slotBytes) != 0 ||
+		!validPins(cfg) {
+		return errConfig
+	}
+	var clk clockConfig
+	var err error
+	if cfg.PDM {
+		clk, err = pdmClocks(cfg.SampleRate)
+	} else {
+		clk, err = clocks(cfg.SampleRate, slotBits, cfg.MCLKMultiple)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+	s.rxMu.Lock()
+	defer s.rxMu.Unlock()
+
+	s.stop()
+	s.cfg, s.clk, s.slotBytes = cfg, clk, slotBytes
+	s.stride = slotBytes * int(3-cfg.Channels)
+	s.configureI2S(slotBits)
+	s.configureDMA()
+	s.route()
+
+	if cfg.Pins.DOut != NoPin {
+		s.tx.build(cfg.Buffers, cfg.BufferSize, s.addr)
+	} else {
+		s.tx = ring{}
+	}
+	if cfg.Pins.DIn != NoPin {
+		s.rx.build(cfg.Buffers, cfg.BufferSize, s.addr)
+	} else {
+		s.rx = ring{}
+	}
+	return nil
+}
+
+func validPins(cfg Config) bool {
+	p := cfg.Pins
+	for _, pin := range [...]uint8{p.MCLK, p.BCLK, p.WS, p.DOut, p.DIn} {
+		if pin != NoPin && pin > maxGPIO {
+			return false
+		}
+	}
+	if cfg.PDM {
+		return p.WS != NoPin && p.DIn != NoPin && p.DOut == NoPin
+	}
+	return p.BCLK != NoPin && p.WS != NoPin
+}
+
+func (s *I2S) duplex() bool {
+	return s.cfg.Pins.DOut != NoPin && s.cfg.Pins.DIn != NoPin
+}
+
+func (s *I2S) configureI2S(slotBits uint32) {
+	r := s.regs
+	setBits(r, systemPeripClkEn0, systemI2S0)
+	setBits(r, systemPeripRstEn0, systemI2S0)
+	clearBits(r, systemPeripRstEn0, systemI2S0)
+	setBits(r, systemPeripClkEn1, systemDMA)
+	clearBits(r, systemPeripRstEn1, systemDMA)
+
+	for _, conf := range [...]uint32{i2sTxConf, i2sRxConf} {
+		r.Store(conf, i2sConfReset|i2sConfFIFOReset)
+		r.Store(conf, 0)
+	}
+
+	clkm := s.clk.div | i2sClkmActive | i2sClkmSelPLL160M<<i2sClkmSelPos
+	r.Store(i2sTxClkmConf, clkm|i2sClkmEnable)
+	r.Store(i2sRxClkmConf, clkm)
+	r.Store(i2sTxClkmDivConf, divConf(s.clk.num, s.clk.den))
+	r.Store(i2sRxClkmDivConf, divConf(s.clk.num, s.clk.den))
+
+	bits := slotBits - 1
+	conf1 := bits | (s.clk.bckDiv-1)<<i2sConf1BckDivPos | bits<<i2sConf1BitsPos |
+		bits<<i2sConf1HalfPos | bits<<i2sConf1ChanPos | i2sConf1MSBShift
+	r.Store(i2sTxConf1, conf1|i2sTxConf1BckNoDly)
+	r.Store(i2sRxConf1, conf1)
+
+	tdm := uint32(i2sTDMChan0 | i2sTDMChan1 | 1<<i2sTDMTotChanPos)
+	r.Store(i2sTxTDMCtrl, tdm)
+	r.Store(i2sRxTDMCtrl, tdm)
+	r.Store(i2sRxEOFNum, uint32(s.cfg.BufferSize*8)/slotBits-1)
+
+	// The S3 runs standard I2S as two-slot TDM.
+	txConf := uint32(i2sConfPCMBypass | i2sConfLeftAlign | i2sConfTDM)
+	if s.duplex() {
+		// The receiver runs off the transmitter's BCLK and WS.
+		txConf |= i2sTxConfLoopback
+	}
+	rxConf := uint32(i2sConfPCMBypass | i2sConfLeftAlign | i2sConfTDM)
+	if s.cfg.PDM {
+		rxConf = i2sConfPCMBypass | i2sRxConfPDM | i2sRxConfPDM2PCM
+	}
+	r.Store(i2sTxConf, txConf)
+	s.update(i2sTxConf)
+	r.Store(i2sRxConf, rxConf)
+	s.update(i2sRxConf)
+}
+
+// update latches the configuration written to conf into the clock domain.
+func (s *I2S) update(conf uint32) {
+	setBits(s.regs, conf, i2sConfUpdate)
+	for n := 0; n < 1000 && s.regs.Load(conf)&i2sConfUpdate != 0; n++ {
+	}
+}
+
+func (s *I2S) configureDMA() {
+	r, ch := s.regs, s.cfg.DMAChannel
+	for _, conf := range [...]uint32{gdmaOutConf0, gdmaInConf0} {
+		r.Store(gdmaReg(ch, conf), gdmaReset)
+		r.Store(gdmaReg(ch, conf), 0)
+	}
+	r.Store(gdmaReg(ch, gdmaOutConf0), gdmaOutAutoWrite|gdmaOutEOFMode)
+	r.Store(gdmaReg(ch, gdmaOutConf1), gdmaCheckOwner)
+	r.Store(gdmaReg(ch, gdmaInConf1), gdmaCheckOwner)
+	r.Store(gdmaReg(ch, gdmaOutPeriSel), gdmaPeriSelI2S0)
+	r.Store(gdmaReg(ch, gdmaInPeriSel), gdmaPeriSelI2S0)
+	r.Store(gdmaReg(ch, gdmaOutIntClr), gdmaAllInterrupts)
+	r.Store(gdmaReg(ch, gdmaInIntClr), gdmaAllInterrupts)
+}
+
+// route connects the I2S signals to their pins through the GPIO matrix.
+func (s *I2S) route() {
+	p := s.cfg.Pins
+	bck, ws := uint32(sigI2S0OBck), uint32(sigI2S0OWS)
+	if p.DOut == NoPin {
+		// Receive only: the receiver generates the clocks itself, the PDM
+		// clock included.
+		bck, ws = sigI2S0IBck, sigI2S0IWS
+	}
+	s.output(p.MCLK, sigI2S0MCLK)
+	s.output(p.BCLK, bck)
+	s.output(p.WS, ws)
+	s.output(p.DOut, sigI2S0OSD)
+	s.input(p.DIn, sigI2S0ISD)
+}
+
+func (s *I2S) output(pin uint8, sig uint32) {
+	if pin == NoPin {
+		return
+	}
+	mux := ioMuxGPIO + 4*uint32(pin)
+	s.regs.Store(mux, s.regs.Load(mux)&^(ioMuxMCUSel|ioMuxFunIE|3<<ioMuxDrvPos)|ioMuxFuncGPIO|2<<ioMuxDrvPos)
+	s.regs.Store(gpioFuncOutSel+4*uint32(pin), sig|gpioOutOENSel)
+	if pin < gpioBankSize {
+		s.regs.Store(gpioEnableW1TS, 1<<pin)
+	} else {
+		s.regs.Store(gpioEnable1W1TS, 1<<(pin-gpioBankSize))
+	}
+}
+
+func (s *I2S) input(pin uint8, sig uint32) {
+	if pin == NoPin {
+		return
+	}
+	mux := ioMuxGPIO + 4*uint32(pin)
+	s.regs.Store(mux, s.regs.Load(mux)&^ioMuxMCUSel|ioMuxFuncGPIO|ioMuxFunIE)
+	s.regs.Store(gpioFuncInSel+4*sig, uint32(pin)|gpioInSelMatrix)
+	if pin < gpioBankSize {
+		s.regs.Store(gpioEnableW1TC, 1<<pin)
+	} else {
+		s.regs.Store(gpioEnable1W1TC, 1<<(pin-gpioBankSize))
+	}
+}
+
+// Write queues samples for output, blocking while the ring is full. Each
+// call hands its samples to the DMA engine straight away, so short writes
+// play without waiting for a buffer to fill up.
+func (s *I2S) Write(samples []int16) (int, error) {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+	if len(s.tx.descs) == 0 {
+		return 0, errNoTX
+	}
+	n := 0
+	for n < len(samples) {
+		i := s.tx.next
+		d := &s.tx.descs[i]
+		if err := s.wait(d, s.kickTx); err != nil {
+			return n, err
+		}
+		buf := s.tx.chunk(i)
+		m := len(samples) - n
+		if room := len(buf) / s.stride; m > room {
+			m = room
+		}
+		for j, v := range samples[n : n+m] {
+			s.put(buf[j*s.stride:], v)
+		}
+		d.give(s.tx.size, m*s.stride)
+		s.tx.next = (i + 1) % len(s.tx.descs)
+		n += m
+		s.kickTx()
+	}
+	return n, nil
+}
+
+// Read fills samples with input, blocking until enough has arrived. The
+// receiver starts on the first call; input that arrives while the ring is
+// full is dropped.
+func (s *I2S) Read(samples []int16) (int, error) {
+	s.rxMu.Lock()
+	defer s.rxMu.Unlock()
+	if len(s.rx.descs) == 0 {
+		return 0, errNoRX
+	}
+	if !s.rx.running {
+		for i := range s.rx.descs {
+			s.rx.descs[i].arm(s.rx.size)
+		}
+		s.rx.next, s.rx.off = 0, 0
+		s.kickRx()
+	}
+	n := 0
+	for n < len(samples) {
+		i := s.rx.next
+		d := &s.rx.descs[i]
+		if err := s.wait(d, s.kickRx); err != nil {
+			return n, err
+		}
+		buf := s.rx.chunk(i)[:d.length()]
+		for n < len(samples) && len(buf)-s.rx.off >= s.stride {
+			samples[n] = s.get(buf[s.rx.off:])
+			s.rx.off += s.stride
+			n++
+		}
+		if len(buf)-s.rx.off < s.stride {
+			d.arm(s.rx.size)
+			s.rx.next, s.rx.off = (i+1)%len(s.rx.descs), 0
+			s.kickRx()
+		}
+	}
+	return n, nil
+}
+
+// wait blocks until d is owned by the CPU, restarting a stalled channel with
+// kick while it waits. TX descriptors come back once sent; RX ones once
+// filled.
+func (s *I2S) wait(d *Descriptor, kick func()) error {
+	for waited := time.Duration(0); d.ownedByDMA(); waited += pollInterval {
+		if waited >= dmaTimeout {
+			return errTimeout
+		}
+		kick()
+		s.sleep(pollInterval)
+	}
+	return nil
+}
+
+// kickTx starts the output channel at the oldest queued descriptor if it
+// has not started yet or stalled on one the CPU still owned.
+func (s *I2S) kickTx() {
+	r, ch := s.regs, s.cfg.DMAChannel
+	if s.tx.running {
+		if r.Load(gdmaReg(ch, gdmaOutIntRaw))&gdmaOutHalted == 0 {
+			return
+		}
+		r.Store(gdmaReg(ch, gdmaOutIntClr), gdmaOutHalted)
+	}
+	j := s.tx.firstOwned()
+	if j < 0 {
+		s.tx.running = false
+		return
+	}
+	link := gdmaReg(ch, gdmaOutLink)
+	r.Store(link, gdmaOutLinkStop)
+	r.Store(link, s.addr(unsafe.Pointer(&s.tx.descs[j]))&gdmaLinkAddrMask|gdmaOutLinkStart)
+	setBits(r, i2sTxConf, i2sConfStart)
+	s.tx.running = true
+}
+
+// kickRx starts the input channel at the first empty descriptor if it has
+// not started yet or stalled because the ring filled up.
+func (s *I2S) kickRx() {
+	r, ch := s.regs, s.cfg.DMAChannel
+	if s.rx.running {
+		if r.Load(gdmaReg(ch, gdmaInIntRaw))&gdmaInHalted == 0 {
+			return
+		}
+		r.Store(gdmaReg(ch, gdmaInIntClr), gdmaInHalted)
+	}
+	j := s.rx.firstOwned()
+	if j < 0 {
+		s.rx.running = false
+		return
+	}
+	link := gdmaReg(ch, gdmaInLink)
+	r.Store(link, gdmaInLinkStop)
+	r.Store(link, s.addr(unsafe.Pointer(&s.rx.descs[j]))&gdmaLinkAddrMask|gdmaInLinkStart)
+	if s.duplex() {
+		// The shared clocks come from the transmitter.
+		setBits(r, i2sTxConf, i2sConfStart)
+	}
+	setBits(r, i2sRxConf, i2sConfStart)
+	s.rx.running = true
+}
+
+// Stop halts both directions and drops queued samples.
+func (s *I2S) Stop() {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+	s.rxMu.Lock()
+	defer s.rxMu.Unlock()
+	s.stop()
+}
+
+func (s *I2S) stop() {
+	if s.tx.running || s.rx.running {
+		r, ch := s.regs, s.cfg.DMAChannel
+		r.Store(gdmaReg(ch, gdmaOutLink), gdmaOutLinkStop)
+		r.Store(gdmaReg(ch, gdmaInLink), gdmaInLinkStop)
+		clearBits(r, i2sTxConf, i2sConfStart)
+		clearBits(r, i2sRxConf, i2sConfStart)
+	}
+	for _, rg := range [...]*ring{&s.tx, &s.rx} {
+		for i := range rg.descs {
+			rg.descs[i].take(rg.size)
+		}
+		rg.next, rg.off, rg.running = 0, 0, false
+	}
+}
+
+// put stores v in the slots of one caller sample at b.
+func (s *I2S) put(b []byte, v int16) {
+	for i := 0; i < s.stride; i += s.slotBytes {
+		pcm.PutSample(b[i:], v, s.slotBytes)
+	}
+}
+
+// get returns the sample in the first slot at b.
+func (s *I2S) get(b []byte) int16 {
+	return pcm.Sample(b, s.slotBytes)
+}
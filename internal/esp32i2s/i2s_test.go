@@ -0,0 +1,346 @@
+package esp32i2s
+
+import (
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// fakeChip is a register file plus a GDMA engine that walks the descriptor
+// rings the way the hardware does, one descriptor per step.
+type fakeChip struct {
+	regs map[uint32]uint32
+	// mem maps fake bus addresses to the memory they stand for.
+	mem      map[uint32]unsafe.Pointer
+	addrs    map[unsafe.Pointer]uint32
+	nextAddr uint32
+
+	out, in   uint32 // descriptor each channel is on, 0 when stopped
+	sent      []byte
+	inputNext byte
+}
+
+func newFakeChip() *fakeChip {
+	return &fakeChip{
+		regs:     make(map[uint32]uint32),
+		mem:      make(map[uint32]unsafe.Pointer),
+		addrs:    make(map[unsafe.Pointer]uint32),
+		nextAddr: 0x3FC88000,
+	}
+}
+
+func (c *fakeChip) Load(addr uint32) uint32 {
+	return c.regs[addr]
+}
+
+func (c *fakeChip) Store(addr, value uint32) {
+	switch addr {
+	case i2sTxConf, i2sRxConf:
+		value &^= i2sConfUpdate // latched at once
+	case gdmaReg(0, gdmaOutLink):
+		if value&gdmaOutLinkStart != 0 {
+			c.out = c.resolve(value)
+		} else if value&gdmaOutLinkStop != 0 {
+			c.out = 0
+		}
+	case gdmaReg(0, gdmaInLink):
+		if value&gdmaInLinkStart != 0 {
+			c.in = c.resolve(value)
+		} else if value&gdmaInLinkStop != 0 {
+			c.in = 0
+		}
+	case gdmaReg(0, gdmaOutIntClr):
+		c.regs[gdmaReg(0, gdmaOutIntRaw)] &^= value
+	case gdmaReg(0, gdmaInIntClr):
+		c.regs[gdmaReg(0, gdmaInIntRaw)] &^= value
+	}
+	c.regs[addr] = value
+}
+
+// resolve turns the 20-bit address in a link register into a full one.
+func (c *fakeChip) resolve(link uint32) uint32 {
+	addr := 0x3FC00000 | link&gdmaLinkAddrMask
+	if _, ok := c.mem[addr]; !ok {
+		panic("link to unknown descriptor")
+	}
+	return addr
+}
+
+func (c *fakeChip) addr(p unsafe.Pointer) uint32 {
+	if a, ok := c.addrs[p]; ok {
+		return a
+	}
+	a := c.nextAddr
+	c.nextAddr += 16
+	c.addrs[p], c.mem[a] = a, p
+	return a
+}
+
+func (c *fakeChip) desc(addr uint32) *Descriptor {
+	return (*Descriptor)(c.mem[addr])
+}
+
+func (c *fakeChip) buf(d *Descriptor, n int) []byte {
+	return unsafe.Slice((*byte)(c.mem[d.Buf]), n)
+}
+
+// step moves each running channel along by one descriptor.
+func (c *fakeChip) step(time.Duration) {
+	if c.out != 0 {
+		d := c.desc(c.out)
+		if !d.ownedByDMA() {
+			c.regs[gdmaReg(0, gdmaOutIntRaw)] |= gdmaOutDscrErr
+			c.out = 0
+		} else {
+			c.sent = append(c.sent, c.buf(d, d.length())...)
+			d.Config &^= descOwnerDMA
+			c.out = d.Next
+		}
+	}
+	if c.in != 0 {
+		d := c.desc(c.in)
+		if !d.ownedByDMA() {
+			c.regs[gdmaReg(0, gdmaInIntRaw)] |= gdmaInDscrErr
+			c.in = 0
+		} else {
+			size := int(d.Config & descSizeMask)
+			b := c.buf(d, size)
+			for i := range b {
+				b[i] = c.inputNext
+				c.inputNext++
+			}
+			d.Config = uint32(size) | uint32(size)<<descLengthPos | descSucEOF
+			c.in = d.Next
+		}
+	}
+}
+
+func newTestI2S(t *testing.T, cfg Config) (*I2S, *fakeChip) {
+	t.Helper()
+	chip := newFakeChip()
+	s := New(chip, chip.addr)
+	s.sleep = chip.step
+	if err := s.Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return s, chip
+}
+
+var testPins = Pins{MCLK: NoPin, BCLK: 41, WS: 43, DOut: 42, DIn: 46}
+
+func TestClocks(t *testing.T) {
+	for _, tt := range []struct {
+		rate, bits    uint32
+		div, num, den uint32
+		bckDiv        uint32
+	}{
+		{16000, 16, 39, 1, 16, 8},
+		{48000, 16, 13, 1, 48, 8},
+		{32000, 32, 19, 17, 32, 4},
+		{8000, 16, 78, 1, 8, 8},
+	} {
+		c, err := clocks(tt.rate, tt.bits, 256)
+		if err != nil {
+			t.Errorf("clocks(%d): %v", tt.rate, err)
+			continue
+		}
+		if c.div != tt.div || c.num != tt.num || c.den != tt.den || c.bckDiv != tt.bckDiv {
+			t.Errorf("clocks(%d, %d) = %d+%d/%d bck/%d; want %d+%d/%d bck/%d", tt.rate, tt.bits,
+				c.div, c.num, c.den, c.bckDiv, tt.div, tt.num, tt.den, tt.bckDiv)
+		}
+	}
+
+	// 44.1kHz has no exact divider; it should land within 0.01%.
+	c, err := clocks(44100, 16, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := float64(SourceClock) / (float64(c.div) + float64(c.num)/float64(c.den)) / 256
+	if got < 44100*0.9999 || got > 44100*1.0001 {
+		t.Errorf("44.1kHz runs at %.1fHz (%d+%d/%d)", got, c.div, c.num, c.den)
+	}
+
+	for _, rate := range []uint32{0, 1000, 1000000} {
+		if _, err := clocks(rate, 16, 256); err == nil {
+			t.Errorf("clocks(%d) succeeded", rate)
+		}
+	}
+}
+
+func TestDivConf(t *testing.T) {
+	for _, tt := range []struct {
+		num, den uint32
+		x, y, z  uint32
+		yn1      bool
+	}{
+		{1, 16, 15, 1, 1, false},
+		{3, 4, 3, 0, 1, true},
+		{0, 1, 0, 0, 0, false},
+	} {
+		v := divConf(tt.num, tt.den)
+		x, y, z := v>>i2sDivXPos&i2sDivFields, v>>i2sDivYPos&i2sDivFields, v>>i2sDivZPos&i2sDivFields
+		if x != tt.x || y != tt.y || z != tt.z || (v&i2sDivYN1 != 0) != tt.yn1 {
+			t.Errorf("divConf(%d, %d) = x%d y%d z%d yn1 %v", tt.num, tt.den, x, y, z, v&i2sDivYN1 != 0)
+		}
+	}
+}
+
+func TestConfigureRegisters(t *testing.T) {
+	_, chip := newTestI2S(t, Config{SampleRate: 16000, Pins: testPins})
+
+	for _, tt := range []struct {
+		name      string
+		addr      uint32
+		mask, val uint32
+	}{
+		{"tx clkm div", i2sTxClkmConf, 0xFF, 39},
+		{"tx clkm source", i2sTxClkmConf, 3 << i2sClkmSelPos, i2sClkmSelPLL160M << i2sClkmSelPos},
+		{"tx bck div", i2sTxConf1, 0x3F << i2sConf1BckDivPos, 7 << i2sConf1BckDivPos},
+		{"tx bits", i2sTxConf1, 0x1F << i2sConf1BitsPos, 15 << i2sConf1BitsPos},
+		{"rx bits", i2sRxConf1, 0x1F << i2sConf1BitsPos, 15 << i2sConf1BitsPos},
+		{"loopback", i2sTxConf, i2sTxConfLoopback, i2sTxConfLoopback},
+		{"rx eof", i2sRxEOFNum, 0xFFF, 255},
+		{"out peri", gdmaReg(0, gdmaOutPeriSel), 0x3F, gdmaPeriSelI2S0},
+		{"in peri", gdmaReg(0, gdmaInPeriSel), 0x3F, gdmaPeriSelI2S0},
+		{"bclk pin", gpioFuncOutSel + 4*41, 0x1FF, sigI2S0OBck},
+		{"ws pin", gpioFuncOutSel + 4*43, 0x1FF, sigI2S0OWS},
+		{"dout pin", gpioFuncOutSel + 4*42, 0x1FF, sigI2S0OSD},
+		{"din pin", gpioFuncInSel + 4*sigI2S0ISD, 0xFF, 46 | gpioInSelMatrix},
+	} {
+		if got := chip.regs[tt.addr] & tt.mask; got != tt.val {
+			t.Errorf("%s: %#x, want %#x", tt.name, got, tt.val)
+		}
+	}
+	if chip.regs[i2sTxConf]&i2sConfStart != 0 {
+		t.Errorf("transmitter started before any samples were written")
+	}
+
+	for _, cfg := range []Config{
+		{SampleRate: 16000, Pins: Pins{BCLK: NoPin, WS: 43, DOut: 42, DIn: NoPin}},
+		{SampleRate: 16000, Pins: testPins, BufferSize: 6},
+		{SampleRate: 16000, Pins: testPins, Channels: 3},
+	} {
+		if err := New(chip, chip.addr).Configure(cfg); err == nil {
+			t.Errorf("Configure(%+v) succeeded", cfg)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	s, chip := newTestI2S(t, Config{SampleRate: 16000, Pins: testPins, BufferSize: 16, Buffers: 3})
+
+	// Mono samples go out in both slots. 20 samples need five descriptors,
+	// so the ring of three has to come round.
+	samples := make([]int16, 20)
+	for i := range samples {
+		samples[i] = int16(0x0100*i + i)
+	}
+	n, err := s.Write(samples)
+	if n != len(samples) || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	for chip.out != 0 {
+		chip.step(0)
+	}
+	if chip.regs[i2sTxConf]&i2sConfStart == 0 {
+		t.Errorf("transmitter not started")
+	}
+
+	// The channel stalled on an empty descriptor; the next Write restarts it
+	// where it stopped.
+	if chip.regs[gdmaReg(0, gdmaOutIntRaw)]&gdmaOutDscrErr == 0 {
+		t.Fatalf("channel didn't stall")
+	}
+	if _, err := s.Write([]int16{-2}); err != nil {
+		t.Fatal(err)
+	}
+	chip.step(0)
+	samples = append(samples, -2)
+
+	if len(chip.sent) != 4*len(samples) {
+		t.Fatalf("sent %d bytes, want %d", len(chip.sent), 4*len(samples))
+	}
+	for i, v := range samples {
+		for slot := 0; slot < 2; slot++ {
+			b := chip.sent[4*i+2*slot:]
+			if got := int16(b[0]) | int16(b[1])<<8; got != v {
+				t.Fatalf("sample %d slot %d = %#x, want %#x", i, slot, got, v)
+			}
+		}
+	}
+}
+
+func TestReadDuplex(t *testing.T) {
+	s, chip := newTestI2S(t, Config{SampleRate: 16000, Pins: testPins, BufferSize: 8, Buffers: 2, Channels: 2})
+
+	// Ask for more than the ring holds so Read has to hand descriptors back
+	// and the channel has to continue past a stall.
+	got := make([]int16, 12)
+	n, err := s.Read(got)
+	if n != len(got) || err != nil {
+		t.Fatalf("Read = %d, %v", n, err)
+	}
+	if chip.regs[i2sRxConf]&i2sConfStart == 0 || chip.regs[i2sTxConf]&i2sConfStart == 0 {
+		t.Errorf("receiver and shared clock not started")
+	}
+	for i, v := range got {
+		lo := byte(2 * i)
+		if want := int16(lo) | int16(lo+1)<<8; v != want {
+			t.Errorf("sample %d = %#x, want %#x", i, v, want)
+		}
+	}
+
+	// Output runs alongside.
+	if _, err := s.Write([]int16{7, 8}); err != nil {
+		t.Fatal(err)
+	}
+	chip.step(0)
+	if len(chip.sent) != 4 || chip.sent[0] != 7 || chip.sent[2] != 8 {
+		t.Errorf("sent %v", chip.sent)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	// Each buffer holds two mono samples.
+	s, _ := newTestI2S(t, Config{SampleRate: 16000, Pins: testPins, BufferSize: 8, Buffers: 2})
+	s.sleep = func(time.Duration) {} // the DMA engine never moves
+	n, err := s.Write(make([]int16, 8))
+	if err != errTimeout || n != 4 {
+		t.Errorf("Write = %d, %v; want 4, %v", n, err, errTimeout)
+	}
+}
+
+func TestPDM(t *testing.T) {
+	s, chip := newTestI2S(t, Config{
+		SampleRate: 16000,
+		PDM:        true,
+		Pins:       Pins{MCLK: NoPin, BCLK: NoPin, WS: 43, DOut: NoPin, DIn: 46},
+	})
+	for _, tt := range []struct {
+		name      string
+		addr      uint32
+		mask, val uint32
+	}{
+		{"rx mode", i2sRxConf, i2sConfTDM | i2sRxConfPDM | i2sRxConfPDM2PCM, i2sRxConfPDM | i2sRxConfPDM2PCM},
+		{"rx clkm div", i2sRxClkmConf, 0xFF, 19},
+		{"rx clkm frac", i2sRxClkmDivConf, ^uint32(0), divConf(17, 32)},
+		{"rx bck div", i2sRxConf1, 0x3F << i2sConf1BckDivPos, 7 << i2sConf1BckDivPos},
+		{"clock pin", gpioFuncOutSel + 4*43, 0x1FF, sigI2S0IWS},
+		{"loopback", i2sTxConf, i2sTxConfLoopback, 0},
+	} {
+		if got := chip.regs[tt.addr] & tt.mask; got != tt.val {
+			t.Errorf("%s: %#x, want %#x", tt.name, got, tt.val)
+		}
+	}
+	if _, err := s.Write([]int16{1}); err != errNoTX {
+		t.Errorf("Write on PDM input = %v, want %v", err, errNoTX)
+	}
+	if n, err := s.Read(make([]int16, 300)); n != 300 || err != nil {
+		t.Errorf("Read = %d, %v", n, err)
+	}
+
+	bad := Config{SampleRate: 16000, PDM: true, Pins: testPins}
+	if err := New(chip, chip.addr).Configure(bad); err == nil {
+		t.Errorf("PDM accepted an output pin")
+	}
+}
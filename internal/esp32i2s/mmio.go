@@ -0,0 +1,28 @@
+//go:build esp32 || esp32s3
+
+package esp32i2s
+
+import (
+	"runtime/volatile"
+	"unsafe"
+)
+
+// I2S0 is the chip's first I2S controller.
+var I2S0 = New(mmio{}, busAddr)
+
+// mmio accesses the registers directly.
+type mmio struct{}
+
+func (mmio) Load(addr uint32) uint32 {
+	return volatile.LoadUint32((*uint32)(unsafe.Add(nil, addr)))
+}
+
+func (mmio) Store(addr, value uint32) {
+	volatile.StoreUint32((*uint32)(unsafe.Add(nil, addr)), value)
+}
+
+// busAddr returns the address of p as the DMA engine sees it. Internal RAM
+// sits at the same address on the data bus.
+func busAddr(p unsafe.Pointer) uint32 {
+	return uint32(uintptr(p))
+}
@@ -0,0 +1,161 @@
+package esp32i2s
+
+// Regs reads and writes 32-bit peripheral registers by bus address. On the
+// chip this is memory-mapped I/O; tests substitute a fake.
+type Regs interface {
+	Load(addr uint32) uint32
+	Store(addr, value uint32)
+}
+
+// System registers gating the peripheral clocks.
+const (
+	systemPeripClkEn0 = 0x600C0018
+	systemPeripClkEn1 = 0x600C001C
+	systemPeripRstEn0 = 0x600C0020
+	systemPeripRstEn1 = 0x600C0024
+
+	systemI2S0 = 1 << 4 // in CLK_EN0/RST_EN0
+	systemDMA  = 1 << 6 // in CLK_EN1/RST_EN1
+)
+
+// I2S0 registers.
+const (
+	i2sBase = 0x6000F000
+
+	i2sRxConf        = i2sBase + 0x20
+	i2sTxConf        = i2sBase + 0x24
+	i2sRxConf1       = i2sBase + 0x28
+	i2sTxConf1       = i2sBase + 0x2C
+	i2sRxClkmConf    = i2sBase + 0x30
+	i2sTxClkmConf    = i2sBase + 0x34
+	i2sRxClkmDivConf = i2sBase + 0x38
+	i2sTxClkmDivConf = i2sBase + 0x3C
+	i2sRxTDMCtrl     = i2sBase + 0x50
+	i2sTxTDMCtrl     = i2sBase + 0x54
+	i2sRxEOFNum      = i2sBase + 0x64
+)
+
+// I2S_TX_CONF and I2S_RX_CONF bits. The layouts agree apart from
+// SIG_LOOPBACK, which only exists in TX_CONF.
+const (
+	i2sConfReset       = 1 << 0
+	i2sConfFIFOReset   = 1 << 1
+	i2sConfStart       = 1 << 2
+	i2sConfUpdate      = 1 << 8
+	i2sConfPCMBypass   = 1 << 12
+	i2sConfLeftAlign   = 1 << 15
+	i2sConfTDM         = 1 << 19
+	i2sRxConfPDM       = 1 << 20
+	i2sRxConfPDM2PCM   = 1 << 21
+	i2sTxConfLoopback  = 1 << 27
+	i2sConf1BckDivPos  = 7
+	i2sConf1BitsPos    = 13
+	i2sConf1HalfPos    = 18
+	i2sConf1ChanPos    = 24
+	i2sConf1MSBShift   = 1 << 29
+	i2sTxConf1BckNoDly = 1 << 30
+)
+
+// I2S_TX_CLKM_CONF and I2S_RX_CLKM_CONF fields.
+const (
+	i2sClkmActive     = 1 << 26
+	i2sClkmSelPos     = 27
+	i2sClkmSelPLL160M = 2
+	i2sClkmEnable     = 1 << 29 // TX only; the same bit in RX picks the MCLK source
+)
+
+// I2S_*_CLKM_DIV_CONF fields.
+const (
+	i2sDivZPos   = 0
+	i2sDivYPos   = 9
+	i2sDivXPos   = 18
+	i2sDivYN1    = 1 << 27
+	i2sDivFields = 0x1FF
+)
+
+// I2S_*_TDM_CTRL fields.
+const (
+	i2sTDMChan0      = 1 << 0
+	i2sTDMChan1      = 1 << 1
+	i2sTDMTotChanPos = 16
+)
+
+// GDMA registers, relative to a channel's block.
+const (
+	gdmaBase         = 0x6003F000
+	gdmaChannelSize  = 0xC0
+	gdmaChannels     = 5
+	gdmaInConf0      = 0x00
+	gdmaInConf1      = 0x04
+	gdmaInIntRaw     = 0x08
+	gdmaInIntClr     = 0x14
+	gdmaInLink       = 0x20
+	gdmaInPeriSel    = 0x48
+	gdmaOutConf0     = 0x60
+	gdmaOutConf1     = 0x64
+	gdmaOutIntRaw    = 0x68
+	gdmaOutIntClr    = 0x74
+	gdmaOutLink      = 0x80
+	gdmaOutPeriSel   = 0xA8
+	gdmaPeriSelI2S0  = 3
+	gdmaCheckOwner   = 1 << 12 // IN_CONF1 and OUT_CONF1
+	gdmaReset        = 1 << 0  // IN_CONF0 and OUT_CONF0
+	gdmaOutAutoWrite = 1 << 2
+	gdmaOutEOFMode   = 1 << 3
+
+	gdmaLinkAddrMask  = 0xFFFFF
+	gdmaOutLinkStop   = 1 << 20
+	gdmaOutLinkStart  = 1 << 21
+	gdmaInLinkStop    = 1 << 21
+	gdmaInLinkStart   = 1 << 22
+	gdmaOutDscrErr    = 1 << 2
+	gdmaInDscrErr     = 1 << 3
+	gdmaInDscrEmpty   = 1 << 4
+	gdmaInHalted      = gdmaInDscrErr | gdmaInDscrEmpty
+	gdmaOutHalted     = gdmaOutDscrErr
+	gdmaAllInterrupts = 0x3FF
+)
+
+// GPIO matrix and IO MUX registers used to route the I2S signals.
+const (
+	gpioEnableW1TS  = 0x60004024
+	gpioEnableW1TC  = 0x60004028
+	gpioEnable1W1TS = 0x60004030
+	gpioEnable1W1TC = 0x60004034
+	gpioFuncInSel   = 0x60004154 // + 4*signal
+	gpioFuncOutSel  = 0x60004554 // + 4*pin
+	gpioInSelMatrix = 1 << 7
+	gpioOutOENSel   = 1 << 10
+
+	ioMuxGPIO     = 0x60009004 // + 4*pin
+	ioMuxFunIE    = 1 << 9
+	ioMuxDrvPos   = 10
+	ioMuxMCUSel   = 7 << 12
+	ioMuxFuncGPIO = 1 << 12
+)
+
+// GPIO matrix signal numbers of I2S0.
+const (
+	sigI2S0OBck  = 12
+	sigI2S0MCLK  = 13
+	sigI2S0OWS   = 14
+	sigI2S0OSD   = 15 // output
+	sigI2S0ISD   = 15 // input
+	sigI2S0IBck  = 16
+	sigI2S0IWS   = 17
+	noPin        = 0xFF
+	maxGPIO      = 48
+	gpioBankSize = 32
+)
+
+func gdmaReg(ch uint8, off uint32) uint32 {
+	return gdmaBase + uint32(ch)*gdmaChannelSize + off
+}
+
+func setBits(r Regs, addr, bits uint32) {
+	r.Store(addr, r.Load(addr)|bits)
+}
+
+func clearBits(r Regs, addr, bits uint32) {
+	r.Store(addr, r.Load(addr)&^bits)
+}
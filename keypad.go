@@ -13,3 +13,14 @@ var KP = &kp{
 type kp struct {
 	*keypad.Device
 }
+
+// Macros records and replays keystroke macros on KP, saving each one as a
+// text file under /macros on SDFS. Bind chords with Macros.Bind, then call
+// Macros.Attach(KP.Device) once KP's own callbacks are set.
+var Macros = newMacros()
+
+func newMacros() *keypad.Macros {
+	m := keypad.NewMacros(SDFS.OpenFile, "/macros")
+	m.Mkdir = SDFS.Mkdir
+	return m
+}
@@ -0,0 +1,450 @@
+package keypad
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSequenceTimeout is how long Bindings waits for the next chord of a
+	// multi-chord sequence before abandoning it.
+	DefaultSequenceTimeout = 1500 * time.Millisecond
+	// DefaultLongPressDuration is how long a chord must be held to trigger a
+	// LongPress binding.
+	DefaultLongPressDuration = 600 * time.Millisecond
+)
+
+// Gesture selects how the final chord of a Binding must be pressed.
+type Gesture uint8
+
+const (
+	// Tap triggers when the chord is pressed. If the same chord also has a
+	// LongPress or DoubleTap binding, Tap is deferred until those are ruled out.
+	Tap Gesture = iota
+	// LongPress triggers once the chord has been held for LongPressDuration.
+	LongPress
+	// DoubleTap triggers when the chord is pressed twice within DoubleTapPeriod.
+	DoubleTap
+)
+
+// Binding associates a chord or sequence of chords with an action.
+type Binding struct {
+	// Keys is the sequence of chords that triggers the binding. A single entry
+	// binds a plain chord such as BtnCtrl|BtnS; several entries bind an
+	// Emacs-style sequence such as {BtnCtrl|BtnX, BtnCtrl|BtnS}.
+	Keys []int64
+	// Gesture applies to the final chord of Keys.
+	Gesture Gesture
+	// Action is called when the binding triggers.
+	Action func()
+}
+
+// Keymap is a named table of bindings, typically one per application mode.
+type Keymap struct {
+	// Name identifies the keymap.
+	Name string
+	// Priority orders active keymaps; higher priorities are consulted first.
+	Priority int
+	// Fallthrough passes chords this keymap doesn't bind on to lower priority
+	// keymaps and finally to (*Bindings).Fallback. A keymap without
+	// Fallthrough swallows every chord it doesn't bind.
+	Fallthrough bool
+
+	// mu guards bindings, which may be changed while the keymap is active.
+	mu       sync.Mutex
+	bindings []Binding
+}
+
+// NewKeymap returns an empty *Keymap.
+func NewKeymap(name string, priority int, fallThrough bool) *Keymap {
+	return &Keymap{
+		Name:        name,
+		Priority:    priority,
+		Fallthrough: fallThrough,
+	}
+}
+
+// Bind binds action to a chord or sequence of chords using the Tap gesture.
+func (k *Keymap) Bind(action func(), keys ...int64) {
+	k.BindGesture(Tap, action, keys...)
+}
+
+// BindGesture binds action to a chord or sequence of chords using gesture.
+// An existing binding for the same keys and gesture is replaced.
+func (k *Keymap) BindGesture(gesture Gesture, action func(), keys ...int64) {
+	if len(keys) == 0 {
+		return
+	}
+	keys = append([]int64(nil), keys...)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for i := range k.bindings {
+		if k.bindings[i].Gesture == gesture && equalChords(k.bindings[i].Keys, keys) {
+			k.bindings[i].Action = action
+			return
+		}
+	}
+	k.bindings = append(k.bindings, Binding{Keys: keys, Gesture: gesture, Action: action})
+}
+
+// Unbind removes every binding for the given chord or sequence of chords.
+func (k *Keymap) Unbind(keys ...int64) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	n := 0
+	for _, b := range k.bindings {
+		if !equalChords(b.Keys, keys) {
+			k.bindings[n] = b
+			n++
+		}
+	}
+	k.bindings = k.bindings[:n]
+}
+
+// Bindings dispatches keypad chords to the actions of its active keymaps.
+// Use Attach to drive it from a *Device.
+type Bindings struct {
+	// SequenceTimeout is how long to wait for the next chord of a sequence.
+	SequenceTimeout time.Duration
+	// LongPressDuration is how long a chord must be held for LongPress.
+	LongPressDuration time.Duration
+	// DoubleTapPeriod is the longest gap between the taps of a DoubleTap.
+	DoubleTapPeriod time.Duration
+	// Fallback receives chords that no keymap consumed, such as plain text
+	// input. Attach defaults it to writing the chord's bytes to the Device's
+	// Receiver, the same way WriteByteCallback does.
+	Fallback func(chord int64)
+
+	mu      sync.Mutex
+	keymaps []*Keymap
+	run     []func()
+	timer   stopper
+	// clock times gestures and schedules expiry checks. Attach takes the
+	// Device's, so a Simulator drives it on virtual time.
+	clock clock
+
+	// pending is the prefix of a sequence typed so far.
+	pending     []int64
+	pendingTap  func()
+	seqDeadline time.Time
+	// held is a chord that is still down and may become a LongPress.
+	held *gestureCandidate
+	// tapped is a released chord that may become a DoubleTap.
+	tapped *gestureCandidate
+}
+
+type gestureCandidate struct {
+	chord    int64
+	tap      func()
+	long     func()
+	double   func()
+	deadline time.Time
+	fired    bool
+}
+
+type bindingMatch struct {
+	tap, long, double func()
+	found             bool
+	prefix            bool
+	swallow           bool
+}
+
+// NewBindings returns an empty *Bindings using the package default timings.
+func NewBindings() *Bindings {
+	return &Bindings{
+		SequenceTimeout:   DefaultSequenceTimeout,
+		LongPressDuration: DefaultLongPressDuration,
+		DoubleTapPeriod:   DefaultDoubleTapPeriod,
+		clock:             realClock{},
+	}
+}
+
+// Attach makes b handle d's button presses and releases. Release events are
+// still forwarded to the previous EventReleaseCallback.
+func (b *Bindings) Attach(d *Device) {
+	if b.Fallback == nil {
+		b.Fallback = d.writeState
+	}
+	b.mu.Lock()
+	b.clock = d.clock
+	b.mu.Unlock()
+	release := d.EventReleaseCallback
+	d.EventPressCallback = func(p int64) {
+		b.Press(p, d.Held())
+	}
+	d.EventReleaseCallback = func(r int64) {
+		b.Release(d.Held())
+		if release != nil {
+			release(r)
+		}
+	}
+}
+
+// Push activates a keymap. Keymaps of equal priority are consulted most
+// recently pushed first.
+func (b *Bindings) Push(k *Keymap) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.keymaps = append([]*Keymap{k}, b.keymaps...)
+	sort.SliceStable(b.keymaps, func(i, j int) bool {
+		return b.keymaps[i].Priority > b.keymaps[j].Priority
+	})
+}
+
+// Remove deactivates a keymap.
+func (b *Bindings) Remove(k *Keymap) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range b.keymaps {
+		if b.keymaps[i] == k {
+			b.keymaps = append(b.keymaps[:i], b.keymaps[i+1:]...)
+			return
+		}
+	}
+}
+
+// Keymap returns the active keymap with the given name, or nil.
+func (b *Bindings) Keymap(name string) *Keymap {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, k := range b.keymaps {
+		if k.Name == name {
+			return k
+		}
+	}
+	return nil
+}
+
+// Press reports that the buttons in pressed went down, leaving chord held.
+func (b *Bindings) Press(pressed, chord int64) {
+	b.mu.Lock()
+	b.press(pressed, chord, b.clock.Now())
+	b.unlock()
+}
+
+// Release reports that buttons went up, leaving chord held.
+func (b *Bindings) Release(chord int64) {
+	b.mu.Lock()
+	b.release(chord, b.clock.Now())
+	b.unlock()
+}
+
+// unlock releases mu and then runs the actions collected while it was held,
+// so actions are free to modify b.
+func (b *Bindings) unlock() {
+	run := b.run
+	b.run = nil
+	b.schedule()
+	b.mu.Unlock()
+	for _, f := range run {
+		f()
+	}
+}
+
+func (b *Bindings) press(pressed, chord int64, now time.Time) {
+	b.expire(now)
+	if h := b.held; h != nil && pressed == chord && chord == h.chord {
+		// key repeat of a chord that may still become a LongPress or
+		// DoubleTap
+		return
+	}
+	modsOnly := pressed&^BtnSpecialMask == 0
+
+	// re-pressing the modifiers of a tapped chord doesn't rule out a double tap
+	if t := b.tapped; t != nil && !(modsOnly && chord != t.chord && chord&t.chord == chord) {
+		b.tapped = nil
+		if t.chord == chord {
+			b.fire(t.double)
+			return
+		}
+		b.fireTap(t)
+	}
+	if h := b.held; h != nil {
+		b.held = nil
+		if !h.fired {
+			b.fireTap(h)
+		}
+	}
+
+	seq := []int64{chord}
+	if !modsOnly {
+		seq = append(append([]int64(nil), b.pending...), chord)
+	}
+	m := b.lookup(seq)
+	if modsOnly {
+		// lone modifiers never start or break a sequence
+		m.prefix = false
+	}
+
+	switch {
+	case m.prefix:
+		b.pending = seq
+		b.pendingTap = m.tap
+		b.seqDeadline = now.Add(b.SequenceTimeout)
+	case m.found:
+		b.clearPending(modsOnly)
+		c := &gestureCandidate{chord: chord, tap: m.tap, long: m.long, double: m.double}
+		switch {
+		case m.long != nil:
+			c.deadline = now.Add(b.LongPressDuration)
+			b.held = c
+		case m.double != nil:
+			b.held = c
+		default:
+			b.fire(m.tap)
+		}
+	case m.swallow:
+		b.clearPending(modsOnly)
+	case len(b.pending) > 0 && !modsOnly:
+		// an unbound continuation abandons the sequence
+		b.clearPending(false)
+	default:
+		b.fallback(chord)
+	}
+}
+
+func (b *Bindings) release(chord int64, now time.Time) {
+	b.expire(now)
+
+	h := b.held
+	if h == nil || chord&h.chord == h.chord {
+		return
+	}
+	b.held = nil
+	switch {
+	case h.fired:
+	case h.double != nil:
+		h.deadline = now.Add(b.DoubleTapPeriod)
+		b.tapped = h
+	default:
+		b.fireTap(h)
+	}
+}
+
+// expire triggers every gesture and sequence whose deadline has passed.
+func (b *Bindings) expire(now time.Time) {
+	if h := b.held; h != nil && h.long != nil && !h.fired && !now.Before(h.deadline) {
+		h.fired = true
+		b.fire(h.long)
+	}
+	if t := b.tapped; t != nil && !now.Before(t.deadline) {
+		b.tapped = nil
+		b.fireTap(t)
+	}
+	if len(b.pending) > 0 && !now.Before(b.seqDeadline) {
+		tap := b.pendingTap
+		b.clearPending(false)
+		b.fire(tap)
+	}
+}
+
+// schedule arms the timer for the earliest outstanding deadline.
+func (b *Bindings) schedule() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	var next time.Time
+	consider := func(t time.Time) {
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+	if h := b.held; h != nil && h.long != nil && !h.fired {
+		consider(h.deadline)
+	}
+	if b.tapped != nil {
+		consider(b.tapped.deadline)
+	}
+	if len(b.pending) > 0 {
+		consider(b.seqDeadline)
+	}
+	if next.IsZero() {
+		return
+	}
+	b.timer = b.clock.AfterFunc(next.Sub(b.clock.Now()), func() {
+		b.mu.Lock()
+		b.expire(b.clock.Now())
+		b.unlock()
+	})
+}
+
+func (b *Bindings) lookup(seq []int64) bindingMatch {
+	for _, k := range b.keymaps {
+		if m := k.match(seq); m.found || m.prefix {
+			return m
+		}
+		if !k.Fallthrough {
+			return bindingMatch{swallow: true}
+		}
+	}
+	return bindingMatch{}
+}
+
+// match returns k's bindings for seq and whether seq begins a longer one.
+func (k *Keymap) match(seq []int64) bindingMatch {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	var m bindingMatch
+	for _, bd := range k.bindings {
+		switch {
+		case equalChords(bd.Keys, seq):
+			m.found = true
+			switch bd.Gesture {
+			case LongPress:
+				m.long = bd.Action
+			case DoubleTap:
+				m.double = bd.Action
+			default:
+				m.tap = bd.Action
+			}
+		case len(bd.Keys) > len(seq) && equalChords(bd.Keys[:len(seq)], seq):
+			m.prefix = true
+		}
+	}
+	return m
+}
+
+func (b *Bindings) clearPending(keep bool) {
+	if keep {
+		return
+	}
+	b.pending = nil
+	b.pendingTap = nil
+	b.seqDeadline = time.Time{}
+}
+
+// fireTap runs a candidate's Tap action, falling back to text input when the
+// chord only has LongPress or DoubleTap bindings.
+func (b *Bindings) fireTap(c *gestureCandidate) {
+	if c.tap != nil {
+		b.fire(c.tap)
+		return
+	}
+	b.fallback(c.chord)
+}
+
+func (b *Bindings) fire(f func()) {
+	if f != nil {
+		b.run = append(b.run, f)
+	}
+}
+
+func (b *Bindings) fallback(chord int64) {
+	if fb := b.Fallback; fb != nil {
+		b.run = append(b.run, func() { fb(chord) })
+	}
+}
+
+func equalChords(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
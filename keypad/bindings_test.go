@@ -0,0 +1,218 @@
+package keypad
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type stopFunc func() bool
+
+func (f stopFunc) Stop() bool { return f() }
+
+// stoppedClock is a clock whose timers never fire.
+type stoppedClock struct{}
+
+func (stoppedClock) Now() time.Time { return time.Time{} }
+
+func (stoppedClock) AfterFunc(time.Duration, func()) stopper {
+	return stopFunc(func() bool { return true })
+}
+
+// newTestBindings returns a *Bindings whose timers never fire; tests advance
+// time by calling press, release and expire directly.
+func newTestBindings() (*Bindings, *[]int64) {
+	b := NewBindings()
+	b.clock = stoppedClock{}
+	var fallback []int64
+	b.Fallback = func(chord int64) {
+		fallback = append(fallback, chord)
+	}
+	return b, &fallback
+}
+
+// step runs one locked operation and then any collected actions.
+func step(b *Bindings, f func()) {
+	b.mu.Lock()
+	f()
+	b.unlock()
+}
+
+func tap(b *Bindings, chord int64, now time.Time) {
+	step(b, func() { b.press(chord&^BtnSpecialMask, chord, now) })
+	step(b, func() { b.release(0, now.Add(10*time.Millisecond)) })
+}
+
+func TestBindingsChord(t *testing.T) {
+	b, fallback := newTestBindings()
+	var saved int
+	k := NewKeymap("edit", 0, true)
+	k.Bind(func() { saved++ }, BtnCtrl|BtnS)
+	b.Push(k)
+
+	now := time.Now()
+	tap(b, BtnCtrl|BtnS, now)
+	tap(b, BtnS, now)
+
+	if saved != 1 {
+		t.Fatalf("Ctrl+S fired %d times, want 1", saved)
+	}
+	if len(*fallback) != 1 || (*fallback)[0] != BtnS {
+		t.Fatalf("fallback = %v, want [%d]", *fallback, int64(BtnS))
+	}
+}
+
+func TestBindingsSequence(t *testing.T) {
+	b, fallback := newTestBindings()
+	var saved int
+	k := NewKeymap("emacs", 0, true)
+	k.Bind(func() { saved++ }, BtnCtrl|BtnX, BtnCtrl|BtnS)
+	b.Push(k)
+
+	now := time.Now()
+	tap(b, BtnCtrl|BtnX, now)
+	tap(b, BtnCtrl|BtnS, now.Add(100*time.Millisecond))
+	if saved != 1 {
+		t.Fatalf("C-x C-s fired %d times, want 1", saved)
+	}
+
+	tap(b, BtnCtrl|BtnX, now.Add(time.Second))
+	tap(b, BtnCtrl|BtnS, now.Add(time.Second+b.SequenceTimeout+time.Millisecond))
+	if saved != 1 {
+		t.Fatalf("C-x C-s fired after timeout, count %d", saved)
+	}
+	if len(*fallback) != 1 || (*fallback)[0] != BtnCtrl|BtnS {
+		t.Fatalf("fallback = %v, want [%d]", *fallback, int64(BtnCtrl|BtnS))
+	}
+}
+
+func TestBindingsLongPress(t *testing.T) {
+	b, _ := newTestBindings()
+	var taps, longs int
+	k := NewKeymap("menu", 0, true)
+	k.Bind(func() { taps++ }, BtnEnter)
+	k.BindGesture(LongPress, func() { longs++ }, BtnEnter)
+	b.Push(k)
+
+	now := time.Now()
+	tap(b, BtnEnter, now)
+	if taps != 1 || longs != 0 {
+		t.Fatalf("short press taps=%d longs=%d, want 1, 0", taps, longs)
+	}
+
+	later := now.Add(time.Second)
+	step(b, func() { b.press(BtnEnter, BtnEnter, later) })
+	step(b, func() { b.expire(later.Add(b.LongPressDuration)) })
+	step(b, func() { b.release(0, later.Add(2*b.LongPressDuration)) })
+	if taps != 1 || longs != 1 {
+		t.Fatalf("long press taps=%d longs=%d, want 1, 1", taps, longs)
+	}
+}
+
+func TestBindingsLongPressRepeat(t *testing.T) {
+	s := NewSimulator()
+	b := NewBindings()
+	var taps, longs int
+	b.Fallback = func(int64) { taps++ }
+	k := NewKeymap("menu", 0, true)
+	k.BindGesture(LongPress, func() { longs++ }, BtnEnter)
+	b.Push(k)
+	b.Attach(s.Device)
+
+	// held past RepeatDelay and LongPressDuration, so repeats come first
+	s.Down(BtnEnter)
+	s.Advance(700 * time.Millisecond)
+	s.Up(BtnEnter)
+	if taps != 0 || longs != 1 {
+		t.Fatalf("held with repeat taps=%d longs=%d, want 0, 1", taps, longs)
+	}
+
+	s.Down(BtnEnter)
+	s.Advance(100 * time.Millisecond)
+	s.Up(BtnEnter)
+	if taps != 1 || longs != 1 {
+		t.Fatalf("short press taps=%d longs=%d, want 1, 1", taps, longs)
+	}
+}
+
+// TestKeymapRebindWhileActive changes an active keymap from another goroutine
+// while keys are dispatched; run it with -race.
+func TestKeymapRebindWhileActive(t *testing.T) {
+	s := NewSimulator()
+	b := NewBindings()
+	b.Fallback = func(int64) {}
+	k := NewKeymap("edit", 0, true)
+	b.Push(k)
+	b.Attach(s.Device)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			k.Bind(func() {}, BtnCtrl|BtnS)
+			k.Unbind(BtnCtrl | BtnS)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		s.Down(BtnCtrl | BtnS)
+		s.Up(BtnCtrl | BtnS)
+	}
+	wg.Wait()
+}
+
+func TestBindingsDoubleTap(t *testing.T) {
+	b, _ := newTestBindings()
+	var taps, doubles int
+	k := NewKeymap("viewer", 0, true)
+	k.Bind(func() { taps++ }, BtnSpace)
+	k.BindGesture(DoubleTap, func() { doubles++ }, BtnSpace)
+	b.Push(k)
+
+	now := time.Now()
+	tap(b, BtnSpace, now)
+	tap(b, BtnSpace, now.Add(100*time.Millisecond))
+	if taps != 0 || doubles != 1 {
+		t.Fatalf("double tap taps=%d doubles=%d, want 0, 1", taps, doubles)
+	}
+
+	later := now.Add(time.Second)
+	tap(b, BtnSpace, later)
+	step(b, func() { b.expire(later.Add(time.Second)) })
+	if taps != 1 || doubles != 1 {
+		t.Fatalf("single tap taps=%d doubles=%d, want 1, 1", taps, doubles)
+	}
+}
+
+func TestBindingsPriorityAndFallthrough(t *testing.T) {
+	b, fallback := newTestBindings()
+	var base, dialog int
+	global := NewKeymap("global", 0, true)
+	global.Bind(func() { base++ }, BtnEsc)
+	global.Bind(func() { base++ }, BtnCtrl|BtnQ)
+	modal := NewKeymap("dialog", 10, false)
+	modal.Bind(func() { dialog++ }, BtnEsc)
+	b.Push(global)
+	b.Push(modal)
+
+	now := time.Now()
+	tap(b, BtnEsc, now)
+	tap(b, BtnCtrl|BtnQ, now)
+	tap(b, BtnA, now)
+	if dialog != 1 || base != 0 || len(*fallback) != 0 {
+		t.Fatalf("modal dialog=%d base=%d fallback=%v, want 1, 0, []", dialog, base, *fallback)
+	}
+
+	modal.Fallthrough = true
+	tap(b, BtnCtrl|BtnQ, now)
+	tap(b, BtnA, now)
+	if base != 1 || len(*fallback) != 1 {
+		t.Fatalf("fallthrough base=%d fallback=%v, want 1, [%d]", base, *fallback, int64(BtnA))
+	}
+
+	b.Remove(modal)
+	tap(b, BtnEsc, now)
+	if base != 2 {
+		t.Fatalf("after Remove base=%d, want 2", base)
+	}
+}
@@ -227,6 +227,7 @@ var (
 
 		// Other Fn buttons
 		BtnEsc: []byte{'\x1b'},
+		BtnDel: []byte{0x1b, '[', '3', '~'},
 		BtnF1:  []byte{0x1b, 'O', 'P'},
 		BtnF2:  []byte{0x1b, 'O', 'Q'},
 		BtnF3:  []byte{0x1b, 'O', 'R'},
@@ -0,0 +1,333 @@
+package keypad
+
+import (
+	"io"
+	"time"
+)
+
+var (
+	// The fatest typist in the word can type (on a full sized, QWERTY Keyboard) just under
+	// 1000 characters per minute. That's about 16.67 Hz or a period of 60 ms.
+	// A DefaultScanPeriod of 20 ms is comfortably below that threshold.
+	// 20ms scan period means two key presses can be registered in 60ms; the first 20ms
+	// are needed to detect the press, the next 20 to detect the release, and the final
+	// 20 to detect the second press.
+	DefaultScanPeriod time.Duration = 20 * time.Millisecond
+)
+
+const (
+	// DefaultRepeatDelay is how long a key must be held before repeat events start.
+	DefaultRepeatDelay = 500 * time.Millisecond
+	// DefaultRepeatPeriod is the time between repeat events after DefaultRepeatDelay.
+	DefaultRepeatPeriod = 50 * time.Millisecond
+)
+
+// Backend reads a keyboard's hardware for a Device. Each board provides one;
+// everything else (state tracking, sticky modifiers, key repeat, injection,
+// callbacks and translation) is done by the Device.
+type Backend interface {
+	// Configure prepares the hardware. NewWithBackend calls it once and
+	// keeps the error for InitErr.
+	Configure() error
+	// Run reads the hardware until stop is closed, calling report with the
+	// Btn* bitmask of held buttons whenever it changes. Start runs it on its
+	// own goroutine; report blocks until the Device has taken the state or
+	// stop is closed. Run returns nil once stop is closed, or an error if
+	// the hardware can't be read.
+	Run(report func(held int64), stop <-chan struct{}) error
+}
+
+// Device tracks the state of a keypad read by a Backend and turns it into
+// callbacks and bytes.
+type Device struct {
+	// keyState tracks what buttons are currently pressed/released
+	keyState
+	// scanPeriod is the time a key event takes to be seen, used to pace
+	// Play and the Simulator.
+	scanPeriod time.Duration
+	// RepeatDelay controls how long a key must be held before repeat events start.
+	// A non-positive value disables key repeat.
+	RepeatDelay time.Duration
+	// RepeatPeriod controls the time between repeat events after RepeatDelay.
+	// A non-positive value disables key repeat.
+	RepeatPeriod time.Duration
+	// Receiver is an io.Writer interface that will have keypad presses written to
+	// as bytes when the EventPressCallback is set to (*Device).WriteByteCallback.
+	// Not every combination of key presses results in a character
+	Receiver io.Writer
+	// EventPressCallback is called when one or more buttons become pressed or repeated.
+	EventPressCallback func(int64)
+	// EventReleaseCallback is called when one or more buttons become released.
+	EventReleaseCallback func(int64)
+	// Sticky enables sticky modifiers when non-nil. Latched and locked
+	// modifiers are included in the mask passed to EventPressCallback.
+	Sticky *StickyKeys
+
+	backend Backend
+	// initErr is guarded by keyState's mu once Start has been called.
+	initErr error
+	// clock is the time source for d and the handlers attached to it.
+	clock clock
+	// stop is used to signal the goroutine handling the keypad to return,
+	// and exited is closed once it has. Both are guarded by keyState's mu.
+	stop, exited chan struct{}
+	// inject carries events from Inject to the keypad goroutine.
+	inject chan keyEvent
+	// hw are the buttons held according to the backend.
+	hw int64
+	// injected are the buttons held down by Inject; they are merged with hw.
+	injected int64
+
+	repeatState int64
+	nextRepeat  time.Time
+	// altBuf avoids allocating when prefixing translated keys with escape.
+	altBuf [8]byte
+}
+
+// NewWithBackend returns a new *Device reading its keys from b, which it
+// configures. A call to (*Device).Start() is needed to start reading.
+// By default, key presses will be converted into character bytes and
+// written to (*Device).Receiver if it is non-nil.
+func NewWithBackend(b Backend) *Device {
+	d := newDevice()
+	d.backend = b
+	d.initErr = b.Configure()
+	return d
+}
+
+// newDevice returns a *Device with default settings and no backend.
+func newDevice() *Device {
+	d := &Device{
+		scanPeriod:   DefaultScanPeriod,
+		RepeatDelay:  DefaultRepeatDelay,
+		RepeatPeriod: DefaultRepeatPeriod,
+		Receiver:     io.Discard,
+		clock:        realClock{},
+		inject:       make(chan keyEvent),
+	}
+	d.EventPressCallback = d.WriteByteCallback
+	return d
+}
+
+// Backend returns the backend d reads its keys from.
+func (d *Device) Backend() Backend {
+	return d.backend
+}
+
+// InitErr reports a keypad initialization failure, if one occurred.
+func (d *Device) InitErr() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.initErr
+}
+
+// Started reports whether the keypad goroutine is running.
+func (d *Device) Started() bool {
+	stop, _ := d.running()
+	return stop != nil
+}
+
+// running returns the keypad goroutine's stop and exited channels, or nils
+// when it isn't running.
+func (d *Device) running() (stop, exited chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stop, d.exited
+}
+
+// Start begins reading the keypad in the background. It does nothing if the
+// backend failed to initialize.
+func (d *Device) Start() {
+	d.mu.Lock()
+	if d.stop != nil || d.initErr != nil || d.backend == nil {
+		d.mu.Unlock()
+		return
+	}
+	stop, exited := make(chan struct{}), make(chan struct{})
+	d.stop, d.exited = stop, exited
+	d.mu.Unlock()
+
+	states := make(chan int64)
+	stopBackend := make(chan struct{})
+	done := make(chan error, 1)
+	report := func(held int64) {
+		select {
+		case states <- held:
+		case <-stopBackend:
+		}
+	}
+	go func() {
+		done <- d.backend.Run(report, stopBackend)
+	}()
+	go d.run(stop, exited, states, stopBackend, done)
+}
+
+// run is the keypad goroutine: it applies backend states and injected events
+// and fires key repeat, sleeping in between. It closes exited when it
+// returns.
+func (d *Device) run(stop, exited chan struct{}, states <-chan int64, stopBackend chan struct{}, done <-chan error) {
+	defer close(exited)
+	defer func() {
+		d.mu.Lock()
+		d.stop, d.exited = nil, nil
+		d.mu.Unlock()
+	}()
+	for {
+		var repeat <-chan time.Time
+		var timer *time.Timer
+		if d.repeatState != 0 {
+			timer = time.NewTimer(d.nextRepeat.Sub(d.clock.Now()))
+			repeat = timer.C
+		}
+
+		select {
+		case <-stop:
+			close(stopBackend)
+			<-done
+			d.clearState()
+			return
+		case err := <-done:
+			// the backend gave up; report it as an initialization failure
+			d.mu.Lock()
+			d.initErr = err
+			d.mu.Unlock()
+			d.clearState()
+			return
+		case ev := <-d.inject:
+			d.applyInjected(ev, d.clock.Now())
+		case held := <-states:
+			d.hw = held
+			d.transition(d.hw|d.injected, d.clock.Now())
+		case <-repeat:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		d.tick(d.clock.Now())
+	}
+}
+
+// Stop stops the keypad goroutine if it is running and forgets held buttons.
+// It returns once the goroutine has finished.
+func (d *Device) Stop() {
+	stop, exited := d.running()
+	if stop == nil {
+		return
+	}
+	select {
+	case stop <- struct{}{}:
+		<-exited
+	case <-exited:
+		// already stopped by a backend error
+	}
+}
+
+// applyInjected merges an injected event with the backend state.
+func (d *Device) applyInjected(ev keyEvent, now time.Time) {
+	d.injected = ev.apply(d.injected)
+	d.transition(d.hw|d.injected, now)
+}
+
+// transition moves the tracked state to next, firing the release and press
+// callbacks for whatever changed and rescheduling key repeat.
+func (d *Device) transition(next int64, now time.Time) {
+	prev, _ := d.load()
+	if next == prev {
+		return
+	}
+
+	r := released(prev, next)
+	p := pressed(prev, next)
+	mods := d.Sticky.update(p, now)
+	d.store(next, mods)
+
+	if r != 0 && d.EventReleaseCallback != nil {
+		d.EventReleaseCallback(r)
+	}
+	if p != 0 && d.EventPressCallback != nil {
+		d.EventPressCallback(p | mods)
+	}
+	d.scheduleRepeat(now)
+}
+
+// tick runs time based processing between transitions, namely key repeat.
+func (d *Device) tick(now time.Time) {
+	if d.repeatState == 0 || d.EventPressCallback == nil || d.RepeatDelay <= 0 || d.RepeatPeriod <= 0 || now.Before(d.nextRepeat) {
+		return
+	}
+	d.EventPressCallback(d.repeatState)
+	d.nextRepeat = now.Add(d.RepeatPeriod)
+}
+
+func (d *Device) scheduleRepeat(now time.Time) {
+	state, mods := d.load()
+	if state == 0 || d.RepeatDelay <= 0 || d.RepeatPeriod <= 0 {
+		d.repeatState = 0
+		d.nextRepeat = time.Time{}
+		return
+	}
+	d.repeatState = state | mods
+	d.nextRepeat = now.Add(d.RepeatDelay)
+}
+
+func (d *Device) clearState() {
+	d.store(0, 0)
+	d.hw = 0
+	d.injected = 0
+	d.repeatState = 0
+	d.nextRepeat = time.Time{}
+}
+
+// WriteByteCallback translates the current button state into bytes using ScancodeToBytes
+// and writes them to Receiver.
+func (d *Device) WriteByteCallback(int64) {
+	d.writeState(d.Held())
+}
+
+// writeState translates state into bytes using ScancodeToBytes and writes them to Receiver.
+func (d *Device) writeState(state int64) {
+	b, ok := ScancodeToBytes[state&^BtnAlt]
+	if !ok {
+		return
+	}
+	if (state & BtnAlt) == BtnAlt {
+		if len(b) < len(d.altBuf) {
+			d.altBuf[0] = 0x1b
+			copy(d.altBuf[1:], b)
+			b = d.altBuf[:len(b)+1]
+		} else {
+			b = append([]byte{0x1b}, b...)
+		}
+	}
+	d.Receiver.Write(b)
+}
+
+// clock is where a Device and the handlers attached to it get the time. The
+// Simulator substitutes a virtual one.
+type clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) stopper
+}
+
+// stopper cancels a timer started by clock.AfterFunc.
+type stopper interface {
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) stopper {
+	return time.AfterFunc(d, f)
+}
+
+func pressed(a, b int64) int64 {
+	return b - (a & b)
+}
+
+func released(a, b int64) int64 {
+	return a - (a & b)
+}
@@ -0,0 +1,133 @@
+package keypad
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBackend reports the states sent on held until stopped.
+type fakeBackend struct {
+	held       chan int64
+	configured bool
+	stopped    chan struct{}
+}
+
+func (f *fakeBackend) Configure() error {
+	f.configured = true
+	return nil
+}
+
+func (f *fakeBackend) Run(report func(held int64), stop <-chan struct{}) error {
+	defer close(f.stopped)
+	for {
+		select {
+		case <-stop:
+			return nil
+		case h := <-f.held:
+			report(h)
+		}
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe to read while the keypad goroutine writes.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestDeviceBackend(t *testing.T) {
+	fb := &fakeBackend{held: make(chan int64), stopped: make(chan struct{})}
+	d := NewWithBackend(fb)
+	if !fb.configured || d.InitErr() != nil {
+		t.Fatalf("NewWithBackend didn't configure the backend")
+	}
+	out := &syncBuffer{}
+	d.Receiver = out
+	d.RepeatDelay = 0
+	d.Start()
+	if !d.Started() {
+		t.Fatalf("Started() = false after Start")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	fb.held <- BtnShift
+	fb.held <- BtnShift | BtnH
+	if err := d.WaitFor(ctx, BtnShift|BtnH); err != nil {
+		t.Fatal(err)
+	}
+	fb.held <- 0
+	for d.State() != 0 {
+		if ctx.Err() != nil {
+			t.Fatalf("State() = %v, want none", Keys(d.State()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	d.Inject(true, BtnI)
+	d.Inject(false, BtnI)
+
+	d.Stop()
+	<-fb.stopped
+	if got := out.String(); got != "Hi" {
+		t.Fatalf("Receiver got %q, want %q", got, "Hi")
+	}
+	if d.Started() || d.State() != 0 {
+		t.Fatalf("after Stop: Started() = %v, State() = %v", d.Started(), Keys(d.State()))
+	}
+}
+
+// failingBackend's Run fails straight away.
+type failingBackend struct{}
+
+func (failingBackend) Configure() error { return nil }
+
+func (failingBackend) Run(report func(held int64), stop <-chan struct{}) error {
+	return errors.New("bus error")
+}
+
+func TestDeviceBackendError(t *testing.T) {
+	d := NewWithBackend(failingBackend{})
+	d.Start()
+	// polled while the keypad goroutine records the error; run with -race
+	for d.InitErr() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	for d.Started() {
+		time.Sleep(time.Millisecond)
+	}
+	d.Start()
+	if d.Started() {
+		t.Fatal("Start() ran a backend that had failed")
+	}
+}
+
+func TestDeviceRepeat(t *testing.T) {
+	s, out := newTestSimulator()
+	s.Down(BtnX)
+	s.Advance(DefaultRepeatDelay + 2*DefaultRepeatPeriod)
+	if got := out.String(); got != strings.Repeat("x", 3) {
+		t.Fatalf("Receiver got %q, want three x", got)
+	}
+	s.Up(BtnX)
+	s.Advance(time.Second)
+	if got := out.String(); got != strings.Repeat("x", 3) {
+		t.Fatalf("Receiver got %q after release, want three x", got)
+	}
+}
@@ -0,0 +1,296 @@
+package keypad
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sparques/cardputer/keypad/tca8418"
+	"tinygo.org/x/drivers"
+)
+
+const (
+	// CardKBAddress is the I2C address of the M5Stack CardKB.
+	CardKBAddress = 0x5F
+	// DefaultExternalPollPeriod is how often an external keyboard is read.
+	DefaultExternalPollPeriod = 20 * time.Millisecond
+	// DefaultExternalProbePeriod is how often a missing external keyboard is
+	// looked for.
+	DefaultExternalProbePeriod = time.Second
+)
+
+// externalDriver talks to one kind of external keyboard.
+type externalDriver interface {
+	// init checks the keyboard is present and configures it.
+	init() error
+	// poll reads the keyboard and reports key changes through emit.
+	poll(emit func(down bool, keys Keys)) error
+}
+
+// ExternalKeyboard polls a keyboard on an I2C bus, such as one plugged into
+// the Grove port, and merges its keys into a Device with Inject, so apps see
+// a single keyboard with the usual Btn* bitmasks, callbacks and
+// ScancodeToBytes translation. The keyboard may be unplugged and plugged back
+// in at any time: keys it was holding are released when it disappears and it
+// is reconfigured when it comes back.
+type ExternalKeyboard struct {
+	// PollPeriod is how often the keyboard is read.
+	PollPeriod time.Duration
+	// ProbePeriod is how often a missing keyboard is looked for.
+	ProbePeriod time.Duration
+	// ConnectCallback is called, if set, when the keyboard is found or lost.
+	ConnectCallback func(connected bool)
+
+	drv       externalDriver
+	d         *Device
+	nextProbe time.Time
+
+	mu        sync.Mutex
+	connected bool
+	// held are the keys injected for this keyboard and not yet released.
+	held Keys
+
+	// runMu serialises Start and Stop. stop is closed to end the polling
+	// goroutine, which closes exited once it has released its keys.
+	runMu        sync.Mutex
+	stop, exited chan struct{}
+}
+
+func newExternalKeyboard(drv externalDriver) *ExternalKeyboard {
+	return &ExternalKeyboard{
+		PollPeriod:  DefaultExternalPollPeriod,
+		ProbePeriod: DefaultExternalProbePeriod,
+		drv:         drv,
+	}
+}
+
+// NewCardKB returns an *ExternalKeyboard for an M5Stack CardKB at
+// CardKBAddress on bus. The CardKB only reports typed characters, so each is
+// merged as a tap of the keys that produce it.
+func NewCardKB(bus drivers.I2C) *ExternalKeyboard {
+	return newExternalKeyboard(&cardKB{bus: bus, address: CardKBAddress})
+}
+
+// NewTCA8418Keyboard returns an *ExternalKeyboard for a key matrix of rows by
+// cols scanned by a TCA8418 at tca8418.DefaultAddress on bus. keymap maps
+// matrix positions to buttons; nil uses AdvKeymap.
+func NewTCA8418Keyboard(bus drivers.I2C, rows, cols int, keymap func(row, col int) Keys) *ExternalKeyboard {
+	if keymap == nil {
+		keymap = AdvKeymap
+	}
+	return newExternalKeyboard(&tcaKeyboard{
+		ctrl:   tca8418.New(bus),
+		rows:   rows,
+		cols:   cols,
+		keymap: keymap,
+	})
+}
+
+// Attach makes e merge its keys into d.
+func (e *ExternalKeyboard) Attach(d *Device) {
+	e.d = d
+}
+
+// Connected reports whether the keyboard is currently present.
+func (e *ExternalKeyboard) Connected() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.connected
+}
+
+// Start begins polling the keyboard in the background.
+func (e *ExternalKeyboard) Start() {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+	if e.stop != nil {
+		return
+	}
+	e.stop, e.exited = make(chan struct{}), make(chan struct{})
+	go e.run(e.stop, e.exited)
+}
+
+// Stop stops polling and releases any keys the keyboard was holding. It
+// returns once the keys are released, so like Inject it must not be called
+// from the keypad callbacks.
+func (e *ExternalKeyboard) Stop() {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+	if e.stop == nil {
+		return
+	}
+	close(e.stop)
+	<-e.exited
+	e.stop, e.exited = nil, nil
+}
+
+func (e *ExternalKeyboard) run(stop, exited chan struct{}) {
+	defer close(exited)
+	ticker := time.NewTicker(e.PollPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			e.disconnect(false)
+			return
+		case now := <-ticker.C:
+			e.step(now)
+		}
+	}
+}
+
+// step polls a present keyboard or, when it is time, probes for a missing one.
+func (e *ExternalKeyboard) step(now time.Time) {
+	if e.Connected() {
+		if e.drv.poll(e.emit) != nil {
+			e.disconnect(true)
+			e.nextProbe = now.Add(e.ProbePeriod)
+		}
+		return
+	}
+	if now.Before(e.nextProbe) {
+		return
+	}
+	if e.drv.init() != nil {
+		e.nextProbe = now.Add(e.ProbePeriod)
+		return
+	}
+	e.setConnected(true)
+}
+
+// emit forwards a key change to the attached Device.
+func (e *ExternalKeyboard) emit(down bool, keys Keys) {
+	e.mu.Lock()
+	if down {
+		e.held |= keys
+	} else {
+		keys &= e.held
+		e.held &^= keys
+	}
+	e.mu.Unlock()
+	if keys != 0 && e.d != nil {
+		e.d.Inject(down, keys)
+	}
+}
+
+// disconnect releases held keys and, if notify is set, reports the loss.
+func (e *ExternalKeyboard) disconnect(notify bool) {
+	e.mu.Lock()
+	held := e.held
+	e.held = 0
+	was := e.connected
+	e.connected = false
+	e.mu.Unlock()
+	if held != 0 && e.d != nil {
+		e.d.Inject(false, held)
+	}
+	if notify && was && e.ConnectCallback != nil {
+		e.ConnectCallback(false)
+	}
+}
+
+func (e *ExternalKeyboard) setConnected(connected bool) {
+	e.mu.Lock()
+	e.connected = connected
+	e.mu.Unlock()
+	if e.ConnectCallback != nil {
+		e.ConnectCallback(connected)
+	}
+}
+
+// cardKB reads an M5Stack CardKB, which returns one byte per read: 0 when no
+// key was typed, otherwise the character or one of a few special codes.
+type cardKB struct {
+	bus     drivers.I2C
+	address uint16
+	buf     [1]byte
+}
+
+// cardKBKeys maps the CardKB's non-ASCII codes to buttons.
+var cardKBKeys = map[byte]Keys{
+	0x08: BtnBackspace,
+	0x09: BtnTab,
+	0x0D: BtnEnter,
+	0x1B: BtnEsc,
+	0x7F: BtnDel,
+	0xB4: BtnLeft,
+	0xB5: BtnUp,
+	0xB6: BtnDown,
+	0xB7: BtnRight,
+}
+
+func (c *cardKB) init() error {
+	// a read both probes for the keyboard and discards a stale key
+	return c.bus.Tx(c.address, nil, c.buf[:])
+}
+
+func (c *cardKB) poll(emit func(down bool, keys Keys)) error {
+	if err := c.bus.Tx(c.address, nil, c.buf[:]); err != nil {
+		return err
+	}
+	b := c.buf[0]
+	if b == 0 {
+		return nil
+	}
+	keys, ok := cardKBKeys[b]
+	if !ok {
+		keys, ok = keysForByte(b)
+	}
+	if ok {
+		emit(true, keys)
+		emit(false, keys)
+	}
+	return nil
+}
+
+// tcaKeyboard reads a key matrix scanned by a TCA8418.
+type tcaKeyboard struct {
+	ctrl       *tca8418.Device
+	rows, cols int
+	keymap     func(row, col int) Keys
+	events     []tca8418.Event
+	held       Keys
+}
+
+func (t *tcaKeyboard) init() error {
+	t.held = 0
+	if err := t.ctrl.ConfigureMatrix(t.rows, t.cols); err != nil {
+		return err
+	}
+	if err := t.ctrl.Flush(); err != nil {
+		return err
+	}
+	return t.ctrl.Configure(tca8418.CFGKeyEventInt | tca8418.CFGOverflowInt | tca8418.CFGOverflowMode)
+}
+
+func (t *tcaKeyboard) poll(emit func(down bool, keys Keys)) error {
+	events, err := t.ctrl.ReadEvents(t.events[:0])
+	t.events = events
+	switch {
+	case err == tca8418.ErrOverflow:
+		// events were lost; start again from nothing held
+		if t.held != 0 {
+			emit(false, t.held)
+			t.held = 0
+		}
+	case err != nil:
+		return err
+	}
+	for _, e := range events {
+		if !e.IsKey() {
+			continue
+		}
+		keys := t.keymap(e.RowCol())
+		if keys == 0 {
+			continue
+		}
+		if e.Pressed() {
+			t.held |= keys
+		} else {
+			t.held &^= keys
+		}
+		emit(e.Pressed(), keys)
+	}
+	if len(events) > 0 {
+		return t.ctrl.ClearInterrupts(tca8418.IntKeyEvent)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package keypad
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeCardKB answers reads with queued bytes, or fails while unplugged.
+type fakeCardKB struct {
+	unplugged bool
+	typed     []byte
+}
+
+func (f *fakeCardKB) Tx(addr uint16, w, r []byte) error {
+	if f.unplugged || addr != CardKBAddress {
+		return errors.New("nack")
+	}
+	r[0] = 0
+	if len(f.typed) > 0 {
+		r[0] = f.typed[0]
+		f.typed = f.typed[1:]
+	}
+	return nil
+}
+
+func TestCardKB(t *testing.T) {
+	s, out := newTestSimulator()
+	bus := &fakeCardKB{unplugged: true}
+	kb := NewCardKB(bus)
+	kb.Attach(s.Device)
+	var connects []bool
+	kb.ConnectCallback = func(c bool) { connects = append(connects, c) }
+
+	now := time.Unix(0, 0)
+	tick := func(n int) {
+		for i := 0; i < n; i++ {
+			now = now.Add(kb.PollPeriod)
+			kb.step(now)
+		}
+	}
+
+	tick(3)
+	if kb.Connected() {
+		t.Fatalf("connected while unplugged")
+	}
+
+	bus.unplugged = false
+	tick(int(kb.ProbePeriod / kb.PollPeriod))
+	if !kb.Connected() {
+		t.Fatalf("not connected after plugging in")
+	}
+
+	bus.typed = []byte{'H', 'i', '!', 0xB5, '\r'}
+	tick(6)
+	if got, want := out.String(), "Hi!\x1b[A\n"; got != want {
+		t.Fatalf("Receiver got %q, want %q", got, want)
+	}
+	if s.State() != 0 {
+		t.Fatalf("State() = %v after taps, want none", Keys(s.State()))
+	}
+
+	bus.unplugged = true
+	tick(1)
+	if kb.Connected() {
+		t.Fatalf("still connected after unplugging")
+	}
+	if len(connects) != 2 || !connects[0] || connects[1] {
+		t.Fatalf("ConnectCallback got %v, want [true false]", connects)
+	}
+}
+
+func TestExternalKeyboardReleasesOnUnplug(t *testing.T) {
+	s, _ := newTestSimulator()
+	kb := newExternalKeyboard(nil)
+	kb.Attach(s.Device)
+	kb.connected = true
+
+	kb.emit(true, BtnShift)
+	kb.emit(true, BtnA)
+	s.Down(BtnB)
+	if want := int64(BtnShift | BtnA | BtnB); s.State() != want {
+		t.Fatalf("State() = %v, want %v", Keys(s.State()), Keys(want))
+	}
+	kb.disconnect(true)
+	if s.State() != BtnB {
+		t.Fatalf("State() = %v after unplug, want B", Keys(s.State()))
+	}
+}
+
+// holdingDriver is a keyboard holding Shift.
+type holdingDriver struct{}
+
+func (holdingDriver) init() error { return nil }
+
+func (holdingDriver) poll(emit func(down bool, keys Keys)) error {
+	emit(true, BtnShift)
+	return nil
+}
+
+func TestExternalKeyboardStopReleases(t *testing.T) {
+	s, _ := newTestSimulator()
+	kb := newExternalKeyboard(holdingDriver{})
+	kb.PollPeriod = time.Millisecond
+	kb.Attach(s.Device)
+
+	kb.Start()
+	for !kb.Connected() || s.State() != BtnShift {
+		time.Sleep(time.Millisecond)
+	}
+	kb.Stop()
+	if s.State() != 0 {
+		t.Fatalf("State() = %v once Stop returned, want none", Keys(s.State()))
+	}
+	kb.Start()
+	kb.Stop()
+}
@@ -0,0 +1,350 @@
+// Package hid converts keypad state into USB HID boot-protocol keyboard
+// reports, so the Cardputer can type into a host computer. It is pure Go and
+// only produces reports; sending them is left to a USB HID sink.
+package hid // import "github.com/sparques/cardputer/keypad/hid"
+
+import (
+	"fmt"
+
+	"github.com/sparques/cardputer/keypad"
+)
+
+// Report is an 8 byte boot-protocol keyboard report: the modifier byte, a
+// reserved byte and the usages of up to six held keys.
+type Report [8]byte
+
+// MaxKeys is the number of keys a Report can hold at once.
+const MaxKeys = 6
+
+// Modifiers returns the report's modifier bits.
+func (r Report) Modifiers() byte {
+	return r[0]
+}
+
+// Keys returns the usages of the keys held in the report.
+func (r Report) Keys() []byte {
+	var keys []byte
+	for _, u := range r[2:] {
+		if u != 0 {
+			keys = append(keys, u)
+		}
+	}
+	return keys
+}
+
+// Modifier bits of a Report.
+const (
+	ModLeftCtrl = 1 << iota
+	ModLeftShift
+	ModLeftAlt
+	ModLeftGUI
+	ModRightCtrl
+	ModRightShift
+	ModRightAlt
+	ModRightGUI
+)
+
+// Usage IDs from the keyboard page of the HID Usage Tables.
+const (
+	UsageErrorRollOver = 0x01
+	UsageA             = 0x04 // through UsageA+25 for Z
+	Usage1             = 0x1E // through Usage1+8 for 9
+	Usage0             = 0x27
+	UsageEnter         = 0x28
+	UsageEscape        = 0x29
+	UsageBackspace     = 0x2A
+	UsageTab           = 0x2B
+	UsageSpace         = 0x2C
+	UsageMinus         = 0x2D
+	UsageEqual         = 0x2E
+	UsageLeftBracket   = 0x2F
+	UsageRightBracket  = 0x30
+	UsageBackslash     = 0x31
+	UsageSemicolon     = 0x33
+	UsageQuote         = 0x34
+	UsageGrave         = 0x35
+	UsageComma         = 0x36
+	UsagePeriod        = 0x37
+	UsageSlash         = 0x38
+	UsageF1            = 0x3A // through UsageF1+11 for F12
+	UsageDelete        = 0x4C
+	UsageRight         = 0x4F
+	UsageLeft          = 0x50
+	UsageDown          = 0x51
+	UsageUp            = 0x52
+)
+
+// modifierBits maps the keypad's modifiers to report modifier bits. Opt is
+// sent as the GUI (Windows or Command) key; Fn only selects a layer.
+var modifierBits = map[int64]byte{
+	keypad.BtnCtrl:  ModLeftCtrl,
+	keypad.BtnShift: ModLeftShift,
+	keypad.BtnAlt:   ModLeftAlt,
+	keypad.BtnOpt:   ModLeftGUI,
+}
+
+// keyUsages are the usages of the keys at the same place on a US keyboard,
+// used for chords that don't type a character, such as Ctrl+C.
+var keyUsages = map[int64]byte{
+	// Row 1
+	keypad.BtnBacktick:   UsageGrave,
+	keypad.Btn1:          Usage1,
+	keypad.Btn2:          Usage1 + 1,
+	keypad.Btn3:          Usage1 + 2,
+	keypad.Btn4:          Usage1 + 3,
+	keypad.Btn5:          Usage1 + 4,
+	keypad.Btn6:          Usage1 + 5,
+	keypad.Btn7:          Usage1 + 6,
+	keypad.Btn8:          Usage1 + 7,
+	keypad.Btn9:          Usage1 + 8,
+	keypad.Btn0:          Usage0,
+	keypad.BtnUnderscore: UsageMinus,
+	keypad.BtnEqual:      UsageEqual,
+	keypad.BtnBackspace:  UsageBackspace,
+	// Row 2
+	keypad.BtnTab:        UsageTab,
+	keypad.BtnQ:          UsageA + 'q' - 'a',
+	keypad.BtnW:          UsageA + 'w' - 'a',
+	keypad.BtnE:          UsageA + 'e' - 'a',
+	keypad.BtnR:          UsageA + 'r' - 'a',
+	keypad.BtnT:          UsageA + 't' - 'a',
+	keypad.BtnY:          UsageA + 'y' - 'a',
+	keypad.BtnU:          UsageA + 'u' - 'a',
+	keypad.BtnI:          UsageA + 'i' - 'a',
+	keypad.BtnO:          UsageA + 'o' - 'a',
+	keypad.BtnP:          UsageA + 'p' - 'a',
+	keypad.BtnBraceLeft:  UsageLeftBracket,
+	keypad.BtnBraceRight: UsageRightBracket,
+	keypad.BtnBackslash:  UsageBackslash,
+	// Row 3
+	keypad.BtnA:         UsageA,
+	keypad.BtnS:         UsageA + 's' - 'a',
+	keypad.BtnD:         UsageA + 'd' - 'a',
+	keypad.BtnF:         UsageA + 'f' - 'a',
+	keypad.BtnG:         UsageA + 'g' - 'a',
+	keypad.BtnH:         UsageA + 'h' - 'a',
+	keypad.BtnJ:         UsageA + 'j' - 'a',
+	keypad.BtnK:         UsageA + 'k' - 'a',
+	keypad.BtnL:         UsageA + 'l' - 'a',
+	keypad.BtnSemicolon: UsageSemicolon,
+	keypad.BtnQuote:     UsageQuote,
+	keypad.BtnEnter:     UsageEnter,
+	// Row 4
+	keypad.BtnZ:      UsageA + 'z' - 'a',
+	keypad.BtnX:      UsageA + 'x' - 'a',
+	keypad.BtnC:      UsageA + 'c' - 'a',
+	keypad.BtnV:      UsageA + 'v' - 'a',
+	keypad.BtnB:      UsageA + 'b' - 'a',
+	keypad.BtnN:      UsageA + 'n' - 'a',
+	keypad.BtnM:      UsageA + 'm' - 'a',
+	keypad.BtnComma:  UsageComma,
+	keypad.BtnPeriod: UsagePeriod,
+	keypad.BtnSlash:  UsageSlash,
+	keypad.BtnSpace:  UsageSpace,
+}
+
+// fnUsages are the usages of the Fn layer.
+var fnUsages = map[int64]byte{
+	keypad.BtnEsc:   UsageEscape,
+	keypad.BtnDel:   UsageDelete,
+	keypad.BtnUp:    UsageUp,
+	keypad.BtnDown:  UsageDown,
+	keypad.BtnLeft:  UsageLeft,
+	keypad.BtnRight: UsageRight,
+	keypad.BtnF1:    UsageF1,
+	keypad.BtnF2:    UsageF1 + 1,
+	keypad.BtnF3:    UsageF1 + 2,
+	keypad.BtnF4:    UsageF1 + 3,
+	keypad.BtnF5:    UsageF1 + 4,
+	keypad.BtnF6:    UsageF1 + 5,
+	keypad.BtnF7:    UsageF1 + 6,
+	keypad.BtnF8:    UsageF1 + 7,
+	keypad.BtnF9:    UsageF1 + 8,
+	keypad.BtnF10:   UsageF1 + 9,
+	keypad.BtnF11:   UsageF1 + 10,
+	keypad.BtnF12:   UsageF1 + 11,
+}
+
+// Stroke is a key on the host keyboard and whether Shift is needed with it.
+type Stroke struct {
+	Usage byte
+	Shift bool
+}
+
+// Layout maps characters to the strokes that type them under a host keyboard
+// layout.
+type Layout map[rune]Stroke
+
+// US is the standard US host keyboard layout.
+var US = func() Layout {
+	l := Layout{
+		'\n': {Usage: UsageEnter},
+		'\r': {Usage: UsageEnter},
+		'\t': {Usage: UsageTab},
+		'\b': {Usage: UsageBackspace},
+		0x1b: {Usage: UsageEscape},
+		' ':  {Usage: UsageSpace},
+	}
+	add := func(usage byte, plain, shifted string) {
+		for i, r := range plain {
+			l[r] = Stroke{Usage: usage + byte(i)}
+		}
+		for i, r := range shifted {
+			l[r] = Stroke{Usage: usage + byte(i), Shift: true}
+		}
+	}
+	add(UsageA, "abcdefghijklmnopqrstuvwxyz", "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	add(Usage1, "1234567890", "!@#$%^&*()")
+	add(UsageMinus, "-=[]\\", "_+{}|")
+	add(UsageSemicolon, ";'`,./", ":\"~<>?")
+	return l
+}()
+
+// Type returns the reports that type s on a host using layout l: a press and
+// a release for each character. It fails if l has no stroke for a character.
+func (l Layout) Type(s string) ([]Report, error) {
+	reports := make([]Report, 0, 2*len(s))
+	for _, r := range s {
+		st, ok := l[r]
+		if !ok {
+			return nil, fmt.Errorf("hid: no key types %q", r)
+		}
+		var press Report
+		if st.Shift {
+			press[0] = ModLeftShift
+		}
+		press[2] = st.Usage
+		reports = append(reports, press, Report{})
+	}
+	return reports, nil
+}
+
+// Converter turns keypad state into reports. Keys that type a printable
+// character are sent as the host key that types the same character, so the
+// host sees what ScancodeToBytes says the Cardputer types, including Shift
+// where the two disagree (the Cardputer's unshifted '_' key, for example).
+// Chords with Ctrl, Alt or Opt are sent by key position instead, and Fn
+// selects the Esc, Delete, arrow and F1-F12 keys. More than MaxKeys held keys
+// are reported as a rollover error, as the boot protocol requires.
+//
+// The zero Converter uses US and keypad.ScancodeToBytes.
+type Converter struct {
+	// Layout is the host's keyboard layout; nil means US.
+	Layout Layout
+	// Bytes maps chords to what they type; nil means keypad.ScancodeToBytes.
+	Bytes map[int64][]byte
+
+	// held are the non-modifier keys held, oldest first.
+	held []int64
+	last Report
+}
+
+// Update returns the report for state and whether it differs from the report
+// Update last returned, so only changes need to be sent to the host.
+func (c *Converter) Update(state int64) (Report, bool) {
+	mods := state & keypad.BtnSpecialMask
+	keys := state &^ mods
+
+	// keep press order so the newest key decides Shift
+	held := c.held[:0]
+	for _, k := range c.held {
+		if keys&k != 0 {
+			held = append(held, k)
+			keys &^= k
+		}
+	}
+	for keys != 0 {
+		k := keys & -keys
+		held = append(held, k)
+		keys &^= k
+	}
+	c.held = held
+
+	var r Report
+	for m, bit := range modifierBits {
+		if mods&m != 0 {
+			r[0] |= bit
+		}
+	}
+
+	n := 0
+	shift := -1
+	for _, k := range c.held {
+		u, s, ok := c.usage(mods, k)
+		if !ok || containsUsage(r[2:2+n], u) {
+			continue
+		}
+		if n == MaxKeys {
+			for i := range r[2:] {
+				r[2+i] = UsageErrorRollOver
+			}
+			shift = -1
+			break
+		}
+		r[2+n] = u
+		n++
+		if s >= 0 {
+			shift = s
+		}
+	}
+	switch shift {
+	case 0:
+		r[0] &^= ModLeftShift
+	case 1:
+		r[0] |= ModLeftShift
+	}
+
+	changed := r != c.last
+	c.last = r
+	return r, changed
+}
+
+// Report returns the report Update last returned.
+func (c *Converter) Report() Report {
+	return c.last
+}
+
+// Reset forgets the held keys and the last report.
+func (c *Converter) Reset() {
+	c.held = c.held[:0]
+	c.last = Report{}
+}
+
+// usage returns the usage for key k held with modifiers mods, and the Shift
+// state it needs: -1 for whatever is held, 0 for released or 1 for pressed.
+func (c *Converter) usage(mods, k int64) (u byte, shift int, ok bool) {
+	if mods&keypad.BtnFn != 0 {
+		u, ok = fnUsages[keypad.BtnFn|k]
+		return u, -1, ok
+	}
+	if mods&(keypad.BtnCtrl|keypad.BtnAlt|keypad.BtnOpt) == 0 {
+		bytes := c.Bytes
+		if bytes == nil {
+			bytes = keypad.ScancodeToBytes
+		}
+		layout := c.Layout
+		if layout == nil {
+			layout = US
+		}
+		if b := bytes[mods&keypad.BtnShift|k]; len(b) == 1 && b[0] >= ' ' && b[0] < 0x7f {
+			if st, ok := layout[rune(b[0])]; ok {
+				shift = 0
+				if st.Shift {
+					shift = 1
+				}
+				return st.Usage, shift, true
+			}
+		}
+	}
+	u, ok = keyUsages[k]
+	return u, -1, ok
+}
+
+func containsUsage(usages []byte, u byte) bool {
+	for _, v := range usages {
+		if v == u {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package hid
+
+import (
+	"testing"
+
+	"github.com/sparques/cardputer/keypad"
+)
+
+func TestConverter(t *testing.T) {
+	var c Converter
+	for _, tt := range []struct {
+		name  string
+		state int64
+		want  Report
+	}{
+		{"letter", keypad.BtnA, Report{0, 0, UsageA}},
+		{"shifted", keypad.BtnShift | keypad.BtnA, Report{ModLeftShift, 0, UsageA}},
+		{"unshifted underscore", keypad.BtnUnderscore, Report{ModLeftShift, 0, UsageMinus}},
+		{"shifted minus", keypad.BtnShift | keypad.BtnUnderscore, Report{0, 0, UsageMinus}},
+		{"ctrl by position", keypad.BtnCtrl | keypad.BtnC, Report{ModLeftCtrl, 0, UsageA + 2}},
+		{"opt is gui", keypad.BtnOpt | keypad.BtnL, Report{ModLeftGUI, 0, UsageA + 11}},
+		{"fn arrow", keypad.BtnUp, Report{0, 0, UsageUp}},
+		{"shift fn arrow", keypad.BtnShift | keypad.BtnLeft, Report{ModLeftShift, 0, UsageLeft}},
+		{"fn function key", keypad.BtnF12, Report{0, 0, UsageF1 + 11}},
+		{"fn only", keypad.BtnFn, Report{}},
+		{"enter", keypad.BtnShift | keypad.BtnEnter, Report{ModLeftShift, 0, UsageEnter}},
+		{"rollover", keypad.BtnQ | keypad.BtnW | keypad.BtnE | keypad.BtnR | keypad.BtnT | keypad.BtnY | keypad.BtnU,
+			Report{0, 0, 1, 1, 1, 1, 1, 1}},
+		{"release", 0, Report{}},
+	} {
+		c.Reset()
+		if got, _ := c.Update(tt.state); got != tt.want {
+			t.Errorf("%s: Update(%v) = %v, want %v", tt.name, keypad.Keys(tt.state), got, tt.want)
+		}
+	}
+}
+
+func TestConverterOrderAndDiff(t *testing.T) {
+	var c Converter
+	steps := []struct {
+		state   int64
+		keys    []byte
+		mods    byte
+		changed bool
+	}{
+		{keypad.BtnS, []byte{UsageA + 18}, 0, true},
+		{keypad.BtnS, []byte{UsageA + 18}, 0, false},
+		// the newest key's character decides Shift
+		{keypad.BtnS | keypad.BtnUnderscore, []byte{UsageA + 18, UsageMinus}, ModLeftShift, true},
+		{keypad.BtnS | keypad.BtnUnderscore | keypad.BtnA, []byte{UsageA + 18, UsageMinus, UsageA}, 0, true},
+		{keypad.BtnUnderscore | keypad.BtnA, []byte{UsageMinus, UsageA}, 0, true},
+		{0, nil, 0, true},
+		{0, nil, 0, false},
+	}
+	for i, s := range steps {
+		r, changed := c.Update(s.state)
+		if string(r.Keys()) != string(s.keys) || r.Modifiers() != s.mods || changed != s.changed {
+			t.Errorf("step %d: Update(%v) = %v, %v; want keys %v mods %#x, %v",
+				i, keypad.Keys(s.state), r, changed, s.keys, s.mods, s.changed)
+		}
+	}
+}
+
+func TestConverterBytes(t *testing.T) {
+	// typing what the keypad map says, not what the key is labelled
+	c := Converter{Bytes: map[int64][]byte{keypad.BtnQ: {'\''}}}
+	if r, _ := c.Update(keypad.BtnQ); r != (Report{0, 0, UsageQuote}) {
+		t.Errorf("remapped Q = %v, want quote", r)
+	}
+}
+
+func TestLayoutType(t *testing.T) {
+	reports, err := US.Type("Hi!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Report{
+		{ModLeftShift, 0, UsageA + 7}, {},
+		{0, 0, UsageA + 8}, {},
+		{ModLeftShift, 0, Usage1}, {},
+	}
+	if len(reports) != len(want) {
+		t.Fatalf("Type returned %d reports, want %d", len(reports), len(want))
+	}
+	for i := range want {
+		if reports[i] != want[i] {
+			t.Errorf("report %d = %v, want %v", i, reports[i], want[i])
+		}
+	}
+	if _, err := US.Type("é"); err == nil {
+		t.Errorf("Type(%q) succeeded, want error", "é")
+	}
+}
@@ -7,21 +7,21 @@
 package keypad // import "github.com/sparques/cardputer/keypad"
 
 import (
-	"io"
 	"machine"
 	"time"
 )
 
 var (
-	// The fatest typist in the word can type (on a full sized, QWERTY Keyboard) just under
-	// 1000 characters per minute. That's about 16.67 Hz or a period of 60 ms.
-	// A DefaultScanPeriod of 20 ms is comfortably below that threshold.
-	// 20ms scan period means two key presses can be registered in 60ms; the first 20ms
-	// are needed to detect the press, the next 20 to detect the release, and the final
-	// 20 to detect the second press.
-	DefaultScanPeriod time.Duration = 20 * time.Millisecond
+	// DefaultIdleTimeout is a reasonable IdleTimeout for battery powered use.
+	DefaultIdleTimeout time.Duration = 5 * time.Second
+	// DefaultIdleScanPeriod is how often the matrix is scanned while idle.
+	DefaultIdleScanPeriod time.Duration = 250 * time.Millisecond
 )
 
+// DefaultIdleAddress is the 74HC138 address of the B to Space half row, which
+// holds the Space bar.
+const DefaultIdleAddress = 3
+
 var (
 	// DefaultAddressLines are the pins that are connected to the 74HC138
 	DefaultAddressLines = [3]machine.Pin{a0, a1, a2}
@@ -29,33 +29,35 @@ var (
 	DefaultSenseLines = [7]machine.Pin{c0, c1, c2, c3, c4, c5, c6}
 )
 
-// Device scans the original Cardputer keypad matrix and tracks button state.
-type Device struct {
+// MatrixBackend scans the original Cardputer keypad matrix through its
+// 74HC138 address decoder.
+type MatrixBackend struct {
 	// addressLines the pins used to set the address on the 74HC138
 	// The indicies should match, addressLines[0] = A0 on the 74HC138 and is equivalent to G8 on the M5 StampC3
 	addressLines [3]machine.Pin
 	// senseLines are the GPIO input pins connected to the keypad
 	// In order, these should be equivalent to G13, G15, G3, G4, G5, G6, G7.
 	senseLines [7]machine.Pin
-	// state tracks what buttons are currently pressed/released
-	state int64
 	// buf is a working buffer for what buttons are currently pressed/released
 	buf int64
 	// scanPeriod is how often to scan over the addressable lines of the keypad
 	scanPeriod time.Duration
-	// Receiver is an io.Writer interface that will have keypad presses written to
-	// as bytes when the EventPressCallback is set to (*Device).WriteByteCallback.
-	// Character repeats (holding down button for multiple characters) is not supported.
-	// Not every combination of key presses results in a character
-	Receiver io.Writer
-	// EventPressCallback is called when a button press is detected
-	EventPressCallback func(int64)
-	// EventReleaseCallback is called a button release is detected
-	EventReleaseCallback func(int64)
-	// stop is used to signal the goroutine handling scanning the keypad to return.
-	stop chan struct{}
-	// altBuf avoids allocating when prefixing translated keys with escape.
-	altBuf [8]byte
+	// IdleTimeout is how long every button must be released before the
+	// backend stops scanning and waits for a key press interrupt. Zero, the
+	// default, keeps scanning forever.
+	//
+	// The 74HC138 always asserts exactly one of its outputs, so no address
+	// connects every key to the sense lines at once. While idle the decoder is
+	// parked on IdleAddress and only the seven keys on that address wake the
+	// keypad by interrupt; the rest are caught by a scan every IdleScanPeriod.
+	IdleTimeout time.Duration
+	// IdleAddress is the 74HC138 address selected while idle. Its keys wake
+	// the keypad immediately. The default, DefaultIdleAddress, selects
+	// B, N, M, comma, period, slash and Space.
+	IdleAddress uint8
+	// IdleScanPeriod is how often the whole matrix is scanned while idle.
+	// Zero limits waking to the IdleAddress keys.
+	IdleScanPeriod time.Duration
 }
 
 // New returns a new *Device. New configures the pins as needed.
@@ -73,128 +75,184 @@ func New() (d *Device) {
 // addrLines specify the set of pins connected to the 74HC138.
 // senseLines are the input pins for detecting key presses.
 func NewWithPins(addrLines [3]machine.Pin, senseLines [7]machine.Pin) *Device {
-	for i := range addrLines {
-		addrLines[i].Configure(machine.PinConfig{Mode: machine.PinOutput})
-		addrLines[i].Low()
+	return NewWithBackend(NewMatrixBackend(addrLines, senseLines))
+}
+
+// NewMatrixBackend returns a *MatrixBackend using the given 74HC138 address
+// lines and sense lines.
+func NewMatrixBackend(addrLines [3]machine.Pin, senseLines [7]machine.Pin) *MatrixBackend {
+	return &MatrixBackend{
+		addressLines:   addrLines,
+		senseLines:     senseLines,
+		scanPeriod:     DefaultScanPeriod,
+		IdleAddress:    DefaultIdleAddress,
+		IdleScanPeriod: DefaultIdleScanPeriod,
 	}
-	for i := range senseLines {
-		senseLines[i].Configure(machine.PinConfig{Mode: machine.PinInputPullup})
+}
+
+// Matrix returns d's backend, to adjust idle behaviour.
+func (d *Device) Matrix() *MatrixBackend {
+	m, _ := d.backend.(*MatrixBackend)
+	return m
+}
+
+// Configure sets up the address and sense lines.
+func (m *MatrixBackend) Configure() error {
+	for i := range m.addressLines {
+		m.addressLines[i].Configure(machine.PinConfig{Mode: machine.PinOutput})
+		m.addressLines[i].Low()
 	}
-	d := &Device{
-		addressLines: addrLines,
-		senseLines:   senseLines,
-		scanPeriod:   DefaultScanPeriod,
-		Receiver:     io.Discard,
+	for i := range m.senseLines {
+		m.senseLines[i].Configure(machine.PinConfig{Mode: machine.PinInputPullup})
 	}
-	d.EventPressCallback = d.WriteByteCallback
-	return d
+	return nil
 }
 
-// Start begins the background scan loop for the keypad matrix.
-func (d *Device) Start() {
-	if d.stop != nil {
-		return
+// Run scans the matrix every scan period, dropping to idle mode after
+// IdleTimeout without a key held.
+func (m *MatrixBackend) Run(report func(held int64), stop <-chan struct{}) error {
+	ticker := time.NewTicker(m.scanPeriod)
+	defer func() { ticker.Stop() }()
+	var last int64
+	lastActive := time.Now()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case now := <-ticker.C:
+			m.scan()
+			if m.buf != 0 {
+				lastActive = now
+			}
+			if m.buf != last {
+				last = m.buf
+				report(last)
+			}
+
+			if m.IdleTimeout <= 0 || now.Sub(lastActive) < m.IdleTimeout {
+				continue
+			}
+			ticker.Stop()
+			if m.idle(stop) {
+				return nil
+			}
+			m.scan()
+			lastActive = time.Now()
+			if m.buf != last {
+				last = m.buf
+				report(last)
+			}
+			ticker = time.NewTicker(m.scanPeriod)
+		}
 	}
+}
 
+// scan reads every address of the matrix into buf.
+func (m *MatrixBackend) scan() {
 	scanSenseLines := func() {
-		for i := range d.senseLines {
-			if !d.senseLines[i].Get() {
-				d.buf |= 1 << i
+		for i := range m.senseLines {
+			if !m.senseLines[i].Get() {
+				m.buf |= 1 << i
 			}
 		}
 	}
 
-	d.stop = make(chan struct{})
+	m.buf = 0
+	m.addressLines[0].High()
+	m.addressLines[1].High()
+	m.addressLines[2].Low()
+	scanSenseLines()
+
+	m.buf <<= 7
+	m.addressLines[0].Low()
+	scanSenseLines()
+
+	m.buf <<= 7
+	m.addressLines[2].High()
+	scanSenseLines()
+
+	m.buf <<= 7
+	m.addressLines[0].High()
+	scanSenseLines()
+
+	m.buf <<= 7
+	m.addressLines[1].Low()
+	scanSenseLines()
 
-	go func() {
-		ticker := time.NewTicker(d.scanPeriod)
-		for {
+	m.buf <<= 7
+	m.addressLines[2].Low()
+	scanSenseLines()
+
+	m.buf <<= 7
+	m.addressLines[0].Low()
+	scanSenseLines()
+
+	m.buf <<= 7
+	m.addressLines[2].High()
+	scanSenseLines()
+}
+
+// idle parks the 74HC138 on IdleAddress, arms falling edge interrupts on the
+// sense lines and blocks until a wake key is pressed, an idle scan finds a
+// key or stop is closed. It reports whether stop was closed.
+func (m *MatrixBackend) idle(stop <-chan struct{}) (stopped bool) {
+	wake := make(chan struct{}, 1)
+	armed := true
+	for _, p := range m.senseLines {
+		err := p.SetInterrupt(machine.PinFalling, func(machine.Pin) {
 			select {
-			case <-d.stop:
-				ticker.Stop()
-				d.stop = nil
-				return
-			case <-ticker.C:
-				d.buf = 0
-				d.addressLines[0].High()
-				d.addressLines[1].High()
-				d.addressLines[2].Low()
-				scanSenseLines()
-
-				d.buf <<= 7
-				d.addressLines[0].Low()
-				scanSenseLines()
-
-				d.buf <<= 7
-				d.addressLines[2].High()
-				scanSenseLines()
-
-				d.buf <<= 7
-				d.addressLines[0].High()
-				scanSenseLines()
-
-				d.buf <<= 7
-				d.addressLines[1].Low()
-				scanSenseLines()
-
-				d.buf <<= 7
-				d.addressLines[2].Low()
-				scanSenseLines()
-
-				d.buf <<= 7
-				d.addressLines[0].Low()
-				scanSenseLines()
-
-				d.buf <<= 7
-				d.addressLines[2].High()
-				scanSenseLines()
-
-				if d.buf == d.state {
-					continue
-				}
-
-				r := released(d.state, d.buf)
-				p := pressed(d.state, d.buf)
-				d.state = d.buf
-
-				if r != 0 && d.EventReleaseCallback != nil {
-					d.EventReleaseCallback(r)
-				}
-
-				if p != 0 && d.EventPressCallback != nil {
-					d.EventPressCallback(p)
-				}
+			case wake <- struct{}{}:
+			default:
 			}
+		})
+		if err != nil {
+			armed = false
 		}
-	}()
-}
-
-// Stop stops the keypad scan loop if it is running.
-func (d *Device) Stop() {
-	if d.stop != nil {
-		d.stop <- struct{}{}
 	}
-}
+	defer func() {
+		for _, p := range m.senseLines {
+			p.SetInterrupt(0, nil)
+		}
+	}()
 
-// WriteByteCallback translates the current button state into bytes using ScancodeToBytes
-// and writes them to Receiver.
-func (d *Device) WriteByteCallback(int64) {
-	b, ok := ScancodeToBytes[d.state&^BtnAlt]
-	if !ok {
-		return
+	period := m.IdleScanPeriod
+	if !armed && period <= 0 {
+		// without interrupts or idle scans nothing could wake the keypad
+		return false
 	}
-	if (d.state & BtnAlt) == BtnAlt {
-		d.altBuf[0] = 0x1b
-		copy(d.altBuf[1:], b)
-		b = d.altBuf[:len(b)+1]
+	var slow <-chan time.Time
+	if period > 0 {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		slow = ticker.C
 	}
-	d.Receiver.Write(b)
-}
 
-func pressed(a, b int64) int64 {
-	return b - (a & b)
+	for {
+		m.setAddress(m.IdleAddress)
+		// a wake key may have gone down before the interrupts were armed
+		for _, p := range m.senseLines {
+			if !p.Get() {
+				return false
+			}
+		}
+
+		select {
+		case <-stop:
+			return true
+		case <-wake:
+			return false
+		case <-slow:
+			m.scan()
+			if m.buf != 0 {
+				return false
+			}
+		}
+	}
 }
 
-func released(a, b int64) int64 {
-	return a - (a & b)
+// setAddress drives the address lines to select output addr of the 74HC138.
+func (m *MatrixBackend) setAddress(addr uint8) {
+	for i, p := range m.addressLines {
+		p.Set(addr&(1<<i) != 0)
+	}
 }
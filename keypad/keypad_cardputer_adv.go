@@ -3,273 +3,296 @@
 package keypad // import "github.com/sparques/cardputer/keypad"
 
 import (
-	"io"
+	"errors"
 	"machine"
+	"sync"
 	"time"
 
 	"github.com/sparques/cardputer/internal/adv"
+	"github.com/sparques/cardputer/keypad/tca8418"
 )
 
 const (
-	// DefaultScanPeriod is how often the Adv keypad interrupt line is polled.
-	DefaultScanPeriod = 20 * time.Millisecond
-	// DefaultRepeatDelay is how long a key must be held before repeat events start.
-	DefaultRepeatDelay = 500 * time.Millisecond
-	// DefaultRepeatPeriod is the time between repeat events after DefaultRepeatDelay.
-	DefaultRepeatPeriod = 50 * time.Millisecond
-
 	keypadIRQ = machine.GPIO11
 	keypadSDA = machine.GPIO8
 	keypadSCL = machine.GPIO9
+
+	// matrixRows and matrixCols are the TCA8418 lines wired to the keyboard.
+	matrixRows = 7
+	matrixCols = 8
 )
 
-// Device reads keyboard events from the Cardputer-Adv TCA8418 controller and tracks button state.
-type Device struct {
-	// state tracks the currently pressed button bitmask.
-	state int64
-	// scanPeriod controls how often the interrupt line is sampled.
+// errNotConfigured is returned by the controller features of a
+// TCA8418Backend whose Configure failed or wasn't called.
+var errNotConfigured = errors.New("keypad controller is not configured")
+
+// SpareLines are the TCA8418 lines the Cardputer-Adv keyboard matrix leaves
+// unused. They can be used as GPIO through Controller.
+var SpareLines = tca8418.AllLines &^ tca8418.MatrixLines(matrixRows, matrixCols)
+
+// TCA8418Backend reads keyboard events from the Cardputer-Adv TCA8418
+// controller.
+type TCA8418Backend struct {
+	// GPICallback is called for events from SpareLines configured as event
+	// generating inputs with Controller().ConfigureInputs. It is called from
+	// the backend's goroutine.
+	GPICallback func(line tca8418.Lines, active bool)
+	// OverflowCallback is called, if set, when the controller's event FIFO
+	// overflowed and every key was released to resynchronise. It is called
+	// from the backend's goroutine.
+	OverflowCallback func()
+
+	// scanPeriod controls how often the interrupt line is sampled if it
+	// can't be used as a pin interrupt.
 	scanPeriod time.Duration
-	// RepeatDelay controls how long a key must be held before repeat events start.
-	// A non-positive value disables key repeat.
-	RepeatDelay time.Duration
-	// RepeatPeriod controls the time between repeat events after RepeatDelay.
-	// A non-positive value disables key repeat.
-	RepeatPeriod time.Duration
-	// Receiver receives translated byte output when WriteByteCallback is used.
-	Receiver io.Writer
-	// EventPressCallback is called when one or more buttons become pressed or repeated.
-	EventPressCallback func(int64)
-	// EventReleaseCallback is called when one or more buttons become released.
-	EventReleaseCallback func(int64)
-
-	stop    chan struct{}
-	irq     machine.Pin
-	bus     *machine.I2C
-	ctrl    *tca8418
-	initErr error
-
-	repeatState int64
-	nextRepeat  time.Time
+	resync     chan struct{}
+	irq        machine.Pin
+	bus        *machine.I2C
+	ctrl       *tca8418.Device
+	events     []tca8418.Event
+	// held are the keys held according to the events read so far.
+	held int64
+	// mu serialises use of the controller between Run and the lock and
+	// configuration methods, which may be called from other goroutines.
+	mu sync.Mutex
 }
 
 // New constructs a Device using the Cardputer-Adv shared I2C bus and keypad IRQ pin.
 func New() *Device {
-	d := &Device{
-		scanPeriod:   DefaultScanPeriod,
-		RepeatDelay:  DefaultRepeatDelay,
-		RepeatPeriod: DefaultRepeatPeriod,
-		Receiver:     io.Discard,
-		irq:          keypadIRQ,
+	return NewWithBackend(NewTCA8418Backend())
+}
+
+// NewTCA8418Backend returns a *TCA8418Backend using the Cardputer-Adv shared
+// I2C bus and keypad IRQ pin.
+func NewTCA8418Backend() *TCA8418Backend {
+	return &TCA8418Backend{
+		scanPeriod: DefaultScanPeriod,
+		irq:        keypadIRQ,
+		resync:     make(chan struct{}, 1),
 	}
-	d.EventPressCallback = d.WriteByteCallback
+}
+
+// TCA8418 returns d's backend, for the controller's GPIO, lock and debounce
+// features.
+func (d *Device) TCA8418() *TCA8418Backend {
+	t, _ := d.backend.(*TCA8418Backend)
+	return t
+}
 
-	d.irq.Configure(machine.PinConfig{Mode: machine.PinInputPullup})
-	d.bus, d.initErr = adv.SharedI2C()
-	if d.initErr != nil {
-		return d
+// Configure sets up the IRQ pin and the controller's keyboard matrix.
+func (t *TCA8418Backend) Configure() error {
+	t.irq.Configure(machine.PinConfig{Mode: machine.PinInputPullup})
+	bus, err := adv.SharedI2C()
+	if err != nil {
+		return err
 	}
+	t.bus = bus
 
-	d.ctrl = newTCA8418(d.bus, tca8418Addr)
-	if err := d.ctrl.configureMatrix(7, 8); err != nil {
-		d.initErr = err
-		return d
+	t.ctrl = tca8418.New(t.bus)
+	if err := t.ctrl.ConfigureMatrix(matrixRows, matrixCols); err != nil {
+		return err
 	}
-	if err := d.ctrl.flush(); err != nil {
-		d.initErr = err
-		return d
+	if err := t.ctrl.Flush(); err != nil {
+		return err
 	}
-	d.initErr = d.ctrl.enableKeyInterrupts()
-	return d
+	// on overflow keep the newest events, which best reflect the keys held now
+	return t.ctrl.Configure(tca8418.CFGKeyEventInt | tca8418.CFGGPIInt | tca8418.CFGKeyLockInt |
+		tca8418.CFGOverflowInt | tca8418.CFGOverflowMode)
 }
 
-// Start begins polling the TCA8418 interrupt line and draining queued key events.
-func (d *Device) Start() {
-	if d.stop != nil || d.initErr != nil || d.ctrl == nil {
-		return
-	}
-	if err := d.recover(); err != nil {
-		d.initErr = err
-		return
+// Run waits on the TCA8418 interrupt line and drains queued key events. It
+// sleeps until the line falls.
+func (t *TCA8418Backend) Run(report func(held int64), stop <-chan struct{}) error {
+	if err := t.recover(); err != nil {
+		return err
 	}
 
-	d.stop = make(chan struct{})
-	go func() {
-		ticker := time.NewTicker(d.scanPeriod)
+	irqEdge := make(chan struct{}, 1)
+	err := t.irq.SetInterrupt(machine.PinFalling, func(machine.Pin) {
+		select {
+		case irqEdge <- struct{}{}:
+		default:
+		}
+	})
+	// fall back to polling the interrupt line if it can't interrupt
+	var poll <-chan time.Time
+	if err != nil {
+		ticker := time.NewTicker(t.scanPeriod)
 		defer ticker.Stop()
+		poll = ticker.C
+	} else {
+		defer t.irq.SetInterrupt(0, nil)
+	}
 
-		for {
-			select {
-			case <-d.stop:
-				d.stop = nil
-				return
-			case <-ticker.C:
-				if !d.irq.Get() {
-					d.drainEvents()
-				}
-				d.maybeRepeat(time.Now())
+	for {
+		// the line is level triggered, so this also catches events
+		// queued before the interrupt was armed
+		var retry <-chan time.Time
+		if !t.irq.Get() {
+			t.drainEvents(report)
+			if !t.irq.Get() {
+				// a failed read or clear left the line low, so it won't
+				// fall again; try once more after a scan period
+				retry = time.After(t.scanPeriod)
 			}
 		}
-	}()
+
+		select {
+		case <-stop:
+			return nil
+		case <-t.resync:
+			t.held = 0
+			report(0)
+		case <-irqEdge:
+		case <-poll:
+		case <-retry:
+		}
+	}
 }
 
-// Stop stops the background keypad polling loop if it is running.
-func (d *Device) Stop() {
-	if d.stop != nil {
-		d.stop <- struct{}{}
+// Controller returns the TCA8418 driver, for instance to use SpareLines as
+// GPIO. It is nil if Configure failed.
+func (t *TCA8418Backend) Controller() *tca8418.Device {
+	return t.ctrl
+}
+
+// SetDebounce enables or disables the controller's hardware debounce on the
+// keyboard matrix. It is enabled by default.
+func (t *TCA8418Backend) SetDebounce(enabled bool) error {
+	if t.ctrl == nil {
+		return errNotConfigured
 	}
-	d.clearState()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ctrl.SetDebounce(tca8418.MatrixLines(matrixRows, matrixCols), enabled)
 }
 
-// InitErr reports a keypad initialization failure, if one occurred.
-func (d *Device) InitErr() error {
-	return d.initErr
+// SetUnlockKeys sets the two keys that, pressed one after the other within
+// window (at most 7s), unlock a keypad locked with Lock.
+func (t *TCA8418Backend) SetUnlockKeys(first, second Keys, window time.Duration) error {
+	if t.ctrl == nil {
+		return errNotConfigured
+	}
+	k1, ok1 := tcaKeyCode(first)
+	k2, ok2 := tcaKeyCode(second)
+	if !ok1 || !ok2 {
+		return tca8418.ErrInvalidKey
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ctrl.SetUnlockKeys(k1, k2, window, 0)
 }
 
-// Started reports whether the keypad polling goroutine was launched.
-func (d *Device) Started() bool {
-	return d.stop != nil
+// Lock locks the keypad in hardware. Every held key is released and no more
+// key events are reported until the unlock keys set with SetUnlockKeys are
+// typed or Unlock is called. Lock may be called from the keypad callbacks.
+func (t *TCA8418Backend) Lock() error {
+	if t.ctrl == nil {
+		return errNotConfigured
+	}
+	t.mu.Lock()
+	err := t.ctrl.Lock()
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	select {
+	case t.resync <- struct{}{}:
+	default:
+	}
+	return nil
 }
 
-// WriteByteCallback translates the current button state into bytes using ScancodeToBytes
-// and writes them to Receiver.
-func (d *Device) WriteByteCallback(int64) {
-	b, ok := ScancodeToBytes[d.state&^BtnAlt]
-	if !ok {
-		return
+// Unlock unlocks a keypad locked with Lock.
+func (t *TCA8418Backend) Unlock() error {
+	if t.ctrl == nil {
+		return errNotConfigured
 	}
-	if (d.state & BtnAlt) == BtnAlt {
-		b = append([]byte{0x1b}, b...)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ctrl.Unlock()
+}
+
+// Locked reports whether the keypad is locked.
+func (t *TCA8418Backend) Locked() bool {
+	if t.ctrl == nil {
+		return false
 	}
-	d.Receiver.Write(b)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	locked, err := t.ctrl.Locked()
+	return err == nil && locked
 }
 
-func (d *Device) drainEvents() {
+// drainEvents reads events until the interrupt line is released, or until
+// an I2C error, leaving Run to retry. The controller is only held while
+// reading, so report can block on a Device whose callbacks call Lock.
+func (t *TCA8418Backend) drainEvents(report func(held int64)) {
 	for {
-		n, err := d.ctrl.eventCount()
-		if err != nil || n == 0 {
-			break
+		t.mu.Lock()
+		events, err := t.ctrl.ReadEvents(t.events[:0])
+		t.events = events
+		var clearErr error
+		if err == nil || err == tca8418.ErrOverflow {
+			clearErr = t.ctrl.ClearInterrupts(tca8418.IntKeyEvent | tca8418.IntGPI | tca8418.IntKeyLock | tca8418.IntCAD)
 		}
-
-		for i := uint8(0); i < n; i++ {
-			event, err := d.ctrl.getEvent()
-			if err != nil || event == 0 {
-				break
+		t.mu.Unlock()
+
+		switch {
+		case err == tca8418.ErrOverflow:
+			// events were lost, so the tracked state can't be trusted;
+			// release everything and carry on with the newest events
+			t.held = 0
+			report(0)
+			if t.OverflowCallback != nil {
+				t.OverflowCallback()
 			}
-			d.applyEvent(event)
-		}
-
-		if d.ctrl.clearKeyInterrupt() != nil {
+		case err != nil:
 			return
 		}
-		if d.irq.Get() {
+		for _, e := range events {
+			t.applyEvent(e, report)
+		}
+
+		if clearErr != nil || t.irq.Get() {
 			return
 		}
 	}
 }
 
-func (d *Device) applyEvent(event uint8) {
-	pressed := (event & 0x80) != 0
-	key := int(event&0x7F) - 1
-	if key < 0 {
+func (t *TCA8418Backend) applyEvent(event tca8418.Event, report func(held int64)) {
+	if event.IsGPI() {
+		if t.GPICallback != nil {
+			t.GPICallback(event.Line(), event.Pressed())
+		}
 		return
 	}
-
-	rawRow := key / 10
-	rawCol := key % 10
-	row, col, ok := remapTCA8418(rawRow, rawCol)
-	if !ok {
+	if !event.IsKey() {
 		return
 	}
 
-	mask := buttonMask(row, col)
+	mask := int64(AdvKeymap(event.RowCol()))
 	if mask == 0 {
 		return
 	}
 
-	prev := d.state
-	if pressed {
-		d.state |= mask
-		p := pressedBits(prev, d.state)
-		if p != 0 && d.EventPressCallback != nil {
-			d.EventPressCallback(p)
-		}
-		if p != 0 {
-			d.scheduleRepeat(time.Now())
-		}
-		return
-	}
-
-	d.state &^= mask
-	r := releasedBits(prev, d.state)
-	if r != 0 && d.EventReleaseCallback != nil {
-		d.EventReleaseCallback(r)
-	}
-	if r != 0 {
-		d.scheduleRepeat(time.Now())
-	}
-}
-
-func (d *Device) scheduleRepeat(now time.Time) {
-	if d.state == 0 || d.RepeatDelay <= 0 || d.RepeatPeriod <= 0 {
-		d.repeatState = 0
-		d.nextRepeat = time.Time{}
-		return
-	}
-	d.repeatState = d.state
-	d.nextRepeat = now.Add(d.RepeatDelay)
-}
-
-func (d *Device) maybeRepeat(now time.Time) {
-	if d.repeatState == 0 || d.EventPressCallback == nil || d.RepeatDelay <= 0 || d.RepeatPeriod <= 0 || now.Before(d.nextRepeat) {
-		return
+	if event.Pressed() {
+		t.held |= mask
+	} else {
+		t.held &^= mask
 	}
-	d.EventPressCallback(d.repeatState)
-	d.nextRepeat = now.Add(d.RepeatPeriod)
+	report(t.held)
 }
 
-func (d *Device) recover() error {
+func (t *TCA8418Backend) recover() error {
 	if err := adv.ResetSharedI2C(); err != nil {
 		return err
 	}
-	d.clearState()
-	if d.ctrl == nil {
+	t.held = 0
+	if t.ctrl == nil {
 		return nil
 	}
-	return d.ctrl.flush()
-}
-
-func (d *Device) clearState() {
-	d.state = 0
-	d.repeatState = 0
-	d.nextRepeat = time.Time{}
-}
-
-func remapTCA8418(rawRow, rawCol int) (row, col int, ok bool) {
-	if rawRow < 0 || rawRow > 6 || rawCol < 0 || rawCol > 7 {
-		return 0, 0, false
-	}
-	col = rawRow * 2
-	if rawCol > 3 {
-		col++
-	}
-	row = (rawCol + 4) % 4
-	if row < 0 || row > 3 || col < 0 || col > 13 {
-		return 0, 0, false
-	}
-	return row, col, true
-}
-
-func buttonMask(row, col int) int64 {
-	if row < 0 || row > 3 || col < 0 || col > 13 {
-		return 0
-	}
-	return int64(1) << (row*14 + col)
-}
-
-func pressedBits(a, b int64) int64 {
-	return b - (a & b)
-}
-
-func releasedBits(a, b int64) int64 {
-	return a - (a & b)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ctrl.Flush()
 }
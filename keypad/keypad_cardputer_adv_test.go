@@ -0,0 +1,27 @@
+//go:build cardputer_adv
+
+package keypad
+
+import (
+	"testing"
+
+	"github.com/sparques/cardputer/keypad/tca8418"
+)
+
+func TestTCAKeyCodeInvertsRemap(t *testing.T) {
+	for rawRow := 0; rawRow < matrixRows; rawRow++ {
+		for rawCol := 0; rawCol < matrixCols; rawCol++ {
+			row, col, ok := remapTCA8418(rawRow, rawCol)
+			if !ok {
+				t.Fatalf("remapTCA8418(%d, %d) not ok", rawRow, rawCol)
+			}
+			got, ok := tcaKeyCode(Keys(buttonMask(row, col)))
+			if want := tca8418.KeyCode(rawRow, rawCol); !ok || got != want {
+				t.Errorf("tcaKeyCode(%v) = %d, want %d", Keys(buttonMask(row, col)), got, want)
+			}
+		}
+	}
+	if _, ok := tcaKeyCode(BtnCtrl | BtnC); ok {
+		t.Errorf("tcaKeyCode accepted a chord")
+	}
+}
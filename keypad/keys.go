@@ -0,0 +1,184 @@
+package keypad
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// Keys is a set of buttons, such as a chord, built from the Btn* bit flags.
+// It converts to and from names like "Ctrl+Shift+A", "Fn+;" and "Up", and
+// implements encoding.TextMarshaler for use in config files.
+type Keys int64
+
+// keyNames are the labels of the individual buttons, indexed by bit.
+var keyNames = [...]string{
+	// Row 1
+	"`", "1", "2", "3", "4", "5", "6", "7", "8", "9", "0", "_", "=", "Backspace",
+	// Row 2
+	"Tab", "Q", "W", "E", "R", "T", "Y", "U", "I", "O", "P", "[", "]", "\\",
+	// Row 3
+	"Fn", "Shift", "A", "S", "D", "F", "G", "H", "J", "K", "L", ";", "'", "Enter",
+	// Row 4
+	"Ctrl", "Opt", "Alt", "Z", "X", "C", "V", "B", "N", "M", ",", ".", "/", "Space",
+}
+
+// keyAliases names the Fn combinations that act as their own keys.
+var keyAliases = [...]struct {
+	name string
+	keys Keys
+}{
+	{"Esc", BtnEsc},
+	{"Del", BtnDel},
+	{"Up", BtnUp},
+	{"Down", BtnDown},
+	{"Left", BtnLeft},
+	{"Right", BtnRight},
+	{"F1", BtnF1},
+	{"F2", BtnF2},
+	{"F3", BtnF3},
+	{"F4", BtnF4},
+	{"F5", BtnF5},
+	{"F6", BtnF6},
+	{"F7", BtnF7},
+	{"F8", BtnF8},
+	{"F9", BtnF9},
+	{"F10", BtnF10},
+	{"F11", BtnF11},
+	{"F12", BtnF12},
+}
+
+// keySynonyms are extra spellings accepted by ParseKeys.
+var keySynonyms = map[string]Keys{
+	"control":   BtnCtrl,
+	"option":    BtnOpt,
+	"escape":    BtnEsc,
+	"delete":    BtnDel,
+	"return":    BtnEnter,
+	"bksp":      BtnBackspace,
+	"spc":       BtnSpace,
+	"backtick":  BtnBacktick,
+	"semicolon": BtnSemicolon,
+	"quote":     BtnQuote,
+	"comma":     BtnComma,
+	"period":    BtnPeriod,
+	"slash":     BtnSlash,
+}
+
+// modifierOrder is the order modifiers are written in by String.
+var modifierOrder = [...]Keys{BtnCtrl, BtnAlt, BtnOpt, BtnShift, BtnFn}
+
+// String returns the keys joined with '+', modifiers first, for example
+// "Ctrl+Shift+A". Fn combinations with their own name, such as BtnUp, are
+// written using that name ("Up", "Shift+Up"). No keys is "None".
+func (k Keys) String() string {
+	if k == 0 {
+		return "None"
+	}
+
+	var alias string
+	if k&BtnFn != 0 {
+		base := k &^ BtnSpecialMask
+		for _, a := range keyAliases {
+			if a.keys&^BtnFn == base {
+				alias = a.name
+				k &^= a.keys
+				break
+			}
+		}
+	}
+
+	var sb strings.Builder
+	add := func(name string) {
+		if sb.Len() > 0 {
+			sb.WriteByte('+')
+		}
+		sb.WriteString(name)
+	}
+	for _, m := range modifierOrder {
+		if k&m != 0 {
+			add(keyNames[bits.TrailingZeros64(uint64(m))])
+			k &^= m
+		}
+	}
+	if alias != "" {
+		add(alias)
+	}
+	for k != 0 {
+		i := bits.TrailingZeros64(uint64(k))
+		k &^= 1 << i
+		if i < len(keyNames) {
+			add(keyNames[i])
+		} else {
+			add(fmt.Sprintf("Bit%d", i))
+		}
+	}
+	return sb.String()
+}
+
+// ParseKeys parses a '+' separated list of key names, such as "Ctrl+Alt+Del",
+// into Keys. It accepts the labels produced by String, the Fn aliases (Esc,
+// Del, Up, Down, Left, Right, F1-F12) and a few synonyms such as "Control"
+// and "Return". Names are not case sensitive.
+func ParseKeys(s string) (Keys, error) {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "None") {
+		return 0, nil
+	}
+
+	var k Keys
+	for len(s) > 0 {
+		// the first rune of a name may itself be '+'-free punctuation such as
+		// ';', so split after it
+		end := strings.IndexByte(s[1:], '+')
+		name := s
+		if end >= 0 {
+			name = s[:end+1]
+			s = s[end+2:]
+			if s == "" {
+				return 0, fmt.Errorf("missing key name after '+' in %q", name+"+")
+			}
+		} else {
+			s = ""
+		}
+		key, ok := lookupKeyName(strings.TrimSpace(name))
+		if !ok {
+			return 0, fmt.Errorf("unknown key name %q", name)
+		}
+		k |= key
+	}
+	if k == 0 {
+		return 0, fmt.Errorf("empty key name")
+	}
+	return k, nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (k Keys) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (k *Keys) UnmarshalText(text []byte) error {
+	v, err := ParseKeys(string(text))
+	if err != nil {
+		return err
+	}
+	*k = v
+	return nil
+}
+
+func lookupKeyName(name string) (Keys, bool) {
+	for i, n := range keyNames {
+		if strings.EqualFold(n, name) {
+			return 1 << i, true
+		}
+	}
+	for _, a := range keyAliases {
+		if strings.EqualFold(a.name, name) {
+			return a.keys, true
+		}
+	}
+	k, ok := keySynonyms[strings.ToLower(name)]
+	return k, ok
+}
@@ -0,0 +1,73 @@
+package keypad
+
+import "testing"
+
+func TestKeysString(t *testing.T) {
+	tests := []struct {
+		keys Keys
+		want string
+	}{
+		{0, "None"},
+		{BtnA, "A"},
+		{BtnShift | BtnCtrl | BtnA, "Ctrl+Shift+A"},
+		{BtnFn | BtnQuote, "Fn+'"},
+		{BtnUp, "Up"},
+		{BtnShift | BtnUp, "Shift+Up"},
+		{BtnCtrl | BtnAlt | BtnDel, "Ctrl+Alt+Del"},
+		{BtnF12, "F12"},
+		{BtnFn, "Fn"},
+		{BtnCtrl | BtnShift | Btn6, "Ctrl+Shift+6"},
+	}
+	for _, tc := range tests {
+		if got := tc.keys.String(); got != tc.want {
+			t.Fatalf("Keys(%#x).String() = %q, want %q", int64(tc.keys), got, tc.want)
+		}
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Keys
+	}{
+		{"Ctrl+Alt+Del", BtnCtrl | BtnAlt | BtnDel},
+		{"ctrl+shift+a", BtnCtrl | BtnShift | BtnA},
+		{"Fn+;", BtnUp},
+		{"Fn+,", BtnLeft},
+		{"Shift + Up", BtnShift | BtnUp},
+		{"F10", BtnF10},
+		{"Control+Return", BtnCtrl | BtnEnter},
+		{"None", 0},
+	}
+	for _, tc := range tests {
+		got, err := ParseKeys(tc.in)
+		if err != nil || got != tc.want {
+			t.Fatalf("ParseKeys(%q) = %#x, %v; want %#x, nil", tc.in, int64(got), err, int64(tc.want))
+		}
+	}
+
+	for _, in := range []string{"", "Ctrl+", "Hyper+A", "+"} {
+		if _, err := ParseKeys(in); err == nil {
+			t.Fatalf("ParseKeys(%q) succeeded, want error", in)
+		}
+	}
+}
+
+func TestKeysRoundTrip(t *testing.T) {
+	for i := range keyNames {
+		k := Keys(1) << i
+		got, err := ParseKeys(k.String())
+		if err != nil || got != k {
+			t.Fatalf("ParseKeys(%q) = %#x, %v; want %#x", k.String(), int64(got), err, int64(k))
+		}
+	}
+	for _, a := range keyAliases {
+		for _, k := range []Keys{a.keys, a.keys | BtnCtrl | BtnShift} {
+			var got Keys
+			text, _ := k.MarshalText()
+			if err := got.UnmarshalText(text); err != nil || got != k {
+				t.Fatalf("UnmarshalText(%q) = %#x, %v; want %#x", text, int64(got), err, int64(k))
+			}
+		}
+	}
+}
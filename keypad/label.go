@@ -0,0 +1,94 @@
+package keypad
+
+import "unicode/utf8"
+
+// MaxLabelLen is the most runes Label returns.
+const MaxLabelLen = 4
+
+// aliasLabels are the labels of the Fn combinations with their own names.
+var aliasLabels = map[Keys]string{
+	BtnUp:    "↑",
+	BtnDown:  "↓",
+	BtnLeft:  "←",
+	BtnRight: "→",
+}
+
+// sequenceLabels name escape sequences that aren't produced by an alias.
+var sequenceLabels = map[string]string{
+	"\x1b[Z": "BTab",
+}
+
+// Label returns a short label, at most MaxLabelLen runes, for what chord types
+// according to ScancodeToBytes, for drawing on a keyboard legend: the
+// character itself, ^C style names for control characters, names such as
+// Esc, Del and F5 for the Fn combinations, and arrows for the cursor keys.
+// Shifted special keys are prefixed with "S" and Alt chords with "M-", or "M"
+// when space is short. Label returns "" when chord types nothing.
+func Label(chord int64) string {
+	if chord&BtnAlt != 0 {
+		l := Label(chord &^ BtnAlt)
+		if l == "" {
+			return ""
+		}
+		if utf8.RuneCountInString(l) <= MaxLabelLen-2 {
+			return "M-" + l
+		}
+		return truncateLabel("M" + l)
+	}
+
+	b, ok := ScancodeToBytes[chord]
+	if !ok {
+		return ""
+	}
+	if len(b) == 1 {
+		switch c := b[0]; {
+		case c == ' ':
+			return "Spc"
+		case c == '\n' || c == '\r':
+			return "Ent"
+		case c == '\t':
+			return "Tab"
+		case c == '\b' || c == 0x7f:
+			return "Bksp"
+		case c == 0x1b:
+			return "Esc"
+		case c < 0x20:
+			return "^" + string(rune(c+'@'))
+		case c < 0x7f:
+			return string(rune(c))
+		}
+		return ""
+	}
+
+	if l, ok := sequenceLabels[string(b)]; ok {
+		return l
+	}
+	prefix := ""
+	if chord&BtnShift != 0 {
+		prefix = "S"
+		chord &^= BtnShift
+	}
+	if l, ok := aliasLabels[Keys(chord)]; ok {
+		return prefix + l
+	}
+	for _, a := range keyAliases {
+		if a.keys == Keys(chord) {
+			return truncateLabel(prefix + a.name)
+		}
+	}
+	return ""
+}
+
+func truncateLabel(s string) string {
+	if utf8.RuneCountInString(s) <= MaxLabelLen {
+		return s
+	}
+	n := 0
+	for i := range s {
+		if n == MaxLabelLen {
+			return s[:i]
+		}
+		n++
+	}
+	return s
+}
@@ -0,0 +1,32 @@
+package keypad
+
+import "testing"
+
+func TestLabel(t *testing.T) {
+	for _, tt := range []struct {
+		chord int64
+		want  string
+	}{
+		{BtnA, "a"},
+		{BtnShift | BtnA, "A"},
+		{BtnSpace, "Spc"},
+		{BtnEnter, "Ent"},
+		{BtnBackspace, "Bksp"},
+		{BtnCtrl | BtnC, "^C"},
+		{BtnCtrl | BtnBraceLeft, "Esc"},
+		{BtnEsc, "Esc"},
+		{BtnDel, "Del"},
+		{BtnF12, "F12"},
+		{BtnUp, "↑"},
+		{BtnShift | BtnLeft, "S←"},
+		{BtnShift | BtnTab, "BTab"},
+		{BtnAlt | BtnX, "M-x"},
+		{BtnAlt | BtnF10, "MF10"},
+		{BtnFn | BtnA, ""},
+		{BtnOpt | BtnA, ""},
+	} {
+		if got := Label(tt.chord); got != tt.want {
+			t.Errorf("Label(%v) = %q, want %q", Keys(tt.chord), got, tt.want)
+		}
+	}
+}
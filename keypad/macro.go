@@ -0,0 +1,414 @@
+package keypad
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"tinygo.org/x/tinyfs"
+)
+
+// DefaultMacroRecordKeys is the chord that starts and stops macro recording.
+const DefaultMacroRecordKeys Keys = BtnFn | BtnOpt
+
+// ErrMacroPlaying is returned by (*Macros).Play while another macro is playing.
+var ErrMacroPlaying = errors.New("macro already playing")
+
+// keyEvent is a synthetic press or release of keys.
+type keyEvent struct {
+	down bool
+	keys int64
+}
+
+func (ev keyEvent) apply(state int64) int64 {
+	if ev.down {
+		return state | ev.keys
+	}
+	return state &^ ev.keys
+}
+
+// Inject presses (down) or releases keys as though they changed on the keypad.
+// The event goes through the same state tracking, sticky modifiers, callbacks
+// and key repeat as a physical one, and injected keys stay held until Inject
+// releases them. While the scan loop is running the event is handed to it, so
+// Inject must not be called from the keypad callbacks. An event injected as
+// the scan loop stops is dropped.
+func (d *Device) Inject(down bool, keys Keys) {
+	ev := keyEvent{down: down, keys: int64(keys)}
+	if stop, exited := d.running(); stop != nil {
+		select {
+		case d.inject <- ev:
+		case <-exited:
+		}
+		return
+	}
+	d.applyInjected(ev, d.clock.Now())
+}
+
+// Play performs script through d's normal event path using Inject. When
+// realTime is set the script's waits are honoured; otherwise they are skipped
+// and events follow each other as quickly as the scan loop accepts them.
+func (d *Device) Play(script Script, realTime bool) error {
+	var keyDelay time.Duration
+	if realTime {
+		keyDelay = d.scanPeriod
+	}
+	return script.run(devicePlayer{d: d, realTime: realTime}, keyDelay)
+}
+
+// devicePlayer is the scriptDriver used by Play.
+type devicePlayer struct {
+	d        *Device
+	realTime bool
+}
+
+func (p devicePlayer) setKeys(down bool, keys Keys) {
+	p.d.Inject(down, keys)
+}
+
+func (p devicePlayer) wait(d time.Duration) {
+	if p.realTime {
+		time.Sleep(d)
+	}
+}
+
+// Macros records keystroke macros from a Device and replays them through its
+// normal event path. Each macro is bound to a chord with Bind: pressing the
+// chord plays the macro, and pressing RecordKeys followed by the chord records
+// into it until RecordKeys is pressed again. Keys typed while recording still
+// reach the Device's callbacks as usual.
+//
+// Macros are kept in memory and, when NewMacros was given a way to open files,
+// saved as Script text files named <dir>/<name>.txt so they can be replayed
+// after a restart or edited by hand.
+type Macros struct {
+	// RecordKeys is the chord that starts and stops recording.
+	RecordKeys Keys
+	// RealTime replays macros with their recorded timing. Otherwise waits
+	// are skipped and the keys are replayed as fast as possible.
+	RealTime bool
+	// RecordCallback is called, if set, when recording into the named macro
+	// starts or stops.
+	RecordCallback func(name string, recording bool)
+	// ErrorCallback is called, if set, when saving a recording or playing a
+	// macro triggered from the keypad fails.
+	ErrorCallback func(error)
+	// Mkdir, if set, creates the macro directory before a macro is saved.
+	// Its error is ignored, as the directory usually exists already.
+	Mkdir func(path string, perm os.FileMode) error
+
+	open func(path string, flags int) (tinyfs.File, error)
+	dir  string
+	d    *Device
+	// now is the clock used to time recordings; tests replace it.
+	now func() time.Time
+
+	mu      sync.Mutex
+	bound   map[Keys]string
+	scripts map[string]Script
+	playing bool
+	// queued is a macro whose chord was pressed, to play once every key in
+	// queuedKeys is released.
+	queued     string
+	queuedKeys Keys
+	// armed is set once RecordKeys is pressed, until a macro chord follows.
+	armed bool
+	// recording is the name of the macro being recorded.
+	recording string
+	rec       Script
+	// recDown are the keys pressed since recording started and still held.
+	recDown Keys
+	// last is the physical state at the previous event.
+	last   Keys
+	lastAt time.Time
+}
+
+// NewMacros returns a *Macros saving macros under dir in files opened with
+// open, such as cardputer.SDFS.OpenFile. open may be nil to keep macros in
+// memory only.
+func NewMacros(open func(path string, flags int) (tinyfs.File, error), dir string) *Macros {
+	return &Macros{
+		RecordKeys: DefaultMacroRecordKeys,
+		open:       open,
+		dir:        dir,
+		now:        time.Now,
+		bound:      make(map[Keys]string),
+		scripts:    make(map[string]Script),
+	}
+}
+
+// Attach makes m watch d's button presses and releases. Chords used by m are
+// swallowed; everything else is forwarded to the previous callbacks, so set
+// up WriteByteCallback, Bindings and the like before calling Attach.
+func (m *Macros) Attach(d *Device) {
+	m.d = d
+	press := d.EventPressCallback
+	release := d.EventReleaseCallback
+	d.EventPressCallback = func(p int64) {
+		if m.press(Keys(d.State()), Keys(d.Held())) {
+			return
+		}
+		if press != nil {
+			press(p)
+		}
+	}
+	d.EventReleaseCallback = func(r int64) {
+		m.release(Keys(d.State()))
+		if release != nil {
+			release(r)
+		}
+	}
+}
+
+// Bind makes keys play the macro called name, and RecordKeys followed by keys
+// record it. The macro plays once the chord is released, so its keys don't
+// merge with the ones still held.
+func (m *Macros) Bind(keys Keys, name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bound[keys] = name
+}
+
+// Unbind removes the macro bound to keys. The macro itself is kept.
+func (m *Macros) Unbind(keys Keys) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.bound, keys)
+}
+
+// Recording returns the name of the macro being recorded, or "".
+func (m *Macros) Recording() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.recording
+}
+
+// Macro returns the named macro, loading it from the filesystem if needed.
+func (m *Macros) Macro(name string) (Script, error) {
+	m.mu.Lock()
+	script, ok := m.scripts[name]
+	m.mu.Unlock()
+	if ok {
+		return script, nil
+	}
+	if m.open == nil {
+		return nil, fmt.Errorf("no macro named %q", name)
+	}
+
+	f, err := m.open(m.path(name), os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	src, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	script, err = ParseScript(string(src))
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	m.scripts[name] = script
+	m.mu.Unlock()
+	return script, nil
+}
+
+// SetMacro replaces the named macro and saves it.
+func (m *Macros) SetMacro(name string, script Script) error {
+	m.mu.Lock()
+	m.scripts[name] = script
+	m.mu.Unlock()
+	return m.save(name, script)
+}
+
+// Play replays the named macro on the attached Device and returns once it
+// has finished. Macro chords are ignored while it plays.
+func (m *Macros) Play(name string) error {
+	if m.d == nil {
+		return errors.New("macros not attached to a device")
+	}
+	script, err := m.Macro(name)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	if m.playing {
+		m.mu.Unlock()
+		return ErrMacroPlaying
+	}
+	m.playing = true
+	m.mu.Unlock()
+
+	err = m.d.Play(script, m.RealTime)
+
+	m.mu.Lock()
+	m.playing = false
+	m.mu.Unlock()
+	return err
+}
+
+// press handles a key press leaving state physically held and chord held with
+// sticky modifiers applied. It reports whether the press was consumed.
+func (m *Macros) press(state, chord Keys) bool {
+	m.mu.Lock()
+	now := m.now()
+	if m.playing {
+		m.record(state, now)
+		m.mu.Unlock()
+		return false
+	}
+
+	switch name, bound := m.bound[chord]; {
+	case chord == m.RecordKeys:
+		if m.recording == "" {
+			m.armed = true
+			m.mu.Unlock()
+			return true
+		}
+		name, script := m.stop()
+		m.mu.Unlock()
+		m.recordCallback(name, false)
+		if err := m.save(name, script); err != nil {
+			m.errorCallback(err)
+		}
+		return true
+	case m.armed && chord&^BtnSpecialMask == 0:
+		// still building the macro chord
+	case m.armed:
+		m.armed = false
+		if bound {
+			m.start(name, state, now)
+			m.mu.Unlock()
+			m.recordCallback(name, true)
+			return true
+		}
+	case bound && m.recording == "":
+		m.queued, m.queuedKeys = name, state
+		m.mu.Unlock()
+		return true
+	}
+
+	m.record(state, now)
+	m.mu.Unlock()
+	return false
+}
+
+func (m *Macros) release(state Keys) {
+	m.mu.Lock()
+	m.record(state, m.now())
+	name := m.queued
+	if name == "" || state&m.queuedKeys != 0 {
+		m.mu.Unlock()
+		return
+	}
+	m.queued, m.queuedKeys = "", 0
+	m.mu.Unlock()
+
+	// Play injects, which the keypad goroutine running this callback must
+	// be free to receive.
+	go func() {
+		if err := m.Play(name); err != nil {
+			m.errorCallback(err)
+		}
+	}()
+}
+
+func (m *Macros) start(name string, state Keys, now time.Time) {
+	m.recording = name
+	m.rec = nil
+	m.recDown = 0
+	m.last = state
+	m.lastAt = now
+}
+
+// stop ends the recording and returns it, dropping the presses of RecordKeys
+// that ended it and releasing any keys still held.
+func (m *Macros) stop() (string, Script) {
+	rec := m.rec
+	for len(rec) > 0 {
+		step := rec[len(rec)-1]
+		if step.Op == ScriptDown && step.Keys&^m.RecordKeys == 0 {
+			m.recDown &^= step.Keys
+		} else if step.Op != ScriptWait {
+			break
+		}
+		rec = rec[:len(rec)-1]
+	}
+	if m.recDown != 0 {
+		rec = append(rec, ScriptStep{Op: ScriptUp, Keys: m.recDown})
+	}
+
+	name := m.recording
+	m.scripts[name] = rec
+	m.recording = ""
+	m.rec = nil
+	m.recDown = 0
+	return name, rec
+}
+
+// record appends the change from the previous state to the recording.
+// Releases of keys that were already held when recording started are left out.
+func (m *Macros) record(state Keys, now time.Time) {
+	if m.recording == "" {
+		return
+	}
+	down := state &^ m.last
+	up := m.last &^ state & m.recDown
+	m.last = state
+	if down == 0 && up == 0 {
+		return
+	}
+
+	if gap := now.Sub(m.lastAt).Round(time.Millisecond); gap > 0 && len(m.rec) > 0 {
+		m.rec = append(m.rec, ScriptStep{Op: ScriptWait, Duration: gap})
+	}
+	m.lastAt = now
+	if up != 0 {
+		m.rec = append(m.rec, ScriptStep{Op: ScriptUp, Keys: up})
+		m.recDown &^= up
+	}
+	if down != 0 {
+		m.rec = append(m.rec, ScriptStep{Op: ScriptDown, Keys: down})
+		m.recDown |= down
+	}
+}
+
+func (m *Macros) save(name string, script Script) error {
+	if m.open == nil {
+		return nil
+	}
+	if m.Mkdir != nil {
+		m.Mkdir(m.dir, 0o755)
+	}
+	f, err := m.open(m.path(name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, script.String()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (m *Macros) path(name string) string {
+	return path.Join(m.dir, name+".txt")
+}
+
+func (m *Macros) recordCallback(name string, recording bool) {
+	if m.RecordCallback != nil {
+		m.RecordCallback(name, recording)
+	}
+}
+
+func (m *Macros) errorCallback(err error) {
+	if m.ErrorCallback != nil {
+		m.ErrorCallback(err)
+	}
+}
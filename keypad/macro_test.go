@@ -0,0 +1,179 @@
+package keypad
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tinygo.org/x/tinyfs"
+)
+
+type memFS map[string]*bytes.Buffer
+
+type memFile struct {
+	*bytes.Buffer
+}
+
+func (memFile) Close() error                       { return nil }
+func (memFile) Seek(int64, int) (int64, error)     { return 0, nil }
+func (memFile) IsDir() bool                        { return false }
+func (memFile) Readdir(int) ([]os.FileInfo, error) { return nil, nil }
+func (memFile) Stat() (os.FileInfo, error)         { return nil, nil }
+
+func (m memFS) OpenFile(path string, flags int) (tinyfs.File, error) {
+	buf, ok := m[path]
+	if flags&os.O_CREATE != 0 {
+		buf = new(bytes.Buffer)
+		m[path] = buf
+	} else if !ok {
+		return nil, os.ErrNotExist
+	}
+	return memFile{buf}, nil
+}
+
+func TestMacrosRecordAndPlay(t *testing.T) {
+	fs := memFS{}
+	s, out := newTestSimulator()
+	m := NewMacros(fs.OpenFile, "/macros")
+	m.now = s.Now
+	m.Bind(BtnFn|Btn1, "greet")
+	var events []string
+	m.RecordCallback = func(name string, recording bool) {
+		events = append(events, name+map[bool]string{true: " on", false: " off"}[recording])
+	}
+	m.Attach(s.Device)
+
+	err := s.Run(`
+		down Fn; down Opt; up Opt; up Fn
+		press Fn+1
+		type 'ls'
+		wait 100ms
+		press Enter
+		down Fn; down Opt; up Opt; up Fn
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "ls\n" {
+		t.Fatalf("Receiver got %q while recording, want %q", got, "ls\n")
+	}
+	if strings.Join(events, ",") != "greet on,greet off" {
+		t.Fatalf("record callbacks = %q", events)
+	}
+	if m.Recording() != "" {
+		t.Fatalf("still recording %q", m.Recording())
+	}
+
+	saved := fs["/macros/greet.txt"]
+	if saved == nil {
+		t.Fatalf("macro not saved, files: %v", fs)
+	}
+	for _, unwanted := range []string{"Fn", "Opt", " 1\n"} {
+		if strings.Contains(saved.String(), unwanted) {
+			t.Fatalf("saved macro contains %q:\n%s", unwanted, saved)
+		}
+	}
+	if !strings.Contains(saved.String(), "wait 130ms") {
+		t.Fatalf("saved macro lost its timing:\n%s", saved)
+	}
+
+	// a fresh Macros loads the saved file and replays it instantly
+	s2, out2 := newTestSimulator()
+	m2 := NewMacros(fs.OpenFile, "/macros")
+	m2.Attach(s2.Device)
+	if err := m2.Play("greet"); err != nil {
+		t.Fatal(err)
+	}
+	if got := out2.String(); got != "ls\n" {
+		t.Fatalf("Receiver got %q on playback, want %q", got, "ls\n")
+	}
+	if s2.State() != 0 {
+		t.Fatalf("State() = %#x after playback, want 0", s2.State())
+	}
+}
+
+func TestMacrosRecordKeysAlone(t *testing.T) {
+	s, out := newTestSimulator()
+	m := NewMacros(nil, "")
+	m.Bind(BtnFn|Btn1, "greet")
+	m.Attach(s.Device)
+
+	// arming and then typing something that isn't a macro chord cancels
+	if err := s.Run("press Fn+Opt; type 'x'; press Fn+1"); err != nil {
+		t.Fatal(err)
+	}
+	if m.Recording() != "" {
+		t.Fatalf("recording %q, want none", m.Recording())
+	}
+	if got := out.String(); got != "x" {
+		t.Fatalf("Receiver got %q, want %q", got, "x")
+	}
+	if _, err := m.Macro("missing"); err == nil {
+		t.Fatalf("Macro(missing) succeeded")
+	}
+}
+
+// lockedWriter collects writes from the goroutine replaying a macro and
+// signals each one.
+type lockedWriter struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	wrote chan struct{}
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf.Write(p)
+	select {
+	case w.wrote <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+func (w *lockedWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestMacrosChordPlays(t *testing.T) {
+	s := NewSimulator()
+	out := &lockedWriter{wrote: make(chan struct{}, 1)}
+	s.Receiver = out
+	m := NewMacros(nil, "")
+	script, err := ParseScript("type 'ls'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetMacro("list", script)
+	m.Bind(BtnFn|Btn1, "list")
+	m.Attach(s.Device)
+
+	// nothing plays while any key of the chord is still down
+	if err := s.Run("down Fn; press 1"); err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	queued := m.queued
+	m.mu.Unlock()
+	if queued != "list" || out.String() != "" {
+		t.Fatalf("with Fn held queued %q, Receiver got %q; want list, nothing", queued, out.String())
+	}
+
+	if err := s.Run("up Fn"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(5 * time.Second)
+	for out.String() != "ls" {
+		select {
+		case <-out.wrote:
+		case <-deadline:
+			t.Fatalf("Receiver got %q after releasing the chord, want %q", out.String(), "ls")
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package keypad
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMouseToggleKeys turns mouse keys on and off.
+	DefaultMouseToggleKeys Keys = BtnFn | BtnM
+	// DefaultMouseMinSpeed is the pointer speed, in pixels per second, when a
+	// direction key is first held.
+	DefaultMouseMinSpeed = 40
+	// DefaultMouseMaxSpeed is the pointer speed, in pixels per second, after
+	// DefaultMouseAccelTime.
+	DefaultMouseMaxSpeed = 320
+	// DefaultMouseAccelTime is how long the pointer takes to reach full speed.
+	DefaultMouseAccelTime = 1200 * time.Millisecond
+	// DefaultMousePeriod is how often the pointer moves while a direction key
+	// is held.
+	DefaultMousePeriod = 20 * time.Millisecond
+)
+
+// MouseButtons is a set of pointer buttons.
+type MouseButtons uint8
+
+const (
+	MouseLeft MouseButtons = 1 << iota
+	MouseRight
+	MouseMiddle
+)
+
+// PointerEvent reports the pointer after it moved or a button changed.
+type PointerEvent struct {
+	X, Y int
+	// Buttons are the buttons held after the event.
+	Buttons MouseButtons
+	// Pressed and Released are the buttons that changed with this event.
+	// Both are zero for motion.
+	Pressed, Released MouseButtons
+}
+
+// MouseKeys turns the keyboard into a pointing device. While enabled, holding
+// a direction key moves the pointer, starting with a single pixel step and
+// accelerating from MinSpeed to MaxSpeed over AccelTime, and the button keys
+// press and release pointer buttons. Keys used by MouseKeys are swallowed;
+// the rest reach the Device's previous callbacks.
+type MouseKeys struct {
+	// Toggle enables and disables mouse keys. Zero leaves that to SetEnabled.
+	Toggle Keys
+	// Up, Down, Left and Right move the pointer. They default to the Fn
+	// arrow cluster and may be held together to move diagonally.
+	Up, Down, Left, Right Keys
+	// LeftButton, RightButton and MiddleButton press the pointer buttons.
+	// Zero leaves a button unbound.
+	LeftButton, RightButton, MiddleButton Keys
+	// MinSpeed and MaxSpeed are in pixels per second.
+	MinSpeed, MaxSpeed float32
+	// AccelTime is how long a direction must be held to reach MaxSpeed.
+	AccelTime time.Duration
+	// Period is how often the pointer moves while a direction is held.
+	Period time.Duration
+	// Width and Height bound the pointer to 0 <= X < Width, 0 <= Y < Height.
+	Width, Height int
+	// Callback receives every pointer event.
+	Callback func(PointerEvent)
+	// EnableCallback is called when mouse keys are enabled or disabled.
+	EnableCallback func(enabled bool)
+
+	// held returns the attached Device's held keys, and clock its time
+	// source, which schedules the steps while a direction is held.
+	held  func() Keys
+	clock clock
+
+	mu       sync.Mutex
+	enabled  bool
+	x, y     int
+	fx, fy   float32
+	buttons  MouseButtons
+	moving   bool
+	started  time.Time
+	lastStep time.Time
+}
+
+// NewMouseKeys returns a disabled *MouseKeys moving a pointer within width by
+// height pixels, starting at the centre. The left button is Fn+Space and the
+// right button Fn+Enter.
+func NewMouseKeys(width, height int) *MouseKeys {
+	return &MouseKeys{
+		Toggle:      DefaultMouseToggleKeys,
+		Up:          BtnUp,
+		Down:        BtnDown,
+		Left:        BtnLeft,
+		Right:       BtnRight,
+		LeftButton:  BtnFn | BtnSpace,
+		RightButton: BtnFn | BtnEnter,
+		MinSpeed:    DefaultMouseMinSpeed,
+		MaxSpeed:    DefaultMouseMaxSpeed,
+		AccelTime:   DefaultMouseAccelTime,
+		Period:      DefaultMousePeriod,
+		Width:       width,
+		Height:      height,
+		x:           width / 2,
+		y:           height / 2,
+	}
+}
+
+// Attach makes m watch d's button presses and releases.
+func (m *MouseKeys) Attach(d *Device) {
+	m.held = func() Keys { return Keys(d.Held()) }
+	m.clock = d.clock
+	press := d.EventPressCallback
+	release := d.EventReleaseCallback
+	d.EventPressCallback = func(p int64) {
+		if m.press(Keys(d.Held()), d.clock.Now()) {
+			return
+		}
+		if press != nil {
+			press(p)
+		}
+	}
+	d.EventReleaseCallback = func(r int64) {
+		m.release(Keys(d.Held()))
+		if release != nil {
+			release(r)
+		}
+	}
+}
+
+// Enabled reports whether mouse keys are on.
+func (m *MouseKeys) Enabled() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.enabled
+}
+
+// SetEnabled turns mouse keys on or off. Turning them off releases any held
+// pointer buttons.
+func (m *MouseKeys) SetEnabled(enabled bool) {
+	m.mu.Lock()
+	events := m.setEnabled(enabled)
+	m.mu.Unlock()
+	m.publish(events)
+	if m.EnableCallback != nil {
+		m.EnableCallback(enabled)
+	}
+}
+
+// Position returns the pointer position.
+func (m *MouseKeys) Position() (x, y int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.x, m.y
+}
+
+// MoveTo places the pointer at x, y.
+func (m *MouseKeys) MoveTo(x, y int) {
+	m.mu.Lock()
+	m.x, m.y = m.clamp(x, y)
+	m.fx, m.fy = 0, 0
+	ev := m.event()
+	m.mu.Unlock()
+	m.publish([]PointerEvent{ev})
+}
+
+// press handles chord becoming held and reports whether it was consumed.
+func (m *MouseKeys) press(chord Keys, now time.Time) bool {
+	if m.Toggle != 0 && chord == m.Toggle {
+		m.SetEnabled(!m.Enabled())
+		return true
+	}
+
+	m.mu.Lock()
+	if !m.enabled {
+		m.mu.Unlock()
+		return false
+	}
+	var events []PointerEvent
+	consumed := false
+	if b := m.buttonsIn(chord); b != 0 {
+		consumed = true
+		if pressed := b &^ m.buttons; pressed != 0 {
+			m.buttons |= pressed
+			ev := m.event()
+			ev.Pressed = pressed
+			events = append(events, ev)
+		}
+	}
+	startMover := false
+	if dx, dy := m.direction(chord); dx != 0 || dy != 0 {
+		consumed = true
+		if ev, moved := m.step(chord, now); moved {
+			events = append(events, ev)
+		}
+		if !m.moving && m.held != nil {
+			m.moving = true
+			startMover = true
+		}
+	}
+	m.mu.Unlock()
+
+	m.publish(events)
+	if startMover {
+		m.clock.AfterFunc(m.Period, m.move)
+	}
+	return consumed
+}
+
+// release handles keys going up, leaving chord held.
+func (m *MouseKeys) release(chord Keys) {
+	m.mu.Lock()
+	var events []PointerEvent
+	if released := m.buttons &^ m.buttonsIn(chord); released != 0 {
+		m.buttons &^= released
+		ev := m.event()
+		ev.Released = released
+		events = append(events, ev)
+	}
+	m.mu.Unlock()
+	m.publish(events)
+}
+
+// move steps the pointer and schedules itself again after Period until no
+// direction is held.
+func (m *MouseKeys) move() {
+	chord := m.held()
+	m.mu.Lock()
+	ev, moved := m.step(chord, m.clock.Now())
+	stop := !m.moving
+	m.mu.Unlock()
+	if moved {
+		m.publish([]PointerEvent{ev})
+	}
+	if !stop {
+		m.clock.AfterFunc(m.Period, m.move)
+	}
+}
+
+// step moves the pointer for the directions held in chord and reports the
+// resulting event if the pointer moved. Letting go of every direction stops
+// the motion and resets the acceleration.
+func (m *MouseKeys) step(chord Keys, now time.Time) (PointerEvent, bool) {
+	dx, dy := m.direction(chord)
+	if !m.enabled || dx == 0 && dy == 0 {
+		m.moving = false
+		m.started = time.Time{}
+		m.fx, m.fy = 0, 0
+		return PointerEvent{}, false
+	}
+
+	if m.started.IsZero() {
+		// the first step is a single pixel, for precise positioning
+		m.started = now
+		m.lastStep = now
+		m.fx, m.fy = float32(dx), float32(dy)
+	} else {
+		speed := m.MaxSpeed
+		if held := now.Sub(m.started); held < m.AccelTime {
+			speed = m.MinSpeed + (m.MaxSpeed-m.MinSpeed)*float32(held)/float32(m.AccelTime)
+		}
+		dist := speed * float32(now.Sub(m.lastStep).Seconds())
+		m.lastStep = now
+		m.fx += float32(dx) * dist
+		m.fy += float32(dy) * dist
+	}
+
+	ix, iy := int(m.fx), int(m.fy)
+	m.fx -= float32(ix)
+	m.fy -= float32(iy)
+	x, y := m.clamp(m.x+ix, m.y+iy)
+	if x == m.x && y == m.y {
+		return PointerEvent{}, false
+	}
+	m.x, m.y = x, y
+	return m.event(), true
+}
+
+func (m *MouseKeys) setEnabled(enabled bool) []PointerEvent {
+	m.enabled = enabled
+	if enabled || m.buttons == 0 {
+		return nil
+	}
+	ev := m.event()
+	ev.Released = m.buttons
+	ev.Buttons = 0
+	m.buttons = 0
+	return []PointerEvent{ev}
+}
+
+// direction returns the unit motion for the direction keys held in chord.
+func (m *MouseKeys) direction(chord Keys) (dx, dy int) {
+	has := func(k Keys) bool { return k != 0 && chord&k == k }
+	if has(m.Left) {
+		dx--
+	}
+	if has(m.Right) {
+		dx++
+	}
+	if has(m.Up) {
+		dy--
+	}
+	if has(m.Down) {
+		dy++
+	}
+	return dx, dy
+}
+
+func (m *MouseKeys) buttonsIn(chord Keys) MouseButtons {
+	var b MouseButtons
+	for _, bk := range [...]struct {
+		keys   Keys
+		button MouseButtons
+	}{{m.LeftButton, MouseLeft}, {m.RightButton, MouseRight}, {m.MiddleButton, MouseMiddle}} {
+		if bk.keys != 0 && chord&bk.keys == bk.keys {
+			b |= bk.button
+		}
+	}
+	return b
+}
+
+func (m *MouseKeys) clamp(x, y int) (int, int) {
+	if m.Width > 0 {
+		x = max(0, min(x, m.Width-1))
+	}
+	if m.Height > 0 {
+		y = max(0, min(y, m.Height-1))
+	}
+	return x, y
+}
+
+func (m *MouseKeys) event() PointerEvent {
+	return PointerEvent{X: m.x, Y: m.y, Buttons: m.buttons}
+}
+
+func (m *MouseKeys) publish(events []PointerEvent) {
+	if m.Callback == nil {
+		return
+	}
+	for _, ev := range events {
+		m.Callback(ev)
+	}
+}
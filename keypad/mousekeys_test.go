@@ -0,0 +1,132 @@
+package keypad
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMouseKeysToggleAndSwallow(t *testing.T) {
+	s, out := newTestSimulator()
+	m := NewMouseKeys(240, 135)
+	var enabled []bool
+	m.EnableCallback = func(on bool) { enabled = append(enabled, on) }
+	m.Attach(s.Device)
+
+	if err := s.Run("press Up; press Fn+M; press Up; press x; press Fn+M; press Up"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "\x1b[Ax\x1b[A"; got != want {
+		t.Fatalf("Receiver got %q, want %q", got, want)
+	}
+	if len(enabled) != 2 || !enabled[0] || enabled[1] {
+		t.Fatalf("EnableCallback got %v, want [true false]", enabled)
+	}
+}
+
+func TestMouseKeysAcceleration(t *testing.T) {
+	m := NewMouseKeys(240, 135)
+	m.SetEnabled(true)
+	now := time.Unix(0, 0)
+
+	ev, moved := m.step(BtnRight, now)
+	if !moved || ev.X != 121 || ev.Y != 67 {
+		t.Fatalf("first step = %+v, %v; want a single pixel to 121,67", ev, moved)
+	}
+
+	// slow at first
+	for i := 1; i <= 10; i++ {
+		m.step(BtnRight, now.Add(time.Duration(i)*20*time.Millisecond))
+	}
+	x, _ := m.Position()
+	early := x - 121
+
+	// full speed after AccelTime
+	base := now.Add(2 * m.AccelTime)
+	m.step(BtnRight, base)
+	m.MoveTo(0, 67)
+	x0, _ := m.Position()
+	for i := 1; i <= 10; i++ {
+		m.step(BtnRight, base.Add(time.Duration(i)*20*time.Millisecond))
+	}
+	x, _ = m.Position()
+	late := x - x0
+	if early >= late || late < 60 || late > 66 {
+		t.Fatalf("moved %d px in the first 200ms and %d px at full speed, want accelerating to ~64", early, late)
+	}
+
+	// letting go resets acceleration and the next press steps one pixel
+	m.step(0, base.Add(time.Second))
+	x0, y0 := m.Position()
+	ev, _ = m.step(BtnUp|BtnLeft, base.Add(2*time.Second))
+	if ev.X != x0-1 || ev.Y != y0-1 {
+		t.Fatalf("diagonal step to %d,%d, want %d,%d", ev.X, ev.Y, x0-1, y0-1)
+	}
+}
+
+func TestMouseKeysSimulatedHold(t *testing.T) {
+	s, _ := newTestSimulator()
+	m := NewMouseKeys(240, 135)
+	var events int
+	m.Callback = func(PointerEvent) { events++ }
+	m.Attach(s.Device)
+	m.SetEnabled(true)
+	events = 0
+
+	s.Down(BtnRight)
+	s.Advance(500 * time.Millisecond)
+	s.Up(BtnRight)
+	s.Advance(100 * time.Millisecond)
+
+	// the same steps taken by hand on the virtual clock's schedule
+	ref := NewMouseKeys(240, 135)
+	ref.SetEnabled(true)
+	var wantEvents int
+	for t := time.Duration(0); t <= 500*time.Millisecond; t += ref.Period {
+		if _, moved := ref.step(BtnRight, time.Unix(0, 0).Add(t)); moved {
+			wantEvents++
+		}
+	}
+	wantX, _ := ref.Position()
+	if x, _ := m.Position(); x != wantX || events != wantEvents {
+		t.Fatalf("after holding Right 500ms: x = %d after %d events, want %d after %d", x, events, wantX, wantEvents)
+	}
+}
+
+func TestMouseKeysClamp(t *testing.T) {
+	m := NewMouseKeys(10, 10)
+	m.SetEnabled(true)
+	m.MoveTo(-5, 50)
+	if x, y := m.Position(); x != 0 || y != 9 {
+		t.Fatalf("Position() = %d,%d, want 0,9", x, y)
+	}
+	if _, moved := m.step(BtnLeft, time.Unix(0, 0)); moved {
+		t.Fatalf("moved past the left edge")
+	}
+}
+
+func TestMouseKeysButtons(t *testing.T) {
+	s, _ := newTestSimulator()
+	m := NewMouseKeys(240, 135)
+	var events []PointerEvent
+	m.Callback = func(ev PointerEvent) { events = append(events, ev) }
+	m.Attach(s.Device)
+	m.SetEnabled(true)
+
+	if err := s.Run("down Fn; down Space; up Space; down Enter; up Fn; up Enter"); err != nil {
+		t.Fatal(err)
+	}
+	want := []PointerEvent{
+		{X: 120, Y: 67, Buttons: MouseLeft, Pressed: MouseLeft},
+		{X: 120, Y: 67, Released: MouseLeft},
+		{X: 120, Y: 67, Buttons: MouseRight, Pressed: MouseRight},
+		{X: 120, Y: 67, Released: MouseRight},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events = %+v, want %+v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, events[i], want[i])
+		}
+	}
+}
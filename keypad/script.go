@@ -0,0 +1,283 @@
+package keypad
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ScriptOp is the action performed by a ScriptStep.
+type ScriptOp uint8
+
+const (
+	// ScriptTap presses and releases Keys.
+	ScriptTap ScriptOp = iota
+	// ScriptDown presses Keys and leaves them held.
+	ScriptDown
+	// ScriptUp releases Keys.
+	ScriptUp
+	// ScriptHold presses Keys, waits Duration and releases them.
+	ScriptHold
+	// ScriptType taps the keys that produce Text.
+	ScriptType
+	// ScriptWait waits Duration.
+	ScriptWait
+)
+
+// ScriptStep is a single statement of a Script.
+type ScriptStep struct {
+	Op       ScriptOp
+	Keys     Keys
+	Text     string
+	Duration time.Duration
+}
+
+// Script is a list of keypad actions, such as a test scenario or a recorded
+// macro. See ParseScript for the text form.
+type Script []ScriptStep
+
+// ParseScript parses the text form of a Script. Statements are separated by
+// newlines or by a ',' or ';' following a word, and '#' starts a comment.
+// Key names use the ParseKeys syntax and durations the time.ParseDuration
+// syntax. The statements are:
+//
+//	type 'text'            tap the keys that produce text ("..." allows Go escapes)
+//	press KEYS             tap KEYS, for example press Ctrl+C (tap is a synonym)
+//	down KEYS              press KEYS and keep them held
+//	up KEYS                release KEYS
+//	hold KEYS [for] DUR    press KEYS, wait DUR and release them
+//	wait DUR               let DUR pass (sleep is a synonym)
+//
+// For example: type 'hello', press Ctrl+C, hold Fn+; for 600ms
+func ParseScript(src string) (Script, error) {
+	var script Script
+	statements, err := splitScript(src)
+	if err != nil {
+		return nil, err
+	}
+	for _, words := range statements {
+		step, err := parseScriptStep(words)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", strings.Join(words, " "), err)
+		}
+		script = append(script, step)
+	}
+	return script, nil
+}
+
+// String returns the text form of the script, one statement per line.
+func (s Script) String() string {
+	var sb strings.Builder
+	for _, step := range s {
+		switch step.Op {
+		case ScriptTap:
+			fmt.Fprintf(&sb, "press %s\n", scriptKeys(step.Keys))
+		case ScriptDown:
+			fmt.Fprintf(&sb, "down %s\n", scriptKeys(step.Keys))
+		case ScriptUp:
+			fmt.Fprintf(&sb, "up %s\n", scriptKeys(step.Keys))
+		case ScriptHold:
+			fmt.Fprintf(&sb, "hold %s for %s\n", scriptKeys(step.Keys), step.Duration)
+		case ScriptType:
+			fmt.Fprintf(&sb, "type %s\n", strconv.Quote(step.Text))
+		case ScriptWait:
+			fmt.Fprintf(&sb, "wait %s\n", step.Duration)
+		}
+	}
+	return sb.String()
+}
+
+// scriptKeys formats keys so that a lone separator or quote key isn't
+// mistaken for script syntax.
+func scriptKeys(k Keys) string {
+	switch s := k.String(); s {
+	case ",":
+		return "Comma"
+	case ";":
+		return "Semicolon"
+	case "'":
+		return "Quote"
+	default:
+		return s
+	}
+}
+
+// scriptDriver performs the primitive actions of a Script.
+type scriptDriver interface {
+	// setKeys changes the held keys.
+	setKeys(down bool, keys Keys)
+	// wait lets d pass.
+	wait(d time.Duration)
+}
+
+// run performs the script on drv, pausing keyDelay after every key event
+// generated by press and type statements.
+func (s Script) run(drv scriptDriver, keyDelay time.Duration) error {
+	for _, step := range s {
+		switch step.Op {
+		case ScriptTap:
+			tapKeys(drv, step.Keys, keyDelay)
+		case ScriptDown:
+			drv.setKeys(true, step.Keys)
+		case ScriptUp:
+			drv.setKeys(false, step.Keys)
+		case ScriptHold:
+			drv.setKeys(true, step.Keys)
+			drv.wait(step.Duration)
+			drv.setKeys(false, step.Keys)
+		case ScriptType:
+			for i := 0; i < len(step.Text); i++ {
+				keys, ok := keysForByte(step.Text[i])
+				if !ok {
+					return fmt.Errorf("no key produces %q", step.Text[i])
+				}
+				tapKeys(drv, keys, keyDelay)
+			}
+		case ScriptWait:
+			drv.wait(step.Duration)
+		}
+	}
+	return nil
+}
+
+func tapKeys(drv scriptDriver, keys Keys, keyDelay time.Duration) {
+	drv.setKeys(true, keys)
+	drv.wait(keyDelay)
+	drv.setKeys(false, keys)
+	drv.wait(keyDelay)
+}
+
+// keysForByte returns the simplest chord that ScancodeToBytes translates to b.
+func keysForByte(b byte) (Keys, bool) {
+	var best int64
+	for k, v := range ScancodeToBytes {
+		if len(v) != 1 || v[0] != b {
+			continue
+		}
+		if best == 0 || bits.OnesCount64(uint64(k)) < bits.OnesCount64(uint64(best)) ||
+			(bits.OnesCount64(uint64(k)) == bits.OnesCount64(uint64(best)) && k < best) {
+			best = k
+		}
+	}
+	return Keys(best), best != 0
+}
+
+func parseScriptStep(words []string) (ScriptStep, error) {
+	args := words[1:]
+	switch strings.ToLower(words[0]) {
+	case "type":
+		if len(args) != 1 {
+			return ScriptStep{}, fmt.Errorf("want one text argument")
+		}
+		return ScriptStep{Op: ScriptType, Text: args[0]}, nil
+	case "press", "tap", "down", "up":
+		if len(args) != 1 {
+			return ScriptStep{}, fmt.Errorf("want one key argument")
+		}
+		keys, err := ParseKeys(args[0])
+		if err != nil {
+			return ScriptStep{}, err
+		}
+		op := map[string]ScriptOp{"press": ScriptTap, "tap": ScriptTap, "down": ScriptDown, "up": ScriptUp}[strings.ToLower(words[0])]
+		return ScriptStep{Op: op, Keys: keys}, nil
+	case "hold":
+		if len(args) == 3 && strings.EqualFold(args[1], "for") {
+			args = []string{args[0], args[2]}
+		}
+		if len(args) != 2 {
+			return ScriptStep{}, fmt.Errorf("want keys and a duration")
+		}
+		keys, err := ParseKeys(args[0])
+		if err != nil {
+			return ScriptStep{}, err
+		}
+		d, err := time.ParseDuration(args[1])
+		if err != nil {
+			return ScriptStep{}, err
+		}
+		return ScriptStep{Op: ScriptHold, Keys: keys, Duration: d}, nil
+	case "wait", "sleep":
+		if len(args) != 1 {
+			return ScriptStep{}, fmt.Errorf("want one duration")
+		}
+		d, err := time.ParseDuration(args[0])
+		if err != nil {
+			return ScriptStep{}, err
+		}
+		return ScriptStep{Op: ScriptWait, Duration: d}, nil
+	}
+	return ScriptStep{}, fmt.Errorf("unknown statement %q", words[0])
+}
+
+// splitScript breaks src into statements of words, handling quotes,
+// separators and comments.
+func splitScript(src string) ([][]string, error) {
+	var statements [][]string
+	var words []string
+	end := func() {
+		if len(words) > 0 {
+			statements = append(statements, words)
+			words = nil
+		}
+	}
+	isSep := func(c byte) bool { return c == ',' || c == ';' }
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			end()
+			i++
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '#' && len(words) == 0:
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '\'' || c == '"':
+			var text string
+			if c == '"' {
+				quoted, err := strconv.QuotedPrefix(src[i:])
+				if err != nil {
+					return nil, fmt.Errorf("bad quoted string at offset %d", i)
+				}
+				text, _ = strconv.Unquote(quoted)
+				i += len(quoted)
+			} else {
+				j := strings.IndexByte(src[i+1:], '\'')
+				if j < 0 {
+					return nil, fmt.Errorf("unterminated quote at offset %d", i)
+				}
+				text = src[i+1 : i+1+j]
+				i += j + 2
+			}
+			words = append(words, text)
+			if i < len(src) && isSep(src[i]) {
+				end()
+				i++
+			}
+		default:
+			j := i
+			for j < len(src) && !unicode.IsSpace(rune(src[j])) {
+				j++
+			}
+			word := src[i:j]
+			i = j
+			// a trailing separator ends the statement unless it is the key
+			// named by a combo such as Fn+,
+			if n := len(word); isSep(word[n-1]) && (n == 1 || word[n-2] != '+') {
+				if n > 1 {
+					words = append(words, word[:n-1])
+				}
+				end()
+				continue
+			}
+			words = append(words, word)
+		}
+	}
+	end()
+	return statements, nil
+}
@@ -0,0 +1,150 @@
+package keypad
+
+import "time"
+
+// DefaultSimKeyDelay is the virtual time a Simulator lets pass after each key
+// event generated by press and type statements.
+const DefaultSimKeyDelay = 30 * time.Millisecond
+
+// Simulator drives a Device without keypad hardware, for host-side tests.
+// Key events are injected into the same state tracking, callback, repeat and
+// WriteByteCallback logic the scan goroutine uses, on a virtual clock that
+// only advances when the simulator waits. Set Receiver and the callbacks on
+// the embedded Device as usual; do not call Start.
+type Simulator struct {
+	*Device
+	// KeyDelay is the virtual time that passes after each key event
+	// generated by press and type statements.
+	KeyDelay time.Duration
+
+	clock *simClock
+}
+
+// NewSimulator returns a *Simulator driving a new, hardware-free Device.
+// Bindings and other handlers attached to it run on its virtual clock.
+func NewSimulator() *Simulator {
+	c := &simClock{now: time.Unix(0, 0)}
+	d := newDevice()
+	d.clock = c
+	return &Simulator{
+		Device:   d,
+		KeyDelay: DefaultSimKeyDelay,
+		clock:    c,
+	}
+}
+
+// Now returns the simulator's virtual time.
+func (s *Simulator) Now() time.Time {
+	return s.clock.now
+}
+
+// Run parses and performs a script. See ParseScript for the syntax.
+func (s *Simulator) Run(script string) error {
+	parsed, err := ParseScript(script)
+	if err != nil {
+		return err
+	}
+	return parsed.run(s, s.KeyDelay)
+}
+
+// Down presses keys and leaves them held.
+func (s *Simulator) Down(keys Keys) {
+	s.setKeys(true, keys)
+}
+
+// Up releases keys.
+func (s *Simulator) Up(keys Keys) {
+	s.setKeys(false, keys)
+}
+
+// Advance lets d pass on the virtual clock, running key repeat as the scan
+// goroutine would.
+func (s *Simulator) Advance(d time.Duration) {
+	s.wait(d)
+}
+
+func (s *Simulator) setKeys(down bool, keys Keys) {
+	s.Device.applyInjected(keyEvent{down: down, keys: int64(keys)}, s.clock.now)
+}
+
+func (s *Simulator) wait(d time.Duration) {
+	c := s.clock
+	step := s.Device.scanPeriod
+	for end := c.now.Add(d); c.now.Before(end); {
+		next := c.now.Add(step)
+		if !next.Before(end) {
+			next = end
+		}
+		if at, ok := c.nextTimer(); ok && at.Before(next) {
+			next = at
+		}
+		c.now = next
+		c.fire()
+		s.Device.tick(c.now)
+	}
+}
+
+// simClock is the Simulator's virtual clock. Its timers fire as wait moves
+// time past them.
+type simClock struct {
+	now    time.Time
+	timers []*simTimer
+}
+
+type simTimer struct {
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func (t *simTimer) Stop() bool {
+	was := !t.stopped
+	t.stopped = true
+	return was
+}
+
+func (c *simClock) Now() time.Time {
+	return c.now
+}
+
+func (c *simClock) AfterFunc(d time.Duration, f func()) stopper {
+	t := &simTimer{at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// nextTimer returns when the earliest pending timer is due.
+func (c *simClock) nextTimer() (time.Time, bool) {
+	var at time.Time
+	for _, t := range c.timers {
+		if !t.stopped && (at.IsZero() || t.at.Before(at)) {
+			at = t.at
+		}
+	}
+	return at, !at.IsZero()
+}
+
+// fire runs every timer that is due, including ones they start.
+func (c *simClock) fire() {
+	for {
+		var due *simTimer
+		n := 0
+		for _, t := range c.timers {
+			switch {
+			case t.stopped:
+			case due == nil && !t.at.After(c.now):
+				due = t
+			default:
+				c.timers[n] = t
+				n++
+			}
+		}
+		clear(c.timers[n:])
+		c.timers = c.timers[:n]
+		if due == nil {
+			return
+		}
+		due.stopped = true
+		due.f()
+	}
+}
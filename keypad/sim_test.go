@@ -0,0 +1,139 @@
+package keypad
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSimulator() (*Simulator, *bytes.Buffer) {
+	s := NewSimulator()
+	out := new(bytes.Buffer)
+	s.Receiver = out
+	return s, out
+}
+
+func TestSimulatorType(t *testing.T) {
+	s, out := newTestSimulator()
+	if err := s.Run(`type 'Hello, world!'`); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "Hello, world!" {
+		t.Fatalf("Receiver got %q, want %q", got, "Hello, world!")
+	}
+	if s.State() != 0 {
+		t.Fatalf("State() = %#x after typing, want 0", s.State())
+	}
+}
+
+func TestSimulatorPressAndCallbacks(t *testing.T) {
+	s, out := newTestSimulator()
+	var presses, releases []int64
+	s.EventPressCallback = func(p int64) {
+		presses = append(presses, p)
+		s.WriteByteCallback(p)
+	}
+	s.EventReleaseCallback = func(r int64) { releases = append(releases, r) }
+
+	if err := s.Run("down Ctrl; press C; up Ctrl"); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "\x03" {
+		t.Fatalf("Receiver got %q, want %q", got, "\x03")
+	}
+	if len(presses) != 2 || presses[0] != BtnCtrl || presses[1] != BtnC {
+		t.Fatalf("presses = %#x, want [Ctrl C]", presses)
+	}
+	if len(releases) != 2 || releases[0] != BtnC || releases[1] != BtnCtrl {
+		t.Fatalf("releases = %#x, want [C Ctrl]", releases)
+	}
+}
+
+func TestSimulatorHold(t *testing.T) {
+	s, out := newTestSimulator()
+	start := s.Now()
+	if err := s.Run("hold Fn+; for 600ms"); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); !strings.HasPrefix(got, "\x1b[A") {
+		t.Fatalf("Receiver got %q, want it to start with ESC [ A", got)
+	}
+	if got := s.Now().Sub(start); got != 600*time.Millisecond {
+		t.Fatalf("virtual clock advanced %v, want 600ms", got)
+	}
+}
+
+func TestSimulatorSticky(t *testing.T) {
+	s, out := newTestSimulator()
+	s.Sticky = NewStickyKeys()
+	var held []int64
+	s.EventPressCallback = func(p int64) {
+		held = append(held, s.Held())
+		s.WriteByteCallback(p)
+	}
+	if err := s.Run("press Shift, press a, press b"); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "Ab" {
+		t.Fatalf("Receiver got %q, want %q", got, "Ab")
+	}
+	// the press of a uses up the latch but Held still reports it
+	if len(held) != 3 || held[1] != BtnShift|BtnA || held[2] != BtnB {
+		t.Fatalf("Held() in press callbacks = %#x, want [Shift Shift+A B]", held)
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	script, err := ParseScript(`
+		# comment
+		type "a\tb"; press Ctrl+C, tap Fn+,
+		down Shift
+		up Shift
+		hold Fn+; for 600ms
+		wait 1s
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Script{
+		{Op: ScriptType, Text: "a\tb"},
+		{Op: ScriptTap, Keys: BtnCtrl | BtnC},
+		{Op: ScriptTap, Keys: BtnFn | BtnComma},
+		{Op: ScriptDown, Keys: BtnShift},
+		{Op: ScriptUp, Keys: BtnShift},
+		{Op: ScriptHold, Keys: BtnUp, Duration: 600 * time.Millisecond},
+		{Op: ScriptWait, Duration: time.Second},
+	}
+	if len(script) != len(want) {
+		t.Fatalf("got %d steps, want %d:\n%s", len(script), len(want), script)
+	}
+	for i := range want {
+		if script[i] != want[i] {
+			t.Errorf("step %d = %+v, want %+v", i, script[i], want[i])
+		}
+	}
+
+	again, err := ParseScript(script.String())
+	if err != nil {
+		t.Fatalf("reparsing %q: %v", script.String(), err)
+	}
+	if again.String() != script.String() {
+		t.Fatalf("round trip changed script:\n%s\nwant:\n%s", again, script)
+	}
+}
+
+func TestParseScriptErrors(t *testing.T) {
+	for _, src := range []string{
+		"jump A",
+		"press",
+		"press Hyper",
+		"hold A",
+		"wait soon",
+		"type 'unterminated",
+	} {
+		if _, err := ParseScript(src); err == nil {
+			t.Errorf("ParseScript(%q) succeeded, want error", src)
+		}
+	}
+}
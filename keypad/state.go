@@ -0,0 +1,83 @@
+package keypad
+
+import (
+	"context"
+	"sync"
+)
+
+// keyState holds the button state shared between the scan goroutine and
+// application code. Its exported methods are promoted to Device.
+type keyState struct {
+	mu sync.Mutex
+	// state is the bitmask of physically held buttons.
+	state int64
+	// mods are the sticky modifiers applied on top of state.
+	mods int64
+	// changed is closed and cleared whenever state changes.
+	changed chan struct{}
+}
+
+// Pressed reports whether every button in mask is currently held.
+func (k *keyState) Pressed(mask int64) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.state&mask == mask
+}
+
+// State returns the bitmask of currently held buttons. Sticky modifiers are
+// not included.
+func (k *keyState) State() int64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.state
+}
+
+// WaitFor blocks until every button in mask is held at the same time or ctx is done.
+func (k *keyState) WaitFor(ctx context.Context, mask int64) error {
+	for {
+		k.mu.Lock()
+		if k.state&mask == mask {
+			k.mu.Unlock()
+			return nil
+		}
+		if k.changed == nil {
+			k.changed = make(chan struct{})
+		}
+		changed := k.changed
+		k.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (k *keyState) load() (state, mods int64) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.state, k.mods
+}
+
+// Held returns the held buttons with sticky modifiers applied. Called from
+// EventPressCallback it includes a latched modifier the press has just used
+// up, which Sticky no longer reports.
+func (k *keyState) Held() int64 {
+	state, mods := k.load()
+	return state | mods
+}
+
+func (k *keyState) store(state, mods int64) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.mods = mods
+	if k.state == state {
+		return
+	}
+	k.state = state
+	if k.changed != nil {
+		close(k.changed)
+		k.changed = nil
+	}
+}
@@ -0,0 +1,57 @@
+package keypad
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKeyStateQueries(t *testing.T) {
+	var k keyState
+	k.store(BtnCtrl|BtnA, BtnShift)
+
+	if got := k.State(); got != BtnCtrl|BtnA {
+		t.Fatalf("State() = %#x, want %#x", got, int64(BtnCtrl|BtnA))
+	}
+	if !k.Pressed(BtnCtrl | BtnA) {
+		t.Fatal("Pressed(Ctrl|A) = false, want true")
+	}
+	if k.Pressed(BtnCtrl | BtnShift) {
+		t.Fatal("Pressed(Ctrl|Shift) = true for a sticky Shift, want false")
+	}
+	if got := k.Held(); got != BtnCtrl|BtnA|BtnShift {
+		t.Fatalf("Held() = %#x, want %#x", got, int64(BtnCtrl|BtnA|BtnShift))
+	}
+}
+
+func TestKeyStateWaitFor(t *testing.T) {
+	var k keyState
+	done := make(chan error, 1)
+	go func() {
+		done <- k.WaitFor(context.Background(), BtnFn|BtnEnter)
+	}()
+
+	k.store(BtnFn, 0)
+	k.store(BtnEnter, 0)
+	select {
+	case err := <-done:
+		t.Fatalf("WaitFor() returned %v before the chord was held", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	k.store(BtnFn|BtnEnter, 0)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("WaitFor() = %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WaitFor() did not return after the chord was held")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := k.WaitFor(ctx, BtnQ); err != context.Canceled {
+		t.Fatalf("WaitFor() with cancelled context = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,130 @@
+package keypad
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDoubleTapPeriod is the longest gap between two taps of the same
+	// modifier that locks it when sticky keys are enabled.
+	DefaultDoubleTapPeriod = 400 * time.Millisecond
+)
+
+// StickyKeys lets modifier chords be typed one key at a time.
+// Tapping Shift, Ctrl, Alt, Opt or Fn latches it for the next non-modifier
+// key. Tapping the same modifier twice within DoubleTapPeriod locks it until
+// it is tapped again. Assign a *StickyKeys to (*Device).Sticky to enable it.
+type StickyKeys struct {
+	// DoubleTapPeriod is the longest gap between two taps that locks a modifier.
+	DoubleTapPeriod time.Duration
+	// ModifierCallback is called whenever the latched or locked modifiers change.
+	// It is intended for drawing a modifier indicator.
+	ModifierCallback func(latched, locked int64)
+
+	// mu guards the fields below, which the keypad goroutine updates while
+	// Latched, Locked and Reset may be called from anywhere.
+	mu        sync.Mutex
+	latched   int64
+	locked    int64
+	lastTap   int64
+	lastTapAt time.Time
+}
+
+// NewStickyKeys returns a *StickyKeys using DefaultDoubleTapPeriod.
+func NewStickyKeys() *StickyKeys {
+	return &StickyKeys{
+		DoubleTapPeriod: DefaultDoubleTapPeriod,
+	}
+}
+
+// Latched returns the modifiers that will apply to the next key only.
+func (s *StickyKeys) Latched() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latched
+}
+
+// Locked returns the modifiers that apply to every key until tapped again.
+func (s *StickyKeys) Locked() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.locked
+}
+
+// Reset releases all latched and locked modifiers.
+func (s *StickyKeys) Reset() {
+	s.mu.Lock()
+	changed := s.latched != 0 || s.locked != 0
+	s.latched = 0
+	s.locked = 0
+	s.lastTap = 0
+	s.mu.Unlock()
+	if changed {
+		s.notify(0, 0)
+	}
+}
+
+// update advances the sticky state for a transition that pressed the given
+// buttons and returns the modifiers that should be applied on top of the
+// held buttons.
+// A nil *StickyKeys applies no modifiers.
+func (s *StickyKeys) update(pressed int64, now time.Time) int64 {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	if pressed == 0 {
+		defer s.mu.Unlock()
+		return s.locked
+	}
+
+	prevLatched, prevLocked := s.latched, s.locked
+	if pressed&^BtnSpecialMask == 0 {
+		mods := pressed
+		for mods != 0 {
+			m := mods & -mods
+			mods &^= m
+			s.tap(m, now)
+		}
+	}
+
+	applied := s.latched | s.locked
+	if pressed&^BtnSpecialMask != 0 {
+		// the latch is consumed by the first non-modifier key; modifiers
+		// pressed along with it are part of its chord, not taps
+		s.latched = 0
+		s.lastTap = 0
+	}
+	latched, locked := s.latched, s.locked
+	s.mu.Unlock()
+	if latched != prevLatched || locked != prevLocked {
+		s.notify(latched, locked)
+	}
+	return applied
+}
+
+// tap applies a tap of modifier m. s.mu must be held.
+func (s *StickyKeys) tap(m int64, now time.Time) {
+	switch {
+	case s.locked&m != 0:
+		s.locked &^= m
+	case s.latched&m != 0 && s.lastTap == m && now.Sub(s.lastTapAt) <= s.DoubleTapPeriod:
+		s.latched &^= m
+		s.locked |= m
+	case s.latched&m != 0:
+		s.latched &^= m
+	default:
+		s.latched |= m
+	}
+	s.lastTap = m
+	s.lastTapAt = now
+}
+
+// notify reports a change to ModifierCallback. It is called without s.mu
+// held, so the callback may call Latched, Locked or Reset.
+func (s *StickyKeys) notify(latched, locked int64) {
+	if s.ModifierCallback != nil {
+		s.ModifierCallback(latched, locked)
+	}
+}
@@ -0,0 +1,132 @@
+package keypad
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStickyKeysLatch(t *testing.T) {
+	s := NewStickyKeys()
+	now := time.Now()
+
+	if got := s.update(BtnShift, now); got != BtnShift {
+		t.Fatalf("update(Shift) = %#x, want %#x", got, int64(BtnShift))
+	}
+	if got := s.update(BtnA, now.Add(time.Second)); got != BtnShift {
+		t.Fatalf("update(A) = %#x, want %#x", got, int64(BtnShift))
+	}
+	if got := s.update(BtnB, now.Add(2*time.Second)); got != 0 {
+		t.Fatalf("update(B) after latch consumed = %#x, want 0", got)
+	}
+}
+
+func TestStickyKeysDoubleTapLocks(t *testing.T) {
+	s := NewStickyKeys()
+	now := time.Now()
+
+	s.update(BtnCtrl, now)
+	s.update(BtnCtrl, now.Add(s.DoubleTapPeriod/2))
+	if s.Locked() != BtnCtrl || s.Latched() != 0 {
+		t.Fatalf("after double tap latched=%#x locked=%#x, want latched=0 locked=%#x", s.Latched(), s.Locked(), int64(BtnCtrl))
+	}
+
+	for i, key := range []int64{BtnA, BtnB} {
+		if got := s.update(key, now.Add(time.Duration(i+1)*time.Second)); got != BtnCtrl {
+			t.Fatalf("update(%#x) = %#x, want %#x", key, got, int64(BtnCtrl))
+		}
+	}
+
+	s.update(BtnCtrl, now.Add(5*time.Second))
+	if s.Locked() != 0 {
+		t.Fatalf("locked = %#x after third tap, want 0", s.Locked())
+	}
+}
+
+func TestStickyKeysChordIsNotATap(t *testing.T) {
+	s := NewStickyKeys()
+	now := time.Now()
+
+	s.update(BtnShift, now)
+	s.update(BtnShift, now.Add(s.DoubleTapPeriod/2))
+	if s.Locked() != BtnShift {
+		t.Fatalf("locked = %#x after double tap, want %#x", s.Locked(), int64(BtnShift))
+	}
+	if got := s.update(BtnShift|BtnA, now.Add(time.Second)); got != BtnShift || s.Locked() != BtnShift {
+		t.Fatalf("update(Shift|A) = %#x, locked = %#x; want Shift still locked", got, s.Locked())
+	}
+
+	s.update(BtnShift, now.Add(2*time.Second))
+	s.update(BtnCtrl, now.Add(3*time.Second))
+	if got := s.update(BtnCtrl|BtnA, now.Add(3*time.Second+s.DoubleTapPeriod/2)); got != BtnCtrl || s.Locked() != 0 {
+		t.Fatalf("latched Ctrl then Ctrl+A: update = %#x, locked = %#x; want Ctrl applied, nothing locked", got, s.Locked())
+	}
+}
+
+func TestStickyKeysSlowSecondTapCancels(t *testing.T) {
+	s := NewStickyKeys()
+	now := time.Now()
+
+	s.update(BtnFn, now)
+	s.update(BtnFn, now.Add(2*s.DoubleTapPeriod))
+	if s.Latched() != 0 || s.Locked() != 0 {
+		t.Fatalf("after slow second tap latched=%#x locked=%#x, want both 0", s.Latched(), s.Locked())
+	}
+}
+
+func TestStickyKeysModifierCallback(t *testing.T) {
+	s := NewStickyKeys()
+	var calls int
+	var latched, locked int64
+	s.ModifierCallback = func(l, k int64) {
+		calls++
+		latched, locked = l, k
+	}
+	now := time.Now()
+
+	s.update(BtnAlt, now)
+	if calls != 1 || latched != BtnAlt || locked != 0 {
+		t.Fatalf("callback calls=%d latched=%#x locked=%#x, want 1, %#x, 0", calls, latched, locked, int64(BtnAlt))
+	}
+	s.update(0, now)
+	if calls != 1 {
+		t.Fatalf("callback called on release, calls=%d", calls)
+	}
+	s.update(BtnX, now.Add(time.Second))
+	if calls != 2 || latched != 0 {
+		t.Fatalf("callback calls=%d latched=%#x, want 2, 0", calls, latched)
+	}
+}
+
+func TestStickyKeysNil(t *testing.T) {
+	var s *StickyKeys
+	if got := s.update(BtnShift|BtnA, time.Now()); got != 0 {
+		t.Fatalf("nil update() = %#x, want 0", got)
+	}
+}
+
+// TestStickyKeysConcurrent reads and resets the state while it is updated, as
+// applications do from outside the keypad goroutine; run it with -race.
+func TestStickyKeysConcurrent(t *testing.T) {
+	s := NewStickyKeys()
+	s.ModifierCallback = func(latched, locked int64) {
+		s.Latched() // callbacks may read the state
+	}
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			s.Latched()
+			s.Locked()
+			s.Reset()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		s.update(BtnShift, now)
+		s.update(BtnA, now)
+	}
+	wg.Wait()
+}
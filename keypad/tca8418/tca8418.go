@@ -0,0 +1,383 @@
+// Package tca8418 is a driver for the TI TCA8418 I2C keypad scan controller,
+// as used by the Cardputer-Adv keyboard. It supports matrix scanning through
+// the 10 deep event FIFO, FIFO overflow detection, the hardware keypad lock,
+// per line debounce and the use of lines outside the matrix as GPIO.
+package tca8418 // import "github.com/sparques/cardputer/keypad/tca8418"
+
+import (
+	"errors"
+	"time"
+
+	"tinygo.org/x/drivers"
+)
+
+// DefaultAddress is the fixed I2C address of the TCA8418.
+const DefaultAddress = 0x34
+
+// FIFODepth is the number of events the controller can queue.
+const FIFODepth = 10
+
+// Register map.
+const (
+	RegCFG         = 0x01
+	RegIntStat     = 0x02
+	RegKeyLckEC    = 0x03
+	RegKeyEventA   = 0x04
+	RegKPLckTimer  = 0x0E
+	RegUnlock1     = 0x0F
+	RegUnlock2     = 0x10
+	RegGPIOIntStat = 0x11 // 0x11-0x13
+	RegGPIODatStat = 0x14 // 0x14-0x16
+	RegGPIODatOut  = 0x17 // 0x17-0x19
+	RegGPIOIntEn   = 0x1A // 0x1A-0x1C
+	RegKPGPIO      = 0x1D // 0x1D-0x1F
+	RegGPIEM       = 0x20 // 0x20-0x22
+	RegGPIODir     = 0x23 // 0x23-0x25
+	RegGPIOIntLvl  = 0x26 // 0x26-0x28
+	RegDebounceDis = 0x29 // 0x29-0x2B
+	RegGPIOPull    = 0x2C // 0x2C-0x2E
+)
+
+// CFG register bits.
+const (
+	CFGKeyEventInt  = 0x01 // KE_IEN: interrupt on key events
+	CFGGPIInt       = 0x02 // GPI_IEN: interrupt on GPI events
+	CFGKeyLockInt   = 0x04 // K_LCK_IEN: interrupt on key lock activity
+	CFGOverflowInt  = 0x08 // OVR_FLOW_IEN: interrupt on FIFO overflow
+	CFGIntPulse     = 0x10 // INT_CFG: pulse INT rather than hold it
+	CFGOverflowMode = 0x20 // OVR_FLOW_M: on overflow drop the oldest event, not the newest
+	CFGGPIEventLock = 0x40 // GPI_E_CFG: GPI events are not queued while the keypad is locked
+	CFGAutoIncr     = 0x80 // AI: auto-increment register addresses
+)
+
+// INT_STAT register bits. Each is cleared by writing it back.
+const (
+	IntKeyEvent = 0x01 // K_INT
+	IntGPI      = 0x02 // GPI_INT
+	IntKeyLock  = 0x04 // K_LCK_INT
+	IntOverflow = 0x08 // OVR_FLOW_INT
+	IntCAD      = 0x10 // CAD_INT: CTRL-ALT-DEL combination
+)
+
+const (
+	keyLckECCount  = 0x0F
+	keyLckECLock1  = 0x10
+	keyLckECLock2  = 0x20
+	keyLckECEnable = 0x40
+)
+
+var (
+	// ErrOverflow is returned by ReadEvents when events were lost because the
+	// FIFO filled up. The events that were read are still returned.
+	ErrOverflow = errors.New("tca8418: event FIFO overflowed")
+	// ErrInvalidKey is returned when a key code is outside 1-80.
+	ErrInvalidKey = errors.New("tca8418: invalid key code")
+)
+
+// Lines is a set of the controller's 18 lines: ROW0-ROW7 in bits 0-7 and
+// COL0-COL9 in bits 8-17, matching the layout of the three register banks.
+type Lines uint32
+
+// Row returns the line ROWn.
+func Row(n int) Lines { return 1 << n }
+
+// Col returns the line COLn.
+func Col(n int) Lines { return 1 << (8 + n) }
+
+// AllLines is every line of the controller.
+const AllLines Lines = 1<<18 - 1
+
+// MatrixLines returns the lines used by a rows x cols key matrix.
+func MatrixLines(rows, cols int) Lines {
+	var l Lines
+	for i := 0; i < rows && i < 8; i++ {
+		l |= Row(i)
+	}
+	for i := 0; i < cols && i < 10; i++ {
+		l |= Col(i)
+	}
+	return l
+}
+
+// Event is an entry of the event FIFO. Bit 7 is set for a press; the low bits
+// hold the key code: 1-80 for matrix keys (row*10 + col + 1) and 97-114 for
+// GPI events (ROW0-ROW7 then COL0-COL9).
+type Event uint8
+
+// Pressed reports whether the event is a key press or a GPI line becoming
+// active, rather than a release.
+func (e Event) Pressed() bool { return e&0x80 != 0 }
+
+// Code returns the key code without the press bit.
+func (e Event) Code() uint8 { return uint8(e & 0x7F) }
+
+// IsKey reports whether the event comes from the key matrix.
+func (e Event) IsKey() bool { return e.Code() >= 1 && e.Code() <= 80 }
+
+// IsGPI reports whether the event comes from a GPI line.
+func (e Event) IsGPI() bool { return e.Code() >= 97 && e.Code() <= 114 }
+
+// RowCol returns the matrix position of a key event.
+func (e Event) RowCol() (row, col int) {
+	k := int(e.Code()) - 1
+	return k / 10, k % 10
+}
+
+// Line returns the line of a GPI event.
+func (e Event) Line() Lines {
+	if !e.IsGPI() {
+		return 0
+	}
+	return 1 << (e.Code() - 97)
+}
+
+// KeyCode returns the key code of the matrix position row, col.
+func KeyCode(row, col int) uint8 {
+	return uint8(row*10 + col + 1)
+}
+
+// Device is a TCA8418 on an I2C bus.
+type Device struct {
+	bus     drivers.I2C
+	Address uint16
+}
+
+// New returns a *Device for the controller at DefaultAddress on bus.
+func New(bus drivers.I2C) *Device {
+	return &Device{bus: bus, Address: DefaultAddress}
+}
+
+// Configure writes the CFG register; cfg is a combination of the CFG* bits.
+func (d *Device) Configure(cfg uint8) error {
+	return d.WriteRegister(RegCFG, cfg)
+}
+
+// ConfigureMatrix assigns the first rows rows and cols columns to the key
+// matrix. The remaining lines are left as GPIO.
+func (d *Device) ConfigureMatrix(rows, cols int) error {
+	return d.writeLines(RegKPGPIO, MatrixLines(rows, cols))
+}
+
+// EventCount returns the number of events waiting in the FIFO.
+func (d *Device) EventCount() (int, error) {
+	v, err := d.ReadRegister(RegKeyLckEC)
+	if err != nil {
+		return 0, err
+	}
+	return int(v & keyLckECCount), nil
+}
+
+// ReadEvent pops the oldest event from the FIFO. It returns 0 if the FIFO is
+// empty.
+func (d *Device) ReadEvent() (Event, error) {
+	v, err := d.ReadRegister(RegKeyEventA)
+	return Event(v), err
+}
+
+// ReadEvents pops every queued event, oldest first, appending them to buf.
+// If the FIFO overflowed since the last call, the overflow interrupt is
+// cleared and ErrOverflow is returned along with the events; the caller
+// should assume it missed events and resynchronise its key state.
+func (d *Device) ReadEvents(buf []Event) ([]Event, error) {
+	status, err := d.InterruptStatus()
+	if err != nil {
+		return buf, err
+	}
+	for n := 0; n < FIFODepth; n++ {
+		e, err := d.ReadEvent()
+		if err != nil {
+			return buf, err
+		}
+		if e == 0 {
+			break
+		}
+		buf = append(buf, e)
+	}
+	if status&IntOverflow != 0 {
+		if err := d.ClearInterrupts(IntOverflow); err != nil {
+			return buf, err
+		}
+		return buf, ErrOverflow
+	}
+	return buf, nil
+}
+
+// Flush discards every queued event and clears all interrupts.
+func (d *Device) Flush() error {
+	for n := 0; n < FIFODepth; n++ {
+		e, err := d.ReadEvent()
+		if err != nil {
+			return err
+		}
+		if e == 0 {
+			break
+		}
+	}
+	// reading GPIO_INT_STAT clears it
+	if _, err := d.readLines(RegGPIOIntStat); err != nil {
+		return err
+	}
+	return d.ClearInterrupts(IntKeyEvent | IntGPI | IntKeyLock | IntOverflow | IntCAD)
+}
+
+// InterruptStatus returns the INT_STAT register, a combination of the Int* bits.
+func (d *Device) InterruptStatus() (uint8, error) {
+	return d.ReadRegister(RegIntStat)
+}
+
+// ClearInterrupts clears the Int* bits in mask.
+func (d *Device) ClearInterrupts(mask uint8) error {
+	return d.WriteRegister(RegIntStat, mask)
+}
+
+// SetDebounce enables or disables the hardware debounce of lines. Debounce
+// is enabled on every line after reset.
+func (d *Device) SetDebounce(lines Lines, enabled bool) error {
+	dis, err := d.readLines(RegDebounceDis)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		dis &^= lines
+	} else {
+		dis |= lines
+	}
+	return d.writeLines(RegDebounceDis, dis)
+}
+
+// SetUnlockKeys sets the two keys that must be pressed in order to unlock a
+// locked keypad, and the longest time allowed between them, at most 7s.
+// maskTime, at most 31s, limits a locked keypad to one key lock interrupt per
+// period; zero disables the interrupt mask.
+func (d *Device) SetUnlockKeys(first, second uint8, window, maskTime time.Duration) error {
+	if first < 1 || first > 80 || second < 1 || second > 80 {
+		return ErrInvalidKey
+	}
+	if err := d.WriteRegister(RegUnlock1, first); err != nil {
+		return err
+	}
+	if err := d.WriteRegister(RegUnlock2, second); err != nil {
+		return err
+	}
+	w := min(uint8(window/time.Second), 7)
+	m := min(uint8(maskTime/time.Second), 31)
+	return d.WriteRegister(RegKPLckTimer, m<<3|w)
+}
+
+// Lock enables the keypad lock. Key events stop until the unlock keys are
+// pressed or Unlock is called.
+func (d *Device) Lock() error {
+	return d.setKeyLock(true)
+}
+
+// Unlock disables the keypad lock.
+func (d *Device) Unlock() error {
+	return d.setKeyLock(false)
+}
+
+// Locked reports whether the keypad lock is enabled.
+func (d *Device) Locked() (bool, error) {
+	v, err := d.ReadRegister(RegKeyLckEC)
+	return v&keyLckECEnable != 0, err
+}
+
+func (d *Device) setKeyLock(on bool) error {
+	var v uint8
+	if on {
+		v = keyLckECEnable
+	}
+	// only K_LCK_EN is writable; the event count and status bits are read only
+	return d.WriteRegister(RegKeyLckEC, v)
+}
+
+// ConfigureInputs makes lines GPIO inputs. With events set, their changes are
+// queued in the FIFO as GPI events (see Event.Line) and raise IntGPI;
+// activeHigh selects whether a rising (true) or falling (false) edge counts
+// as a press. pull enables the internal pull-up.
+func (d *Device) ConfigureInputs(lines Lines, events, activeHigh, pull bool) error {
+	if err := d.updateLines(RegGPIODir, lines, false); err != nil {
+		return err
+	}
+	if err := d.updateLines(RegGPIOPull, lines, !pull); err != nil {
+		return err
+	}
+	if err := d.updateLines(RegGPIOIntLvl, lines, activeHigh); err != nil {
+		return err
+	}
+	if err := d.updateLines(RegGPIEM, lines, events); err != nil {
+		return err
+	}
+	return d.updateLines(RegGPIOIntEn, lines, events)
+}
+
+// ConfigureOutputs makes lines GPIO outputs.
+func (d *Device) ConfigureOutputs(lines Lines) error {
+	if err := d.updateLines(RegGPIEM, lines, false); err != nil {
+		return err
+	}
+	if err := d.updateLines(RegGPIOIntEn, lines, false); err != nil {
+		return err
+	}
+	return d.updateLines(RegGPIODir, lines, true)
+}
+
+// SetOutputs drives the output lines in lines high or low.
+func (d *Device) SetOutputs(lines Lines, high bool) error {
+	return d.updateLines(RegGPIODatOut, lines, high)
+}
+
+// ReadInputs returns the lines that currently read high.
+func (d *Device) ReadInputs() (Lines, error) {
+	return d.readLines(RegGPIODatStat)
+}
+
+// ReadRegister reads a single register.
+func (d *Device) ReadRegister(reg uint8) (uint8, error) {
+	out := []byte{0}
+	if err := d.bus.Tx(d.Address, []byte{reg}, out); err != nil {
+		return 0, err
+	}
+	return out[0], nil
+}
+
+// WriteRegister writes a single register.
+func (d *Device) WriteRegister(reg, value uint8) error {
+	return d.bus.Tx(d.Address, []byte{reg, value}, nil)
+}
+
+// readLines reads the three register bank starting at reg.
+func (d *Device) readLines(reg uint8) (Lines, error) {
+	var l Lines
+	for i := uint8(0); i < 3; i++ {
+		v, err := d.ReadRegister(reg + i)
+		if err != nil {
+			return 0, err
+		}
+		l |= Lines(v) << (8 * i)
+	}
+	return l & AllLines, nil
+}
+
+// writeLines writes the three register bank starting at reg.
+func (d *Device) writeLines(reg uint8, l Lines) error {
+	for i := uint8(0); i < 3; i++ {
+		if err := d.WriteRegister(reg+i, uint8(l>>(8*i))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateLines sets or clears lines in the bank at reg, leaving the others.
+func (d *Device) updateLines(reg uint8, lines Lines, set bool) error {
+	l, err := d.readLines(reg)
+	if err != nil {
+		return err
+	}
+	if set {
+		l |= lines
+	} else {
+		l &^= lines
+	}
+	return d.writeLines(reg, l)
+}
@@ -0,0 +1,215 @@
+package tca8418
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeBus emulates the parts of a TCA8418 the driver relies on: a register
+// file, the event FIFO behind KEY_EVENT_A, write-1-to-clear INT_STAT and the
+// read-only bits of KEY_LCK_EC.
+type fakeBus struct {
+	regs [0x2F]uint8
+	fifo []uint8
+	err  error
+}
+
+func (b *fakeBus) Tx(addr uint16, w, r []byte) error {
+	if b.err != nil {
+		return b.err
+	}
+	if addr != DefaultAddress {
+		return errors.New("wrong address")
+	}
+	reg := w[0]
+	if len(w) == 2 {
+		switch reg {
+		case RegIntStat:
+			b.regs[reg] &^= w[1]
+		case RegKeyLckEC:
+			b.regs[reg] = b.regs[reg]&^keyLckECEnable | w[1]&keyLckECEnable
+		default:
+			b.regs[reg] = w[1]
+		}
+		return nil
+	}
+	switch reg {
+	case RegKeyEventA:
+		r[0] = 0
+		if len(b.fifo) > 0 {
+			r[0] = b.fifo[0]
+			b.fifo = b.fifo[1:]
+		}
+	case RegKeyLckEC:
+		r[0] = b.regs[reg]&^keyLckECCount | uint8(len(b.fifo))
+	default:
+		r[0] = b.regs[reg]
+	}
+	return nil
+}
+
+// push queues an event the way the controller does, setting the interrupt
+// bits and dropping the oldest event on overflow.
+func (b *fakeBus) push(e uint8) {
+	if len(b.fifo) == FIFODepth {
+		b.fifo = b.fifo[1:]
+		b.regs[RegIntStat] |= IntOverflow
+	}
+	b.fifo = append(b.fifo, e)
+	b.regs[RegIntStat] |= IntKeyEvent
+}
+
+func TestConfigureMatrix(t *testing.T) {
+	bus := &fakeBus{}
+	d := New(bus)
+	if err := d.ConfigureMatrix(7, 8); err != nil {
+		t.Fatal(err)
+	}
+	want := [3]uint8{0x7F, 0xFF, 0x00}
+	for i, w := range want {
+		if got := bus.regs[RegKPGPIO+i]; got != w {
+			t.Errorf("KP_GPIO%d = %#x, want %#x", i+1, got, w)
+		}
+	}
+	if spare := AllLines &^ MatrixLines(7, 8); spare != Row(7)|Col(8)|Col(9) {
+		t.Errorf("spare lines = %#x", spare)
+	}
+}
+
+func TestReadEvents(t *testing.T) {
+	bus := &fakeBus{}
+	d := New(bus)
+	bus.push(0x80 | KeyCode(2, 3))
+	bus.push(KeyCode(2, 3))
+
+	if n, _ := d.EventCount(); n != 2 {
+		t.Fatalf("EventCount() = %d, want 2", n)
+	}
+	events, err := d.ReadEvents(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || !events[0].Pressed() || events[1].Pressed() {
+		t.Fatalf("events = %#x, want press then release", events)
+	}
+	if row, col := events[0].RowCol(); row != 2 || col != 3 || !events[0].IsKey() {
+		t.Fatalf("RowCol() = %d,%d, want 2,3", row, col)
+	}
+}
+
+func TestReadEventsOverflow(t *testing.T) {
+	bus := &fakeBus{}
+	d := New(bus)
+	for i := 0; i < FIFODepth+3; i++ {
+		bus.push(0x80 | uint8(i+1))
+	}
+
+	events, err := d.ReadEvents(nil)
+	if err != ErrOverflow {
+		t.Fatalf("ReadEvents() error = %v, want ErrOverflow", err)
+	}
+	if len(events) != FIFODepth || events[0].Code() != 4 {
+		t.Fatalf("events = %#x, want the newest %d", events, FIFODepth)
+	}
+	if bus.regs[RegIntStat]&IntOverflow != 0 {
+		t.Fatalf("overflow interrupt not cleared")
+	}
+	if _, err := d.ReadEvents(nil); err != nil {
+		t.Fatalf("second ReadEvents() error = %v, want nil", err)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	bus := &fakeBus{}
+	d := New(bus)
+	bus.push(0x81)
+	bus.regs[RegIntStat] |= IntGPI | IntCAD
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(bus.fifo) != 0 || bus.regs[RegIntStat] != 0 {
+		t.Fatalf("after Flush fifo=%v INT_STAT=%#x", bus.fifo, bus.regs[RegIntStat])
+	}
+}
+
+func TestKeyLock(t *testing.T) {
+	bus := &fakeBus{}
+	d := New(bus)
+	if err := d.SetUnlockKeys(KeyCode(0, 0), KeyCode(6, 7), 2*time.Second, 40*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if bus.regs[RegUnlock1] != 1 || bus.regs[RegUnlock2] != 68 {
+		t.Fatalf("UNLOCK = %d,%d, want 1,68", bus.regs[RegUnlock1], bus.regs[RegUnlock2])
+	}
+	if got := bus.regs[RegKPLckTimer]; got != 31<<3|2 {
+		t.Fatalf("KP_LCK_TIMER = %#x, want %#x", got, 31<<3|2)
+	}
+	if err := d.SetUnlockKeys(0, 81, 0, 0); err != ErrInvalidKey {
+		t.Fatalf("SetUnlockKeys(0, 81) error = %v, want ErrInvalidKey", err)
+	}
+
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if locked, _ := d.Locked(); !locked {
+		t.Fatalf("Locked() = false after Lock")
+	}
+	if err := d.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if locked, _ := d.Locked(); locked {
+		t.Fatalf("Locked() = true after Unlock")
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	bus := &fakeBus{}
+	d := New(bus)
+	if err := d.SetDebounce(Row(1)|Col(9), false); err != nil {
+		t.Fatal(err)
+	}
+	if bus.regs[RegDebounceDis] != 0x02 || bus.regs[RegDebounceDis+2] != 0x02 {
+		t.Fatalf("DEBOUNCE_DIS = %#x", bus.regs[RegDebounceDis:RegDebounceDis+3])
+	}
+	if err := d.SetDebounce(Row(1), true); err != nil {
+		t.Fatal(err)
+	}
+	if bus.regs[RegDebounceDis] != 0 || bus.regs[RegDebounceDis+2] != 0x02 {
+		t.Fatalf("DEBOUNCE_DIS = %#x", bus.regs[RegDebounceDis:RegDebounceDis+3])
+	}
+}
+
+func TestGPIO(t *testing.T) {
+	bus := &fakeBus{}
+	d := New(bus)
+	in := Row(7) | Col(8)
+	if err := d.ConfigureInputs(in, true, false, true); err != nil {
+		t.Fatal(err)
+	}
+	if bus.regs[RegGPIEM] != 0x80 || bus.regs[RegGPIEM+2] != 0x01 || bus.regs[RegGPIOIntEn] != 0x80 {
+		t.Fatalf("GPI_EM/GPIO_INT_EN not set for %#x", in)
+	}
+	if err := d.ConfigureOutputs(Col(9)); err != nil {
+		t.Fatal(err)
+	}
+	if bus.regs[RegGPIODir+2] != 0x02 {
+		t.Fatalf("GPIO_DIR3 = %#x, want 0x02", bus.regs[RegGPIODir+2])
+	}
+	if err := d.SetOutputs(Col(9), true); err != nil {
+		t.Fatal(err)
+	}
+	if bus.regs[RegGPIODatOut+2] != 0x02 {
+		t.Fatalf("GPIO_DAT_OUT3 = %#x, want 0x02", bus.regs[RegGPIODatOut+2])
+	}
+
+	bus.regs[RegGPIODatStat+1] = 0x01
+	if l, _ := d.ReadInputs(); l != Col(0) {
+		t.Fatalf("ReadInputs() = %#x, want %#x", l, Col(0))
+	}
+
+	e := Event(0x80 | (97 + 8))
+	if !e.IsGPI() || e.Line() != Col(0) {
+		t.Fatalf("Event(%#x).Line() = %#x, want COL0", uint8(e), e.Line())
+	}
+}
@@ -0,0 +1,59 @@
+package keypad
+
+import (
+	"math/bits"
+
+	"github.com/sparques/cardputer/keypad/tca8418"
+)
+
+// The Cardputer-Adv wires its 4x14 keyboard to a TCA8418 as a 7 row by 8
+// column matrix: each controller row covers two keyboard columns and each
+// controller column one keyboard row.
+
+// AdvKeymap maps a TCA8418 matrix position wired like the Cardputer-Adv
+// keyboard to its button. It returns 0 for positions outside the keyboard.
+func AdvKeymap(row, col int) Keys {
+	r, c, ok := remapTCA8418(row, col)
+	if !ok {
+		return 0
+	}
+	return Keys(buttonMask(r, c))
+}
+
+func remapTCA8418(rawRow, rawCol int) (row, col int, ok bool) {
+	if rawRow < 0 || rawRow > 6 || rawCol < 0 || rawCol > 7 {
+		return 0, 0, false
+	}
+	col = rawRow * 2
+	if rawCol > 3 {
+		col++
+	}
+	row = (rawCol + 4) % 4
+	if row < 0 || row > 3 || col < 0 || col > 13 {
+		return 0, 0, false
+	}
+	return row, col, true
+}
+
+// tcaKeyCode returns the TCA8418 key code of a single button, inverting
+// remapTCA8418 and buttonMask.
+func tcaKeyCode(k Keys) (uint8, bool) {
+	if k == 0 || k&(k-1) != 0 {
+		return 0, false
+	}
+	bit := bits.TrailingZeros64(uint64(k))
+	row, col := bit/14, bit%14
+	if row > 3 {
+		return 0, false
+	}
+	rawRow := col / 2
+	rawCol := row + col%2*4
+	return tca8418.KeyCode(rawRow, rawCol), true
+}
+
+func buttonMask(row, col int) int64 {
+	if row < 0 || row > 3 || col < 0 || col > 13 {
+		return 0
+	}
+	return int64(1) << (row*14 + col)
+}
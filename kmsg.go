@@ -4,8 +4,13 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"sync"
 )
 
+// KMsgBufferSize is how many bytes of the most recent debug output are kept
+// for DMesg.
+const KMsgBufferSize = 4096
+
 var (
 	// KMsgWriter receives debug output when KMsgLogger is nil.
 	KMsgWriter = io.Discard
@@ -13,10 +18,43 @@ var (
 	KMsgLogger *log.Logger
 )
 
+// kmsgRing keeps the tail of the debug output.
+var kmsgRing struct {
+	mu   sync.Mutex
+	buf  [KMsgBufferSize]byte
+	next int
+	full bool
+}
+
 func kmsg(v ...any) {
+	msg := fmt.Sprint(v...)
+	kmsgRing.mu.Lock()
+	for i := 0; i < len(msg); i++ {
+		kmsgRing.buf[kmsgRing.next] = msg[i]
+		kmsgRing.next++
+		if kmsgRing.next == len(kmsgRing.buf) {
+			kmsgRing.next = 0
+			kmsgRing.full = true
+		}
+	}
+	kmsgRing.mu.Unlock()
+
 	if KMsgLogger != nil {
-		KMsgLogger.Print(v...)
+		KMsgLogger.Print(msg)
 		return
 	}
-	fmt.Fprint(KMsgWriter, v...)
+	io.WriteString(KMsgWriter, msg)
+}
+
+// DMesg writes the most recent debug output, up to KMsgBufferSize bytes, to w.
+func DMesg(w io.Writer) error {
+	kmsgRing.mu.Lock()
+	defer kmsgRing.mu.Unlock()
+	if kmsgRing.full {
+		if _, err := w.Write(kmsgRing.buf[kmsgRing.next:]); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(kmsgRing.buf[:kmsgRing.next])
+	return err
 }
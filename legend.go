@@ -0,0 +1,226 @@
+package cardputer
+
+import (
+	"image"
+	"sync"
+	"time"
+
+	"github.com/sparques/cardputer/keypad"
+)
+
+// DefaultLegendDelay is how long a modifier must be held before Legend appears.
+const DefaultLegendDelay = 400 * time.Millisecond
+
+// Legend draws a map of the keyboard along the bottom of Display while Fn,
+// Ctrl, Alt or Opt is held, labelling every key with what it types in that
+// layer (see keypad.Label), so the Fn arrows, Esc, Del and F1-F12 can be
+// found without a printed chart. Held and sticky modifiers are highlighted.
+// The legend is drawn over the framebuffer and disappears on release with the
+// picture underneath restored, so it needs a buffered Display.
+//
+// Call Legend.Attach once KP's own callbacks are set.
+var Legend = &legend{
+	Delay: DefaultLegendDelay,
+}
+
+// legend layout, in pixels
+const (
+	legendCellW = 17
+	legendCellH = 9
+	legendCols  = 14
+	legendRows  = 4
+)
+
+var (
+	legendBorder   = NewRGB565(0x00, 0x00, 0x00)
+	legendKey      = NewRGB565(0x30, 0x30, 0x38)
+	legendBlank    = NewRGB565(0x18, 0x18, 0x1C)
+	legendHeld     = NewRGB565(0x20, 0x60, 0xC0)
+	legendText     = NewRGB565(0xFF, 0xFF, 0xFF)
+	legendDimText  = NewRGB565(0x90, 0x90, 0x90)
+	legendTriggers = int64(keypad.BtnFn | keypad.BtnCtrl | keypad.BtnAlt | keypad.BtnOpt)
+)
+
+type legend struct {
+	// Delay is how long a modifier must be held before the legend appears,
+	// so quick combos like Ctrl+C don't flash it.
+	Delay time.Duration
+
+	// mu guards the fields below. layer and labels are read while drawing,
+	// so once shown they only change under Display's lock too.
+	mu    sync.Mutex
+	timer *time.Timer
+	shown bool
+	layer int64
+	// labels holds each key's label as runes, ready for overlayAt.
+	labels [legendRows * legendCols][]rune
+}
+
+// Attach makes Legend follow KP's modifier keys.
+func (l *legend) Attach() {
+	press := KP.EventPressCallback
+	release := KP.EventReleaseCallback
+	KP.EventPressCallback = func(p int64) {
+		l.update()
+		if press != nil {
+			press(p)
+		}
+	}
+	KP.EventReleaseCallback = func(r int64) {
+		l.update()
+		if release != nil {
+			release(r)
+		}
+	}
+}
+
+// modifiers returns the modifiers applied to KP's current keys, including
+// sticky ones, and any modifier latched for the next key.
+func (l *legend) modifiers() int64 {
+	mods := KP.Held() & keypad.BtnSpecialMask
+	if KP.Sticky != nil {
+		mods |= KP.Sticky.Latched()
+	}
+	return mods
+}
+
+func (l *legend) update() {
+	mods := l.modifiers()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	switch {
+	case mods&legendTriggers == 0:
+		if l.shown {
+			l.shown = false
+			Display.hideOverlay(l)
+		}
+	case l.shown:
+		Display.updateOverlay(l, func() bool {
+			if mods == l.layer {
+				return false
+			}
+			l.setLayer(mods)
+			return true
+		})
+	default:
+		l.timer = time.AfterFunc(l.Delay, l.show)
+	}
+}
+
+// show is called by the Delay timer, on its own goroutine.
+func (l *legend) show() {
+	mods := l.modifiers()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.shown || mods&legendTriggers == 0 {
+		return
+	}
+	l.shown = true
+	l.setLayer(mods)
+	Display.showOverlay(l)
+}
+
+func (l *legend) setLayer(mods int64) {
+	l.layer = mods
+	for i := range l.labels {
+		key := int64(1) << i
+		if key&keypad.BtnSpecialMask != 0 {
+			l.labels[i] = []rune(legendModifierNames[key])
+			continue
+		}
+		l.labels[i] = []rune(keypad.Label(mods | key))
+	}
+}
+
+var legendModifierNames = map[int64]string{
+	keypad.BtnFn:    "Fn",
+	keypad.BtnShift: "Sft",
+	keypad.BtnCtrl:  "Ctl",
+	keypad.BtnOpt:   "Opt",
+	keypad.BtnAlt:   "Alt",
+}
+
+func (l *legend) overlayBounds() image.Rectangle {
+	w := legendCols*legendCellW + 1
+	h := legendRows*legendCellH + 1
+	x := (dispWidth - w) / 2
+	return image.Rect(x, dispHeight-h, x+w, dispHeight)
+}
+
+func (l *legend) overlayAt(x, y int) (RGB565, bool) {
+	b := l.overlayBounds()
+	rx, ry := x-b.Min.X-1, y-b.Min.Y-1
+	if rx < 0 || ry < 0 {
+		return legendBorder, true
+	}
+	cx, cy := rx%legendCellW, ry%legendCellH
+	if cx == legendCellW-1 || cy == legendCellH-1 {
+		return legendBorder, true
+	}
+
+	i := ry/legendCellH*legendCols + rx/legendCellW
+	key := int64(1) << i
+	runes := l.labels[i]
+	bg, fg := legendKey, legendText
+	switch {
+	case key&l.layer != 0:
+		bg = legendHeld
+	case key&keypad.BtnSpecialMask != 0:
+		fg = legendDimText
+	case len(runes) == 0:
+		bg = legendBlank
+	}
+
+	// centre the label in the cell's legendCellW-1 x legendCellH-1 interior
+	tw := len(runes)*4 - 1
+	tx, ty := cx-(legendCellW-1-tw)/2, cy-(legendCellH-1-5)/2
+	if tx < 0 || ty < 0 || tx >= tw || ty >= 5 || tx%4 == 3 {
+		return bg, true
+	}
+	if glyphPixel(runes[tx/4], tx%4, ty) {
+		return fg, true
+	}
+	return bg, true
+}
+
+// glyphPixel reports whether pixel x, y of the 3x5 glyph for r is set.
+func glyphPixel(r rune, x, y int) bool {
+	var g uint16
+	switch {
+	case r >= ' ' && r <= '~':
+		g = font3x5[r-' ']
+	default:
+		g = font3x5Extra[r]
+	}
+	return g>>(3*(4-y))&(4>>x) != 0
+}
+
+// font3x5 is a 3x5 pixel font for ASCII ' ' to '~'. Each octal digit is a
+// row, top first, with 4 the leftmost pixel.
+var font3x5 = [...]uint16{
+	0o00000, 0o22202, 0o55000, 0o57575, 0o36236, 0o51245, 0o25253, 0o22000, // space ! " # $ % & '
+	0o12221, 0o42224, 0o05250, 0o02720, 0o00024, 0o00700, 0o00002, 0o11244, // ( ) * + , - . /
+	0o75557, 0o26227, 0o71747, 0o71317, 0o55711, 0o74717, 0o74757, 0o71111, // 0-7
+	0o75757, 0o75717, 0o02020, 0o02024, 0o12421, 0o07070, 0o42124, 0o71202, // 8 9 : ; < = > ?
+	0o75647, 0o25755, 0o65656, 0o34443, 0o65556, 0o74647, 0o74644, 0o34553, // @ A-G
+	0o55755, 0o72227, 0o11153, 0o55655, 0o44447, 0o57555, 0o65555, 0o25552, // H-O
+	0o65644, 0o25563, 0o65655, 0o34216, 0o72222, 0o55557, 0o55552, 0o55575, // P-W
+	0o55255, 0o55222, 0o71247, 0o64446, 0o44211, 0o31113, 0o25000, 0o00007, // X Y Z [ \ ] ^ _
+	0o42000, 0o03553, 0o44656, 0o03443, 0o11353, 0o02743, 0o12722, 0o35316, // ` a-g
+	0o44655, 0o20222, 0o10116, 0o45655, 0o62227, 0o00775, 0o00655, 0o00252, // h-o
+	0o06564, 0o03531, 0o00344, 0o03216, 0o27223, 0o00557, 0o00552, 0o00577, // p-w
+	0o00525, 0o55316, 0o07247, 0o32623, 0o22222, 0o62326, 0o03600, // x y z { | } ~
+}
+
+// font3x5Extra holds the glyphs keypad.Label uses outside ASCII.
+var font3x5Extra = map[rune]uint16{
+	'↑': 0o27222,
+	'↓': 0o22272,
+	'←': 0o13731,
+	'→': 0o46764,
+}
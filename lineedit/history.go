@@ -0,0 +1,116 @@
+package lineedit
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"tinygo.org/x/tinyfs"
+)
+
+// DefaultHistorySize is the number of lines kept by NewHistory when max is not positive.
+const DefaultHistorySize = 100
+
+// FileOpener opens files for history persistence. cardputer.SDFS satisfies it.
+type FileOpener interface {
+	OpenFile(path string, flags int) (tinyfs.File, error)
+}
+
+// History is a bounded list of previously entered lines, oldest first.
+type History struct {
+	// Max is the most lines kept; older lines are dropped first.
+	Max int
+
+	lines []string
+	fs    FileOpener
+	path  string
+}
+
+// NewHistory returns an empty *History holding up to max lines.
+func NewHistory(max int) *History {
+	if max <= 0 {
+		max = DefaultHistorySize
+	}
+	return &History{Max: max}
+}
+
+// Persist loads history from path on fs and saves it back after every Add.
+// A missing file, one whose error matches os.ErrNotExist, is not an error.
+func (h *History) Persist(fs FileOpener, path string) error {
+	h.fs = fs
+	h.path = path
+	f, err := fs.OpenFile(path, os.O_RDONLY)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return h.Load(f)
+}
+
+// Add appends line to the history. Blank lines and immediate repeats are ignored.
+// If the history is persisted, the file is rewritten.
+func (h *History) Add(line string) error {
+	if strings.TrimSpace(line) == "" {
+		return nil
+	}
+	if n := len(h.lines); n > 0 && h.lines[n-1] == line {
+		return nil
+	}
+	h.lines = append(h.lines, line)
+	h.trim()
+	if h.fs == nil {
+		return nil
+	}
+	f, err := h.fs.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if err := h.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Len returns the number of lines in the history.
+func (h *History) Len() int {
+	return len(h.lines)
+}
+
+// Line returns the i'th line, counting from the oldest.
+func (h *History) Line(i int) string {
+	return h.lines[i]
+}
+
+// Load appends newline separated lines read from r.
+func (h *History) Load(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if line := s.Text(); line != "" {
+			h.lines = append(h.lines, line)
+		}
+	}
+	h.trim()
+	return s.Err()
+}
+
+// Save writes the history to w, one line per entry.
+func (h *History) Save(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, line := range h.lines {
+		bw.WriteString(line)
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+func (h *History) trim() {
+	if h.Max > 0 && len(h.lines) > h.Max {
+		h.lines = append(h.lines[:0], h.lines[len(h.lines)-h.Max:]...)
+	}
+}
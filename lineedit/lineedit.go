@@ -0,0 +1,465 @@
+// lineedit provides a readline-style line editor driven by the ANSI byte
+// sequences the keypad writes to its Receiver. It supports cursor movement,
+// kill and yank, history, tab completion and password masking, and renders
+// through any ANSI-capable io.Writer.
+//
+// Typical use:
+//
+//	ed := lineedit.New(term)
+//	cardputer.KP.Receiver = ed
+//	cardputer.KP.Start()
+//	for {
+//		line, err := ed.ReadLine("> ")
+//		...
+//	}
+//
+// Besides the usual Emacs control keys (Ctrl-A/E/B/F/K/U/W/Y/P/N), the
+// Cardputer arrow keys move the cursor and walk the history, Fn+Backspace
+// deletes forward, and Shift+Left/Right (Fn+Shift+,//) jump to the start and
+// end of the line.
+package lineedit // import "github.com/sparques/cardputer/lineedit"
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrInterrupted is returned by ReadLine when Ctrl-C is pressed.
+	ErrInterrupted = errors.New("interrupted")
+)
+
+// Completer returns the candidates that could replace line[start:pos], where
+// pos is the cursor position in runes.
+type Completer func(line string, pos int) (start int, candidates []string)
+
+// Editor is a single-line editor. Write the keypad's output to it, for example
+// by setting it as the keypad Receiver, and call ReadLine to collect lines.
+type Editor struct {
+	// History receives accepted lines and is walked with Up/Down.
+	// It is nil by default; lines are not remembered.
+	History *History
+	// Complete is called when Tab is pressed. Nil disables completion.
+	Complete Completer
+	// Mask, when non-zero, is drawn in place of every character and
+	// accepted lines are not added to History.
+	Mask rune
+	// ErrorCallback is called, if set, when adding an accepted line to a
+	// persisted History fails. It is called from Write once the line has
+	// been handed to ReadLine.
+	ErrorCallback func(error)
+
+	mu     sync.Mutex
+	out    io.Writer
+	prompt string
+	line   []rune
+	pos    int
+	// kill is the yank buffer; lastKill merges consecutive kills into it.
+	kill     []rune
+	lastKill bool
+	// histPos indexes History while browsing; saved holds the edited line.
+	histPos int
+	saved   []rune
+	// esc holds a partially received escape sequence.
+	esc []byte
+	// lastTab tracks a second Tab press to list candidates.
+	lastTab bool
+	// histErr is the error from History.Add, for Write to report.
+	histErr error
+	done    chan result
+	scratch []byte
+}
+
+type result struct {
+	line string
+	err  error
+}
+
+// New returns an *Editor that renders to out.
+func New(out io.Writer) *Editor {
+	return &Editor{
+		out:     out,
+		histPos: -1,
+		done:    make(chan result, 4),
+	}
+}
+
+// ReadLine draws prompt and blocks until a line is accepted with Enter.
+// It returns io.EOF if Ctrl-D is pressed on an empty line and ErrInterrupted
+// if Ctrl-C is pressed. Input typed before ReadLine is called is kept.
+func (e *Editor) ReadLine(prompt string) (string, error) {
+	e.mu.Lock()
+	e.prompt = prompt
+	e.refresh()
+	e.mu.Unlock()
+
+	r := <-e.done
+	return r.line, r.err
+}
+
+// ReadPassword is like ReadLine but masks the input with '*'.
+func (e *Editor) ReadPassword(prompt string) (string, error) {
+	e.mu.Lock()
+	mask := e.Mask
+	e.Mask = '*'
+	e.mu.Unlock()
+
+	line, err := e.ReadLine(prompt)
+
+	e.mu.Lock()
+	e.Mask = mask
+	e.mu.Unlock()
+	return line, err
+}
+
+// Write feeds keypad output to the editor. It always consumes all of p.
+func (e *Editor) Write(p []byte) (int, error) {
+	e.mu.Lock()
+	for _, b := range p {
+		e.feed(b)
+	}
+	err := e.histErr
+	e.histErr = nil
+	e.mu.Unlock()
+
+	if err != nil && e.ErrorCallback != nil {
+		e.ErrorCallback(err)
+	}
+	return len(p), nil
+}
+
+func (e *Editor) feed(b byte) {
+	if len(e.esc) > 0 {
+		e.esc = append(e.esc, b)
+		e.escape()
+		return
+	}
+
+	tab := false
+	kill := false
+	switch b {
+	case 0x1b:
+		e.esc = append(e.esc[:0], b)
+		return
+	case '\n', '\r':
+		e.accept(nil)
+		return
+	case '\b', 0x7f:
+		e.backspace()
+	case '\t':
+		tab = true
+		e.complete()
+	case 0x01: // Ctrl-A
+		e.pos = 0
+	case 0x02: // Ctrl-B
+		e.move(-1)
+	case 0x03: // Ctrl-C
+		e.write("^C")
+		e.accept(ErrInterrupted)
+		return
+	case 0x04: // Ctrl-D
+		if len(e.line) == 0 {
+			e.accept(io.EOF)
+			return
+		}
+		e.deleteForward()
+	case 0x05: // Ctrl-E
+		e.pos = len(e.line)
+	case 0x06: // Ctrl-F
+		e.move(1)
+	case 0x0b: // Ctrl-K
+		kill = true
+		e.killRange(e.pos, len(e.line), false)
+	case 0x0c: // Ctrl-L
+		e.write("\x1b[H\x1b[2J")
+	case 0x0e: // Ctrl-N
+		e.historyStep(1)
+	case 0x10: // Ctrl-P
+		e.historyStep(-1)
+	case 0x15: // Ctrl-U
+		kill = true
+		e.killRange(0, e.pos, true)
+	case 0x17: // Ctrl-W
+		kill = true
+		e.killRange(e.wordStart(), e.pos, true)
+	case 0x19: // Ctrl-Y
+		e.insert(e.kill...)
+	default:
+		if b < 0x20 {
+			return
+		}
+		e.insert(rune(b))
+	}
+	e.lastTab = tab
+	e.lastKill = kill
+	e.refresh()
+}
+
+// escape interprets the escape sequence collected in e.esc once it is complete.
+// Esc followed by a byte that starts no known sequence is dropped.
+func (e *Editor) escape() {
+	seq := e.esc
+	if len(seq) < 2 {
+		return
+	}
+	kill := false
+	switch seq[1] {
+	case '[', 'O':
+		if len(seq) < 3 {
+			return
+		}
+		final := seq[len(seq)-1]
+		if final < 0x40 || final > 0x7e {
+			if len(seq) > 8 {
+				e.esc = e.esc[:0]
+			}
+			return
+		}
+		e.csi(string(seq[2:len(seq)-1]), final)
+	case 'b', 'B':
+		e.pos = e.wordStart()
+	case 'f', 'F':
+		e.pos = e.wordEnd()
+	case 'd', 'D':
+		kill = true
+		e.killRange(e.pos, e.wordEnd(), false)
+	case '\b', 0x7f:
+		kill = true
+		e.killRange(e.wordStart(), e.pos, true)
+	default:
+		// not a sequence we know, such as a lone Esc before Enter: drop
+		// the Esc and handle the byte on its own
+		b := seq[1]
+		e.esc = e.esc[:0]
+		e.feed(b)
+		return
+	}
+	e.esc = e.esc[:0]
+	e.lastTab = false
+	e.lastKill = kill
+	e.refresh()
+}
+
+func (e *Editor) csi(params string, final byte) {
+	switch final {
+	case 'A':
+		e.historyStep(-1)
+	case 'B':
+		e.historyStep(1)
+	case 'C':
+		if params == "1;2" {
+			e.pos = len(e.line)
+			return
+		}
+		e.move(1)
+	case 'D':
+		if params == "1;2" {
+			e.pos = 0
+			return
+		}
+		e.move(-1)
+	case 'H':
+		e.pos = 0
+	case 'F':
+		e.pos = len(e.line)
+	case '~':
+		switch params {
+		case "1", "7":
+			e.pos = 0
+		case "4", "8":
+			e.pos = len(e.line)
+		case "3":
+			e.deleteForward()
+		}
+	}
+}
+
+func (e *Editor) accept(err error) {
+	line := string(e.line)
+	if err == nil && e.Mask == 0 && e.History != nil {
+		if err := e.History.Add(line); err != nil {
+			e.histErr = err
+		}
+	}
+	e.write("\r\n")
+	e.line = e.line[:0]
+	e.pos = 0
+	e.histPos = -1
+	e.saved = nil
+	e.lastTab = false
+	e.lastKill = false
+	e.prompt = ""
+	select {
+	case e.done <- result{line: line, err: err}:
+	default:
+		// nobody is reading and the queue is full; drop the oldest line
+		<-e.done
+		e.done <- result{line: line, err: err}
+	}
+}
+
+func (e *Editor) insert(r ...rune) {
+	e.line = append(e.line, r...)
+	copy(e.line[e.pos+len(r):], e.line[e.pos:])
+	copy(e.line[e.pos:], r)
+	e.pos += len(r)
+}
+
+func (e *Editor) move(n int) {
+	e.pos += n
+	if e.pos < 0 {
+		e.pos = 0
+	}
+	if e.pos > len(e.line) {
+		e.pos = len(e.line)
+	}
+}
+
+func (e *Editor) backspace() {
+	if e.pos == 0 {
+		return
+	}
+	e.line = append(e.line[:e.pos-1], e.line[e.pos:]...)
+	e.pos--
+}
+
+func (e *Editor) deleteForward() {
+	if e.pos >= len(e.line) {
+		return
+	}
+	e.line = append(e.line[:e.pos], e.line[e.pos+1:]...)
+}
+
+// killRange removes line[from:to] into the yank buffer. Consecutive kills are
+// merged; backward kills are prepended.
+func (e *Editor) killRange(from, to int, backward bool) {
+	if from >= to {
+		return
+	}
+	cut := append([]rune(nil), e.line[from:to]...)
+	switch {
+	case !e.lastKill:
+		e.kill = cut
+	case backward:
+		e.kill = append(cut, e.kill...)
+	default:
+		e.kill = append(e.kill, cut...)
+	}
+	e.line = append(e.line[:from], e.line[to:]...)
+	e.pos = from
+}
+
+func (e *Editor) wordStart() int {
+	i := e.pos
+	for i > 0 && e.line[i-1] == ' ' {
+		i--
+	}
+	for i > 0 && e.line[i-1] != ' ' {
+		i--
+	}
+	return i
+}
+
+func (e *Editor) wordEnd() int {
+	i := e.pos
+	for i < len(e.line) && e.line[i] == ' ' {
+		i++
+	}
+	for i < len(e.line) && e.line[i] != ' ' {
+		i++
+	}
+	return i
+}
+
+func (e *Editor) historyStep(dir int) {
+	h := e.History
+	if h == nil || h.Len() == 0 {
+		return
+	}
+	next := e.histPos
+	switch {
+	case next < 0 && dir < 0:
+		e.saved = append([]rune(nil), e.line...)
+		next = h.Len() - 1
+	case next < 0:
+		return
+	default:
+		next += dir
+	}
+	switch {
+	case next < 0:
+		return
+	case next >= h.Len():
+		e.histPos = -1
+		e.line = append(e.line[:0], e.saved...)
+	default:
+		e.histPos = next
+		e.line = append(e.line[:0], []rune(h.Line(next))...)
+	}
+	e.pos = len(e.line)
+}
+
+func (e *Editor) complete() {
+	if e.Complete == nil {
+		return
+	}
+	start, candidates := e.Complete(string(e.line), e.pos)
+	if start < 0 || start > e.pos || len(candidates) == 0 {
+		return
+	}
+	word := string(e.line[start:e.pos])
+	prefix := commonPrefix(candidates)
+	switch {
+	case len(candidates) == 1:
+		e.replace(start, candidates[0]+" ")
+	case len(prefix) > len(word):
+		e.replace(start, prefix)
+	case e.lastTab:
+		// second Tab without progress lists the candidates
+		e.write("\r\n" + strings.Join(candidates, "  ") + "\r\n")
+	}
+}
+
+func (e *Editor) replace(start int, s string) {
+	tail := append([]rune(nil), e.line[e.pos:]...)
+	e.line = append(append(e.line[:start], []rune(s)...), tail...)
+	e.pos = start + len([]rune(s))
+}
+
+// refresh redraws the prompt and line and places the cursor.
+func (e *Editor) refresh() {
+	buf := append(e.scratch[:0], '\r')
+	buf = append(buf, e.prompt...)
+	if e.Mask != 0 {
+		for range e.line {
+			buf = append(buf, string(e.Mask)...)
+		}
+	} else {
+		buf = append(buf, string(e.line)...)
+	}
+	buf = append(buf, "\x1b[K"...)
+	if back := len(e.line) - e.pos; back > 0 {
+		buf = append(buf, "\x1b["...)
+		buf = strconv.AppendInt(buf, int64(back), 10)
+		buf = append(buf, 'D')
+	}
+	e.scratch = buf
+	e.out.Write(buf)
+}
+
+func (e *Editor) write(s string) {
+	io.WriteString(e.out, s)
+}
+
+func commonPrefix(s []string) string {
+	prefix := s[0]
+	for _, c := range s[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
@@ -0,0 +1,189 @@
+package lineedit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"tinygo.org/x/tinyfs"
+)
+
+// typeLine feeds input to e and returns the accepted line.
+func typeLine(t *testing.T, e *Editor, input string) (string, error) {
+	t.Helper()
+	e.Write([]byte(input))
+	select {
+	case r := <-e.done:
+		return r.line, r.err
+	default:
+		t.Fatalf("input %q did not complete a line", input)
+		return "", nil
+	}
+}
+
+func TestEditorEditing(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"plain", "hello\n", "hello"},
+		{"backspace", "helo\bp\n", "help"},
+		{"left insert", "hllo\x1b[D\x1b[D\x1b[De\n", "hello"},
+		{"home end", "ello\x01h\x05!\n", "hello!"},
+		{"shift arrows", "bc\x1b[1;2Da\x1b[1;2Cd\n", "abcd"},
+		{"delete forward", "xhi\x01\x1b[3~\n", "hi"},
+		{"kill yank", "hello world\x17\x17\x19\n", "hello world"},
+		{"kill to end", "hello world\x1bb\x0b\x01\x19\n", "worldhello "},
+		{"kill to start", "abc def\x15xyz\n", "xyz"},
+		{"alt word", "one two\x1bb\x1bdthree\n", "one three"},
+		{"lone esc", "a\x1bx\x1b\n", "ax"},
+	}
+	for _, tc := range tests {
+		e := New(io.Discard)
+		got, err := typeLine(t, e, tc.input)
+		if err != nil || got != tc.want {
+			t.Fatalf("%s: line = %q, %v; want %q, nil", tc.name, got, err, tc.want)
+		}
+	}
+}
+
+func TestEditorControl(t *testing.T) {
+	e := New(io.Discard)
+	if _, err := typeLine(t, e, "abc\x03"); !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("Ctrl-C err = %v, want ErrInterrupted", err)
+	}
+	if _, err := typeLine(t, e, "\x04"); err != io.EOF {
+		t.Fatalf("Ctrl-D err = %v, want io.EOF", err)
+	}
+}
+
+func TestEditorHistory(t *testing.T) {
+	e := New(io.Discard)
+	e.History = NewHistory(2)
+	typeLine(t, e, "first\n")
+	typeLine(t, e, "second\n")
+	typeLine(t, e, "third\n")
+	if e.History.Len() != 2 {
+		t.Fatalf("history len = %d, want 2", e.History.Len())
+	}
+
+	got, _ := typeLine(t, e, "\x1b[A\x1b[A\n")
+	if got != "second" {
+		t.Fatalf("Up Up = %q, want %q", got, "second")
+	}
+	got, _ = typeLine(t, e, "draft\x1b[A\x1b[B\n")
+	if got != "draft" {
+		t.Fatalf("Up Down = %q, want %q", got, "draft")
+	}
+}
+
+func TestEditorCompletion(t *testing.T) {
+	e := New(io.Discard)
+	e.Complete = func(line string, pos int) (int, []string) {
+		start := strings.LastIndex(line[:pos], " ") + 1
+		var out []string
+		for _, c := range []string{"mkdir", "mv", "more"} {
+			if strings.HasPrefix(c, line[start:pos]) {
+				out = append(out, c)
+			}
+		}
+		return start, out
+	}
+	if got, _ := typeLine(t, e, "mk\tx\n"); got != "mkdir x" {
+		t.Fatalf("single completion = %q, want %q", got, "mkdir x")
+	}
+	if got, _ := typeLine(t, e, "mo\t\n"); got != "more " {
+		t.Fatalf("completion = %q, want %q", got, "more ")
+	}
+}
+
+func TestEditorMask(t *testing.T) {
+	var out bytes.Buffer
+	e := New(&out)
+	e.History = NewHistory(0)
+	e.Mask = '*'
+	got, _ := typeLine(t, e, "secret\n")
+	if got != "secret" {
+		t.Fatalf("masked line = %q, want %q", got, "secret")
+	}
+	if strings.Contains(out.String(), "secret") {
+		t.Fatalf("masked output %q contains the password", out.String())
+	}
+	if e.History.Len() != 0 {
+		t.Fatalf("masked line added to history")
+	}
+}
+
+type memFS map[string]*bytes.Buffer
+
+type memFile struct {
+	*bytes.Buffer
+}
+
+func (memFile) Close() error                       { return nil }
+func (memFile) Seek(int64, int) (int64, error)     { return 0, nil }
+func (memFile) IsDir() bool                        { return false }
+func (memFile) Readdir(int) ([]os.FileInfo, error) { return nil, nil }
+func (memFile) Stat() (os.FileInfo, error)         { return nil, nil }
+
+func (m memFS) OpenFile(path string, flags int) (tinyfs.File, error) {
+	buf, ok := m[path]
+	if flags&os.O_CREATE != 0 {
+		buf = new(bytes.Buffer)
+		m[path] = buf
+	} else if !ok {
+		return nil, os.ErrNotExist
+	}
+	return memFile{buf}, nil
+}
+
+func TestHistoryPersist(t *testing.T) {
+	fs := memFS{}
+	h := NewHistory(10)
+	if err := h.Persist(fs, "/history"); err != nil {
+		t.Fatalf("Persist() on missing file = %v", err)
+	}
+	h.Add("ls")
+	h.Add("ls")
+	h.Add("cat x")
+
+	if got := fs["/history"].String(); got != "ls\ncat x\n" {
+		t.Fatalf("saved history = %q", got)
+	}
+
+	h2 := NewHistory(10)
+	if err := h2.Persist(fs, "/history"); err != nil {
+		t.Fatalf("Persist() = %v", err)
+	}
+	if h2.Len() != 2 || h2.Line(1) != "cat x" {
+		t.Fatalf("loaded history len=%d", h2.Len())
+	}
+}
+
+type failFS struct{}
+
+func (failFS) OpenFile(path string, flags int) (tinyfs.File, error) {
+	return nil, errors.New("card removed")
+}
+
+func TestHistoryErrors(t *testing.T) {
+	h := NewHistory(10)
+	if err := h.Persist(failFS{}, "/history"); err == nil {
+		t.Fatal("Persist() with a failing filesystem succeeded")
+	}
+
+	e := New(io.Discard)
+	e.History = h
+	var got error
+	e.ErrorCallback = func(err error) { got = err }
+	if line, err := typeLine(t, e, "ls\n"); line != "ls" || err != nil {
+		t.Fatalf("line = %q, %v; want %q, nil", line, err, "ls")
+	}
+	if got == nil {
+		t.Fatal("ErrorCallback not called for a failed History.Add")
+	}
+}
@@ -3,12 +3,14 @@
 package cardputer
 
 // Microphone exposes the Cardputer-Adv microphone path.
-// Codec configuration is supported; PCM capture still depends on a future ESP32 I2S backend.
+// Reads capture PCM from the ES8311 over I2S0, sharing the link with Speaker.
+// Rates the codec can't clock are resampled from the nearest one it can.
 var Microphone = &microphone{}
 
 type microphone struct {
 	codec     *ES8311
 	transport audioTransport
+	conv      audioConverter
 }
 
 func (mic *microphone) Init() error {
@@ -52,12 +54,14 @@ func (mic *microphone) SetVolume(volume uint8) error {
 
 func (mic *microphone) SetSampleRate(rate uint32) error {
 	codec, err := configureSharedES8311(func(cfg *ES8311Config) {
-		cfg.SampleRate = rate
+		clk := SelectES8311Clock(rate, *cfg)
+		cfg.SampleRate, cfg.MCLKMultiple = clk.SampleRate, clk.MCLKMultiple
 	})
 	if err != nil {
 		return err
 	}
 	mic.codec = codec
+	mic.conv.rate = rate
 	return mic.attachTransport()
 }
 
@@ -87,7 +91,7 @@ func (mic *microphone) Read(samples []int16) (int, error) {
 	if err := mic.Init(); err != nil {
 		return 0, err
 	}
-	return mic.transport.Read(samples)
+	return mic.conv.read(samples, sharedES8311Config.SampleRate, mic.transport.Read)
 }
 
 func (mic *microphone) attachTransport() error {
@@ -98,3 +102,7 @@ func (mic *microphone) attachTransport() error {
 	mic.transport = transport
 	return nil
 }
+
+func (mic *microphone) sampleRate() uint32 {
+	return mic.conv.callerRate(sharedES8311Config.SampleRate)
+}
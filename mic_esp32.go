@@ -2,38 +2,85 @@
 
 package cardputer
 
-import "errors"
+// Microphone captures the original Cardputer's SPM1423 PDM microphone on I2S0,
+// converted to PCM by the controller. Gain and volume are applied to the
+// samples: each gain step adds 6dB, as on the Adv codec, but starts at 0dB
+// since the PDM output is already at full scale. Reading stops any speaker
+// output, as the two share a clock pin.
+var Microphone = &microphone{enabled: true, volume: 1 << 16}
 
-// Microphone exposes microphone control for the current board.
-// On the original Cardputer build, configuration and capture are not yet implemented.
-var Microphone = &microphone{}
+type microphone struct {
+	transport audioTransport
+	enabled   bool
+	gain      uint8
+	volume    int32
+	conv      audioConverter
+}
 
-type microphone struct{}
+func (mic *microphone) Init() error {
+	transport, err := configureSharedAudio(func(*AudioTransportConfig) {})
+	if err != nil {
+		return err
+	}
+	mic.transport = transport
+	return nil
+}
 
-func (*microphone) Init() error {
-	return errors.New("microphone support is not implemented for the ESP32-S3 cardputer target")
+func (mic *microphone) SetGain(gain uint8) error {
+	if gain > 7 {
+		gain = 7
+	}
+	mic.gain = gain
+	return mic.Init()
 }
 
-func (*microphone) SetGain(uint8) error {
-	return errors.New("microphone support is not implemented for the ESP32-S3 cardputer target")
+func (mic *microphone) SetVolume(volume uint8) error {
+	mic.volume = volumeGain(volume)
+	return mic.Init()
 }
 
-func (*microphone) SetVolume(uint8) error {
-	return errors.New("microphone support is not implemented for the ESP32-S3 cardputer target")
+func (mic *microphone) SetSampleRate(rate uint32) error {
+	transport, err := configureSharedAudio(func(cfg *AudioTransportConfig) {
+		cfg.SampleRate = rate
+	})
+	if err != nil {
+		return err
+	}
+	mic.transport = transport
+	mic.conv.rate = rate
+	return nil
 }
 
-func (*microphone) SetSampleRate(uint32) error {
-	return errors.New("microphone support is not implemented for the ESP32-S3 cardputer target")
+func (mic *microphone) SetBitsPerSample(bits ES8311Resolution) error {
+	transport, err := configureSharedAudio(func(cfg *AudioTransportConfig) {
+		cfg.BitsPerSample = bits
+	})
+	if err != nil {
+		return err
+	}
+	mic.transport = transport
+	return nil
 }
 
-func (*microphone) SetBitsPerSample(ES8311Resolution) error {
-	return errors.New("microphone support is not implemented for the ESP32-S3 cardputer target")
+// UseDigital turns capture on or off. While off, Read returns silence.
+func (mic *microphone) UseDigital(enable bool) error {
+	mic.enabled = enable
+	return mic.Init()
 }
 
-func (*microphone) UseDigital(bool) error {
-	return errors.New("microphone support is not implemented for the ESP32-S3 cardputer target")
+func (mic *microphone) Read(samples []int16) (int, error) {
+	if err := mic.Init(); err != nil {
+		return 0, err
+	}
+	if !mic.enabled {
+		clear(samples)
+		return len(samples), nil
+	}
+	n, err := mic.conv.read(samples, sharedAudioConfig.SampleRate, mic.transport.Read)
+	scaleSamples(samples[:n], samples[:n], mic.volume<<mic.gain)
+	return n, err
 }
 
-func (*microphone) Read([]int16) (int, error) {
-	return 0, errAudioStreamUnavailable
+func (mic *microphone) sampleRate() uint32 {
+	return mic.conv.callerRate(sharedAudioConfig.SampleRate)
 }
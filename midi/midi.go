@@ -0,0 +1,234 @@
+// midi reads Standard MIDI Files, formats 0 and 1, and plays them on a
+// synth.Synth. Player is sample-clocked: it renders exactly as many samples
+// as the file's tempo map says lie between events, so timing stays exact
+// however the output is paced. It knows nothing about the hardware;
+// cardputer.PlayMIDI feeds a Player to the Mixer.
+package midi // import "github.com/sparques/cardputer/midi"
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+	"slices"
+	"time"
+)
+
+var (
+	ErrNotMIDI     = errors.New("not a Standard MIDI File")
+	ErrUnsupported = errors.New("unsupported MIDI file format")
+	errTruncated   = errors.New("MIDI track is truncated")
+)
+
+// StatusTempo marks an Event as a tempo change.
+const StatusTempo = 0xFF
+
+// defaultTempo is 120 beats per minute, in microseconds per quarter note.
+const defaultTempo = 500000
+
+// Event is a channel message or tempo change.
+type Event struct {
+	// Tick is the time from the start of the file in the file's ticks.
+	Tick uint32
+	// Status is the channel message's status byte, or StatusTempo.
+	Status       byte
+	Data1, Data2 byte
+	// Tempo is the new tempo of a tempo change, in microseconds per quarter
+	// note.
+	Tempo uint32
+}
+
+// File is a parsed MIDI file.
+type File struct {
+	Format uint16
+	// Division is ticks per quarter note or, with its top bit set, SMPTE
+	// frames per second (negated, in the high byte) and ticks per frame.
+	Division uint16
+	// Events holds every track's events merged in time order. System
+	// exclusive and meta events other than tempo changes are left out.
+	Events []Event
+}
+
+// Parse reads a MIDI file from r.
+func Parse(r io.Reader) (*File, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 14 || string(data[:4]) != "MThd" {
+		return nil, ErrNotMIDI
+	}
+	be := binary.BigEndian
+	hdrLen := int(be.Uint32(data[4:]))
+	if hdrLen < 6 || 8+hdrLen > len(data) {
+		return nil, ErrNotMIDI
+	}
+	f := &File{Format: be.Uint16(data[8:]), Division: be.Uint16(data[12:])}
+	if f.Format > 1 || !validDivision(f.Division) {
+		return nil, ErrUnsupported
+	}
+
+	for rest := data[8+hdrLen:]; len(rest) >= 8; {
+		size := int(be.Uint32(rest[4:]))
+		if size > len(rest)-8 {
+			return nil, errTruncated
+		}
+		if string(rest[:4]) == "MTrk" {
+			if err := f.parseTrack(rest[8 : 8+size]); err != nil {
+				return nil, err
+			}
+		}
+		rest = rest[8+size:]
+	}
+	// A stable sort keeps a tempo change in the first track ahead of notes
+	// at the same tick in later ones.
+	slices.SortStableFunc(f.Events, func(a, b Event) int {
+		return cmp.Compare(a.Tick, b.Tick)
+	})
+	return f, nil
+}
+
+// validDivision reports whether d gives a usable tick rate: a non-zero count
+// of ticks per quarter note, or one of the SMPTE frame rates with a non-zero
+// count of ticks per frame.
+func validDivision(d uint16) bool {
+	if d&0x8000 == 0 {
+		return d != 0
+	}
+	switch -int8(d >> 8) {
+	case 24, 25, 29, 30:
+		return d&0xFF != 0
+	}
+	return false
+}
+
+func (f *File) parseTrack(b []byte) error {
+	r := bytes.NewReader(b)
+	var tick uint32
+	var running byte
+	for r.Len() > 0 {
+		delta, err := varint(r)
+		if err != nil {
+			return err
+		}
+		tick += delta
+		status, err := r.ReadByte()
+		if err != nil {
+			return errTruncated
+		}
+		switch {
+		case status == 0xFF:
+			kind, err := r.ReadByte()
+			if err != nil {
+				return errTruncated
+			}
+			body, err := chunk(r)
+			if err != nil {
+				return err
+			}
+			switch {
+			case kind == 0x2F:
+				return nil
+			case kind == 0x51 && len(body) == 3:
+				tempo := uint32(body[0])<<16 | uint32(body[1])<<8 | uint32(body[2])
+				f.Events = append(f.Events, Event{Tick: tick, Status: StatusTempo, Tempo: tempo})
+			}
+			continue
+		case status == 0xF0 || status == 0xF7:
+			if _, err := chunk(r); err != nil {
+				return err
+			}
+			continue
+		case status < 0x80:
+			// Running status: this is the first data byte.
+			if running == 0 {
+				return errTruncated
+			}
+			r.UnreadByte()
+			status = running
+		default:
+			running = status
+		}
+
+		e := Event{Tick: tick, Status: status}
+		if e.Data1, err = r.ReadByte(); err != nil {
+			return errTruncated
+		}
+		if kind := status & 0xF0; kind != 0xC0 && kind != 0xD0 {
+			if e.Data2, err = r.ReadByte(); err != nil {
+				return errTruncated
+			}
+		}
+		f.Events = append(f.Events, e)
+	}
+	return nil
+}
+
+// varint reads a variable-length quantity.
+func varint(r *bytes.Reader) (uint32, error) {
+	var v uint32
+	for i := 0; i < 4; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, errTruncated
+		}
+		v = v<<7 | uint32(b&0x7F)
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, errTruncated
+}
+
+// chunk reads a length-prefixed meta or system exclusive body.
+func chunk(r *bytes.Reader) ([]byte, error) {
+	n, err := varint(r)
+	if err != nil {
+		return nil, err
+	}
+	if int64(n) > int64(r.Len()) {
+		return nil, errTruncated
+	}
+	b := make([]byte, n)
+	r.Read(b)
+	return b, nil
+}
+
+// Duration returns how long the file plays, to its last event.
+func (f *File) Duration() time.Duration {
+	var d time.Duration
+	var tick uint32
+	tempo := uint32(defaultTempo)
+	for _, e := range f.Events {
+		num, den := f.ticksPerSecond(tempo)
+		d += ticksDuration(e.Tick-tick, num, den)
+		tick = e.Tick
+		if e.Status == StatusTempo {
+			tempo = e.Tempo
+		}
+	}
+	return d
+}
+
+// ticksDuration returns how long ticks last at num/den ticks a second. The
+// product is formed in 128 bits, as a long rest overflows 64.
+func ticksDuration(ticks uint32, num, den uint64) time.Duration {
+	hi, lo := bits.Mul64(uint64(ticks)*den, uint64(time.Second))
+	if hi >= num {
+		return math.MaxInt64
+	}
+	q, _ := bits.Div64(hi, lo, num)
+	return time.Duration(min(q, math.MaxInt64))
+}
+
+// ticksPerSecond returns the tick rate at tempo as the fraction num/den.
+func (f *File) ticksPerSecond(tempo uint32) (num, den uint64) {
+	if f.Division&0x8000 != 0 {
+		fps := uint64(-int8(f.Division >> 8))
+		return fps * uint64(f.Division&0xFF), 1
+	}
+	return uint64(f.Division) * 1000000, uint64(max(tempo, 1))
+}
@@ -0,0 +1,193 @@
+package midi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sparques/cardputer/synth"
+)
+
+func smf(format, division uint16, tracks ...[]byte) []byte {
+	b := []byte("MThd\x00\x00\x00\x06")
+	b = binary.BigEndian.AppendUint16(b, format)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(tracks)))
+	b = binary.BigEndian.AppendUint16(b, division)
+	// An unknown chunk, to be skipped.
+	b = append(b, "XFIH\x00\x00\x00\x02ab"...)
+	for _, t := range tracks {
+		b = append(b, "MTrk"...)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(t)))
+		b = append(b, t...)
+	}
+	return b
+}
+
+// testSong has a quarter-note rest at 120bpm, an eighth-note A4, a rest to
+// the end of the second beat, a tempo change to 240bpm, then a quarter-rest
+// and a quarter-note C5, all at 96 ticks a quarter note:
+//
+//	A4 from 0.5s to 0.75s, C5 from 1.25s to 1.5s.
+var testSong = smf(1, 96,
+	[]byte{
+		0x00, 0xFF, 0x51, 0x03, 0x07, 0xA1, 0x20, // 500000us
+		0x81, 0x40, 0xFF, 0x51, 0x03, 0x03, 0xD0, 0x90, // 250000us at 192
+		0x00, 0xFF, 0x2F, 0x00,
+	},
+	[]byte{
+		0x00, 0xFF, 0x03, 0x04, 'o', 'r', 'g', 'n',
+		0x00, 0xC0, 16, // organ
+		0x00, 0xF0, 0x02, 0x01, 0xF7, // system exclusive
+		0x60, 0x90, 69, 100, // at 96
+		0x30, 69, 0, // running status note off at 144
+		0x81, 0x10, 0x90, 72, 100, // at 288
+		0x60, 0x80, 72, 0, // at 384
+		0x00, 0xFF, 0x2F, 0x00,
+	},
+)
+
+func TestParse(t *testing.T) {
+	f, err := Parse(bytes.NewReader(testSong))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Format != 1 || f.Division != 96 {
+		t.Errorf("format %d, division %d", f.Format, f.Division)
+	}
+	want := []Event{
+		{Tick: 0, Status: StatusTempo, Tempo: 500000},
+		{Tick: 0, Status: 0xC0, Data1: 16},
+		{Tick: 96, Status: 0x90, Data1: 69, Data2: 100},
+		{Tick: 144, Status: 0x90, Data1: 69},
+		{Tick: 192, Status: StatusTempo, Tempo: 250000},
+		{Tick: 288, Status: 0x90, Data1: 72, Data2: 100},
+		{Tick: 384, Status: 0x80, Data1: 72},
+	}
+	if len(f.Events) != len(want) {
+		t.Fatalf("events %+v", f.Events)
+	}
+	for i := range want {
+		if f.Events[i] != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, f.Events[i], want[i])
+		}
+	}
+	if d := f.Duration(); d != 1500*time.Millisecond {
+		t.Errorf("Duration = %v, want 1.5s", d)
+	}
+}
+
+func TestSMPTEDivision(t *testing.T) {
+	// 25 frames a second of 40 ticks: a quarter-second note at tick 250
+	f, err := Parse(bytes.NewReader(smf(0, 0xE728, []byte{
+		0x00, 0x90, 60, 100,
+		0x81, 0x7A, 0x80, 60, 0,
+		0x00, 0xFF, 0x2F, 0x00,
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := f.Duration(); d != 250*time.Millisecond {
+		t.Errorf("Duration = %v, want 250ms", d)
+	}
+}
+
+func TestDurationLongRest(t *testing.T) {
+	// a note 96000 ticks, or 1000 beats at 120bpm, after the start
+	f, err := Parse(bytes.NewReader(smf(0, 96, []byte{
+		0x00, 0x90, 60, 100,
+		0x85, 0xEE, 0x00, 0x80, 60, 0,
+		0x00, 0xFF, 0x2F, 0x00,
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := f.Duration(); d != 500*time.Second {
+		t.Errorf("Duration = %v, want 500s", d)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		file []byte
+		err  error
+	}{
+		{"empty", nil, ErrNotMIDI},
+		{"riff", []byte("RIFF\x00\x00\x00\x06\x00\x00\x00\x01\x00\x60"), ErrNotMIDI},
+		{"format 2", smf(2, 96), ErrUnsupported},
+		{"no division", smf(0, 0), ErrUnsupported},
+		{"no ticks per frame", smf(0, 0xE200), ErrUnsupported},
+		{"bad frame rate", smf(0, 0xE428), ErrUnsupported},
+		{"truncated", smf(0, 96, []byte{0x00, 0x90, 60}), errTruncated},
+	} {
+		if _, err := Parse(bytes.NewReader(tt.file)); err != tt.err {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+// sounding returns the first sample from start on that is non-zero, or -1.
+func sounding(buf []int16, start int) int {
+	for i := start; i < len(buf); i++ {
+		if buf[i] != 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestPlayer(t *testing.T) {
+	f, err := Parse(bytes.NewReader(testSong))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPlayer(f, synth.New(16000))
+	var out []int16
+	buf := make([]int16, 333)
+	for {
+		n, err := p.Read(buf)
+		out = append(out, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) > 5*16000 {
+			t.Fatal("player never finished")
+		}
+	}
+
+	// Each note starts with a zero-phase sample, so sound begins one
+	// sample after the event.
+	if i := sounding(out, 0); i != 8001 {
+		t.Errorf("A4 starts at sample %d, want 8001", i)
+	}
+	// The organ releases in about 50ms.
+	if i := sounding(out, 12000+1000); i != 20001 {
+		t.Errorf("C5 starts at sample %d, want 20001 after the tempo change", i)
+	}
+	if len(out) < 24000+800 || len(out) > 24000+1000+len(buf) {
+		t.Errorf("played %d samples, want the song and the release of its last note", len(out))
+	}
+}
+
+func TestPlayerLoop(t *testing.T) {
+	f, err := Parse(bytes.NewReader(testSong))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPlayer(f, synth.New(8000))
+	p.Loop = true
+	// Three times through, 1.5s each at 8kHz. The last C5 of one pass is
+	// still fading as the next starts.
+	buf := make([]int16, 3*12000+10)
+	if n, err := p.Read(buf); n != len(buf) || err != nil {
+		t.Fatalf("Read = %d, %v", n, err)
+	}
+	if i := sounding(buf, 2*12000+1000); i != 2*12000+4000+1 {
+		t.Errorf("third A4 starts at %d, want %d", i, 2*12000+4001)
+	}
+}
@@ -0,0 +1,101 @@
+package midi
+
+import (
+	"io"
+
+	"github.com/sparques/cardputer/synth"
+)
+
+// Player renders a File on a Synth. Its Read makes it a mixer.Source.
+type Player struct {
+	// Loop starts the file again from the top when it ends, leaving any
+	// notes still ringing to fade out.
+	Loop bool
+
+	f     *File
+	s     *synth.Synth
+	next  int
+	tempo uint32
+	// tick and frac are the playback position: tick plus frac/(the
+	// current tempo's tick-rate denominator times the sample rate).
+	tick uint32
+	frac uint64
+}
+
+// NewPlayer returns a Player for f rendering on s from the start.
+func NewPlayer(f *File, s *synth.Synth) *Player {
+	p := &Player{f: f, s: s}
+	p.Rewind()
+	return p
+}
+
+// Rewind moves back to the start of the file.
+func (p *Player) Rewind() {
+	p.next, p.tick, p.frac, p.tempo = 0, 0, 0, defaultTempo
+}
+
+// Read renders the next len(dst) samples. Once the last event has played
+// and the last note faded out, it returns io.EOF.
+func (p *Player) Read(dst []int16) (int, error) {
+	events := p.f.Events
+	rate := uint64(p.s.SampleRate)
+	n := 0
+	for n < len(dst) {
+		for p.next < len(events) && events[p.next].Tick <= p.tick {
+			p.dispatch(events[p.next])
+			p.next++
+		}
+
+		chunk := len(dst) - n
+		if p.next == len(events) {
+			if p.Loop && len(events) > 0 && events[len(events)-1].Tick > 0 {
+				p.Rewind()
+				continue
+			}
+			if p.s.Active() == 0 {
+				return n, io.EOF
+			}
+		} else {
+			// Render up to the sample the next event falls on.
+			num, den := p.f.ticksPerSecond(p.tempo)
+			den *= rate
+			need := (uint64(events[p.next].Tick-p.tick)*den - p.frac + num - 1) / num
+			chunk = int(min(need, uint64(chunk)))
+		}
+		p.s.Read(dst[n : n+chunk])
+		n += chunk
+		p.advance(chunk)
+	}
+	return n, nil
+}
+
+// advance moves the position on by samples.
+func (p *Player) advance(samples int) {
+	num, den := p.f.ticksPerSecond(p.tempo)
+	den *= uint64(p.s.SampleRate)
+	total := p.frac + uint64(samples)*num
+	p.tick += uint32(total / den)
+	p.frac = total % den
+}
+
+func (p *Player) dispatch(e Event) {
+	if e.Status == StatusTempo {
+		// Keep the fraction of a tick already played at the new rate.
+		_, old := p.f.ticksPerSecond(p.tempo)
+		_, den := p.f.ticksPerSecond(e.Tempo)
+		p.frac = p.frac * den / old
+		p.tempo = e.Tempo
+		return
+	}
+	ch := e.Status & 0x0F
+	switch e.Status & 0xF0 {
+	case 0x80:
+		p.s.NoteOff(ch, e.Data1)
+	case 0x90:
+		p.s.NoteOn(ch, e.Data1, e.Data2)
+	case 0xB0:
+		p.s.ControlChange(ch, e.Data1, e.Data2)
+	case 0xC0:
+		p.s.ProgramChange(ch, e.Data1)
+	}
+}
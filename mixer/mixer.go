@@ -0,0 +1,267 @@
+// mixer sums any number of voices into one stream of 16-bit PCM, with a
+// volume, pan and priority for each, clipping the result at full scale. It
+// pulls samples from its voices as it is read, so it knows nothing about the
+// hardware; cardputer.Mixer feeds what it mixes to the Speaker.
+package mixer // import "github.com/sparques/cardputer/mixer"
+
+import (
+	"errors"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/sparques/cardputer/pcm"
+)
+
+// ErrNoVoice is returned by Add when every voice is taken by one of higher
+// priority.
+var ErrNoVoice = errors.New("no free voice")
+
+// Source supplies the samples of a voice: mono, at the mixer's sample rate.
+// Read returns io.EOF, or any other error, to end the voice.
+type Source interface {
+	Read(dst []int16) (int, error)
+}
+
+// SourceFunc adapts a function to a Source.
+type SourceFunc func(dst []int16) (int, error)
+
+func (f SourceFunc) Read(dst []int16) (int, error) {
+	return f(dst)
+}
+
+// Mixer mixes its voices as it is read. The zero value is a mono mixer with
+// no voice limit.
+type Mixer struct {
+	// Channels is 1 for mono output or 2 for interleaved stereo, which is
+	// the only case where pan has an effect. 0 means 1.
+	Channels int
+
+	mu        sync.Mutex
+	voices    []*Voice
+	maxVoices int
+	acc       []int32
+	scratch   []int16
+}
+
+// Voice is one source playing in a Mixer.
+type Voice struct {
+	m        *Mixer
+	src      Source
+	priority int
+	// gain and the per-channel gains are in Q16.
+	gain        int32
+	left, right int32
+	done        chan struct{}
+	err         error
+}
+
+// SetMaxVoices limits how many voices play at once; 0 means no limit. Voices
+// over the new limit are stopped, lowest priority first.
+func (m *Mixer) SetMaxVoices(n int) {
+	m.mu.Lock()
+	m.maxVoices = n
+	for n > 0 && len(m.voices) > n {
+		m.remove(m.lowest(), nil)
+	}
+	m.mu.Unlock()
+}
+
+// Add starts src playing at full volume, centred. If the mixer is full the
+// lowest-priority voice is stopped to make room, unless every voice has a
+// higher priority than priority, in which case Add returns ErrNoVoice.
+func (m *Mixer) Add(src Source, priority int) (*Voice, error) {
+	v := &Voice{
+		m:        m,
+		src:      src,
+		priority: priority,
+		gain:     1 << 16,
+		left:     1 << 16,
+		right:    1 << 16,
+		done:     make(chan struct{}),
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.maxVoices > 0 && len(m.voices) >= m.maxVoices {
+		i := m.lowest()
+		if m.voices[i].priority > priority {
+			return nil, ErrNoVoice
+		}
+		m.remove(i, nil)
+	}
+	m.voices = append(m.voices, v)
+	return v, nil
+}
+
+// Voices returns how many voices are playing.
+func (m *Mixer) Voices() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.voices)
+}
+
+// StopAll removes every voice, ending each with err.
+func (m *Mixer) StopAll(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for len(m.voices) > 0 {
+		m.remove(len(m.voices)-1, err)
+	}
+}
+
+// lowest returns the index of the voice to give up first: the
+// lowest-priority one, the oldest of those if several tie.
+func (m *Mixer) lowest() int {
+	low := 0
+	for i, v := range m.voices {
+		if v.priority < m.voices[low].priority {
+			low = i
+		}
+	}
+	return low
+}
+
+func (m *Mixer) remove(i int, err error) {
+	v := m.voices[i]
+	m.voices = append(m.voices[:i], m.voices[i+1:]...)
+	v.err = err
+	close(v.done)
+}
+
+// Read fills dst with the next len(dst)/Channels frames of the mix, silence
+// if there are no voices, and returns len(dst). Voices whose source ends are
+// removed.
+func (m *Mixer) Read(dst []int16) int {
+	channels := max(m.Channels, 1)
+	frames := len(dst) / channels
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.acc) < frames*channels {
+		m.acc = make([]int32, frames*channels)
+		m.scratch = make([]int16, frames)
+	}
+	acc := m.acc[:frames*channels]
+	clear(acc)
+	for i := 0; i < len(m.voices); {
+		v := m.voices[i]
+		n, err := v.fill(m.scratch[:frames])
+		for f, s := range m.scratch[:n] {
+			if channels == 1 {
+				acc[f] += int32(int64(s) * int64(v.gain) >> 16)
+				continue
+			}
+			acc[2*f] += int32(int64(s) * int64(v.gain) * int64(v.left) >> 32)
+			acc[2*f+1] += int32(int64(s) * int64(v.gain) * int64(v.right) >> 32)
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			m.remove(i, err)
+			continue
+		}
+		i++
+	}
+	for i, s := range acc {
+		dst[i] = int16(min(max(s, math.MinInt16), math.MaxInt16))
+	}
+	return frames * channels
+}
+
+// fill reads from the source until dst is full or it stops supplying
+// samples, and returns how many it got.
+func (v *Voice) fill(dst []int16) (int, error) {
+	n := 0
+	for n < len(dst) {
+		m, err := v.src.Read(dst[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+		if m == 0 {
+			break
+		}
+	}
+	return n, nil
+}
+
+// SetVolume sets the voice's gain as a percentage; over 100 amplifies.
+func (v *Voice) SetVolume(percent uint8) {
+	v.m.mu.Lock()
+	v.gain = int32(percent) << 16 / 100
+	v.m.mu.Unlock()
+}
+
+// SetPan places the voice from -100, hard left, to 100, hard right. The
+// nearer channel stays at full level and the other fades.
+func (v *Voice) SetPan(pan int8) {
+	p := int32(min(max(pan, -100), 100))
+	v.m.mu.Lock()
+	v.left = min(100-p, 100) << 16 / 100
+	v.right = min(100+p, 100) << 16 / 100
+	v.m.mu.Unlock()
+}
+
+// Stop removes the voice from the mix.
+func (v *Voice) Stop() {
+	v.m.mu.Lock()
+	defer v.m.mu.Unlock()
+	for i, w := range v.m.voices {
+		if w == v {
+			v.m.remove(i, nil)
+			return
+		}
+	}
+}
+
+// Done is closed once the voice has finished or been stopped.
+func (v *Voice) Done() <-chan struct{} {
+	return v.done
+}
+
+// Err returns the error that ended the voice, or nil if its source ran out
+// or it was stopped.
+func (v *Voice) Err() error {
+	select {
+	case <-v.done:
+		return v.err
+	default:
+		return nil
+	}
+}
+
+// Resampled returns src converted from rate from to the mixer's rate to.
+func Resampled(src Source, from, to uint32) Source {
+	if from == to {
+		return src
+	}
+	return &resampled{src: src, rs: pcm.NewResampler(from, to, pcm.Polyphase)}
+}
+
+type resampled struct {
+	src        Source
+	rs         *pcm.Resampler
+	buf        [128]int16
+	head, tail int
+	err        error
+}
+
+func (r *resampled) Read(dst []int16) (int, error) {
+	n := 0
+	for n < len(dst) {
+		if r.head == r.tail {
+			if r.err != nil {
+				return n, r.err
+			}
+			m, err := r.src.Read(r.buf[:])
+			r.head, r.tail, r.err = 0, m, err
+			if m == 0 && err == nil {
+				return n, nil
+			}
+		}
+		out, used := r.rs.Process(dst[n:], r.buf[r.head:r.tail])
+		n += out
+		r.head += used
+	}
+	return n, nil
+}
@@ -0,0 +1,175 @@
+package mixer
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+// constant is a source of n samples of v.
+func constant(v int16, n int) Source {
+	return SourceFunc(func(dst []int16) (int, error) {
+		if n == 0 {
+			return 0, io.EOF
+		}
+		m := min(len(dst), n)
+		for i := range dst[:m] {
+			dst[i] = v
+		}
+		n -= m
+		return m, nil
+	})
+}
+
+func TestMix(t *testing.T) {
+	var m Mixer
+	a, _ := m.Add(constant(1000, 6), 0)
+	b, _ := m.Add(constant(-300, 3), 0)
+	b.SetVolume(50)
+
+	dst := make([]int16, 4)
+	if n := m.Read(dst); n != 4 {
+		t.Fatalf("Read = %d, want 4", n)
+	}
+	if want := []int16{850, 850, 850, 1000}; !equal(dst, want) {
+		t.Errorf("first block %v, want %v", dst, want)
+	}
+	select {
+	case <-b.Done():
+	default:
+		t.Errorf("finished voice still playing")
+	}
+	if m.Voices() != 1 {
+		t.Errorf("Voices = %d, want 1", m.Voices())
+	}
+
+	m.Read(dst)
+	if want := []int16{1000, 1000, 0, 0}; !equal(dst, want) {
+		t.Errorf("second block %v, want %v", dst, want)
+	}
+	if m.Voices() != 0 || a.Err() != nil {
+		t.Errorf("Voices = %d, err %v after the last voice ended", m.Voices(), a.Err())
+	}
+}
+
+func TestClip(t *testing.T) {
+	var m Mixer
+	m.Add(constant(30000, 10), 0)
+	m.Add(constant(30000, 10), 0)
+	v, _ := m.Add(constant(-30000, 10), 0)
+	v.SetVolume(0)
+	dst := make([]int16, 2)
+	m.Read(dst)
+	if dst[0] != 32767 {
+		t.Errorf("two loud voices mix to %d, want 32767", dst[0])
+	}
+}
+
+func TestPan(t *testing.T) {
+	m := Mixer{Channels: 2}
+	left, _ := m.Add(constant(1000, 10), 0)
+	left.SetPan(-100)
+	half, _ := m.Add(constant(2000, 10), 0)
+	half.SetPan(50)
+	dst := make([]int16, 4)
+	m.Read(dst)
+	if want := []int16{2000, 2000, 2000, 2000}; !equal(dst, want) {
+		t.Errorf("stereo mix %v, want %v", dst, want)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	var m Mixer
+	m.SetMaxVoices(2)
+	low, _ := m.Add(constant(1, 100), 1)
+	high, _ := m.Add(constant(2, 100), 5)
+	if _, err := m.Add(constant(3, 100), 0); err != ErrNoVoice {
+		t.Errorf("Add below every priority: %v, want ErrNoVoice", err)
+	}
+	if _, err := m.Add(constant(4, 100), 1); err != nil {
+		t.Fatalf("Add at the lowest priority: %v", err)
+	}
+	select {
+	case <-low.Done():
+	default:
+		t.Errorf("lowest-priority voice wasn't stolen")
+	}
+	m.SetMaxVoices(1)
+	if m.Voices() != 1 || high.Err() != nil {
+		t.Errorf("after SetMaxVoices(1): %d voices", m.Voices())
+	}
+	dst := make([]int16, 1)
+	m.Read(dst)
+	if dst[0] != 2 {
+		t.Errorf("mix %d, want the high-priority voice's 2", dst[0])
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	var m Mixer
+	bad := errors.New("card removed")
+	v, _ := m.Add(SourceFunc(func(dst []int16) (int, error) {
+		dst[0] = 7
+		return 1, bad
+	}), 0)
+	dst := make([]int16, 3)
+	m.Read(dst)
+	if !equal(dst, []int16{7, 0, 0}) || v.Err() != bad {
+		t.Errorf("mix %v, err %v", dst, v.Err())
+	}
+}
+
+func TestResampled(t *testing.T) {
+	src := Resampled(constant(1000, 800), 8000, 16000)
+	var got []int16
+	buf := make([]int16, 100)
+	for {
+		n, err := src.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(got) < 1600 || len(got) > 1604 {
+		t.Fatalf("%d samples, want about 1600", len(got))
+	}
+	if got[800] < 990 || got[800] > 1010 {
+		t.Errorf("steady level %d, want 1000", got[800])
+	}
+}
+
+func equal(a, b []int16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStopAll(t *testing.T) {
+	var m Mixer
+	a, _ := m.Add(constant(1, 100), 0)
+	b, _ := m.Add(constant(2, 100), 3)
+	gone := errors.New("speaker failed")
+	m.StopAll(gone)
+	if m.Voices() != 0 {
+		t.Fatalf("Voices = %d after StopAll, want 0", m.Voices())
+	}
+	for _, v := range []*Voice{a, b} {
+		select {
+		case <-v.Done():
+		default:
+			t.Fatal("voice not done after StopAll")
+		}
+		if v.Err() != gone {
+			t.Errorf("Err = %v, want %v", v.Err(), gone)
+		}
+	}
+}
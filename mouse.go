@@ -0,0 +1,94 @@
+package cardputer
+
+import (
+	"image"
+
+	"github.com/sparques/cardputer/keypad"
+)
+
+// Mouse turns the keyboard into a pointing device for Display. Toggle it with
+// Fn+M: while on, the Fn arrow keys move an accelerating pointer, Fn+Space is
+// the left button and Fn+Enter the right. Set Mouse.Callback to receive
+// pointer events, then call Mouse.Attach once KP's own callbacks are set.
+//
+// The pointer is drawn over the framebuffer without modifying it, so it
+// needs a buffered Display (the default for Init).
+var Mouse = &mouse{
+	MouseKeys: keypad.NewMouseKeys(dispWidth, dispHeight),
+}
+
+type mouse struct {
+	*keypad.MouseKeys
+	cursor pointerCursor
+}
+
+// Attach makes Mouse handle KP's keys and draw the pointer on Display while
+// mouse keys are enabled.
+func (m *mouse) Attach() {
+	callback := m.Callback
+	m.Callback = func(ev keypad.PointerEvent) {
+		m.moveCursor(ev.X, ev.Y)
+		if callback != nil {
+			callback(ev)
+		}
+	}
+	enable := m.EnableCallback
+	m.EnableCallback = func(enabled bool) {
+		if enabled {
+			m.moveCursor(m.Position())
+			Display.showOverlay(&m.cursor)
+		} else {
+			Display.hideOverlay(&m.cursor)
+		}
+		if enable != nil {
+			enable(enabled)
+		}
+	}
+	m.MouseKeys.Attach(KP.Device)
+}
+
+// moveCursor puts the pointer at x, y. It is called from MouseKeys' timer
+// as well as KP's goroutine, so the position is changed under Display's lock.
+func (m *mouse) moveCursor(x, y int) {
+	Display.updateOverlay(&m.cursor, func() bool {
+		if x == m.cursor.x && y == m.cursor.y {
+			return false
+		}
+		m.cursor.x, m.cursor.y = x, y
+		return true
+	})
+}
+
+// pointerCursor is an arrow with its tip at x, y.
+type pointerCursor struct {
+	x, y int
+}
+
+// pointerShape is the arrow: 'X' is the outline, 'o' the fill.
+var pointerShape = [...]string{
+	"X      ",
+	"XX     ",
+	"XoX    ",
+	"XooX   ",
+	"XoooX  ",
+	"XooooX ",
+	"XoooooX",
+	"XooXXXX",
+	"XoX    ",
+	"XX     ",
+	"X      ",
+}
+
+func (c *pointerCursor) overlayBounds() image.Rectangle {
+	return image.Rect(c.x, c.y, c.x+len(pointerShape[0]), c.y+len(pointerShape))
+}
+
+func (c *pointerCursor) overlayAt(x, y int) (RGB565, bool) {
+	switch pointerShape[y-c.y][x-c.x] {
+	case 'X':
+		return NewRGB565(0, 0, 0), true
+	case 'o':
+		return NewRGB565(0xFF, 0xFF, 0xFF), true
+	}
+	return 0, false
+}
@@ -0,0 +1,45 @@
+//go:build esp32 || esp32s3
+
+package cardputer
+
+import (
+	"github.com/sparques/cardputer/midi"
+	"github.com/sparques/cardputer/mixer"
+	"github.com/sparques/cardputer/rtttl"
+	"github.com/sparques/cardputer/synth"
+)
+
+// PlayRTTTL queues an RTTTL ringtone on Speaker at volume percent and returns
+// at once.
+//
+//	cardputer.PlayRTTTL("Beep:d=8,o=5,b=140:c6,e6,g6", 60)
+func PlayRTTTL(song string, volume uint8) error {
+	s, err := rtttl.Parse(song)
+	if err != nil {
+		return err
+	}
+	for i := range s.Notes {
+		if s.Notes[i].Volume != 0 {
+			s.Notes[i].Volume = volume
+		}
+	}
+	return Speaker.PlayMelody(s.Notes...)
+}
+
+// PlayMIDI loads the Standard MIDI File at path on SDFS and plays it through
+// Mixer on the built-in synthesiser, looping if loop is set. Stop the
+// returned voice to end it early.
+func PlayMIDI(path string, loop bool) (*mixer.Voice, error) {
+	f, err := SDFS.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	song, err := midi.Parse(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	p := midi.NewPlayer(song, synth.New(Mixer.SampleRate()))
+	p.Loop = loop
+	return Mixer.Add(p, 0)
+}
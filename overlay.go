@@ -0,0 +1,87 @@
+package cardputer
+
+import "image"
+
+// overlay is drawn on the panel on top of the framebuffer without changing
+// it, like a sprite. Whatever is under an overlay is restored from the
+// framebuffer when it moves or is hidden, so overlays need a buffered Display.
+type overlay interface {
+	// overlayBounds returns the area the overlay may cover.
+	overlayBounds() image.Rectangle
+	// overlayAt returns the overlay's colour at x, y, or false where it is
+	// transparent.
+	overlayAt(x, y int) (RGB565, bool)
+}
+
+// showOverlay draws o above everything drawn so far and keeps it there.
+func (d *display) showOverlay(o overlay) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, v := range d.overlays {
+		if v == o {
+			d.flush(o.overlayBounds())
+			return
+		}
+	}
+	d.overlays = append(d.overlays, o)
+	d.flush(o.overlayBounds())
+}
+
+// hideOverlay removes o, restoring the framebuffer underneath.
+func (d *display) hideOverlay(o overlay) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, v := range d.overlays {
+		if v == o {
+			d.overlays = append(d.overlays[:i], d.overlays[i+1:]...)
+			d.flush(o.overlayBounds())
+			return
+		}
+	}
+}
+
+// updateOverlay calls change, which may move o or alter how it looks, and
+// redraws o if it is visible and change reports it changed. Overlays are
+// drawn from several goroutines, so whatever overlayAt reads is only changed
+// this way, under d.mu.
+func (d *display) updateOverlay(o overlay, change func() bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	old := o.overlayBounds()
+	if !change() {
+		return
+	}
+	for _, v := range d.overlays {
+		if v == o {
+			d.flush(old)
+			d.flush(o.overlayBounds())
+			return
+		}
+	}
+}
+
+// overlayAt returns the colour of the topmost overlay covering x, y. d.mu
+// must be held.
+func (d *display) overlayAt(x, y int) (RGB565, bool) {
+	pt := image.Pt(x, y)
+	for i := len(d.overlays) - 1; i >= 0; i-- {
+		o := d.overlays[i]
+		if !pt.In(o.overlayBounds()) {
+			continue
+		}
+		if c, ok := o.overlayAt(x, y); ok {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// redrawOverlays draws the overlays again where r was painted over them.
+// d.mu must be held.
+func (d *display) redrawOverlays(r image.Rectangle) {
+	for _, o := range d.overlays {
+		if b := o.overlayBounds().Intersect(r); !b.Empty() {
+			d.flush(b)
+		}
+	}
+}
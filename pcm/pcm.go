@@ -0,0 +1,97 @@
+// pcm converts 16-bit sample streams between the layouts and rates the
+// Cardputer's audio hardware and files use: mono and interleaved stereo,
+// 16, 24 and 32-bit packed words, float32, and any sample rate to any other.
+// Like tone it knows nothing about the hardware, so it is tested on the host.
+package pcm // import "github.com/sparques/cardputer/pcm"
+
+import "math"
+
+// SlotWidth returns the bytes an I2S slot takes for a codec word of bits:
+// 2 for 16-bit words and 4 for anything wider.
+func SlotWidth(bits uint8) int {
+	if bits <= 16 {
+		return 2
+	}
+	return 4
+}
+
+// PutSample stores v little-endian in the first width bytes of b. Widths 3
+// and 4 hold v in the top 16 bits, the way 24 and 32-bit words carry it, with
+// the low bits zero.
+func PutSample(b []byte, v int16, width int) {
+	switch width {
+	case 2:
+		b[0], b[1] = byte(v), byte(v>>8)
+	case 3:
+		b[0], b[1], b[2] = 0, byte(v), byte(v>>8)
+	case 4:
+		b[0], b[1], b[2], b[3] = 0, 0, byte(v), byte(v>>8)
+	}
+}
+
+// Sample returns the top 16 bits of the little-endian word of width bytes at
+// b.
+func Sample(b []byte, width int) int16 {
+	return int16(b[width-2]) | int16(b[width-1])<<8
+}
+
+// Pack stores src in dst as words of width bytes, 2, 3 or 4, and returns how
+// many samples fit.
+func Pack(dst []byte, src []int16, width int) int {
+	n := min(len(src), len(dst)/width)
+	for i, v := range src[:n] {
+		PutSample(dst[i*width:], v, width)
+	}
+	return n
+}
+
+// Unpack reads words of width bytes from src into dst and returns how many
+// it read.
+func Unpack(dst []int16, src []byte, width int) int {
+	n := min(len(dst), len(src)/width)
+	for i := range dst[:n] {
+		dst[i] = Sample(src[i*width:], width)
+	}
+	return n
+}
+
+// MonoToStereo writes each sample of src to both channels of the
+// interleaved frames in dst and returns the frames written.
+func MonoToStereo(dst, src []int16) int {
+	n := min(len(src), len(dst)/2)
+	for i, v := range src[:n] {
+		dst[2*i], dst[2*i+1] = v, v
+	}
+	return n
+}
+
+// StereoToMono averages the channels of the interleaved frames in src into
+// dst and returns the frames read. dst may be src.
+func StereoToMono(dst, src []int16) int {
+	n := min(len(dst), len(src)/2)
+	for i := range dst[:n] {
+		dst[i] = int16((int32(src[2*i]) + int32(src[2*i+1])) >> 1)
+	}
+	return n
+}
+
+// FromFloat32 converts samples in [-1, 1] to int16, clipping anything outside,
+// and returns how many it converted.
+func FromFloat32(dst []int16, src []float32) int {
+	n := min(len(dst), len(src))
+	for i, v := range src[:n] {
+		s := math.Round(float64(v) * math.MaxInt16)
+		dst[i] = int16(min(max(s, math.MinInt16), math.MaxInt16))
+	}
+	return n
+}
+
+// ToFloat32 converts samples to the range [-1, 1) and returns how many it
+// converted.
+func ToFloat32(dst []float32, src []int16) int {
+	n := min(len(dst), len(src))
+	for i, v := range src[:n] {
+		dst[i] = float32(v) / (math.MaxInt16 + 1)
+	}
+	return n
+}
@@ -0,0 +1,154 @@
+package pcm
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPack(t *testing.T) {
+	src := []int16{0x1234, -2, math.MinInt16}
+	for _, tt := range []struct {
+		width int
+		want  []byte
+	}{
+		{2, []byte{0x34, 0x12, 0xFE, 0xFF, 0x00, 0x80}},
+		{3, []byte{0, 0x34, 0x12, 0, 0xFE, 0xFF, 0, 0x00, 0x80}},
+		{4, []byte{0, 0, 0x34, 0x12, 0, 0, 0xFE, 0xFF, 0, 0, 0x00, 0x80}},
+	} {
+		b := make([]byte, len(tt.want)+1)
+		if n := Pack(b, src, tt.width); n != len(src) || string(b[:len(tt.want)]) != string(tt.want) {
+			t.Errorf("width %d: Pack = %d, % x, want % x", tt.width, n, b, tt.want)
+		}
+		got := make([]int16, 4)
+		if n := Unpack(got, b, tt.width); n != len(src) || got[0] != src[0] || got[1] != src[1] || got[2] != src[2] {
+			t.Errorf("width %d: Unpack = %d, %v", tt.width, n, got)
+		}
+	}
+	for bits, want := range map[uint8]int{16: 2, 18: 4, 20: 4, 24: 4, 32: 4} {
+		if got := SlotWidth(bits); got != want {
+			t.Errorf("SlotWidth(%d) = %d, want %d", bits, got, want)
+		}
+	}
+}
+
+func TestChannels(t *testing.T) {
+	stereo := make([]int16, 6)
+	if n := MonoToStereo(stereo, []int16{1, -7, 300, 9}); n != 3 {
+		t.Errorf("MonoToStereo = %d, want 3", n)
+	}
+	if want := []int16{1, 1, -7, -7, 300, 300}; string(fmtInts(stereo)) != string(fmtInts(want)) {
+		t.Errorf("MonoToStereo: %v, want %v", stereo, want)
+	}
+
+	frames := []int16{100, 200, -32768, -32768, 32767, 32767, 5}
+	if n := StereoToMono(frames, frames); n != 3 || frames[0] != 150 || frames[1] != -32768 || frames[2] != 32767 {
+		t.Errorf("StereoToMono = %d, %v", n, frames[:3])
+	}
+}
+
+func fmtInts(s []int16) []byte {
+	b := make([]byte, 2*len(s))
+	Pack(b, s, 2)
+	return b
+}
+
+func TestFloat32(t *testing.T) {
+	ints := make([]int16, 5)
+	FromFloat32(ints, []float32{0, 0.5, -1, 2, -3})
+	if want := []int16{0, 16384, -32767, 32767, -32768}; string(fmtInts(ints)) != string(fmtInts(want)) {
+		t.Errorf("FromFloat32: %v, want %v", ints, want)
+	}
+	floats := make([]float32, 3)
+	ToFloat32(floats, []int16{0, -32768, 16384})
+	if floats[0] != 0 || floats[1] != -1 || floats[2] != 0.5 {
+		t.Errorf("ToFloat32: %v", floats)
+	}
+}
+
+// resample runs n samples of a freq hertz sine at from through a Resampler
+// to rate to, feeding it in pieces of chunk, and returns the output and the
+// output it should ideally have produced.
+func resample(from, to uint32, q Quality, freq float64, n, chunk int) (got, want []float64) {
+	r := NewResampler(from, to, q)
+	src := make([]int16, n)
+	for i := range src {
+		src[i] = int16(16000 * math.Sin(2*math.Pi*freq*float64(i)/float64(from)))
+	}
+	out := make([]int16, r.OutLen(n))
+	total := 0
+	for fed := 0; fed < n; {
+		end := min(n, fed+chunk)
+		w, used := r.Process(out[total:], src[fed:end])
+		total += w
+		fed += used
+	}
+	for m, v := range out[:total] {
+		// Output m is the input at m*from/to, delayed.
+		t := float64(m)*float64(from)/float64(to) - float64(r.Delay())
+		got = append(got, float64(v))
+		if t < 0 {
+			want = append(want, 0)
+		} else {
+			want = append(want, 16000*math.Sin(2*math.Pi*freq*t/float64(from)))
+		}
+	}
+	return got, want
+}
+
+// rmsError is the RMS difference of got and want after the filter has
+// settled, relative to the test signal's amplitude.
+func rmsError(got, want []float64) float64 {
+	var sum float64
+	settled := got[40:]
+	for i, v := range settled {
+		d := v - want[40+i]
+		sum += d * d
+	}
+	return math.Sqrt(sum/float64(len(settled))) / 16000
+}
+
+func TestResample(t *testing.T) {
+	for _, tt := range []struct {
+		from, to uint32
+		q        Quality
+		freq     float64
+		maxErr   float64
+	}{
+		{8000, 16000, Linear, 440, 0.02},
+		{8000, 16000, Polyphase, 440, 0.002},
+		{11025, 22050, Polyphase, 1000, 0.002},
+		{44100, 16000, Polyphase, 1000, 0.002},
+		{22050, 48000, Polyphase, 3000, 0.005},
+		{16000, 16000, Linear, 1000, 0},
+	} {
+		got, want := resample(tt.from, tt.to, tt.q, tt.freq, 2000, 37)
+		if wantLen := 2000 * int(tt.to) / int(tt.from); len(got) < wantLen || len(got) > wantLen+3 {
+			t.Errorf("%d->%d: %d samples, want about %d", tt.from, tt.to, len(got), wantLen)
+		}
+		if e := rmsError(got, want); e > tt.maxErr+1e-4 {
+			t.Errorf("%d->%d quality %d: error %.4f, want under %.4f", tt.from, tt.to, tt.q, e, tt.maxErr)
+		}
+	}
+}
+
+func TestResampleAntiAlias(t *testing.T) {
+	// A 10kHz tone is above 16kHz's Nyquist frequency, so downsampling
+	// should all but remove it.
+	got, _ := resample(44100, 16000, Polyphase, 10000, 4000, 256)
+	if e := rmsError(got, make([]float64, len(got))); e > 0.05 {
+		t.Errorf("10kHz tone left at %.3f of full level", e)
+	}
+}
+
+func TestResampleChunking(t *testing.T) {
+	whole, _ := resample(48000, 44100, Polyphase, 1000, 1000, 1000)
+	pieces, _ := resample(48000, 44100, Polyphase, 1000, 1000, 3)
+	if len(whole) != len(pieces) {
+		t.Fatalf("%d samples in one go, %d in pieces", len(whole), len(pieces))
+	}
+	for i := range whole {
+		if whole[i] != pieces[i] {
+			t.Fatalf("sample %d: %v in one go, %v in pieces", i, whole[i], pieces[i])
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package pcm
+
+import "math"
+
+// Quality selects the interpolation a Resampler uses.
+type Quality uint8
+
+const (
+	// Linear interpolates between neighbouring samples. It is cheap but
+	// leaves images of the signal above the lower rate's Nyquist frequency.
+	Linear Quality = iota
+	// Polyphase uses a windowed-sinc filter, low-pass filtered to the lower
+	// of the two rates. It takes 16 taps, more in proportion when
+	// downsampling so the cut-off stays sharp.
+	Polyphase
+)
+
+const (
+	polyTaps = 16
+	// polyPhases is how many fractional positions the filter is tabulated
+	// at; outputs between two are interpolated.
+	polyPhases = 32
+	// coeffShift is the fixed-point scale of the filter coefficients.
+	coeffShift = 14
+)
+
+// Resampler converts a mono stream from one sample rate to another, keeping
+// the last few input samples between calls so a stream can be fed in pieces
+// of any size. Output lags input by Delay input samples.
+type Resampler struct {
+	from, to uint32
+	quality  Quality
+	// frac is the position of the next output past the newest sample in
+	// hist, in 1/to of an input sample. An input is taken whenever it
+	// reaches to.
+	frac uint32
+	hist []int16
+	// coeffs holds polyPhases+1 rows of len(hist) coefficients.
+	coeffs []int16
+}
+
+// NewResampler returns a Resampler from rate from to rate to.
+func NewResampler(from, to uint32, q Quality) *Resampler {
+	g := gcd(from, to)
+	r := &Resampler{from: from / g, to: to / g, quality: q}
+	if q == Polyphase {
+		taps := polyTaps
+		if to < from {
+			taps *= int((from + to - 1) / to)
+		}
+		r.hist = make([]int16, taps)
+		r.coeffs = polyCoeffs(from, to, taps)
+	} else {
+		r.hist = make([]int16, 2)
+	}
+	return r
+}
+
+func gcd(a, b uint32) uint32 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return max(a, 1)
+}
+
+// polyCoeffs tabulates a Blackman-windowed sinc, cut off at 0.9 of the lower
+// rate's Nyquist frequency when downsampling, with each row normalised to
+// unity gain.
+func polyCoeffs(from, to uint32, taps int) []int16 {
+	cutoff := 1.0
+	if to < from {
+		cutoff = 0.9 * float64(to) / float64(from)
+	}
+	coeffs := make([]int16, (polyPhases+1)*taps)
+	row := make([]float64, taps)
+	for p := 0; p <= polyPhases; p++ {
+		sum := 0.0
+		for j := range row {
+			// Distance from tap j to the output, in input samples.
+			d := float64(j-taps/2+1) - float64(p)/polyPhases
+			x := math.Pi * cutoff * d
+			h := cutoff
+			if x != 0 {
+				h = math.Sin(x) / x * cutoff
+			}
+			w := (d + float64(taps/2)) / float64(taps)
+			h *= 0.42 - 0.5*math.Cos(2*math.Pi*w) + 0.08*math.Cos(4*math.Pi*w)
+			row[j] = h
+			sum += h
+		}
+		for j, h := range row {
+			coeffs[p*taps+j] = int16(math.Round(h / sum * (1 << coeffShift)))
+		}
+	}
+	return coeffs
+}
+
+// Delay returns how many input samples the output lags behind the input.
+func (r *Resampler) Delay() int {
+	return len(r.hist)/2 + 1
+}
+
+// Reset forgets the stream so far, as if the Resampler were new.
+func (r *Resampler) Reset() {
+	r.frac = 0
+	clear(r.hist)
+}
+
+// OutLen returns how many samples the next n input samples produce, given
+// room for them all.
+func (r *Resampler) OutLen(n int) int {
+	end := uint64(n+1) * uint64(r.to)
+	if end <= uint64(r.frac) {
+		return 0
+	}
+	return int((end - uint64(r.frac) + uint64(r.from) - 1) / uint64(r.from))
+}
+
+// Process resamples src into dst. It stops when dst is full or src is used
+// up and returns how many samples it wrote and read; unread input should be
+// passed again on the next call.
+func (r *Resampler) Process(dst, src []int16) (n, used int) {
+	for {
+		// Take the input the next output needs even when dst is full, so
+		// room for OutLen(len(src)) samples always uses up src.
+		for r.frac >= r.to {
+			if used == len(src) {
+				return n, used
+			}
+			copy(r.hist, r.hist[1:])
+			r.hist[len(r.hist)-1] = src[used]
+			used++
+			r.frac -= r.to
+		}
+		if n == len(dst) {
+			return n, used
+		}
+		dst[n] = r.sample()
+		n++
+		r.frac += r.from
+	}
+}
+
+func (r *Resampler) sample() int16 {
+	if r.quality != Polyphase {
+		a, b := int64(r.hist[0]), int64(r.hist[1])
+		return int16(a + (b-a)*int64(r.frac)/int64(r.to))
+	}
+	// Interpolate between the outputs of the two tabulated phases either
+	// side of frac.
+	pos := uint64(r.frac) * polyPhases
+	p := int(pos / uint64(r.to))
+	w := int64(pos % uint64(r.to))
+	taps := len(r.hist)
+	lo := r.coeffs[p*taps:][:taps]
+	hi := r.coeffs[(p+1)*taps:][:taps]
+	var a, b int64
+	for j, v := range r.hist {
+		a += int64(v) * int64(lo[j])
+		b += int64(v) * int64(hi[j])
+	}
+	s := (a + (b-a)*w/int64(r.to)) >> coeffShift
+	return int16(min(max(s, math.MinInt16), math.MaxInt16))
+}
@@ -0,0 +1,141 @@
+// rtttl parses RTTTL, the Nokia ringtone format, into notes for
+// cardputer.Speaker.PlayMelody:
+//
+//	Tetris:d=4,o=5,b=160:e6,8b,8c6,8d6,16e6,16d6,8c6,8b,a,8a,8c6,e6
+//
+// A name, then defaults for duration, octave and tempo in beats per minute,
+// then the notes: an optional duration, a letter or p for a pause, an
+// optional sharp, an optional octave, and an optional dot to lengthen it by
+// half.
+package rtttl // import "github.com/sparques/cardputer/rtttl"
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sparques/cardputer/tone"
+)
+
+// ErrSyntax is wrapped by the errors Parse returns.
+var ErrSyntax = errors.New("malformed RTTTL")
+
+// Song is a parsed ringtone.
+type Song struct {
+	Name string
+	// Notes play at full volume; lower Volume on them to taste.
+	Notes []tone.Note
+}
+
+// semitones is each note letter's distance above C.
+var semitones = map[byte]int{'c': 0, 'd': 2, 'e': 4, 'f': 5, 'g': 7, 'a': 9, 'b': 11, 'h': 11}
+
+// Parse parses an RTTTL ringtone.
+func Parse(s string) (*Song, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), ":", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: want name:defaults:notes", ErrSyntax)
+	}
+	song := &Song{Name: strings.TrimSpace(parts[0])}
+
+	duration, octave, bpm := 4, 6, 63
+	for _, def := range strings.Split(parts[1], ",") {
+		def = strings.TrimSpace(def)
+		if def == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(def, "=")
+		v, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || err != nil || v <= 0 {
+			return nil, fmt.Errorf("%w: default %q", ErrSyntax, def)
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "d":
+			duration = v
+		case "o":
+			octave = v
+		case "b":
+			bpm = v
+		default:
+			return nil, fmt.Errorf("%w: default %q", ErrSyntax, def)
+		}
+	}
+	// A whole note is four beats.
+	whole := 4 * time.Minute / time.Duration(bpm)
+
+	for _, tok := range strings.Split(parts[2], ",") {
+		tok = strings.ToLower(strings.TrimSpace(tok))
+		if tok == "" {
+			continue
+		}
+		n, err := parseNote(tok, duration, octave, whole)
+		if err != nil {
+			return nil, err
+		}
+		song.Notes = append(song.Notes, n)
+	}
+	return song, nil
+}
+
+func parseNote(tok string, duration, octave int, whole time.Duration) (tone.Note, error) {
+	bad := fmt.Errorf("%w: note %q", ErrSyntax, tok)
+	i := 0
+	digits := func() (int, bool) {
+		start := i
+		for i < len(tok) && tok[i] >= '0' && tok[i] <= '9' {
+			i++
+		}
+		v, err := strconv.Atoi(tok[start:i])
+		return v, err == nil
+	}
+	if d, ok := digits(); ok {
+		if d == 0 {
+			return tone.Note{}, bad
+		}
+		duration = d
+	}
+	if i == len(tok) {
+		return tone.Note{}, bad
+	}
+	letter := tok[i]
+	i++
+	semitone, isNote := semitones[letter]
+	if !isNote && letter != 'p' {
+		return tone.Note{}, bad
+	}
+	if i < len(tok) && tok[i] == '#' {
+		semitone++
+		i++
+	}
+	// The dot is written before the octave as often as after it.
+	dotted := false
+	if i < len(tok) && tok[i] == '.' {
+		dotted = true
+		i++
+	}
+	if o, ok := digits(); ok {
+		octave = o
+	}
+	if i < len(tok) && tok[i] == '.' {
+		dotted = true
+		i++
+	}
+	if i != len(tok) {
+		return tone.Note{}, bad
+	}
+
+	n := tone.Note{Duration: whole / time.Duration(duration)}
+	if dotted {
+		n.Duration += n.Duration / 2
+	}
+	if isNote {
+		// MIDI key 69 is A4, 440Hz.
+		key := 12*(octave+1) + semitone
+		n.Freq = 440 * math.Pow(2, float64(key-69)/12)
+		n.Volume = 100
+	}
+	return n, nil
+}
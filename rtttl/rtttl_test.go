@@ -0,0 +1,69 @@
+package rtttl
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	song, err := Parse("Test Tune:d=4,o=5,b=120:a,8c#6,p,16b.,2e4.,8g#.7,h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if song.Name != "Test Tune" {
+		t.Errorf("Name = %q", song.Name)
+	}
+	// At 120bpm a quarter note is 500ms.
+	want := []struct {
+		freq float64
+		dur  time.Duration
+	}{
+		{880, 500 * time.Millisecond},
+		{1108.73, 250 * time.Millisecond},
+		{0, 500 * time.Millisecond},
+		{987.77, 187500 * time.Microsecond},
+		{329.63, 1500 * time.Millisecond},
+		{3322.44, 375 * time.Millisecond},
+		{987.77, 500 * time.Millisecond},
+	}
+	if len(song.Notes) != len(want) {
+		t.Fatalf("%d notes, want %d", len(song.Notes), len(want))
+	}
+	for i, n := range song.Notes {
+		if math.Abs(n.Freq-want[i].freq) > 0.01 || n.Duration != want[i].dur {
+			t.Errorf("note %d = %.2fHz for %v, want %.2fHz for %v", i, n.Freq, n.Duration, want[i].freq, want[i].dur)
+		}
+		if rest := want[i].freq == 0; rest != (n.Volume == 0) {
+			t.Errorf("note %d: volume %d", i, n.Volume)
+		}
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	// Missing defaults fall back to d=4, o=6, b=63.
+	song, err := Parse("x::a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := song.Notes[0]; math.Abs(n.Freq-1760) > 0.01 || n.Duration != 4*time.Minute/63/4 {
+		t.Errorf("note = %.2fHz for %v", n.Freq, n.Duration)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{
+		"no colons",
+		"x:d=4,q=2:a",
+		"x:b=0:a",
+		"x:d=4:z",
+		"x:d=4:0a",
+		"x:d=4:a5x",
+		"x:d=4:8",
+	} {
+		if _, err := Parse(s); !errors.Is(err, ErrSyntax) {
+			t.Errorf("Parse(%q) = %v, want ErrSyntax", s, err)
+		}
+	}
+}
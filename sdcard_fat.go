@@ -77,7 +77,8 @@ func (s *sdfs) Open(path string) (tinyfs.File, error) {
 	if err := s.Mount(); err != nil {
 		return nil, err
 	}
-	return s.fs.Open(cleanSDFSPath(path))
+	f, err := s.fs.Open(cleanSDFSPath(path))
+	return f, sdfsError("open", path, err)
 }
 
 // OpenFile opens a file on the mounted FAT filesystem using os.O_* flags.
@@ -85,7 +86,8 @@ func (s *sdfs) OpenFile(path string, flags int) (tinyfs.File, error) {
 	if err := s.Mount(); err != nil {
 		return nil, err
 	}
-	return s.fs.OpenFile(cleanSDFSPath(path), flags)
+	f, err := s.fs.OpenFile(cleanSDFSPath(path), flags)
+	return f, sdfsError("open", path, err)
 }
 
 // Mkdir creates a directory on the mounted FAT filesystem.
@@ -117,7 +119,8 @@ func (s *sdfs) Stat(path string) (os.FileInfo, error) {
 	if err := s.Mount(); err != nil {
 		return nil, err
 	}
-	return s.fs.Stat(cleanSDFSPath(path))
+	info, err := s.fs.Stat(cleanSDFSPath(path))
+	return info, sdfsError("stat", path, err)
 }
 
 // Free returns the number of free bytes reported by the mounted FAT filesystem.
@@ -128,6 +131,16 @@ func (s *sdfs) Free() (int64, error) {
 	return s.fs.Free()
 }
 
+// sdfsError reports fatfs's missing file and path results as os.ErrNotExist,
+// so callers can check for them with errors.Is.
+func sdfsError(op, path string, err error) error {
+	switch err {
+	case fatfs.FileResultNoFile, fatfs.FileResultNoPath:
+		return &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+	}
+	return err
+}
+
 func cleanSDFSPath(path string) string {
 	path = strings.TrimSpace(path)
 	switch path {
@@ -0,0 +1,135 @@
+package cardputer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/sparques/cardputer/shell"
+	"github.com/sparques/irtrx"
+	"github.com/sparques/irtrx/nec"
+	"github.com/sparques/irtrx/samsung"
+)
+
+// NewShell returns a shell.Shell working on SDFS and writing to out, with the
+// device commands bat, ir, beep and dmesg added to the built-ins.
+//
+// Typical use, with a lineedit.Editor as KP.Receiver:
+//
+//	sh := cardputer.NewShell(term)
+//	sh.Run(ed)
+func NewShell(out io.Writer) *shell.Shell {
+	sh := shell.New(SDFS, out)
+	for name, cmd := range deviceCommands {
+		sh.Register(name, cmd)
+	}
+	return sh
+}
+
+var deviceCommands = map[string]*shell.Command{
+	"bat":   {Usage: "bat", Run: batCommand},
+	"beep":  {Usage: "beep [HZ [MS]]", Run: beepCommand},
+	"dmesg": {Usage: "dmesg", Run: dmesgCommand},
+	"ir":    {Usage: "ir send nec|samsung ADDR CMD | ir send raw ON OFF...", Run: irCommand},
+}
+
+func batCommand(sh *shell.Shell, out io.Writer, args []string) error {
+	_, err := fmt.Fprintf(out, "%d%% (%d mV)\n", BatteryLevel(), BatteryMilliVolts())
+	return err
+}
+
+// beepCommand plays the standard beep, or a tone of the given pitch and
+// length.
+func beepCommand(sh *shell.Shell, out io.Writer, args []string) error {
+	if len(args) == 0 {
+		Speaker.Beep()
+		return nil
+	}
+	if len(args) > 2 {
+		return shell.ErrUsage
+	}
+	freq, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return err
+	}
+	ms := uint64(200)
+	if len(args) == 2 {
+		if ms, err = strconv.ParseUint(args[1], 0, 32); err != nil {
+			return err
+		}
+	}
+	return Speaker.Tone(freq, time.Duration(ms)*time.Millisecond, beepVolume)
+}
+
+func dmesgCommand(sh *shell.Shell, out io.Writer, args []string) error {
+	return DMesg(out)
+}
+
+func irCommand(sh *shell.Shell, out io.Writer, args []string) error {
+	if len(args) < 2 || args[0] != "send" {
+		return shell.ErrUsage
+	}
+	frame, err := parseIRFrame(args[1], args[2:])
+	if err != nil {
+		return err
+	}
+	IRLED.SendFrame(frame)
+	return nil
+}
+
+// parseIRFrame builds a frame for the ir send command. raw takes on and off
+// times in microseconds.
+func parseIRFrame(protocol string, args []string) (irtrx.FrameMarshaller, error) {
+	switch protocol {
+	case "nec", "samsung":
+		if len(args) != 2 {
+			return nil, shell.ErrUsage
+		}
+		bits := 8
+		if protocol == "samsung" {
+			bits = 16
+		}
+		addr, err := strconv.ParseUint(args[0], 0, bits)
+		if err != nil {
+			return nil, err
+		}
+		cmd, err := strconv.ParseUint(args[1], 0, bits)
+		if err != nil {
+			return nil, err
+		}
+		if protocol == "nec" {
+			return nec.NECFrame{Addr: byte(addr), Cmd: byte(cmd)}, nil
+		}
+		return &samsung.Frame{Addr: uint16(addr), Cmd: uint16(cmd)}, nil
+	case "raw":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return nil, errors.New("raw needs on and off times in pairs")
+		}
+		var frame rawIRFrame
+		for i := 0; i < len(args); i += 2 {
+			on, err := strconv.ParseUint(args[i], 0, 32)
+			if err != nil {
+				return nil, err
+			}
+			off, err := strconv.ParseUint(args[i+1], 0, 32)
+			if err != nil {
+				return nil, err
+			}
+			frame = append(frame, irtrx.TimePair{
+				time.Duration(on) * time.Microsecond,
+				time.Duration(off) * time.Microsecond,
+			})
+		}
+		return frame, nil
+	}
+	return nil, fmt.Errorf("unknown protocol %q", protocol)
+}
+
+// rawIRFrame sends its on/off pairs as they are.
+type rawIRFrame []irtrx.TimePair
+
+func (f rawIRFrame) MarshalFrame() []irtrx.TimePair {
+	return f
+}
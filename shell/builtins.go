@@ -0,0 +1,205 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// builtins are the commands every Shell starts with.
+var builtins = map[string]*Command{
+	"help":  {Usage: "help", Run: help},
+	"echo":  {Usage: "echo [WORD]...", Run: echo},
+	"exit":  {Usage: "exit", Run: func(*Shell, io.Writer, []string) error { return ErrExit }},
+	"pwd":   {Usage: "pwd", Run: pwd},
+	"cd":    {Usage: "cd [DIR]", Run: cd},
+	"ls":    {Usage: "ls [PATH]...", Run: ls},
+	"cat":   {Usage: "cat FILE...", Run: cat},
+	"cp":    {Usage: "cp SRC DST", Run: cp},
+	"mv":    {Usage: "mv SRC DST", Run: mv},
+	"rm":    {Usage: "rm PATH...", Run: rm},
+	"mkdir": {Usage: "mkdir DIR...", Run: mkdir},
+	"df":    {Usage: "df", Run: df},
+}
+
+func help(sh *Shell, out io.Writer, args []string) error {
+	for _, name := range sh.Commands() {
+		fmt.Fprintln(out, sh.commands[name].Usage)
+	}
+	return nil
+}
+
+func echo(sh *Shell, out io.Writer, args []string) error {
+	_, err := fmt.Fprintln(out, strings.Join(args, " "))
+	return err
+}
+
+func pwd(sh *Shell, out io.Writer, args []string) error {
+	_, err := fmt.Fprintln(out, sh.Dir)
+	return err
+}
+
+func cd(sh *Shell, out io.Writer, args []string) error {
+	var dir string
+	switch len(args) {
+	case 0:
+		dir = "/"
+	case 1:
+		dir = sh.Path(args[0])
+	default:
+		return ErrUsage
+	}
+	if dir != "/" {
+		info, err := sh.FS.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s: not a directory", args[0])
+		}
+	}
+	sh.Dir = dir
+	return nil
+}
+
+func ls(sh *Shell, out io.Writer, args []string) error {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	for _, arg := range args {
+		f, err := sh.FS.Open(sh.Path(arg))
+		if err != nil {
+			return err
+		}
+		var infos []os.FileInfo
+		if f.IsDir() {
+			infos, err = f.Readdir(0)
+			if len(args) > 1 {
+				fmt.Fprintf(out, "%s:\n", arg)
+			}
+		} else {
+			var info os.FileInfo
+			if info, err = f.Stat(); err == nil {
+				infos = append(infos, info)
+			}
+		}
+		f.Close()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		for _, info := range infos {
+			if info.IsDir() {
+				fmt.Fprintf(out, "%8s %s/\n", "", info.Name())
+			} else {
+				fmt.Fprintf(out, "%8d %s\n", info.Size(), info.Name())
+			}
+		}
+	}
+	return nil
+}
+
+func cat(sh *Shell, out io.Writer, args []string) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+	for _, arg := range args {
+		f, err := sh.FS.Open(sh.Path(arg))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// target returns where src goes when copied or moved to dst: into dst if it
+// is a directory, otherwise dst itself.
+func target(sh *Shell, src, dst string) string {
+	dst = sh.Path(dst)
+	if info, err := sh.FS.Stat(dst); err == nil && info.IsDir() {
+		return path.Join(dst, path.Base(src))
+	}
+	return dst
+}
+
+func cp(sh *Shell, out io.Writer, args []string) error {
+	if len(args) != 2 {
+		return ErrUsage
+	}
+	src := sh.Path(args[0])
+	in, err := sh.FS.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if in.IsDir() {
+		return fmt.Errorf("%s: is a directory", args[0])
+	}
+
+	// opening dst truncates it, so copying a file onto itself would empty it
+	dst := target(sh, src, args[1])
+	if dst == src {
+		return fmt.Errorf("%s and %s are the same file", args[0], args[1])
+	}
+	w, err := sh.FS.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func mv(sh *Shell, out io.Writer, args []string) error {
+	if len(args) != 2 {
+		return ErrUsage
+	}
+	src := sh.Path(args[0])
+	return sh.FS.Rename(src, target(sh, src, args[1]))
+}
+
+func rm(sh *Shell, out io.Writer, args []string) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+	for _, arg := range args {
+		if err := sh.FS.Remove(sh.Path(arg)); err != nil {
+			return fmt.Errorf("%s: %w", arg, err)
+		}
+	}
+	return nil
+}
+
+func mkdir(sh *Shell, out io.Writer, args []string) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+	for _, arg := range args {
+		if err := sh.FS.Mkdir(sh.Path(arg), 0o777); err != nil {
+			return fmt.Errorf("%s: %w", arg, err)
+		}
+	}
+	return nil
+}
+
+func df(sh *Shell, out io.Writer, args []string) error {
+	free, ok := sh.FS.(interface{ Free() (int64, error) })
+	if !ok {
+		return errors.New("free space not available")
+	}
+	n, err := free.Free()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%d bytes free\n", n)
+	return err
+}
@@ -0,0 +1,242 @@
+// shell provides a small command interpreter for the Cardputer. Lines are read
+// from a LineReader such as a lineedit.Editor fed by the keypad, split into
+// words with shlex quoting rules and dispatched to a registry of commands that
+// applications can extend. Output of any command can be redirected to a file
+// with > or >>.
+//
+// The built-in commands work on an FS such as cardputer.SDFS; device commands
+// are registered by the cardputer package.
+package shell // import "github.com/sparques/cardputer/shell"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/google/shlex"
+	"github.com/sparques/cardputer/lineedit"
+	"tinygo.org/x/tinyfs"
+)
+
+var (
+	// ErrExit is returned by a command to make Run return.
+	ErrExit = errors.New("exit")
+	// ErrUsage is returned by a command called with the wrong arguments; the
+	// shell then prints the command's usage.
+	ErrUsage = errors.New("usage")
+)
+
+// FS is the filesystem the file commands work on. cardputer.SDFS satisfies it.
+// If it also has a Free() (int64, error) method, df reports free space.
+type FS interface {
+	Open(path string) (tinyfs.File, error)
+	OpenFile(path string, flags int) (tinyfs.File, error)
+	Mkdir(path string, mode os.FileMode) error
+	Remove(path string) error
+	Rename(oldPath, newPath string) error
+	Stat(path string) (os.FileInfo, error)
+}
+
+// LineReader supplies the lines Run executes. *lineedit.Editor satisfies it.
+type LineReader interface {
+	ReadLine(prompt string) (string, error)
+}
+
+// Command is an entry in a Shell's registry.
+type Command struct {
+	// Usage is a one line synopsis, such as "cp SRC DST".
+	Usage string
+	// Run executes the command with its arguments, not including its own
+	// name. Output goes to out, which is a file when redirected.
+	Run func(sh *Shell, out io.Writer, args []string) error
+}
+
+// Shell reads, parses and runs command lines.
+type Shell struct {
+	// FS is the filesystem for the file commands and redirection.
+	FS FS
+	// Dir is the working directory that relative paths are resolved against.
+	Dir string
+	// Stdout receives command output that isn't redirected.
+	Stdout io.Writer
+	// Stderr receives error messages. Nil means Stdout.
+	Stderr io.Writer
+
+	commands map[string]*Command
+}
+
+// New returns a *Shell with the built-in commands, working in / on fs and
+// writing to out.
+func New(fs FS, out io.Writer) *Shell {
+	sh := &Shell{
+		FS:       fs,
+		Dir:      "/",
+		Stdout:   out,
+		commands: make(map[string]*Command),
+	}
+	for name, cmd := range builtins {
+		sh.Register(name, cmd)
+	}
+	return sh
+}
+
+// Register adds cmd under name, replacing any command of that name.
+func (sh *Shell) Register(name string, cmd *Command) {
+	sh.commands[name] = cmd
+}
+
+// Unregister removes the command called name.
+func (sh *Shell) Unregister(name string) {
+	delete(sh.commands, name)
+}
+
+// Command returns the command called name, or nil.
+func (sh *Shell) Command(name string) *Command {
+	return sh.commands[name]
+}
+
+// Commands returns the names of the registered commands in order.
+func (sh *Shell) Commands() []string {
+	names := make([]string, 0, len(sh.commands))
+	for name := range sh.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Prompt returns the prompt Run shows, which names the working directory.
+func (sh *Shell) Prompt() string {
+	return sh.Dir + "$ "
+}
+
+// Run reads and executes lines from r until it returns io.EOF or a command
+// returns ErrExit. Errors are printed and don't stop the shell; Ctrl-C
+// abandons the line being typed.
+func (sh *Shell) Run(r LineReader) error {
+	for {
+		line, err := r.ReadLine(sh.Prompt())
+		switch {
+		case err == io.EOF:
+			return nil
+		case err == lineedit.ErrInterrupted:
+			continue
+		case err != nil:
+			return err
+		}
+		if err := sh.Exec(line); err == ErrExit {
+			return nil
+		} else if err != nil {
+			fmt.Fprintln(sh.stderr(), err)
+		}
+	}
+}
+
+// Exec parses and runs one command line. Errors are returned prefixed with
+// the command name; usage errors include the command's synopsis.
+func (sh *Shell) Exec(line string) error {
+	words, redirect, appendTo, err := parse(line)
+	if err != nil {
+		return err
+	}
+	if len(words) == 0 {
+		if redirect != "" {
+			return errors.New("missing command")
+		}
+		return nil
+	}
+
+	name := words[0]
+	cmd := sh.commands[name]
+	if cmd == nil {
+		return fmt.Errorf("%s: command not found", name)
+	}
+
+	out := sh.Stdout
+	if redirect != "" {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if appendTo {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		f, err := sh.FS.OpenFile(sh.Path(redirect), flags)
+		if err != nil {
+			return fmt.Errorf("%s: %w", redirect, err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	err = cmd.Run(sh, out, words[1:])
+	switch {
+	case err == nil, err == ErrExit:
+		return err
+	case err == ErrUsage:
+		return fmt.Errorf("usage: %s", cmd.Usage)
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
+
+// Path resolves p against the working directory.
+func (sh *Shell) Path(p string) string {
+	if !path.IsAbs(p) {
+		p = path.Join(sh.Dir, p)
+	}
+	return path.Clean(p)
+}
+
+func (sh *Shell) stderr() io.Writer {
+	if sh.Stderr != nil {
+		return sh.Stderr
+	}
+	return sh.Stdout
+}
+
+// parse splits line into words and an optional redirection target. The first
+// > or >> outside quotes starts the redirection.
+func parse(line string) (words []string, redirect string, appendTo bool, err error) {
+	cmd, target := line, ""
+	var quote byte
+	escaped := false
+scan:
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '>':
+			cmd, target = line[:i], line[i+1:]
+			if strings.HasPrefix(target, ">") {
+				appendTo = true
+				target = target[1:]
+			}
+			break scan
+		}
+	}
+
+	if words, err = shlex.Split(cmd); err != nil {
+		return nil, "", false, err
+	}
+	if cmd == line {
+		return words, "", false, nil
+	}
+	targets, err := shlex.Split(target)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if len(targets) != 1 {
+		return nil, "", false, errors.New("redirection needs one file name")
+	}
+	return words, targets[0], appendTo, nil
+}
@@ -0,0 +1,263 @@
+package shell
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"tinygo.org/x/tinyfs"
+)
+
+// memFS is an in-memory FS. Directories are entries with a nil data.
+type memFS struct {
+	files map[string]*[]byte
+}
+
+func newMemFS() *memFS {
+	return &memFS{files: map[string]*[]byte{"/": nil}}
+}
+
+func (m *memFS) Open(p string) (tinyfs.File, error) {
+	return m.OpenFile(p, os.O_RDONLY)
+}
+
+func (m *memFS) OpenFile(p string, flags int) (tinyfs.File, error) {
+	data, ok := m.files[p]
+	switch {
+	case !ok && flags&os.O_CREATE == 0:
+		return nil, os.ErrNotExist
+	case !ok:
+		if _, ok := m.files[path.Dir(p)]; !ok {
+			return nil, os.ErrNotExist
+		}
+		data = new([]byte)
+		m.files[p] = data
+	case data != nil && flags&os.O_TRUNC != 0:
+		*data = (*data)[:0]
+	}
+	return &memFile{fs: m, name: p, data: data}, nil
+}
+
+func (m *memFS) Mkdir(p string, _ os.FileMode) error {
+	if _, ok := m.files[p]; ok {
+		return os.ErrExist
+	}
+	m.files[p] = nil
+	return nil
+}
+
+func (m *memFS) Remove(p string) error {
+	if _, ok := m.files[p]; !ok {
+		return os.ErrNotExist
+	}
+	delete(m.files, p)
+	return nil
+}
+
+func (m *memFS) Rename(oldPath, newPath string) error {
+	data, ok := m.files[oldPath]
+	if !ok {
+		return os.ErrNotExist
+	}
+	delete(m.files, oldPath)
+	m.files[newPath] = data
+	return nil
+}
+
+func (m *memFS) Stat(p string) (os.FileInfo, error) {
+	data, ok := m.files[p]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return memInfo{name: path.Base(p), data: data}, nil
+}
+
+func (m *memFS) Free() (int64, error) {
+	return 1 << 20, nil
+}
+
+type memFile struct {
+	fs   *memFS
+	name string
+	data *[]byte
+	off  int
+}
+
+func (f *memFile) Read(b []byte) (int, error) {
+	if f.data == nil {
+		return 0, errors.New("is a directory")
+	}
+	if f.off >= len(*f.data) {
+		return 0, io.EOF
+	}
+	n := copy(b, (*f.data)[f.off:])
+	f.off += n
+	return n, nil
+}
+
+func (f *memFile) Write(b []byte) (int, error) {
+	*f.data = append(*f.data, b...)
+	return len(b), nil
+}
+
+func (f *memFile) Close() error                       { return nil }
+func (f *memFile) Seek(int64, int) (int64, error)     { return 0, errors.New("not supported") }
+func (f *memFile) IsDir() bool                        { return f.data == nil }
+func (f *memFile) Stat() (os.FileInfo, error)         { return f.fs.Stat(f.name) }
+func (f *memFile) Readdir(int) ([]os.FileInfo, error) { return f.fs.readdir(f.name), nil }
+
+func (m *memFS) readdir(dir string) []os.FileInfo {
+	var infos []os.FileInfo
+	for p, data := range m.files {
+		if p != "/" && path.Dir(p) == dir {
+			infos = append(infos, memInfo{name: path.Base(p), data: data})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos
+}
+
+type memInfo struct {
+	name string
+	data *[]byte
+}
+
+func (i memInfo) Name() string { return i.name }
+func (i memInfo) Size() int64 {
+	if i.data == nil {
+		return 0
+	}
+	return int64(len(*i.data))
+}
+func (i memInfo) Mode() os.FileMode  { return 0o666 }
+func (i memInfo) ModTime() time.Time { return time.Time{} }
+func (i memInfo) IsDir() bool        { return i.data == nil }
+func (i memInfo) Sys() any           { return nil }
+
+// lines is a LineReader returning each line in turn, then io.EOF.
+type lines []string
+
+func (l *lines) ReadLine(string) (string, error) {
+	if len(*l) == 0 {
+		return "", io.EOF
+	}
+	line := (*l)[0]
+	*l = (*l)[1:]
+	return line, nil
+}
+
+func run(t *testing.T, sh *Shell, script ...string) string {
+	t.Helper()
+	out := sh.Stdout.(*bytes.Buffer)
+	out.Reset()
+	in := lines(script)
+	if err := sh.Run(&in); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestFileCommands(t *testing.T) {
+	fs := newMemFS()
+	sh := New(fs, &bytes.Buffer{})
+
+	got := run(t, sh,
+		`mkdir notes`,
+		`cd notes`,
+		`echo "hello  world" > a.txt`,
+		`echo again >> a.txt`,
+		`cp a.txt b.txt`,
+		`mv b.txt /`,
+		`cat a.txt /b.txt`,
+		`pwd`,
+		`ls`,
+		`rm a.txt`,
+		`cd ..`,
+		`ls /`,
+		`df`,
+	)
+	want := "hello  world\nagain\nhello  world\nagain\n" +
+		"/notes\n" +
+		"      19 a.txt\n" +
+		"      19 b.txt\n" +
+		"         notes/\n" +
+		"1048576 bytes free\n"
+	if got != want {
+		t.Fatalf("output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestErrorsAndRegistry(t *testing.T) {
+	sh := New(newMemFS(), &bytes.Buffer{})
+	sh.Register("greet", &Command{
+		Usage: "greet NAME",
+		Run: func(sh *Shell, out io.Writer, args []string) error {
+			if len(args) != 1 {
+				return ErrUsage
+			}
+			_, err := io.WriteString(out, "hi "+args[0]+"\n")
+			return err
+		},
+	})
+
+	got := run(t, sh,
+		`greet 'a > b'`,
+		`greet`,
+		`frob`,
+		`cat missing`,
+		`echo keep > f`,
+		`cp f ./f`,
+		`cp f .`,
+		`cat f`,
+		`exit`,
+		`echo unreachable`,
+	)
+	for _, want := range []string{
+		"hi a > b\n",
+		"usage: greet NAME\n",
+		"frob: command not found\n",
+		"cat: " + os.ErrNotExist.Error(),
+		"cp: f and ./f are the same file\n",
+		"cp: f and . are the same file\n",
+		"keep\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output %q lacks %q", got, want)
+		}
+	}
+	if strings.Contains(got, "unreachable") {
+		t.Errorf("exit didn't stop the shell: %q", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		line     string
+		words    []string
+		redirect string
+		appendTo bool
+	}{
+		{`ls -l`, []string{"ls", "-l"}, "", false},
+		{`echo "a b" c>out`, []string{"echo", "a b", "c"}, "out", false},
+		{`echo '>' \> >> "my log"`, []string{"echo", ">", ">"}, "my log", true},
+	} {
+		words, redirect, appendTo, err := parse(tt.line)
+		if err != nil {
+			t.Errorf("parse(%q): %v", tt.line, err)
+			continue
+		}
+		if strings.Join(words, "|") != strings.Join(tt.words, "|") || redirect != tt.redirect || appendTo != tt.appendTo {
+			t.Errorf("parse(%q) = %q, %q, %v; want %q, %q, %v",
+				tt.line, words, redirect, appendTo, tt.words, tt.redirect, tt.appendTo)
+		}
+	}
+	if _, _, _, err := parse(`echo > a b`); err == nil {
+		t.Errorf("parse accepted two redirection targets")
+	}
+}
@@ -0,0 +1,176 @@
+//go:build esp32 || esp32s3
+
+package cardputer
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sparques/cardputer/mixer"
+	"github.com/sparques/cardputer/tone"
+)
+
+// DefaultMixerLatency is how far ahead of playback Mixer mixes unless
+// SetLatency says otherwise.
+const DefaultMixerLatency = 20 * time.Millisecond
+
+// Mixer plays any number of voices on Speaker at once, so UI sounds, music
+// and game effects can overlap. It mixes on its own goroutine, which starts
+// with the first voice and idles while there are none. While voices play it
+// owns Speaker: play through Mixer rather than writing to Speaker directly.
+// Speaker's Tone, PlayMelody and beeps, PlayRTTTL, PlayMIDI and WAVPlayer all
+// play through it.
+// Sources given to Add must be at Speaker's sample rate; use AddAt for others.
+//
+//	dec, _ := wav.NewDecoder(f)
+//	v, err := cardputer.Mixer.AddAt(dec, dec.SampleRate, 0)
+var Mixer = &speakerMixer{latency: DefaultMixerLatency}
+
+type speakerMixer struct {
+	mix     mixer.Mixer
+	mu      sync.Mutex
+	latency time.Duration
+	running bool
+	err     error
+	wake    chan struct{}
+	buf     []int16
+	// out is held while a block is written to Speaker and while AddAt
+	// changes Speaker's rate, so the rate never changes mid-block.
+	out sync.Mutex
+}
+
+// SetLatency sets how much audio is mixed ahead of playback. Shorter reacts
+// to new voices sooner; longer rides out a busy CPU. It takes effect from the
+// next block.
+func (m *speakerMixer) SetLatency(d time.Duration) {
+	m.mu.Lock()
+	m.latency = max(d, time.Millisecond)
+	m.mu.Unlock()
+}
+
+// SetMaxVoices limits how many voices play at once; 0 means no limit.
+func (m *speakerMixer) SetMaxVoices(n int) {
+	m.mix.SetMaxVoices(n)
+}
+
+// SampleRate returns the rate sources have to supply samples at.
+func (m *speakerMixer) SampleRate() uint32 {
+	return Speaker.sampleRate()
+}
+
+// Voices returns how many voices are playing.
+func (m *speakerMixer) Voices() int {
+	return m.mix.Voices()
+}
+
+// Add starts src playing; see mixer.Mixer.Add for how priority decides which
+// voice gives way when they are all taken.
+func (m *speakerMixer) Add(src mixer.Source, priority int) (*mixer.Voice, error) {
+	if err := Speaker.Init(); err != nil {
+		return nil, err
+	}
+	m.out.Lock()
+	v, err := m.mix.Add(src, priority)
+	m.out.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	m.start()
+	return v, nil
+}
+
+// AddAt is like Add for a source at rate. With no other voice playing it
+// sets Speaker to rate with SetSampleRate, so src plays as it is; otherwise
+// src is resampled to Speaker's current rate.
+func (m *speakerMixer) AddAt(src mixer.Source, rate uint32, priority int) (*mixer.Voice, error) {
+	if err := Speaker.Init(); err != nil {
+		return nil, err
+	}
+	// out stays held until src is added, so no other AddAt can change the
+	// rate src is resampled to
+	m.out.Lock()
+	if m.mix.Voices() == 0 && rate != Speaker.sampleRate() {
+		if err := Speaker.SetSampleRate(rate); err != nil {
+			m.out.Unlock()
+			return nil, err
+		}
+	}
+	v, err := m.mix.Add(mixer.Resampled(src, rate, Speaker.sampleRate()), priority)
+	m.out.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	m.start()
+	return v, nil
+}
+
+// start wakes the mixing goroutine, starting it if needed.
+func (m *speakerMixer) start() {
+	m.mu.Lock()
+	if !m.running {
+		m.running, m.err = true, nil
+		m.wake = make(chan struct{}, 1)
+		go m.run(m.wake)
+	}
+	wake := m.wake
+	m.mu.Unlock()
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// Tone adds a voice playing freq hertz for duration at volume percent, with
+// Speaker's waveform and envelope.
+func (m *speakerMixer) Tone(freq float64, duration time.Duration, volume uint8, priority int) (*mixer.Voice, error) {
+	g := Speaker.generator()
+	g.Start(tone.Note{Freq: freq, Duration: duration, Volume: volume})
+	return m.Add(mixer.SourceFunc(func(dst []int16) (int, error) {
+		if n := g.Read(dst); n > 0 {
+			return n, nil
+		}
+		return 0, io.EOF
+	}), priority)
+}
+
+// Err returns the Speaker error that last stopped the mixing goroutine.
+func (m *speakerMixer) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// run mixes a block at a time, sleeping on wake while there are no voices.
+func (m *speakerMixer) run(wake chan struct{}) {
+	for {
+		if m.mix.Voices() == 0 {
+			<-wake
+			continue
+		}
+		m.mu.Lock()
+		frames := int(int64(m.latency) * int64(Speaker.sampleRate()) / int64(time.Second))
+		frames = max(frames, 1)
+		if cap(m.buf) < frames {
+			m.buf = make([]int16, frames)
+		}
+		buf := m.buf[:frames]
+		m.mu.Unlock()
+
+		m.mix.Read(buf)
+		m.out.Lock()
+		_, err := Speaker.Write(buf)
+		m.out.Unlock()
+		if err != nil {
+			kmsg("mixer: ", err, "\n")
+			// nothing will read the voices now, so end them for anyone
+			// waiting on Done; under mu, so a voice Add makes afterwards
+			// starts a new goroutine
+			m.mu.Lock()
+			m.mix.StopAll(err)
+			m.running, m.err = false, err
+			m.mu.Unlock()
+			return
+		}
+	}
+}
@@ -0,0 +1,174 @@
+//go:build esp32 || esp32s3
+
+package cardputer
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sparques/cardputer/tone"
+)
+
+// MelodyQueueSize is how many melodies PlayMelody holds waiting to play.
+const MelodyQueueSize = 8
+
+var errMelodyQueueFull = errors.New("melody queue is full")
+
+// beepVolume is the volume of Beep, AckBeep and NakBeep.
+const beepVolume = 60
+
+// speakerTone is the tone state both boards' speakers share.
+type speakerTone struct {
+	waveform        tone.Waveform
+	attack, release time.Duration
+
+	// melodies waits behind the melody playing on Mixer, if melodyOn.
+	melodyMu sync.Mutex
+	melodies [][]tone.Note
+	melodyOn bool
+}
+
+// SetWaveform picks the waveform for Tone and PlayMelody. The default is
+// tone.Sine.
+func (spk *speaker) SetWaveform(w tone.Waveform) {
+	spk.waveform = w
+}
+
+// SetEnvelope sets the attack and release ramps for Tone and PlayMelody. Zero
+// for both restores tone.DefaultAttack and tone.DefaultRelease.
+func (spk *speaker) SetEnvelope(attack, release time.Duration) {
+	spk.attack, spk.release = attack, release
+}
+
+// Tone plays freq hertz for duration at volume percent of full scale on
+// Mixer and returns once the last sample has been mixed.
+func (spk *speaker) Tone(freq float64, duration time.Duration, volume uint8) error {
+	v, err := Mixer.Tone(freq, duration, volume, 0)
+	if err != nil {
+		return err
+	}
+	<-v.Done()
+	return v.Err()
+}
+
+// generator returns a tone generator with the speaker's rate, waveform and
+// envelope.
+func (spk *speaker) generator() tone.Generator {
+	g := tone.Generator{
+		SampleRate: spk.sampleRate(),
+		Waveform:   spk.waveform,
+		Attack:     spk.attack,
+		Release:    spk.release,
+	}
+	if g.Attack == 0 && g.Release == 0 {
+		g.Attack, g.Release = tone.DefaultAttack, tone.DefaultRelease
+	}
+	return g
+}
+
+// PlayMelody queues notes to play on Mixer in the background and returns at
+// once. Melodies play one after another in the order they were queued, over
+// whatever else Mixer is playing; when MelodyQueueSize are already waiting
+// the new one is refused.
+func (spk *speaker) PlayMelody(notes ...tone.Note) error {
+	spk.melodyMu.Lock()
+	if spk.melodyOn {
+		defer spk.melodyMu.Unlock()
+		if len(spk.melodies) >= MelodyQueueSize {
+			return errMelodyQueueFull
+		}
+		spk.melodies = append(spk.melodies, notes)
+		return nil
+	}
+	spk.melodyOn = true
+	spk.melodyMu.Unlock()
+
+	// Mixer reads the voice under its own lock and the voice then takes
+	// melodyMu, so Add mustn't be called holding melodyMu.
+	m := &melody{g: spk.generator(), notes: notes, next: spk.nextMelody}
+	if _, err := Mixer.Add(m, 0); err != nil {
+		spk.melodyMu.Lock()
+		spk.melodyOn = false
+		clear(spk.melodies)
+		spk.melodies = spk.melodies[:0]
+		spk.melodyMu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// nextMelody hands the melody voice the next queued melody, or ends it.
+func (spk *speaker) nextMelody() ([]tone.Note, bool) {
+	spk.melodyMu.Lock()
+	defer spk.melodyMu.Unlock()
+	if len(spk.melodies) == 0 {
+		spk.melodyOn = false
+		return nil, false
+	}
+	notes := spk.melodies[0]
+	spk.melodies[0] = nil
+	spk.melodies = spk.melodies[1:]
+	return notes, true
+}
+
+// playNotes plays notes on a Mixer voice of their own, so they sound at once
+// over anything else playing.
+func (spk *speaker) playNotes(notes ...tone.Note) {
+	if _, err := Mixer.Add(&melody{g: spk.generator(), notes: notes}, 0); err != nil {
+		kmsg("beep: ", err, "\n")
+	}
+}
+
+// melody is a mixer.Source playing notes one after another, then whatever
+// next supplies.
+type melody struct {
+	g     tone.Generator
+	notes []tone.Note
+	next  func() ([]tone.Note, bool)
+}
+
+func (m *melody) Read(dst []int16) (int, error) {
+	n := 0
+	for n < len(dst) {
+		if k := m.g.Read(dst[n:]); k > 0 {
+			n += k
+			continue
+		}
+		if len(m.notes) == 0 {
+			var ok bool
+			if m.next != nil {
+				m.notes, ok = m.next()
+			}
+			if !ok {
+				return n, io.EOF
+			}
+			continue
+		}
+		m.g.Start(m.notes[0])
+		m.notes = m.notes[1:]
+	}
+	return n, nil
+}
+
+// Beep plays a short A5 in the background.
+func (spk *speaker) Beep() {
+	spk.playNotes(tone.Note{Freq: 880, Duration: 100 * time.Millisecond, Volume: beepVolume})
+}
+
+// AckBeep plays a rising C6-G6 in the background, for confirmations.
+func (spk *speaker) AckBeep() {
+	spk.playNotes(
+		tone.Note{Freq: 1047, Duration: 60 * time.Millisecond, Volume: beepVolume},
+		tone.Note{Freq: 1568, Duration: 90 * time.Millisecond, Volume: beepVolume},
+	)
+}
+
+// NakBeep plays a falling E5-A4 in the background, for refusals and errors.
+func (spk *speaker) NakBeep() {
+	spk.playNotes(
+		tone.Note{Freq: 659, Duration: 120 * time.Millisecond, Volume: beepVolume},
+		tone.Note{Freq: 440, Duration: 200 * time.Millisecond, Volume: beepVolume},
+	)
+}
@@ -0,0 +1,284 @@
+// synth is a small polyphonic synthesiser for MIDI playback. Each voice is
+// two sine operators, a carrier phase-modulated by a modulator, shaped by an
+// ADSR envelope; the General MIDI program families each get a patch, and the
+// percussion channel plays noise and thumps. It renders into a buffer, so it
+// knows nothing about the hardware and is tested on the host.
+package synth // import "github.com/sparques/cardputer/synth"
+
+import (
+	"math"
+	"time"
+
+	"github.com/sparques/cardputer/tone"
+)
+
+// Polyphony is how many notes sound at once. A note beyond that takes over
+// the quietest released voice, or failing that the oldest.
+const Polyphony = 8
+
+// PercussionChannel is the General MIDI drum channel, 10 counting from one.
+const PercussionChannel = 9
+
+// Patch is the sound of a voice.
+type Patch struct {
+	// Ratio is the modulator's frequency as a multiple of the carrier's and
+	// Index its peak depth in radians. Index 0 is a plain sine. The depth
+	// follows the envelope, so notes mellow as they fade.
+	Ratio, Index float64
+	// Attack, Decay and Release are the envelope times, and Sustain the
+	// level held after the decay as a percentage of the peak.
+	Attack, Decay, Release time.Duration
+	Sustain                uint8
+	// Noise plays white noise instead of the operators.
+	Noise bool
+}
+
+// Patches holds a patch for each General MIDI program family of eight:
+// pianos, chromatic percussion, organs and so on.
+var Patches = [16]Patch{
+	{Ratio: 1, Index: 1.5, Attack: 2 * time.Millisecond, Decay: 800 * time.Millisecond, Sustain: 20, Release: 200 * time.Millisecond},
+	{Ratio: 3.5, Index: 2, Attack: time.Millisecond, Decay: 400 * time.Millisecond, Sustain: 0, Release: 300 * time.Millisecond},
+	{Ratio: 1, Index: 0, Attack: 5 * time.Millisecond, Decay: 0, Sustain: 100, Release: 50 * time.Millisecond},
+	{Ratio: 2, Index: 1.2, Attack: 2 * time.Millisecond, Decay: 600 * time.Millisecond, Sustain: 10, Release: 150 * time.Millisecond},
+	{Ratio: 1, Index: 1, Attack: 3 * time.Millisecond, Decay: 300 * time.Millisecond, Sustain: 50, Release: 80 * time.Millisecond},
+	{Ratio: 1, Index: 0.8, Attack: 80 * time.Millisecond, Decay: 200 * time.Millisecond, Sustain: 80, Release: 300 * time.Millisecond},
+	{Ratio: 1, Index: 0.6, Attack: 100 * time.Millisecond, Decay: 200 * time.Millisecond, Sustain: 80, Release: 400 * time.Millisecond},
+	{Ratio: 1, Index: 2.5, Attack: 30 * time.Millisecond, Decay: 100 * time.Millisecond, Sustain: 70, Release: 100 * time.Millisecond},
+	{Ratio: 3, Index: 1.5, Attack: 20 * time.Millisecond, Decay: 100 * time.Millisecond, Sustain: 70, Release: 80 * time.Millisecond},
+	{Ratio: 1, Index: 0.3, Attack: 40 * time.Millisecond, Decay: 100 * time.Millisecond, Sustain: 80, Release: 150 * time.Millisecond},
+	{Ratio: 1, Index: 3, Attack: 5 * time.Millisecond, Decay: 100 * time.Millisecond, Sustain: 80, Release: 100 * time.Millisecond},
+	{Ratio: 0.5, Index: 1, Attack: 300 * time.Millisecond, Decay: 300 * time.Millisecond, Sustain: 80, Release: 600 * time.Millisecond},
+	{Ratio: 1.41, Index: 2, Attack: 100 * time.Millisecond, Decay: 500 * time.Millisecond, Sustain: 60, Release: 500 * time.Millisecond},
+	{Ratio: 3, Index: 1, Attack: 2 * time.Millisecond, Decay: 500 * time.Millisecond, Sustain: 0, Release: 200 * time.Millisecond},
+	{Ratio: 1.4, Index: 3, Attack: time.Millisecond, Decay: 200 * time.Millisecond, Sustain: 0, Release: 100 * time.Millisecond},
+	{Noise: true, Attack: 10 * time.Millisecond, Decay: 300 * time.Millisecond, Sustain: 40, Release: 200 * time.Millisecond},
+}
+
+// Drums are the percussion channel's patches: Kick for the bass drums,
+// Snare for everything else.
+var (
+	Kick  = Patch{Ratio: 1, Index: 0, Attack: time.Millisecond, Decay: 150 * time.Millisecond, Sustain: 0, Release: 50 * time.Millisecond}
+	Snare = Patch{Noise: true, Attack: time.Millisecond, Decay: 120 * time.Millisecond, Sustain: 0, Release: 50 * time.Millisecond}
+)
+
+type stage uint8
+
+const (
+	off stage = iota
+	attack
+	decay
+	sustain
+	release
+)
+
+type voice struct {
+	ch, key uint8
+	stage   stage
+	started uint32
+	// env is the envelope level in Q16, moving by the rate for its stage
+	// each sample.
+	env, level                 int32
+	attackRate, decayRate, rel int32
+	phase, step, mphase, mstep uint32
+	index                      int64
+	amp                        int32
+	noise                      bool
+	lfsr                       uint32
+}
+
+// Synth renders MIDI channel messages as audio.
+type Synth struct {
+	SampleRate uint32
+
+	voices  [Polyphony]voice
+	program [16]uint8
+	volume  [16]uint8
+	notes   uint32
+}
+
+// New returns a Synth rendering at rate hertz, every channel on program 0
+// at volume 100.
+func New(rate uint32) *Synth {
+	s := &Synth{SampleRate: rate}
+	s.Reset()
+	return s
+}
+
+// Reset silences every voice and restores the channels' programs and
+// volumes.
+func (s *Synth) Reset() {
+	for i := range s.voices {
+		s.voices[i].stage = off
+	}
+	clear(s.program[:])
+	for i := range s.volume {
+		s.volume[i] = 100
+	}
+}
+
+// NoteOn starts key on channel ch. Velocity 0 stops it instead.
+func (s *Synth) NoteOn(ch, key, velocity uint8) {
+	ch, key, velocity = ch&15, key&127, velocity&127
+	if velocity == 0 {
+		s.NoteOff(ch, key)
+		return
+	}
+	v := s.allocate()
+	p := Patches[s.program[ch]/8]
+	freq := 440 * math.Pow(2, float64(int(key)-69)/12)
+	if ch == PercussionChannel {
+		p = Snare
+		if key == 35 || key == 36 {
+			p, freq = Kick, 55
+		}
+	}
+
+	s.notes++
+	*v = voice{
+		ch:         ch,
+		key:        key,
+		stage:      attack,
+		started:    s.notes,
+		level:      int32(p.Sustain) << 16 / 100,
+		attackRate: s.rate(1<<16, p.Attack),
+		rel:        s.rate(1<<16, p.Release),
+		noise:      p.Noise,
+		lfsr:       0xACE1,
+		amp:        int32(velocity) * int32(s.volume[ch]) << 16 / (127 * 127),
+	}
+	v.decayRate = s.rate(1<<16-v.level, p.Decay)
+	v.step = s.step(freq)
+	v.mstep = s.step(freq * p.Ratio)
+	// A full-scale modulator moves the carrier's phase by Index radians.
+	v.index = int64(p.Index * (1 << 32) / (2 * math.Pi * (1 << 15)))
+}
+
+// step returns the phase increment per sample for freq hertz, a full turn
+// being 1<<32.
+func (s *Synth) step(freq float64) uint32 {
+	freq = min(freq, float64(s.SampleRate)/2)
+	return uint32(freq * (1 << 32) / float64(s.SampleRate))
+}
+
+// rate returns how much the envelope moves each sample to cover span in d.
+func (s *Synth) rate(span int32, d time.Duration) int32 {
+	n := int64(d) * int64(s.SampleRate) / int64(time.Second)
+	return int32(max(int64(span)/max(n, 1), 1))
+}
+
+// allocate returns a free voice, or else the quietest released one, or else
+// the oldest.
+func (s *Synth) allocate() *voice {
+	var best *voice
+	for i := range s.voices {
+		v := &s.voices[i]
+		switch {
+		case v.stage == off:
+			return v
+		case best == nil:
+			best = v
+		case v.stage == release && (best.stage != release || v.env < best.env):
+			best = v
+		case v.stage != release && best.stage != release && v.started < best.started:
+			best = v
+		}
+	}
+	return best
+}
+
+// NoteOff releases key on channel ch.
+func (s *Synth) NoteOff(ch, key uint8) {
+	for i := range s.voices {
+		v := &s.voices[i]
+		if v.stage != off && v.stage != release && v.ch == ch&15 && v.key == key&127 {
+			v.stage = release
+		}
+	}
+}
+
+// ProgramChange picks the instrument for channel ch.
+func (s *Synth) ProgramChange(ch, program uint8) {
+	s.program[ch&15] = program & 127
+}
+
+// ControlChange handles channel volume (controller 7) and all sound or notes
+// off (120 and 123) on channel ch. Other controllers are ignored.
+func (s *Synth) ControlChange(ch, controller, value uint8) {
+	ch &= 15
+	switch controller {
+	case 7:
+		s.volume[ch] = value & 127
+	case 120, 123:
+		for i := range s.voices {
+			if v := &s.voices[i]; v.ch == ch && v.stage != off {
+				v.stage = release
+				if controller == 120 {
+					v.stage = off
+				}
+			}
+		}
+	}
+}
+
+// Active returns how many voices are sounding, releasing ones included.
+func (s *Synth) Active() int {
+	n := 0
+	for i := range s.voices {
+		if s.voices[i].stage != off {
+			n++
+		}
+	}
+	return n
+}
+
+// Read renders len(dst) samples and returns len(dst).
+func (s *Synth) Read(dst []int16) int {
+	for i := range dst {
+		var sum int32
+		for j := range s.voices {
+			if v := &s.voices[j]; v.stage != off {
+				sum += v.next()
+			}
+		}
+		// Four loud notes reach full scale; more clip.
+		sum >>= 2
+		dst[i] = int16(min(max(sum, math.MinInt16), math.MaxInt16))
+	}
+	return len(dst)
+}
+
+// next returns the voice's next sample and advances it.
+func (v *voice) next() int32 {
+	switch v.stage {
+	case attack:
+		v.env += v.attackRate
+		if v.env >= 1<<16 {
+			v.env, v.stage = 1<<16, decay
+		}
+	case decay:
+		v.env -= v.decayRate
+		if v.env <= v.level {
+			v.env, v.stage = v.level, sustain
+		}
+	case release:
+		v.env -= v.rel
+	}
+	if v.env <= 0 && v.stage != attack {
+		v.env, v.stage = 0, off
+		return 0
+	}
+
+	var s int32
+	if v.noise {
+		v.lfsr ^= v.lfsr << 13
+		v.lfsr ^= v.lfsr >> 17
+		v.lfsr ^= v.lfsr << 5
+		s = int32(int16(v.lfsr))
+	} else {
+		mod := int64(tone.SineAt(v.mphase)) * v.index * int64(v.env) >> 16
+		s = int32(tone.SineAt(v.phase + uint32(mod)))
+		v.phase += v.step
+		v.mphase += v.mstep
+	}
+	return int32(int64(s) * int64(v.env) * int64(v.amp) >> 32)
+}
@@ -0,0 +1,119 @@
+package synth
+
+import (
+	"testing"
+	"time"
+)
+
+func render(s *Synth, d time.Duration) []int16 {
+	buf := make([]int16, int64(d)*int64(s.SampleRate)/int64(time.Second))
+	s.Read(buf)
+	return buf
+}
+
+func peak(buf []int16) int {
+	p := 0
+	for _, v := range buf {
+		p = max(p, int(v), -int(v))
+	}
+	return p
+}
+
+func TestNote(t *testing.T) {
+	s := New(16000)
+	// Organs are a plain sine at full sustain, so the pitch can be read off
+	// the zero crossings.
+	s.ProgramChange(0, 16)
+	s.NoteOn(0, 69, 127)
+	buf := render(s, time.Second)
+	crossings := 0
+	for i := 1; i < len(buf); i++ {
+		if (buf[i-1] < 0) != (buf[i] < 0) {
+			crossings++
+		}
+	}
+	if crossings < 876 || crossings > 884 {
+		t.Errorf("%d zero crossings in a second of A4, want 880", crossings)
+	}
+	// A quarter of full scale at full velocity, scaled by the default
+	// channel volume of 100.
+	if p, want := peak(buf[8000:]), 32767/4*100/127; p < want-50 || p > want+50 {
+		t.Errorf("peak %d, want %d", p, want)
+	}
+
+	s.NoteOff(0, 69)
+	render(s, 60*time.Millisecond)
+	if s.Active() != 0 || peak(render(s, 10*time.Millisecond)) != 0 {
+		t.Errorf("%d voices still active after release", s.Active())
+	}
+}
+
+func TestDecayToSilence(t *testing.T) {
+	s := New(8000)
+	// Chromatic percussion has no sustain, so a note ends by itself.
+	s.ProgramChange(3, 8)
+	s.NoteOn(3, 72, 100)
+	if peak(render(s, 100*time.Millisecond)) == 0 {
+		t.Fatal("note is silent")
+	}
+	render(s, 500*time.Millisecond)
+	if s.Active() != 0 {
+		t.Errorf("note still sounding after its decay")
+	}
+}
+
+func TestVelocityAndVolume(t *testing.T) {
+	s := New(8000)
+	s.ProgramChange(0, 16)
+	s.NoteOn(0, 60, 127)
+	loud := peak(render(s, 100*time.Millisecond)[400:])
+	s.Reset()
+	s.ProgramChange(0, 16)
+	s.NoteOn(0, 60, 64)
+	soft := peak(render(s, 100*time.Millisecond)[400:])
+	if d := soft*2 - loud; d < -200 || d > 200 {
+		t.Errorf("half velocity peaks at %d against %d", soft, loud)
+	}
+
+	s.ControlChange(0, 7, 0)
+	s.NoteOn(0, 62, 127)
+	s.NoteOff(0, 60)
+	render(s, 100*time.Millisecond)
+	if p := peak(render(s, 10*time.Millisecond)); p != 0 {
+		t.Errorf("channel at volume 0 peaks at %d", p)
+	}
+	s.NoteOn(0, 64, 0)
+}
+
+func TestPolyphony(t *testing.T) {
+	s := New(8000)
+	for key := uint8(60); key < 60+Polyphony+2; key++ {
+		s.NoteOn(1, key, 100)
+	}
+	if s.Active() != Polyphony {
+		t.Errorf("Active = %d, want %d", s.Active(), Polyphony)
+	}
+	// The two oldest notes were taken over.
+	for _, v := range s.voices {
+		if v.key < 62 {
+			t.Errorf("key %d still playing", v.key)
+		}
+	}
+	s.ControlChange(1, 120, 0)
+	if s.Active() != 0 {
+		t.Errorf("Active = %d after all sound off", s.Active())
+	}
+}
+
+func TestDrums(t *testing.T) {
+	s := New(8000)
+	s.NoteOn(PercussionChannel, 36, 127)
+	s.NoteOn(PercussionChannel, 38, 127)
+	if peak(render(s, 50*time.Millisecond)) == 0 {
+		t.Fatal("drums are silent")
+	}
+	render(s, 300*time.Millisecond)
+	if s.Active() != 0 {
+		t.Errorf("drums still sounding")
+	}
+}
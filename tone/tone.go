@@ -0,0 +1,165 @@
+// tone synthesises simple notes as 16-bit PCM: sine, square, triangle and
+// sawtooth waves shaped by a linear attack/release envelope so they start and
+// stop without clicks. It knows nothing about the hardware; cardputer.Speaker
+// plays what it generates.
+package tone // import "github.com/sparques/cardputer/tone"
+
+import (
+	"math"
+	"time"
+)
+
+// Waveform selects the shape of a tone.
+type Waveform uint8
+
+const (
+	Sine Waveform = iota
+	Square
+	Triangle
+	Saw
+)
+
+// Default envelope times, short enough not to soften a beep.
+const (
+	DefaultAttack  = 5 * time.Millisecond
+	DefaultRelease = 20 * time.Millisecond
+)
+
+// Note is one tone of a melody.
+type Note struct {
+	// Freq is the pitch in hertz, limited to half the sample rate. 0 is a
+	// rest.
+	Freq float64
+	// Duration is how long the note lasts, envelope included.
+	Duration time.Duration
+	// Volume is the peak amplitude as a percentage of full scale.
+	Volume uint8
+}
+
+// Generator renders notes at a sample rate. The zero value isn't usable; set
+// SampleRate first.
+type Generator struct {
+	SampleRate uint32
+	Waveform   Waveform
+	// Attack and Release are the envelope ramp times. They are shortened in
+	// proportion when a note is too short for both.
+	Attack, Release time.Duration
+
+	amp                  int32
+	pos, total           int
+	attackEnd, releaseAt int
+	phase, step          uint32
+	rest                 bool
+}
+
+// Start begins rendering n, replacing any note in progress.
+func (g *Generator) Start(n Note) {
+	g.total = int(uint64(n.Duration) * uint64(g.SampleRate) / uint64(time.Second))
+	g.pos, g.phase = 0, 0
+	g.rest = n.Freq <= 0 || n.Volume == 0
+	vol := int32(n.Volume)
+	if vol > 100 {
+		vol = 100
+	}
+	g.amp = math.MaxInt16 * vol / 100
+	if !g.rest {
+		freq := min(n.Freq, float64(g.SampleRate)/2)
+		g.step = uint32(freq * (1 << 32) / float64(g.SampleRate))
+	}
+
+	attack := g.samples(g.Attack)
+	release := g.samples(g.Release)
+	if attack+release > g.total {
+		attack = attack * g.total / (attack + release)
+		release = g.total - attack
+	}
+	g.attackEnd, g.releaseAt = attack, g.total-release
+}
+
+func (g *Generator) samples(d time.Duration) int {
+	return int(uint64(d) * uint64(g.SampleRate) / uint64(time.Second))
+}
+
+// Len returns how many samples the current note has left.
+func (g *Generator) Len() int {
+	return g.total - g.pos
+}
+
+// Read fills dst with the next samples of the note and returns how many it
+// wrote, which is 0 once the note is over.
+func (g *Generator) Read(dst []int16) int {
+	n := min(len(dst), g.Len())
+	for i := range dst[:n] {
+		if g.rest {
+			dst[i] = 0
+		} else {
+			dst[i] = int16(int32(g.wave()) * g.envelope() >> 15)
+			g.phase += g.step
+		}
+		g.pos++
+	}
+	return n
+}
+
+// envelope returns the current amplitude in Q15.
+func (g *Generator) envelope() int32 {
+	switch {
+	case g.pos < g.attackEnd:
+		return int32(int64(g.amp) * int64(g.pos) / int64(g.attackEnd))
+	case g.pos >= g.releaseAt:
+		return int32(int64(g.amp) * int64(g.total-g.pos) / int64(g.total-g.releaseAt))
+	}
+	return g.amp
+}
+
+// wave returns the waveform at the current phase at full scale.
+func (g *Generator) wave() int16 {
+	switch g.Waveform {
+	case Square:
+		if g.phase < 1<<31 {
+			return math.MaxInt16
+		}
+		return -math.MaxInt16
+	case Triangle:
+		// Fall from the peak over the first half period and climb back over
+		// the second.
+		v := int32(g.phase>>15) - 1<<16
+		if v < 0 {
+			v = -v
+		}
+		return int16(min(v, 1<<16-1) - 1<<15)
+	case Saw:
+		return int16(int32(g.phase>>16) - 1<<15)
+	}
+	return SineAt(g.phase)
+}
+
+// sineTable holds a quarter of a sine wave, sineSteps+1 points.
+var sineTable = func() (t [sineSteps + 1]int16) {
+	for i := range t {
+		t[i] = int16(math.Round(math.MaxInt16 * math.Sin(float64(i)*math.Pi/2/sineSteps)))
+	}
+	return t
+}()
+
+const sineSteps = 256
+
+// SineAt returns the sine of phase at full scale, a full turn being 1<<32,
+// by linear interpolation in a quarter-wave table.
+func SineAt(phase uint32) int16 {
+	quadrant := phase >> 30
+	x := phase & (1<<30 - 1)
+	if quadrant&1 != 0 {
+		x = 1<<30 - x
+	}
+	i := x >> 22 // x * sineSteps >> 30
+	frac := int32(x>>6) & 0xFFFF
+	v := int32(sineTable[i])
+	if i < sineSteps {
+		v += (int32(sineTable[i+1]) - v) * frac >> 16
+	}
+	if quadrant >= 2 {
+		v = -v
+	}
+	return int16(v)
+}
@@ -0,0 +1,84 @@
+package tone
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func render(g *Generator, n Note) []int16 {
+	g.Start(n)
+	out := make([]int16, 0, g.Len())
+	buf := make([]int16, 100)
+	for {
+		m := g.Read(buf)
+		if m == 0 {
+			return out
+		}
+		out = append(out, buf[:m]...)
+	}
+}
+
+func TestWaveforms(t *testing.T) {
+	// 1kHz at 8kHz is eight samples a period; with no envelope the shapes
+	// can be checked sample by sample.
+	want := map[Waveform][]int16{
+		Sine:     {0, 23170, 32767, 23170, 0, -23170, -32767, -23170},
+		Square:   {32767, 32767, 32767, 32767, -32767, -32767, -32767, -32767},
+		Triangle: {32767, 16383, 0, -16384, -32768, -16384, 0, 16383},
+		Saw:      {-32768, -24576, -16384, -8192, 0, 8192, 16384, 24576},
+	}
+	for w, samples := range want {
+		g := &Generator{SampleRate: 8000, Waveform: w}
+		got := render(g, Note{Freq: 1000, Duration: time.Millisecond, Volume: 100})
+		if len(got) != 8 {
+			t.Fatalf("waveform %d: %d samples, want 8", w, len(got))
+		}
+		for i := range got {
+			if d := int(got[i]) - int(samples[i]); d < -2 || d > 2 {
+				t.Errorf("waveform %d: %v, want %v", w, got, samples)
+				break
+			}
+		}
+	}
+}
+
+func TestSineAccuracy(t *testing.T) {
+	for phase := uint64(0); phase < 1<<32; phase += 1<<32/1000 + 7 {
+		want := math.MaxInt16 * math.Sin(2*math.Pi*float64(phase)/(1<<32))
+		if got := float64(SineAt(uint32(phase))); math.Abs(got-want) > 4 {
+			t.Fatalf("SineAt(%#x) = %v, want %.0f", phase, got, want)
+		}
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	g := &Generator{SampleRate: 8000, Waveform: Square, Attack: time.Millisecond, Release: 2 * time.Millisecond}
+	got := render(g, Note{Freq: 100, Duration: 10 * time.Millisecond, Volume: 50})
+	if len(got) != 80 {
+		t.Fatalf("%d samples, want 80", len(got))
+	}
+	// The square wave is negative for the second half of the note.
+	peak := 32767 * 50 / 100
+	near := func(v int16, want int) bool { return math.Abs(float64(int(v)-want)) <= 2 }
+	if got[0] != 0 || !near(got[4], peak/2) || !near(got[20], peak) || !near(got[79], -peak/16) {
+		t.Errorf("envelope: start %d, mid-attack %d, sustain %d, end %d", got[0], got[4], got[20], got[79])
+	}
+
+	// A note shorter than the envelope keeps its proportions.
+	got = render(g, Note{Freq: 100, Duration: 3 * time.Millisecond / 2, Volume: 100})
+	if len(got) != 12 || got[0] != 0 || got[4] <= got[11] {
+		t.Errorf("short note: %v", got)
+	}
+
+	// Rests are silent but take their time.
+	got = render(g, Note{Duration: 5 * time.Millisecond, Volume: 100})
+	for _, v := range got {
+		if v != 0 {
+			t.Fatalf("rest: %v", got)
+		}
+	}
+	if len(got) != 40 {
+		t.Errorf("rest: %d samples, want 40", len(got))
+	}
+}
@@ -0,0 +1,102 @@
+//go:build (esp32 || esp32s3) && usbhid
+
+package cardputer
+
+import (
+	"sync"
+	"time"
+
+	"machine/usb/hid"
+	"machine/usb/hid/keyboard"
+
+	keyhid "github.com/sparques/cardputer/keypad/hid"
+)
+
+// usbKeyboardReportID is the report ID of the keyboard in TinyGo's HID
+// descriptor.
+const usbKeyboardReportID = 0x02
+
+// DefaultUSBReportInterval is how long USBKeyboard.Type waits between reports
+// so the host polls each one.
+const DefaultUSBReportInterval = 10 * time.Millisecond
+
+// USBKeyboard sends KP to the host as a USB HID keyboard. Call
+// USBKeyboard.Attach once KP's own callbacks are set; from then on every press
+// and release is reported to the host, with sticky modifiers applied. Type
+// sends a string, for use as a password typer or macro pad.
+//
+// USBKeyboard needs TinyGo's USB device stack on the native USB port and is
+// only built with the usbhid build tag.
+var USBKeyboard = &usbKeyboard{
+	Interval: DefaultUSBReportInterval,
+}
+
+type usbKeyboard struct {
+	// Interval is how long Type waits between reports.
+	Interval time.Duration
+	// Layout is the host's keyboard layout; nil means US.
+	Layout keyhid.Layout
+
+	mu   sync.Mutex
+	conv keyhid.Converter
+	pkt  [1 + len(keyhid.Report{})]byte
+}
+
+// Attach makes USBKeyboard follow KP.
+func (u *usbKeyboard) Attach() {
+	keyboard.Port() // registers the keyboard with the HID interface
+	press := KP.EventPressCallback
+	release := KP.EventReleaseCallback
+	KP.EventPressCallback = func(p int64) {
+		u.update()
+		if press != nil {
+			press(p)
+		}
+	}
+	KP.EventReleaseCallback = func(r int64) {
+		u.update()
+		if release != nil {
+			release(r)
+		}
+	}
+}
+
+func (u *usbKeyboard) update() {
+	// Held has the modifiers KP applied to this key, even a sticky latch
+	// the key has just used up
+	state := KP.Held()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.conv.Layout = u.Layout
+	if r, changed := u.conv.Update(state); changed {
+		u.send(r)
+	}
+}
+
+// Type types s on the host. It fails without sending anything if the layout
+// has no key for a character of s.
+func (u *usbKeyboard) Type(s string) error {
+	layout := u.Layout
+	if layout == nil {
+		layout = keyhid.US
+	}
+	reports, err := layout.Type(s)
+	if err != nil {
+		return err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, r := range reports {
+		u.send(r)
+		time.Sleep(u.Interval)
+	}
+	// restore whatever KP is holding
+	u.send(u.conv.Report())
+	return nil
+}
+
+func (u *usbKeyboard) send(r keyhid.Report) {
+	u.pkt[0] = usbKeyboardReportID
+	copy(u.pkt[1:], r[:])
+	hid.SendUSBPacket(u.pkt[:])
+}
@@ -0,0 +1,65 @@
+package wav
+
+import "encoding/binary"
+
+var imaStepTable = [89]int32{
+	7, 8, 9, 10, 11, 12, 13, 14, 16, 17,
+	19, 21, 23, 25, 28, 31, 34, 37, 41, 45,
+	50, 55, 60, 66, 73, 80, 88, 97, 107, 118,
+	130, 143, 157, 173, 190, 209, 230, 253, 279, 307,
+	337, 371, 408, 449, 494, 544, 598, 658, 724, 796,
+	876, 963, 1060, 1166, 1282, 1411, 1552, 1707, 1878, 2066,
+	2272, 2499, 2749, 3024, 3327, 3660, 4026, 4428, 4871, 5358,
+	5894, 6484, 7132, 7845, 8630, 9493, 10442, 11487, 12635, 13899,
+	15289, 16818, 18500, 20350, 22385, 24623, 27086, 29794, 32767,
+}
+
+var imaIndexTable = [8]int32{-1, -1, -1, -1, 2, 4, 6, 8}
+
+// imaState is the predictor of one ADPCM channel.
+type imaState struct {
+	predictor, index int32
+}
+
+func (s *imaState) decode(nibble byte) int32 {
+	step := imaStepTable[s.index]
+	diff := step >> 3
+	if nibble&1 != 0 {
+		diff += step >> 2
+	}
+	if nibble&2 != 0 {
+		diff += step >> 1
+	}
+	if nibble&4 != 0 {
+		diff += step
+	}
+	if nibble&8 != 0 {
+		diff = -diff
+	}
+	s.predictor = min(max(s.predictor+diff, -32768), 32767)
+	s.index = min(max(s.index+imaIndexTable[nibble&7], 0), 88)
+	return s.predictor
+}
+
+// decodeADPCMChannel adds channel c of the IMA ADPCM block b to acc, one
+// entry per frame. Each channel has a four byte header holding its first
+// sample, then the channels take turns with four bytes, eight samples, each.
+func decodeADPCMChannel(acc []int32, b []byte, c, channels int) {
+	hdr := b[4*c:]
+	s := imaState{
+		predictor: int32(int16(binary.LittleEndian.Uint16(hdr))),
+		index:     min(int32(hdr[2]), 88),
+	}
+	acc[0] += s.predictor
+	frame := 1
+	for word := 4*channels + 4*c; word+4 <= len(b) && frame < len(acc); word += 4 * channels {
+		for _, v := range b[word : word+4] {
+			if frame+2 > len(acc) {
+				return
+			}
+			acc[frame] += s.decode(v & 0xF)
+			acc[frame+1] += s.decode(v >> 4)
+			frame += 2
+		}
+	}
+}
@@ -0,0 +1,309 @@
+// wav decodes RIFF/WAVE audio into mono 16-bit samples for the Cardputer's
+// speaker. 8, 16 and 24-bit PCM and IMA ADPCM are supported, in any number of
+// channels; channels are averaged together. Data is read in small pieces, so
+// files far larger than RAM can be streamed from an SD card.
+package wav // import "github.com/sparques/cardputer/wav"
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Format is a WAVE audio format code.
+type Format uint16
+
+const (
+	FormatPCM        Format = 0x0001
+	FormatIMAADPCM   Format = 0x0011
+	formatExtensible Format = 0xFFFE
+)
+
+var (
+	ErrNotWAV      = errors.New("not a RIFF/WAVE file")
+	ErrUnsupported = errors.New("unsupported WAVE encoding")
+	ErrNoData      = errors.New("WAVE file has no data chunk")
+	errSeek        = errors.New("seek out of range")
+)
+
+// Header describes the audio in a WAVE file.
+type Header struct {
+	Format        Format
+	Channels      uint16
+	SampleRate    uint32
+	BitsPerSample uint16
+	// BlockAlign is the size in bytes of a frame, or of an ADPCM block.
+	BlockAlign uint16
+	// SamplesPerBlock is the number of frames in an ADPCM block.
+	SamplesPerBlock uint16
+	// Frames is the length of the audio in frames.
+	Frames int64
+}
+
+// chunkSize is how much PCM Read fetches from the file at a time.
+const chunkSize = 512
+
+// Decoder reads the samples of a WAVE file.
+type Decoder struct {
+	Header
+
+	r         io.ReadSeeker
+	dataStart int64
+	dataLen   int64
+	// off is the read position in the data chunk.
+	off int64
+	// pos is the frame Read returns next.
+	pos int64
+	buf []byte
+
+	// block holds the decoded frames of the current ADPCM block, from
+	// blockOff on still to be returned.
+	block    []int16
+	blockOff int
+	acc      []int32
+}
+
+// NewDecoder parses the header of the WAVE file in r and leaves it ready to
+// read from the first frame.
+func NewDecoder(r io.ReadSeeker) (*Decoder, error) {
+	d := &Decoder{r: r}
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, ErrNotWAV
+	}
+	if string(riff[:4]) != "RIFF" || string(riff[8:]) != "WAVE" {
+		return nil, ErrNotWAV
+	}
+
+	offset := int64(len(riff))
+	haveFmt, haveData := false, false
+	for !haveFmt || !haveData {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if !haveFmt {
+				return nil, ErrNotWAV
+			}
+			return nil, ErrNoData
+		}
+		offset += int64(len(hdr))
+		size := int64(binary.LittleEndian.Uint32(hdr[4:]))
+		switch string(hdr[:4]) {
+		case "fmt ":
+			if size < 16 || size > 64 {
+				return nil, ErrNotWAV
+			}
+			fmtChunk := make([]byte, size)
+			if _, err := io.ReadFull(r, fmtChunk); err != nil {
+				return nil, ErrNotWAV
+			}
+			if err := d.parseFmt(fmtChunk); err != nil {
+				return nil, err
+			}
+			haveFmt = true
+			size = size + size&1
+			if _, err := r.Seek(offset+size, io.SeekStart); err != nil {
+				return nil, err
+			}
+		case "data":
+			d.dataStart, d.dataLen = offset, size
+			haveData = true
+			if haveFmt {
+				break
+			}
+			fallthrough
+		default:
+			size += size & 1
+			if _, err := r.Seek(offset+size, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+		offset += size
+	}
+
+	if d.Format == FormatIMAADPCM {
+		blocks, rest := d.dataLen/int64(d.BlockAlign), d.dataLen%int64(d.BlockAlign)
+		d.Frames = blocks * int64(d.SamplesPerBlock)
+		if rest > 4*int64(d.Channels) {
+			d.Frames += adpcmFrames(int(rest), int(d.Channels))
+		}
+		d.block = make([]int16, d.SamplesPerBlock)
+		d.acc = make([]int32, d.SamplesPerBlock)
+		d.buf = make([]byte, d.BlockAlign)
+	} else {
+		d.Frames = d.dataLen / int64(d.BlockAlign)
+		d.dataLen = d.Frames * int64(d.BlockAlign)
+		d.buf = make([]byte, chunkSize-chunkSize%int(d.BlockAlign)+int(d.BlockAlign))
+	}
+	return d, d.SeekFrame(0)
+}
+
+func (d *Decoder) parseFmt(b []byte) error {
+	le := binary.LittleEndian
+	d.Format = Format(le.Uint16(b))
+	d.Channels = le.Uint16(b[2:])
+	d.SampleRate = le.Uint32(b[4:])
+	d.BlockAlign = le.Uint16(b[12:])
+	d.BitsPerSample = le.Uint16(b[14:])
+	if d.Format == formatExtensible && len(b) >= 26 {
+		// The first two bytes of the sub-format GUID are the real format.
+		d.Format = Format(le.Uint16(b[24:]))
+	}
+	if d.Channels == 0 || d.SampleRate == 0 || d.BlockAlign == 0 {
+		return ErrNotWAV
+	}
+
+	switch d.Format {
+	case FormatPCM:
+		switch d.BitsPerSample {
+		case 8, 16, 24:
+		default:
+			return ErrUnsupported
+		}
+		if int(d.BlockAlign) != int(d.Channels)*int(d.BitsPerSample)/8 {
+			return ErrNotWAV
+		}
+	case FormatIMAADPCM:
+		if d.BitsPerSample != 4 || len(b) < 20 || int(d.BlockAlign) <= 4*int(d.Channels) ||
+			int(d.BlockAlign)%(4*int(d.Channels)) != 0 {
+			return ErrUnsupported
+		}
+		d.SamplesPerBlock = le.Uint16(b[18:])
+		if int64(d.SamplesPerBlock) != adpcmFrames(int(d.BlockAlign), int(d.Channels)) {
+			return ErrNotWAV
+		}
+	default:
+		return ErrUnsupported
+	}
+	return nil
+}
+
+// adpcmFrames returns the frames in an ADPCM block of size bytes: one in the
+// header of each channel, then two per byte per channel.
+func adpcmFrames(size, channels int) int64 {
+	return int64(1 + (size-4*channels)*2/channels)
+}
+
+// Position returns the frame Read returns next.
+func (d *Decoder) Position() int64 {
+	return d.pos
+}
+
+// SeekFrame moves to frame, which may be Frames to seek to the end.
+func (d *Decoder) SeekFrame(frame int64) error {
+	if frame < 0 || frame > d.Frames {
+		return errSeek
+	}
+	var off int64
+	skip := 0
+	if d.Format == FormatIMAADPCM {
+		block := frame / int64(d.SamplesPerBlock)
+		off = block * int64(d.BlockAlign)
+		skip = int(frame % int64(d.SamplesPerBlock))
+	} else {
+		off = frame * int64(d.BlockAlign)
+	}
+	if _, err := d.r.Seek(d.dataStart+off, io.SeekStart); err != nil {
+		return err
+	}
+	d.off, d.pos = off, frame
+	d.block, d.blockOff = d.block[:0], 0
+	if skip > 0 {
+		if err := d.nextBlock(); err != nil {
+			return err
+		}
+		d.blockOff = skip
+	}
+	return nil
+}
+
+// Read decodes up to len(dst) frames into dst, returning io.EOF after the
+// last one.
+func (d *Decoder) Read(dst []int16) (int, error) {
+	if d.pos >= d.Frames {
+		return 0, io.EOF
+	}
+	if d.Format == FormatIMAADPCM {
+		return d.readADPCM(dst)
+	}
+	return d.readPCM(dst)
+}
+
+func (d *Decoder) readPCM(dst []int16) (int, error) {
+	align := int(d.BlockAlign)
+	frames := min(len(dst), len(d.buf)/align, int(d.Frames-d.pos))
+	b := d.buf[:frames*align]
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return 0, unexpected(err)
+	}
+	d.off += int64(len(b))
+	d.pos += int64(frames)
+
+	channels := int(d.Channels)
+	width := int(d.BitsPerSample / 8)
+	for i := range dst[:frames] {
+		var sum int32
+		for c := 0; c < channels; c++ {
+			s := b[(i*channels+c)*width:]
+			switch width {
+			case 1:
+				sum += (int32(s[0]) - 128) << 8
+			case 2:
+				sum += int32(int16(binary.LittleEndian.Uint16(s)))
+			case 3:
+				sum += int32(int16(uint16(s[1]) | uint16(s[2])<<8))
+			}
+		}
+		dst[i] = int16(sum / int32(channels))
+	}
+	return frames, nil
+}
+
+func (d *Decoder) readADPCM(dst []int16) (int, error) {
+	n := 0
+	for n < len(dst) && d.pos < d.Frames {
+		if d.blockOff >= len(d.block) {
+			if err := d.nextBlock(); err != nil {
+				return n, err
+			}
+		}
+		m := copy(dst[n:], d.block[d.blockOff:])
+		m = min(m, int(d.Frames-d.pos))
+		d.blockOff += m
+		d.pos += int64(m)
+		n += m
+	}
+	return n, nil
+}
+
+// nextBlock reads and decodes the ADPCM block at off.
+func (d *Decoder) nextBlock() error {
+	size := int(min(int64(d.BlockAlign), d.dataLen-d.off))
+	channels := int(d.Channels)
+	if size <= 4*channels {
+		return io.ErrUnexpectedEOF
+	}
+	b := d.buf[:size]
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return unexpected(err)
+	}
+	d.off += int64(size)
+
+	frames := int(adpcmFrames(size, channels))
+	acc := d.acc[:frames]
+	clear(acc)
+	for c := 0; c < channels; c++ {
+		decodeADPCMChannel(acc, b, c, channels)
+	}
+	d.block, d.blockOff = d.block[:frames], 0
+	for i, v := range acc {
+		d.block[i] = int16(v / int32(channels))
+	}
+	return nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,285 @@
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+)
+
+// build returns a WAVE file with a fmt chunk holding fmtBody and a data
+// chunk holding data, after an odd-sized chunk that has to be skipped.
+func build(fmtBody, data []byte) []byte {
+	var b bytes.Buffer
+	chunk := func(id string, body []byte) {
+		b.WriteString(id)
+		binary.Write(&b, binary.LittleEndian, uint32(len(body)))
+		b.Write(body)
+		if len(body)%2 != 0 {
+			b.WriteByte(0)
+		}
+	}
+	b.WriteString("RIFF\x00\x00\x00\x00WAVE")
+	chunk("LIST", []byte("odd"))
+	chunk("fmt ", fmtBody)
+	chunk("data", data)
+	return b.Bytes()
+}
+
+func pcmFmt(format Format, channels, rate, bits int) []byte {
+	align := channels * bits / 8
+	b := binary.LittleEndian.AppendUint16(nil, uint16(format))
+	b = binary.LittleEndian.AppendUint16(b, uint16(channels))
+	b = binary.LittleEndian.AppendUint32(b, uint32(rate))
+	b = binary.LittleEndian.AppendUint32(b, uint32(rate*align))
+	b = binary.LittleEndian.AppendUint16(b, uint16(align))
+	return binary.LittleEndian.AppendUint16(b, uint16(bits))
+}
+
+func readAll(t *testing.T, d *Decoder, chunk int) []int16 {
+	t.Helper()
+	var out []int16
+	buf := make([]int16, chunk)
+	for {
+		n, err := d.Read(buf)
+		out = append(out, buf[:n]...)
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPCM(t *testing.T) {
+	extensible := append(pcmFmt(formatExtensible, 1, 8000, 16), 22, 0, 16, 0, 4, 0, 0, 0)
+	extensible = append(extensible, 1, 0, 0, 0, 0, 0, 0x10, 0, 0x80, 0, 0, 0xAA, 0, 0x38, 0x9B, 0x71)
+
+	for _, tt := range []struct {
+		name  string
+		fmt   []byte
+		data  []byte
+		want  []int16
+		rate  uint32
+		chans uint16
+	}{
+		{"8-bit mono", pcmFmt(FormatPCM, 1, 8000, 8), []byte{128, 255, 0}, []int16{0, 127 << 8, -128 << 8}, 8000, 1},
+		{"16-bit stereo", pcmFmt(FormatPCM, 2, 44100, 16),
+			[]byte{0x00, 0x10, 0x00, 0x30, 0xFF, 0xFF, 0x01, 0x00},
+			[]int16{0x2000, 0}, 44100, 2},
+		{"24-bit mono", pcmFmt(FormatPCM, 1, 48000, 24), []byte{0xFF, 0x34, 0x12, 0x00, 0x00, 0x80}, []int16{0x1234, -0x8000}, 48000, 1},
+		{"extensible", extensible, []byte{0x01, 0x02}, []int16{0x0201}, 8000, 1},
+	} {
+		d, err := NewDecoder(bytes.NewReader(build(tt.fmt, tt.data)))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if d.SampleRate != tt.rate || d.Channels != tt.chans || d.Frames != int64(len(tt.want)) {
+			t.Errorf("%s: header %+v", tt.name, d.Header)
+		}
+		got := readAll(t, d, 2)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSeek(t *testing.T) {
+	var data []byte
+	for i := 0; i < 1000; i++ {
+		data = binary.LittleEndian.AppendUint16(data, uint16(i))
+	}
+	d, err := NewDecoder(bytes.NewReader(build(pcmFmt(FormatPCM, 1, 8000, 16), data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SeekFrame(990); err != nil {
+		t.Fatal(err)
+	}
+	got := readAll(t, d, 4)
+	if len(got) != 10 || got[0] != 990 || got[9] != 999 || d.Position() != 1000 {
+		t.Errorf("after SeekFrame(990): %v, position %d", got, d.Position())
+	}
+	if d.SeekFrame(1001) == nil {
+		t.Errorf("SeekFrame past the end succeeded")
+	}
+}
+
+// imaEncode is the reference IMA ADPCM encoder, used to make test blocks.
+func imaEncode(samples []int16, channels int) []byte {
+	states := make([]imaState, channels)
+	var b []byte
+	for c := range states {
+		// Start with a mid-sized step so a loud signal is tracked at once.
+		states[c] = imaState{predictor: int32(samples[c]), index: 60}
+		b = binary.LittleEndian.AppendUint16(b, uint16(samples[c]))
+		b = append(b, 60, 0)
+	}
+	frames := len(samples) / channels
+	for f := 1; f < frames; f += 8 {
+		for c := range states {
+			s := &states[c]
+			for i := 0; i < 8; i += 2 {
+				var pair byte
+				for k := 0; k < 2; k++ {
+					diff := int32(samples[(f+i+k)*channels+c]) - s.predictor
+					var nibble byte
+					if diff < 0 {
+						nibble, diff = 8, -diff
+					}
+					step := imaStepTable[s.index]
+					for mask := byte(4); mask != 0; mask >>= 1 {
+						if diff >= step {
+							nibble |= mask
+							diff -= step
+						}
+						step >>= 1
+					}
+					s.decode(nibble)
+					pair |= nibble << (4 * k)
+				}
+				b = append(b, pair)
+			}
+		}
+	}
+	return b
+}
+
+func TestADPCM(t *testing.T) {
+	const (
+		channels = 2
+		perBlock = 33 // 1 + 4 words of 8
+		align    = 4*channels + 16*channels
+	)
+	// Two blocks of a stereo sine, the right channel at half the level of
+	// the left.
+	var pcm []int16
+	var want []int16
+	for i := 0; i < 2*perBlock; i++ {
+		v := int16(10000 * math.Sin(float64(i)*2*math.Pi/20))
+		pcm = append(pcm, v, v/2)
+		want = append(want, int16((int32(v)+int32(v/2))/2))
+	}
+	data := append(imaEncode(pcm[:2*perBlock], channels), imaEncode(pcm[2*perBlock:], channels)...)
+
+	fmtBody := pcmFmt(FormatIMAADPCM, channels, 8000, 4)
+	binary.LittleEndian.PutUint16(fmtBody[12:], align)
+	fmtBody = append(fmtBody, 2, 0, perBlock, 0)
+
+	d, err := NewDecoder(bytes.NewReader(build(fmtBody, data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Frames != 2*perBlock {
+		t.Fatalf("Frames = %d, want %d", d.Frames, 2*perBlock)
+	}
+	got := readAll(t, d, 7)
+	if len(got) != len(want) {
+		t.Fatalf("decoded %d frames, want %d", len(got), len(want))
+	}
+	for i := range got {
+		const tolerance = 600
+		if d := int(got[i]) - int(want[i]); d < -tolerance || d > tolerance {
+			t.Fatalf("frame %d = %d, want %d±%d", i, got[i], want[i], tolerance)
+		}
+	}
+
+	// Seeking into the second block decodes it and skips ahead.
+	if err := d.SeekFrame(perBlock + 5); err != nil {
+		t.Fatal(err)
+	}
+	more := readAll(t, d, 100)
+	if len(more) != perBlock-5 || more[0] != got[perBlock+5] {
+		t.Errorf("after SeekFrame: %d frames starting %d, want %d starting %d", len(more), more[0], perBlock-5, got[perBlock+5])
+	}
+}
+
+func TestBadFiles(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		file []byte
+		err  error
+	}{
+		{"empty", nil, ErrNotWAV},
+		{"not riff", []byte("RIFX\x00\x00\x00\x00WAVE"), ErrNotWAV},
+		{"float", build(pcmFmt(3, 1, 8000, 32), nil), ErrUnsupported},
+		{"12-bit", build(pcmFmt(FormatPCM, 1, 8000, 12), nil), ErrUnsupported},
+		{"no data", []byte("RIFF\x00\x00\x00\x00WAVEfmt \x10\x00\x00\x00" + string(pcmFmt(FormatPCM, 1, 8000, 16))), ErrNoData},
+	} {
+		if _, err := NewDecoder(bytes.NewReader(tt.file)); err != tt.err {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+// memFile is an in-memory io.WriteSeeker.
+type memFile struct {
+	b   []byte
+	off int
+}
+
+func (m *memFile) Write(p []byte) (int, error) {
+	if n := m.off + len(p); n > len(m.b) {
+		m.b = append(m.b, make([]byte, n-len(m.b))...)
+	}
+	m.off += copy(m.b[m.off:], p)
+	return len(p), nil
+}
+
+func (m *memFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += int64(m.off)
+	case io.SeekEnd:
+		offset += int64(len(m.b))
+	}
+	m.off = int(offset)
+	return offset, nil
+}
+
+func TestWriter(t *testing.T) {
+	var f memFile
+	w, err := NewWriter(&f, 16000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]int16, 700)
+	for i := range want {
+		want[i] = int16(i*97 - 30000)
+	}
+	for _, chunk := range [][]int16{want[:1], want[1:600], want[600:]} {
+		if n, err := w.Write(chunk); n != len(chunk) || err != nil {
+			t.Fatalf("Write = %d, %v", n, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Size() != int64(len(f.b)) || f.off != len(f.b) {
+		t.Errorf("Size = %d, file is %d bytes, offset %d", w.Size(), len(f.b), f.off)
+	}
+
+	d, err := NewDecoder(bytes.NewReader(f.b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.SampleRate != 16000 || d.Channels != 1 || d.Frames != int64(len(want)) {
+		t.Fatalf("header %+v", d.Header)
+	}
+	got := readAll(t, d, 256)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sample %d = %d, want %d", i, got[i], want[i])
+		}
+	}
+}
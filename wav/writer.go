@@ -0,0 +1,96 @@
+package wav
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// HeaderSize is the length of the header Writer puts before the samples.
+const HeaderSize = 44
+
+var errTooLong = errors.New("WAVE file would exceed 4GiB")
+
+// Writer writes mono 16-bit PCM as a WAVE file. The header is written with
+// zero sizes and patched by Close, so w has to be seekable.
+type Writer struct {
+	w      io.WriteSeeker
+	frames int64
+	buf    []byte
+}
+
+// NewWriter writes the header of a WAVE file at rate hertz to w.
+func NewWriter(w io.WriteSeeker, rate uint32) (*Writer, error) {
+	var hdr [HeaderSize]byte
+	le := binary.LittleEndian
+	copy(hdr[0:], "RIFF")
+	copy(hdr[8:], "WAVEfmt ")
+	le.PutUint32(hdr[16:], 16)
+	le.PutUint16(hdr[20:], uint16(FormatPCM))
+	le.PutUint16(hdr[22:], 1)
+	le.PutUint32(hdr[24:], rate)
+	le.PutUint32(hdr[28:], rate*2)
+	le.PutUint16(hdr[32:], 2)
+	le.PutUint16(hdr[34:], 16)
+	copy(hdr[36:], "data")
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, buf: make([]byte, chunkSize)}, nil
+}
+
+// Write appends samples to the file.
+func (w *Writer) Write(samples []int16) (int, error) {
+	if w.Size()+2*int64(len(samples)) > math.MaxUint32 {
+		return 0, errTooLong
+	}
+	n := 0
+	for n < len(samples) {
+		chunk := samples[n:min(len(samples), n+len(w.buf)/2)]
+		b := w.buf[:2*len(chunk)]
+		for i, s := range chunk {
+			binary.LittleEndian.PutUint16(b[2*i:], uint16(s))
+		}
+		m, err := w.w.Write(b)
+		n += m / 2
+		w.frames += int64(m / 2)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Frames returns how many samples have been written.
+func (w *Writer) Frames() int64 {
+	return w.frames
+}
+
+// Size returns the length of the file so far, header included.
+func (w *Writer) Size() int64 {
+	return HeaderSize + 2*w.frames
+}
+
+// Close fills in the sizes in the header and leaves w at the end of the
+// file. It doesn't close w.
+func (w *Writer) Close() error {
+	var size [4]byte
+	for _, patch := range []struct {
+		off  int64
+		size int64
+	}{
+		{4, w.Size() - 8},
+		{40, 2 * w.frames},
+	} {
+		if _, err := w.w.Seek(patch.off, io.SeekStart); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(size[:], uint32(patch.size))
+		if _, err := w.w.Write(size[:]); err != nil {
+			return err
+		}
+	}
+	_, err := w.w.Seek(0, io.SeekEnd)
+	return err
+}
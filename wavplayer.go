@@ -0,0 +1,209 @@
+//go:build esp32 || esp32s3
+
+package cardputer
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sparques/cardputer/mixer"
+	"github.com/sparques/cardputer/wav"
+	"tinygo.org/x/tinyfs"
+)
+
+var errPlayerStopped = errors.New("player is stopped")
+
+// WAVPlayer plays a WAVE file as a Mixer voice, so it mixes with whatever
+// else is playing. Started while nothing else plays, it sets Speaker to the
+// file's sample rate; otherwise it is resampled to Speaker's rate.
+//
+//	p, err := cardputer.PlayWAV("/sounds/chime.wav")
+type WAVPlayer struct {
+	// Loop restarts the file from the beginning when it ends.
+	Loop bool
+	// OnDone is called when the file ends or an error stops it, with nil or
+	// that error. It isn't called after Pause or Stop.
+	OnDone func(err error)
+
+	mu      sync.Mutex
+	f       tinyfs.File
+	dec     *wav.Decoder
+	voice   *mixer.Voice
+	paused  bool
+	stopped bool
+}
+
+// PlayWAV opens path on SDFS and starts playing it.
+func PlayWAV(path string) (*WAVPlayer, error) {
+	f, err := SDFS.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := NewWAVPlayer(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := p.Play(); err != nil {
+		p.Stop()
+		return nil, err
+	}
+	return p, nil
+}
+
+// NewWAVPlayer returns a player for the WAVE file f, which it closes on Stop.
+// Nothing plays until Play.
+func NewWAVPlayer(f tinyfs.File) (*WAVPlayer, error) {
+	dec, err := wav.NewDecoder(f)
+	if err != nil {
+		return nil, err
+	}
+	return &WAVPlayer{f: f, dec: dec}, nil
+}
+
+// Header describes the file being played.
+func (p *WAVPlayer) Header() wav.Header {
+	return p.dec.Header
+}
+
+// Play starts or resumes playback on Mixer. Playing a file that has ended
+// starts it again.
+func (p *WAVPlayer) Play() error {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return errPlayerStopped
+	}
+	p.paused = false
+	if p.voice != nil {
+		p.mu.Unlock()
+		return nil
+	}
+	if p.dec.Position() == p.dec.Frames {
+		if err := p.dec.SeekFrame(0); err != nil {
+			p.mu.Unlock()
+			return err
+		}
+	}
+	p.mu.Unlock()
+
+	// Mixer reads the player under its own lock, so Add and Stop on the
+	// voice are called without holding p.mu.
+	v, err := Mixer.AddAt(wavSource{p}, p.dec.SampleRate, 0)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	if p.voice != nil || p.paused || p.stopped {
+		// Pause, Stop or another Play got in first
+		p.mu.Unlock()
+		v.Stop()
+		return nil
+	}
+	p.voice = v
+	p.mu.Unlock()
+	go p.wait(v)
+	return nil
+}
+
+// Pause holds playback at the current position until Play.
+func (p *WAVPlayer) Pause() {
+	p.mu.Lock()
+	p.paused = true
+	v := p.voice
+	p.voice = nil
+	p.mu.Unlock()
+	if v != nil {
+		v.Stop()
+	}
+}
+
+// Paused reports whether playback is paused.
+func (p *WAVPlayer) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// Seek moves playback to t from the start of the file.
+func (p *WAVPlayer) Seek(t time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return errPlayerStopped
+	}
+	frame := int64(t) * int64(p.dec.SampleRate) / int64(time.Second)
+	return p.dec.SeekFrame(min(frame, p.dec.Frames))
+}
+
+// Position returns how far into the file playback has got.
+func (p *WAVPlayer) Position() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.frameTime(p.dec.Position())
+}
+
+// Duration returns the length of the file.
+func (p *WAVPlayer) Duration() time.Duration {
+	return p.frameTime(p.dec.Frames)
+}
+
+func (p *WAVPlayer) frameTime(frame int64) time.Duration {
+	return time.Duration(frame * int64(time.Second) / int64(p.dec.SampleRate))
+}
+
+// Stop ends playback and closes the file. The player can't be used again.
+func (p *WAVPlayer) Stop() error {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return nil
+	}
+	p.stopped = true
+	v := p.voice
+	p.voice = nil
+	err := p.f.Close()
+	p.mu.Unlock()
+	if v != nil {
+		v.Stop()
+	}
+	return err
+}
+
+// wait reports the end of v through OnDone, unless Pause, Stop or a later
+// Play has taken over from it.
+func (p *WAVPlayer) wait(v *mixer.Voice) {
+	<-v.Done()
+	p.mu.Lock()
+	if p.voice != v {
+		p.mu.Unlock()
+		return
+	}
+	p.voice = nil
+	done := p.OnDone
+	p.mu.Unlock()
+	if done != nil {
+		done(v.Err())
+	}
+}
+
+// wavSource reads the player's decoder for Mixer.
+type wavSource struct {
+	p *WAVPlayer
+}
+
+func (s wavSource) Read(dst []int16) (int, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return 0, io.EOF
+	}
+	n, err := p.dec.Read(dst)
+	if err == io.EOF && p.Loop {
+		err = p.dec.SeekFrame(0)
+	}
+	return n, err
+}
@@ -0,0 +1,265 @@
+//go:build esp32 || esp32s3
+
+package cardputer
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sparques/cardputer/wav"
+	"tinygo.org/x/tinyfs"
+)
+
+var (
+	errRecorderStarted = errors.New("recorder already started")
+	errRecorderStopped = errors.New("recorder is stopped")
+)
+
+// recordChunk is how many samples the recorder captures between hand-offs to
+// the file writer.
+const recordChunk = 1024
+
+// WAVRecorder captures Microphone into a WAVE file in the background. One
+// goroutine reads the microphone into two alternating buffers while another
+// writes full ones to the file, so a slow SD card write doesn't stall
+// capture. Samples that arrive while both buffers are still waiting to be
+// written are dropped and counted.
+//
+//	r, err := cardputer.RecordWAV("/notes/0001.wav", time.Minute)
+//	...
+//	err = r.Stop()
+type WAVRecorder struct {
+	// MaxDuration and MaxSize end the recording once it is that long, or the
+	// file that many bytes, header included. Zero means no limit. Set them
+	// before Start.
+	MaxDuration time.Duration
+	MaxSize     int64
+	// OnDone is called from the writing goroutine when a limit or an error
+	// ends the recording, after the file is closed, with nil or that error.
+	// It isn't called after Stop.
+	OnDone func(err error)
+
+	mu       sync.Mutex
+	f        tinyfs.File
+	w        *wav.Writer
+	rate     uint32
+	started  bool
+	stopping bool
+	stopped  bool
+	frames   int64
+	dropped  uint64
+	err      error
+	free     chan []int16
+	full     chan []int16
+	done     chan struct{}
+	bufs     [2][recordChunk]int16
+	scratch  [recordChunk]int16
+}
+
+// RecordWAV creates path on SDFS, replacing any file there, and starts
+// recording into it for up to maxDuration, or until Stop if that is 0.
+func RecordWAV(path string, maxDuration time.Duration) (*WAVRecorder, error) {
+	f, err := SDFS.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+	r := NewWAVRecorder(f)
+	r.MaxDuration = maxDuration
+	if err := r.Start(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewWAVRecorder returns a recorder writing to f, which it closes when the
+// recording ends. Nothing is captured until Start.
+func NewWAVRecorder(f tinyfs.File) *WAVRecorder {
+	return &WAVRecorder{f: f}
+}
+
+// Start writes the file header and begins capturing at Microphone's sample
+// rate.
+func (r *WAVRecorder) Start() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return errRecorderStopped
+	}
+	if r.started {
+		return errRecorderStarted
+	}
+	if err := Microphone.Init(); err != nil {
+		return err
+	}
+	r.rate = Microphone.sampleRate()
+	w, err := wav.NewWriter(r.f, r.rate)
+	if err != nil {
+		return err
+	}
+	r.w = w
+	r.started = true
+	r.free = make(chan []int16, len(r.bufs))
+	r.full = make(chan []int16, len(r.bufs))
+	r.done = make(chan struct{})
+	for i := range r.bufs {
+		r.free <- r.bufs[i][:]
+	}
+	go r.capture(r.limit())
+	go r.drain()
+	return nil
+}
+
+// limit returns how many samples MaxDuration and MaxSize allow, or -1 for no
+// limit.
+func (r *WAVRecorder) limit() int64 {
+	limit := int64(-1)
+	if r.MaxDuration > 0 {
+		limit = int64(r.MaxDuration) * int64(r.rate) / int64(time.Second)
+	}
+	if r.MaxSize > 0 {
+		size := max(r.MaxSize-wav.HeaderSize, 0) / 2
+		if limit < 0 || size < limit {
+			limit = size
+		}
+	}
+	return limit
+}
+
+// Stop ends the recording, waits for the buffered samples to be written and
+// closes the file. It returns the first error the recording hit.
+func (r *WAVRecorder) Stop() error {
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		return nil
+	}
+	r.stopped = true
+	if !r.started {
+		r.mu.Unlock()
+		return r.f.Close()
+	}
+	r.stopping = true
+	done := r.done
+	r.mu.Unlock()
+	<-done
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Recording reports whether samples are still being captured.
+func (r *WAVRecorder) Recording() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.started && !r.stopping
+}
+
+// Duration returns how much audio has been written to the file.
+func (r *WAVRecorder) Duration() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rate == 0 {
+		return 0
+	}
+	return time.Duration(r.frames * int64(time.Second) / int64(r.rate))
+}
+
+// Dropped returns how many samples were lost because the file writer fell
+// behind.
+func (r *WAVRecorder) Dropped() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.dropped
+}
+
+// capture reads the microphone until Stop, an error or the limit, handing
+// each filled buffer to drain.
+func (r *WAVRecorder) capture(limit int64) {
+	defer close(r.full)
+	var captured int64
+	for limit < 0 || captured < limit {
+		r.mu.Lock()
+		stopping := r.stopping
+		r.mu.Unlock()
+		if stopping {
+			return
+		}
+
+		var buf []int16
+		spare := false
+		select {
+		case buf = <-r.free:
+		default:
+			buf, spare = r.scratch[:], true
+		}
+		if limit >= 0 {
+			buf = buf[:min(int64(len(buf)), limit-captured)]
+		}
+		n, err := Microphone.Read(buf)
+		if err != nil {
+			r.fail(err)
+			return
+		}
+		if spare {
+			r.mu.Lock()
+			r.dropped += uint64(n)
+			r.mu.Unlock()
+			continue
+		}
+		captured += int64(n)
+		r.full <- buf[:n]
+	}
+	r.mu.Lock()
+	r.stopping = true
+	r.mu.Unlock()
+}
+
+// drain writes buffers from capture to the file, then finishes it.
+func (r *WAVRecorder) drain() {
+	for buf := range r.full {
+		r.mu.Lock()
+		failed := r.err != nil
+		r.mu.Unlock()
+		if !failed {
+			n, err := r.w.Write(buf)
+			r.mu.Lock()
+			r.frames += int64(n)
+			r.mu.Unlock()
+			if err != nil {
+				r.fail(err)
+			}
+		}
+		r.free <- buf[:cap(buf)]
+	}
+	if err := r.w.Close(); err != nil {
+		r.fail(err)
+	}
+	if err := r.f.Close(); err != nil {
+		r.fail(err)
+	}
+
+	r.mu.Lock()
+	err, done := r.err, r.OnDone
+	if r.stopped {
+		done = nil
+	}
+	r.mu.Unlock()
+	close(r.done)
+	if done != nil {
+		done(err)
+	}
+}
+
+// fail records the first error and stops capture.
+func (r *WAVRecorder) fail(err error) {
+	r.mu.Lock()
+	if r.err == nil {
+		r.err = err
+	}
+	r.stopping = true
+	r.mu.Unlock()
+}